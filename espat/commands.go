@@ -25,6 +25,9 @@ const (
 
 	// Configure UART
 	UARTConfig = "+UART"
+
+	// Manufacturing (certificates) data
+	SystemMfg = "+SYSMFG"
 )
 
 // WiFi commands.
@@ -57,6 +60,9 @@ const (
 	// Enable/disable DHCP
 	DHCPConfig = "+CWDHCP"
 
+	// Set host name of station
+	SetHostname = "+CWHOSTNAME"
+
 	// Set MAC address of station
 	SetStationMACAddress = "+CIPSTAMAC"
 
@@ -106,6 +112,18 @@ const (
 	// Set transmission mode
 	TransmissionMode = "+CIPMODE"
 
+	// Set DNS servers
+	DNSConfig = "+CIPDNS"
+
 	// Set timeout when ESP8266/ESP32 runs as TCP server
 	SetServerTimeout = "+CIPSTO"
+
+	// Set socket options of a TCP connection
+	TCPOptions = "+CIPTCPOPT"
+
+	// Set SSL client configuration of a connection
+	SSLConfig = "+CIPSSLCCONF"
+
+	// Set SSL client Server Name Indication of a connection
+	SSLServerName = "+CIPSSLCSNI"
 )
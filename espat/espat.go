@@ -23,6 +23,8 @@ package espat // import "tinygo.org/x/drivers/espat"
 import (
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"machine"
 	"net"
 	"net/netip"
@@ -42,10 +44,30 @@ type Config struct {
 	Rx   machine.Pin
 }
 
+// maxLinks is the number of simultaneous connections of the ESP8266/ESP32 in
+// multiple connections mode (link IDs 0-4)
+const maxLinks = 5
+
+// maxSockets is maxLinks plus a listening socket
+const maxSockets = maxLinks + 1
+
 type socket struct {
-	inUse    bool
-	protocol int
-	laddr    netip.AddrPort
+	protocol  int
+	laddr     netip.AddrPort
+	link      int // link ID of the connection, -1 if none
+	listening bool
+	// TCP options, applied once connected
+	noDelay   bool
+	keepAlive bool
+	keepIntvl int // seconds
+}
+
+// link is a connection of the ESP8266/ESP32
+type link struct {
+	inUse     bool // owned by a socket, or waiting to be accepted
+	connected bool
+	// data received from the connection
+	data []byte
 }
 
 type Device struct {
@@ -53,26 +75,34 @@ type Device struct {
 	uart *machine.UART
 	// command responses that come back from the ESP8266/ESP32
 	response []byte
-	// data received from a TCP/UDP connection forwarded by the ESP8266/ESP32
-	data   []byte
-	socket socket
+	// incomplete +IPD header left by the last response
+	rest []byte
+	// link and byte count of +IPD data still to be read from the UART
+	ipdLink      int
+	ipdRemaining int
+	sockets      map[int]*socket // keyed by sockfd
+	links        [maxLinks]link
+	// link IDs of connections to the TCP server, waiting for Accept
+	accepted []int
+	// SSL config of IPPROTO_TLS sockets, nil if not set
+	tlsConfig *netdev.TLSConfig
+	// CRC-32 of the certificates and keys written to the manufacturing
+	// data, by namespace
+	mfgCRC map[string]uint32
 	mu     sync.Mutex
 }
 
 func NewDevice(cfg *Config) *Device {
 	return &Device{
 		cfg:      cfg,
-		response: make([]byte, 1500),
-		data:     make([]byte, 0, 1500),
+		response: make([]byte, 2048),
+		sockets:  make(map[int]*socket),
+		mfgCRC:   make(map[string]uint32),
 	}
 }
 
-func (d *Device) NetConnect(params *netlink.ConnectParams) error {
-
-	if len(params.Ssid) == 0 {
-		return netlink.ErrMissingSSID
-	}
-
+// connectDevice sets up the UART and checks the ESP8266/ESP32 answers
+func (d *Device) connectDevice() error {
 	d.uart = d.cfg.Uart
 	d.uart.Configure(machine.UARTConfig{TX: d.cfg.Tx, RX: d.cfg.Rx})
 
@@ -92,12 +122,33 @@ func (d *Device) NetConnect(params *netlink.ConnectParams) error {
 	}
 
 	fmt.Printf("CONNECTED\r\n")
+	return nil
+}
+
+func (d *Device) NetConnect(params *netlink.ConnectParams) error {
+
+	if len(params.Ssid) == 0 {
+		return netlink.ErrMissingSSID
+	}
+
+	if err := params.CheckIPConfig(); err != nil {
+		return err
+	}
+
+	if err := d.connectDevice(); err != nil {
+		return err
+	}
 
 	// Connect to Wifi AP
 	fmt.Printf("Connecting to Wifi SSID '%s'...", params.Ssid)
 
 	d.SetWifiMode(WifiModeClient)
 
+	if err := d.configureIP(params); err != nil {
+		fmt.Printf("FAILED\r\n")
+		return err
+	}
+
 	err := d.ConnectToAP(params.Ssid, params.Passphrase, 10 /* secs */)
 	if err != nil {
 		fmt.Printf("FAILED\r\n")
@@ -106,16 +157,51 @@ func (d *Device) NetConnect(params *netlink.ConnectParams) error {
 
 	fmt.Printf("CONNECTED\r\n")
 
+	// Sockets use link IDs of the multiple connections mode
+	if err := d.SetMux(TCPMuxMultiple); err != nil {
+		return err
+	}
+
 	ip, err := d.Addr()
 	if err != nil {
 		return err
 	}
-	fmt.Printf("DHCP-assigned IP: %s\r\n", ip)
+	if params.IP.IsValid() {
+		fmt.Printf("Static IP: %s\r\n", ip)
+	} else {
+		fmt.Printf("DHCP-assigned IP: %s\r\n", ip)
+	}
 	fmt.Printf("\r\n")
 
 	return nil
 }
 
+// configureIP sets the hostname, then the static IP address or DHCP, and
+// the DNS servers, before connecting
+func (d *Device) configureIP(params *netlink.ConnectParams) error {
+	if params.Hostname != "" {
+		if err := d.SetHostname(params.Hostname); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if params.IP.IsValid() {
+		err = d.SetClientIPConfig(params.IP.String(), params.Gateway.String(), params.Subnet.String())
+	} else {
+		err = d.EnableClientDHCP()
+	}
+	if err != nil {
+		return err
+	}
+
+	var servers []string
+	for _, dns := range params.DNS {
+		servers = append(servers, dns.String())
+	}
+	return d.SetDNS(servers...)
+}
+
 func (d *Device) NetDisconnect() {
 	d.DisconnectFromAP()
 	fmt.Printf("\r\nDisconnected from Wifi\r\n\r\n")
@@ -125,6 +211,149 @@ func (d *Device) NetNotify(cb func(netlink.Event)) {
 	fmt.Printf("\r\n%s\r\n", netlink.ErrNotSupported)
 }
 
+func (d *Device) NetScan() ([]netlink.ScanResult, error) {
+	if d.uart == nil {
+		if err := d.connectDevice(); err != nil {
+			return nil, err
+		}
+		// Scanning needs the station mode
+		if err := d.SetWifiMode(WifiModeClient); err != nil {
+			return nil, err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	resp, err := d.ListAccessPoints()
+	if err != nil {
+		return nil, netlink.ErrScanFailed
+	}
+	return parseAccessPoints(string(resp)), nil
+}
+
+// parseAccessPoints parses the networks listed by +CWLAP, as
+// +CWLAP:(<ecn>,<"ssid">,<rssi>,<"mac">,<channel>,...)
+func parseAccessPoints(resp string) []netlink.ScanResult {
+	var results []netlink.ScanResult
+	const prefix = "+CWLAP:("
+	for _, line := range strings.Split(resp, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		line = line[len(prefix):]
+
+		// The SSID is quoted and may contain commas: it ends before the
+		// RSSI, which is always negative
+		i := strings.Index(line, ",\"")
+		j := strings.LastIndex(line, "\",-")
+		if i == -1 || j < i+1 {
+			continue
+		}
+		ecn, err := strconv.Atoi(line[:i])
+		if err != nil {
+			continue
+		}
+		result := netlink.ScanResult{
+			Ssid:     line[i+2 : j],
+			AuthType: ecnAuthType(ecn),
+		}
+
+		fields := strings.Split(strings.TrimRight(line[j+2:], ")\r"), ",")
+		if len(fields) < 3 {
+			continue
+		}
+		result.Rssi, _ = strconv.Atoi(fields[0])
+		result.Bssid, _ = net.ParseMAC(strings.Trim(fields[1], `"`))
+		result.Channel, _ = strconv.Atoi(fields[2])
+		results = append(results, result)
+	}
+	return results
+}
+
+func (d *Device) GetLinkInfo() (netlink.LinkInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var info netlink.LinkInfo
+
+	// +CWJAP:<"ssid">,<"bssid">,<channel>,<rssi>,...
+	resp, err := d.GetConnectedAP()
+	if err != nil {
+		return info, err
+	}
+	const prefix = "+CWJAP:\""
+	for _, line := range strings.Split(string(resp), "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		line = strings.TrimRight(line[len(prefix):], "\r")
+		// The SSID may contain commas
+		i := strings.LastIndex(line, "\",\"")
+		if i == -1 {
+			break
+		}
+		info.Ssid = line[:i]
+		fields := strings.Split(line[i+2:], ",")
+		if len(fields) < 3 {
+			break
+		}
+		info.Bssid, _ = net.ParseMAC(strings.Trim(fields[0], `"`))
+		info.Channel, _ = strconv.Atoi(fields[1])
+		info.Rssi, _ = strconv.Atoi(fields[2])
+	}
+	if info.Ssid == "" {
+		return info, netlink.ErrNotConnected
+	}
+
+	// +CIPSTA:ip:<"ip">, +CIPSTA:gateway:<"gateway">, +CIPSTA:netmask:<"netmask">
+	ips, err := d.GetClientIP()
+	if err != nil {
+		return info, err
+	}
+	for _, line := range strings.Split(ips, "\n") {
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "+CIPSTA:"), ":")
+		if !ok {
+			continue
+		}
+		addr, err := netip.ParseAddr(strings.Trim(value, "\"\r"))
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "ip":
+			info.IP = addr
+		case "gateway":
+			info.Gateway = addr
+		case "netmask":
+			info.Subnet = addr
+		}
+	}
+
+	return info, nil
+}
+
+// ecnAuthType converts an encryption method of +CWLAP
+func ecnAuthType(ecn int) netlink.AuthType {
+	switch ecn {
+	case 0:
+		return netlink.AuthTypeOpen
+	case 1:
+		return netlink.AuthTypeWEP
+	case 2:
+		return netlink.AuthTypeWPA
+	case 3:
+		return netlink.AuthTypeWPA2
+	case 4:
+		return netlink.AuthTypeWPA2Mixed
+	case 6:
+		return netlink.AuthTypeWPA3
+	case 7:
+		return netlink.AuthTypeWPA3Mixed
+	}
+	return netlink.AuthTypeUnknown
+}
+
 func (d *Device) GetHostByName(name string) (netip.Addr, error) {
 	ip, err := d.GetDNS(name)
 	if err != nil {
@@ -152,6 +381,51 @@ func (d *Device) Addr() (netip.Addr, error) {
 	return netip.Addr{}, fmt.Errorf("Error getting IP address")
 }
 
+// newSockfd returns the next available sockfd, or -1 if none available
+func (d *Device) newSockfd() int {
+	if len(d.sockets) >= maxSockets {
+		return -1
+	}
+	// Search for the next available sockfd starting at 0
+	for sockfd := 0; ; sockfd++ {
+		if _, ok := d.sockets[sockfd]; !ok {
+			return sockfd
+		}
+	}
+}
+
+// newLink reserves the next available link ID, or returns -1 if none
+// available
+func (d *Device) newLink() int {
+	for id := range d.links {
+		if !d.links[id].inUse {
+			d.links[id] = link{inUse: true, data: d.links[id].data[:0]}
+			return id
+		}
+	}
+	return -1
+}
+
+// freeLink closes a connection if still up, and releases its link ID
+func (d *Device) freeLink(id int) error {
+	var err error
+	if d.links[id].connected {
+		err = d.DisconnectSocketID(id)
+	}
+	d.links[id] = link{data: d.links[id].data[:0]}
+	return err
+}
+
+// listening reports if a socket runs the TCP server
+func (d *Device) listening() bool {
+	for _, s := range d.sockets {
+		if s.listening {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Device) Socket(domain int, stype int, protocol int) (int, error) {
 
 	switch domain {
@@ -168,37 +442,68 @@ func (d *Device) Socket(domain int, stype int, protocol int) (int, error) {
 		return -1, netdev.ErrProtocolNotSupported
 	}
 
-	// Only supporting single connection mode, so only one socket at a time
-	if d.socket.inUse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sockfd := d.newSockfd()
+	if sockfd == -1 {
 		return -1, netdev.ErrNoMoreSockets
 	}
-	d.socket.inUse = true
-	d.socket.protocol = protocol
 
-	return 0, nil
+	d.sockets[sockfd] = &socket{
+		protocol: protocol,
+		link:     -1,
+	}
+
+	return sockfd, nil
 }
 
 func (d *Device) Bind(sockfd int, ip netip.AddrPort) error {
-	d.socket.laddr = ip
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	socket.laddr = ip
 	return nil
 }
 
 func (d *Device) Connect(sockfd int, host string, ip netip.AddrPort) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	id := d.newLink()
+	if id == -1 {
+		return netdev.ErrNoMoreSockets
+	}
+
 	var err error
 	var addr = ip.Addr().String()
 	var rport = strconv.Itoa(int(ip.Port()))
-	var lport = strconv.Itoa(int(d.socket.laddr.Port()))
+	var lport = strconv.Itoa(int(socket.laddr.Port()))
 
-	switch d.socket.protocol {
+	switch socket.protocol {
 	case netdev.IPPROTO_TCP:
-		err = d.ConnectTCPSocket(addr, rport)
+		err = d.ConnectTCPSocketID(id, addr, rport)
 	case netdev.IPPROTO_UDP:
-		err = d.ConnectUDPSocket(addr, rport, lport)
+		err = d.ConnectUDPSocketID(id, addr, rport, lport)
 	case netdev.IPPROTO_TLS:
-		err = d.ConnectSSLSocket(host, rport)
+		err = d.configureSSL(id)
+		if err == nil {
+			err = d.ConnectSSLSocketID(id, host, rport)
+		}
 	}
 
 	if err != nil {
+		d.links[id] = link{}
 		if host == "" {
 			return fmt.Errorf("Connect to %s timed out", ip)
 		} else {
@@ -206,11 +511,31 @@ func (d *Device) Connect(sockfd int, host string, ip netip.AddrPort) error {
 		}
 	}
 
-	return nil
+	d.links[id].connected = true
+	socket.link = id
+
+	return d.applySockOpts(socket)
 }
 
 func (d *Device) Listen(sockfd int, backlog int) error {
-	switch d.socket.protocol {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	switch socket.protocol {
+	case netdev.IPPROTO_TCP:
+		// The AT firmware runs a single TCP server
+		if d.listening() {
+			return netdev.ErrNoMoreSockets
+		}
+		if err := d.StartServer(strconv.Itoa(int(socket.laddr.Port()))); err != nil {
+			return err
+		}
+		socket.listening = true
 	case netdev.IPPROTO_UDP:
 	default:
 		return netdev.ErrProtocolNotSupported
@@ -219,17 +544,75 @@ func (d *Device) Listen(sockfd int, backlog int) error {
 }
 
 func (d *Device) Accept(sockfd int) (int, netip.AddrPort, error) {
-	return -1, netip.AddrPort{}, netdev.ErrNotSupported
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	server, ok := d.sockets[sockfd]
+	if !ok {
+		return -1, netip.AddrPort{}, netdev.ErrInvalidSocketFd
+	}
+
+	switch server.protocol {
+	case netdev.IPPROTO_TCP:
+	default:
+		return -1, netip.AddrPort{}, netdev.ErrProtocolNotSupported
+	}
+
+	if !server.listening {
+		return -1, netip.AddrPort{}, fmt.Errorf("Must Listen before Accepting")
+	}
+
+	for {
+		// Check for new clients, reported as "<link ID>,CONNECT"
+		d.poll()
+
+		if len(d.accepted) > 0 {
+			id := d.accepted[0]
+			d.accepted = d.accepted[1:]
+
+			clientfd := d.newSockfd()
+			if clientfd == -1 {
+				d.freeLink(id)
+				return -1, netip.AddrPort{}, netdev.ErrNoMoreSockets
+			}
+
+			client := &socket{
+				protocol:  netdev.IPPROTO_TCP,
+				link:      id,
+				noDelay:   server.noDelay,
+				keepAlive: server.keepAlive,
+				keepIntvl: server.keepIntvl,
+			}
+			d.sockets[clientfd] = client
+
+			if err := d.applySockOpts(client); err != nil {
+				return -1, netip.AddrPort{}, err
+			}
+
+			return clientfd, d.remoteAddr(id), nil
+		}
+
+		// Accept() will be sleeping most of the time, checking for
+		// new clients every 1/10 sec.
+		d.mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		d.mu.Lock()
+
+		// Close() of the listener meanwhile stops the server
+		if d.sockets[sockfd] != server {
+			return -1, netip.AddrPort{}, net.ErrClosed
+		}
+	}
 }
 
-func (d *Device) sendChunk(sockfd int, buf []byte, deadline time.Time) (int, error) {
+func (d *Device) sendChunk(id int, buf []byte, deadline time.Time) (int, error) {
 	// Check if we've timed out
 	if !deadline.IsZero() {
 		if time.Now().After(deadline) {
 			return -1, netdev.ErrTimeout
 		}
 	}
-	err := d.StartSocketSend(len(buf))
+	err := d.StartSocketSendID(id, len(buf))
 	if err != nil {
 		return -1, err
 	}
@@ -249,6 +632,14 @@ func (d *Device) Send(sockfd int, buf []byte, flags int, deadline time.Time) (in
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return -1, netdev.ErrInvalidSocketFd
+	}
+	if socket.link == -1 {
+		return -1, fmt.Errorf("Must Connect before Sending")
+	}
+
 	// Break large bufs into chunks so we don't overrun the hw queue
 
 	chunkSize := 1436
@@ -257,7 +648,7 @@ func (d *Device) Send(sockfd int, buf []byte, flags int, deadline time.Time) (in
 		if end > len(buf) {
 			end = len(buf)
 		}
-		_, err := d.sendChunk(sockfd, buf[i:end], deadline)
+		_, err := d.sendChunk(socket.link, buf[i:end], deadline)
 		if err != nil {
 			return -1, err
 		}
@@ -271,6 +662,14 @@ func (d *Device) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (in
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return -1, netdev.ErrInvalidSocketFd
+	}
+	if socket.link == -1 {
+		return -1, fmt.Errorf("Must Connect before Receiving")
+	}
+
 	var length = len(buf)
 
 	// Limit length read size to chunk large read requests
@@ -286,18 +685,22 @@ func (d *Device) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (in
 			}
 		}
 
-		n, err := d.ReadSocket(buf[:length])
+		n, err := d.ReadSocket(socket.link, buf[:length])
 		if err != nil {
 			return -1, err
 		}
-		if n == 0 {
-			d.mu.Unlock()
-			time.Sleep(100 * time.Millisecond)
-			d.mu.Lock()
-			continue
+		if n > 0 {
+			return n, nil
 		}
 
-		return n, nil
+		// Check if the connection was closed, once all data is read
+		if !d.links[socket.link].connected {
+			return -1, io.EOF
+		}
+
+		d.mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		d.mu.Lock()
 	}
 }
 
@@ -305,12 +708,204 @@ func (d *Device) Close(sockfd int) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	d.socket.inUse = false
-	return d.DisconnectSocket()
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	var err error
+	switch {
+	case socket.listening:
+		err = d.StopServer()
+		// Drop clients not accepted yet
+		for _, id := range d.accepted {
+			d.freeLink(id)
+		}
+		d.accepted = d.accepted[:0]
+	case socket.link != -1:
+		err = d.freeLink(socket.link)
+	}
+
+	delete(d.sockets, sockfd)
+	return err
+}
+
+// SetTLSConfig sets the SSL config of IPPROTO_TLS sockets. Certificates and
+// keys are written to the manufacturing data in the module flash, only when
+// they changed since the last call, so call it once rather than before each
+// connection. The AT firmware has no CAs of its own, so the server is only
+// verified with RootCAs: a config with just a ServerName only sends it by SNI.
+func (d *Device) SetTLSConfig(config *netdev.TLSConfig) error {
+	if config != nil {
+		if len(config.ServerFingerprint) > 0 {
+			return netdev.ErrNotSupported
+		}
+		if len(config.RootCAs) == 0 && !config.InsecureSkipVerify && config.ServerName == "" {
+			return netdev.ErrNotSupported
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Certificates and keys are stored in the manufacturing data
+	if config != nil {
+		mfg := []struct {
+			namespace string
+			data      []byte
+		}{
+			{MfgClientCert, config.Certificate},
+			{MfgClientKey, config.PrivateKey},
+			{MfgClientCA, config.RootCAs},
+		}
+		for _, m := range mfg {
+			if len(m.data) == 0 {
+				continue
+			}
+			if m.namespace == MfgClientCA && config.InsecureSkipVerify {
+				continue
+			}
+			crc := crc32.ChecksumIEEE(m.data)
+			if written, ok := d.mfgCRC[m.namespace]; ok && written == crc {
+				continue
+			}
+			if err := d.SetMfgData(m.namespace, m.namespace+".0", m.data); err != nil {
+				delete(d.mfgCRC, m.namespace)
+				return err
+			}
+			d.mfgCRC[m.namespace] = crc
+		}
+	}
+
+	d.tlsConfig = config
+	return nil
+}
+
+// configureSSL sets the SSL config of link ID id, before connecting
+func (d *Device) configureSSL(id int) error {
+	cfg := d.tlsConfig
+	if cfg == nil {
+		return nil
+	}
+	authMode := SSLAuthNone
+	if len(cfg.Certificate) > 0 && len(cfg.PrivateKey) > 0 {
+		authMode |= SSLAuthClient
+	}
+	if len(cfg.RootCAs) > 0 && !cfg.InsecureSkipVerify {
+		authMode |= SSLAuthServer
+	}
+	if err := d.SetSSLConfig(id, authMode); err != nil {
+		return err
+	}
+	if cfg.ServerName != "" {
+		return d.SetSSLServerName(id, cfg.ServerName)
+	}
+	return nil
+}
+
+func (d *Device) SockOptSupported(level int, opt int) bool {
+	switch level {
+	case netdev.SOL_SOCKET:
+		return opt == netdev.SO_KEEPALIVE || opt == netdev.SO_BROADCAST
+	case netdev.SOL_TCP:
+		return opt == netdev.TCP_NODELAY || opt == netdev.TCP_KEEPINTVL
+	}
+	return false
 }
 
 func (d *Device) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
-	return netdev.ErrNotSupported
+	if !d.SockOptSupported(level, opt) {
+		return netdev.ErrNotSupported
+	}
+	v, err := netdev.SockOptInt(value)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	switch {
+	case level == netdev.SOL_SOCKET && opt == netdev.SO_BROADCAST:
+		// UDP connections can always send to the broadcast address
+		return nil
+	case level == netdev.SOL_SOCKET && opt == netdev.SO_KEEPALIVE:
+		socket.keepAlive = v != 0
+	case opt == netdev.TCP_NODELAY:
+		socket.noDelay = v != 0
+	case opt == netdev.TCP_KEEPINTVL:
+		if v < 1 || v > maxKeepAlive {
+			return netdev.ErrInvalidSockOptValue
+		}
+		socket.keepIntvl = v
+	}
+
+	if socket.protocol == netdev.IPPROTO_UDP || socket.link == -1 {
+		return nil
+	}
+	return d.SetTCPOptions(socket.link, socket.noDelay, socket.keepAliveSecs())
+}
+
+// Keep-alive interval of the AT firmware, in seconds
+const (
+	defaultKeepAlive = 75
+	maxKeepAlive     = 7200
+)
+
+// keepAliveSecs returns the keep-alive interval of the socket, 0 if
+// disabled
+func (s *socket) keepAliveSecs() int {
+	switch {
+	case !s.keepAlive:
+		return 0
+	case s.keepIntvl == 0:
+		return defaultKeepAlive
+	}
+	return s.keepIntvl
+}
+
+// applySockOpts sets the TCP options of a newly connected socket, if not
+// the defaults
+func (d *Device) applySockOpts(socket *socket) error {
+	if socket.protocol == netdev.IPPROTO_UDP || (!socket.noDelay && !socket.keepAlive) {
+		return nil
+	}
+	return d.SetTCPOptions(socket.link, socket.noDelay, socket.keepAliveSecs())
+}
+
+// remoteAddr returns the remote address of a connection, from its
+// connection status
+func (d *Device) remoteAddr(id int) netip.AddrPort {
+	resp, err := d.GetConnectionStatus()
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	// +CIPSTATUS:<link ID>,<"type">,<"remote IP">,<remote port>,<local port>,<tetype>
+	prefix := "+CIPSTATUS:" + strconv.Itoa(id) + ","
+	for _, line := range strings.Split(string(resp), "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		fields := strings.Split(line[len(prefix):], ",")
+		if len(fields) < 3 {
+			break
+		}
+		ip, err := netip.ParseAddr(strings.Trim(fields[1], `"`))
+		if err != nil {
+			break
+		}
+		port, err := strconv.Atoi(fields[2])
+		if err != nil {
+			break
+		}
+		return netip.AddrPortFrom(ip, uint16(port))
+	}
+	return netip.AddrPort{}
 }
 
 // Connected checks if there is communication with the ESP8266/ESP32.
@@ -339,27 +934,27 @@ func (d *Device) Read(b []byte) (n int, err error) {
 const pause = 300
 
 // Execute sends an AT command to the ESP8266/ESP32.
-func (d Device) Execute(cmd string) error {
+func (d *Device) Execute(cmd string) error {
 	_, err := d.Write([]byte("AT" + cmd + "\r\n"))
 	return err
 }
 
 // Query sends an AT command to the ESP8266/ESP32 that returns the
 // current value for some configuration parameter.
-func (d Device) Query(cmd string) (string, error) {
+func (d *Device) Query(cmd string) (string, error) {
 	_, err := d.Write([]byte("AT" + cmd + "?\r\n"))
 	return "", err
 }
 
 // Set sends an AT command with params to the ESP8266/ESP32 for a
 // configuration value to be set.
-func (d Device) Set(cmd, params string) error {
+func (d *Device) Set(cmd, params string) error {
 	_, err := d.Write([]byte("AT" + cmd + "=" + params + "\r\n"))
 	return err
 }
 
 // Version returns the ESP8266/ESP32 firmware version info.
-func (d Device) Version() []byte {
+func (d *Device) Version() []byte {
 	d.Execute(Version)
 	r, err := d.Response(2000)
 	if err != nil {
@@ -370,7 +965,7 @@ func (d Device) Version() []byte {
 }
 
 // Echo sets the ESP8266/ESP32 echo setting.
-func (d Device) Echo(set bool) {
+func (d *Device) Echo(set bool) {
 	if set {
 		d.Execute(EchoConfigOn)
 	} else {
@@ -383,27 +978,33 @@ func (d Device) Echo(set bool) {
 // Reset restarts the ESP8266/ESP32 firmware. Due to how the baud rate changes,
 // this messes up communication with the ESP8266/ESP32 module. So make sure you know
 // what you are doing when you call this.
-func (d Device) Reset() {
+func (d *Device) Reset() {
 	d.Execute(Restart)
 	d.Response(100)
 }
 
-// ReadSocket returns the data that has already been read in from the responses.
-func (d *Device) ReadSocket(b []byte) (n int, err error) {
+// ReadSocket returns the data of a connection that has already been read in
+// from the responses.
+func (d *Device) ReadSocket(id int, b []byte) (n int, err error) {
+	if id < 0 || id >= maxLinks {
+		return 0, netdev.ErrInvalidSocketFd
+	}
+
 	// make sure no data in buffer
-	d.Response(300)
+	d.poll()
 
+	l := &d.links[id]
 	count := len(b)
-	if len(b) >= len(d.data) {
+	if len(b) >= len(l.data) {
 		// copy it all, then clear socket data
-		count = len(d.data)
-		copy(b, d.data[:count])
-		d.data = d.data[:0]
+		count = len(l.data)
+		copy(b, l.data[:count])
+		l.data = l.data[:0]
 	} else {
 		// copy all we can, then keep the remaining socket data around
-		copy(b, d.data[:count])
-		copy(d.data, d.data[count:])
-		d.data = d.data[:len(d.data)-count]
+		copy(b, l.data[:count])
+		copy(l.data, l.data[count:])
+		l.data = l.data[:len(l.data)-count]
 	}
 
 	return count, nil
@@ -411,73 +1012,186 @@ func (d *Device) ReadSocket(b []byte) (n int, err error) {
 
 // Response gets the next response bytes from the ESP8266/ESP32.
 // The call will retry for up to timeout milliseconds before returning nothing.
+// Socket data and connection events received meanwhile are not part of
+// the response, they are kept for their connection.
 func (d *Device) Response(timeout int) ([]byte, error) {
-	// read data
-	var size int
-	var start, end int
 	pause := 100 // pause to wait for 100 ms
 	retries := timeout / pause
 
+	end := copy(d.response, d.rest)
 	for {
-		size = d.uart.Buffered()
-
-		if size > 0 {
-			end += size
-			d.uart.Read(d.response[start:end])
-
-			// if "+IPD" then read socket data
-			if strings.Contains(string(d.response[:end]), "+IPD") {
-				// handle socket data
-				return nil, d.parseIPD(end)
-			}
+		var limit int
+		end, limit = d.receive(end)
+		resp := d.response[:limit]
+		d.rest = append(d.rest[:0], d.response[limit:end]...)
+
+		// if "OK" then the command worked
+		if strings.Contains(string(resp), "OK") {
+			return resp, nil
+		}
 
-			// if "OK" then the command worked
-			if strings.Contains(string(d.response[:end]), "OK") {
-				return d.response[start:end], nil
-			}
+		// if "Error" then the command failed
+		if strings.Contains(string(resp), "ERROR") {
+			return resp, errors.New("response error:" + string(resp))
+		}
 
-			// if "Error" then the command failed
-			if strings.Contains(string(d.response[:end]), "ERROR") {
-				return d.response[start:end], errors.New("response error:" + string(d.response[start:end]))
+		// if anything else, then keep reading data in?
+		if end == len(d.response) {
+			// Full without a response: drop all but incomplete socket data
+			end = copy(d.response, d.rest)
+			if end == len(d.response) {
+				end = 0
 			}
-
-			// if anything else, then keep reading data in?
-			start = end
 		}
 
 		// wait longer?
 		retries--
-		if retries == 0 {
-			return nil, errors.New("response timeout error:" + string(d.response[start:end]))
+		if retries <= 0 {
+			return nil, errors.New("response timeout error:" + string(resp))
 		}
 
 		time.Sleep(time.Duration(pause) * time.Millisecond)
 	}
 }
 
-func (d *Device) parseIPD(end int) error {
-	// find the "+IPD," to get length
-	s := strings.Index(string(d.response[:end]), "+IPD,")
+// poll reads the bytes already sent by the ESP8266/ESP32 without waiting for
+// a response, to get socket data and connection events
+func (d *Device) poll() {
+	end := copy(d.response, d.rest)
+	end, limit := d.receive(end)
+	d.rest = append(d.rest[:0], d.response[limit:end]...)
+}
 
-	// find the ":"
-	e := strings.Index(string(d.response[:end]), ":")
+// receive reads the bytes available from the UART after d.response[:end],
+// then moves out socket data and connection events. It returns the new end
+// of the response, and the start of an incomplete +IPD header, or end.
+func (d *Device) receive(end int) (int, int) {
+	for end < len(d.response) {
+		size := d.uart.Buffered()
+		if size == 0 {
+			break
+		}
+		if size > len(d.response)-end {
+			size = len(d.response) - end
+		}
+		n, _ := d.uart.Read(d.response[end : end+size])
+		end += d.pendingIPD(end, n)
+	}
 
-	// find the data length
-	val := string(d.response[s+5 : e])
+	end, limit := d.parseIPD(end)
+	return d.parseLinkEvents(end, limit)
+}
 
-	// TODO: verify count
-	v, err := strconv.Atoi(val)
-	if err != nil {
-		// not expected data here. what to do?
-		return err
+// parseIPD moves the data of +IPD messages in d.response[:end] to their
+// link. It returns the new end of the response, and the start of an
+// incomplete +IPD header, or end.
+func (d *Device) parseIPD(end int) (int, int) {
+	for {
+		// find the "+IPD,"
+		s := strings.Index(string(d.response[:end]), "+IPD,")
+		if s < 0 {
+			return end, end
+		}
+
+		// find the ":"
+		e := strings.IndexByte(string(d.response[s:end]), ':')
+		if e < 0 {
+			return end, s
+		}
+		e += s
+
+		// +IPD,<link ID>,<len>[,<remote IP>,<remote port>]:<data>
+		// or +IPD,<len>:<data> in single connection mode
+		fields := strings.Split(string(d.response[s+5:e]), ",")
+		id, v := 0, -1
+		var err error
+		if len(fields) == 1 {
+			v, err = strconv.Atoi(fields[0])
+		} else if id, err = strconv.Atoi(fields[0]); err == nil {
+			v, err = strconv.Atoi(fields[1])
+		}
+		if err != nil || id < 0 || id >= maxLinks || v < 0 {
+			// not expected data here, drop the header
+			copy(d.response[s:], d.response[e+1:end])
+			end -= e + 1 - s
+			continue
+		}
+		if e+1+v > end {
+			// the data straddles the end of what was read: move out what
+			// is there, receive reads the rest straight into the link
+			d.links[id].data = append(d.links[id].data, d.response[e+1:end]...)
+			d.ipdLink, d.ipdRemaining = id, e+1+v-end
+			return s, s
+		}
+
+		// load up the socket data
+		d.links[id].data = append(d.links[id].data, d.response[e+1:e+1+v]...)
+		end -= e + 1 + v - s
+		copy(d.response[s:], d.response[e+1+v:end+e+1+v-s])
 	}
+}
 
-	// load up the socket data
-	d.data = append(d.data, d.response[e+1:e+1+v]...)
-	return nil
+// pendingIPD moves the data of an incomplete +IPD message from the n bytes
+// just read at d.response[end:] to its link. It returns the count of bytes
+// left in the response.
+func (d *Device) pendingIPD(end, n int) int {
+	m := n
+	if m > d.ipdRemaining {
+		m = d.ipdRemaining
+	}
+	if m == 0 {
+		return n
+	}
+	d.links[d.ipdLink].data = append(d.links[d.ipdLink].data, d.response[end:end+m]...)
+	d.ipdRemaining -= m
+	copy(d.response[end:], d.response[end+m:end+n])
+	return n - m
+}
+
+// parseLinkEvents handles "<link ID>,CONNECT" and "<link ID>,CLOSED" events
+// in d.response[:limit]. It returns the new end of the response and limit.
+func (d *Device) parseLinkEvents(end, limit int) (int, int) {
+	for {
+		resp := string(d.response[:limit])
+		i := strings.Index(resp, ",CONNECT\r\n")
+		l := len(",CONNECT\r\n")
+		connected := true
+		if c := strings.Index(resp, ",CLOSED\r\n"); c >= 0 && (i < 0 || c < i) {
+			i, l, connected = c, len(",CLOSED\r\n"), false
+		}
+		if i < 1 {
+			return end, limit
+		}
+
+		id := int(d.response[i-1] - '0')
+		if id >= 0 && id < maxLinks {
+			d.linkEvent(id, connected)
+		}
+
+		// remove the event from the response
+		copy(d.response[i-1:], d.response[i+l:end])
+		end -= l + 1
+		limit -= l + 1
+	}
+}
+
+// linkEvent updates the state of a connection. Connections to the TCP
+// server are queued for Accept.
+func (d *Device) linkEvent(id int, connected bool) {
+	l := &d.links[id]
+	if connected && !l.inUse && d.listening() {
+		l.inUse = true
+		d.accepted = append(d.accepted, id)
+	}
+	l.connected = connected
 }
 
 // IsSocketDataAvailable returns of there is socket data available
 func (d *Device) IsSocketDataAvailable() bool {
-	return len(d.data) > 0 || d.uart.Buffered() > 0
+	for i := range d.links {
+		if len(d.links[i].data) > 0 {
+			return true
+		}
+	}
+	return len(d.rest) > 0 || d.uart.Buffered() > 0
 }
@@ -32,11 +32,17 @@ func (d *Device) GetDNS(domain string) (string, error) {
 	return strings.Trim(res[0], `"`), nil
 }
 
-// ConnectTCPSocket creates a new TCP socket connection for the ESP8266/ESP32.
-// Currently only supports single connection mode.
+// ConnectTCPSocket creates a new TCP socket connection for the ESP8266/ESP32,
+// using link ID 0 of the multiple connections mode.
 func (d *Device) ConnectTCPSocket(addr, port string) error {
+	return d.ConnectTCPSocketID(0, addr, port)
+}
+
+// ConnectTCPSocketID creates a new TCP socket connection for the ESP8266/ESP32,
+// using link ID id of the multiple connections mode.
+func (d *Device) ConnectTCPSocketID(id int, addr, port string) error {
 	protocol := "TCP"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
+	val := strconv.Itoa(id) + ",\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
 	err := d.Set(TCPConnect, val)
 	if err != nil {
 		return err
@@ -48,10 +54,17 @@ func (d *Device) ConnectTCPSocket(addr, port string) error {
 	return nil
 }
 
-// ConnectUDPSocket creates a new UDP connection for the ESP8266/ESP32.
+// ConnectUDPSocket creates a new UDP connection for the ESP8266/ESP32,
+// using link ID 0 of the multiple connections mode.
 func (d *Device) ConnectUDPSocket(addr, sendport, listenport string) error {
+	return d.ConnectUDPSocketID(0, addr, sendport, listenport)
+}
+
+// ConnectUDPSocketID creates a new UDP connection for the ESP8266/ESP32,
+// using link ID id of the multiple connections mode.
+func (d *Device) ConnectUDPSocketID(id int, addr, sendport, listenport string) error {
 	protocol := "UDP"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + sendport + "," + listenport + ",0"
+	val := strconv.Itoa(id) + ",\"" + protocol + "\",\"" + addr + "\"," + sendport + "," + listenport + ",0"
 	err := d.Set(TCPConnect, val)
 	if err != nil {
 		return err
@@ -63,11 +76,17 @@ func (d *Device) ConnectUDPSocket(addr, sendport, listenport string) error {
 	return nil
 }
 
-// ConnectSSLSocket creates a new SSL socket connection for the ESP8266/ESP32.
-// Currently only supports single connection mode.
+// ConnectSSLSocket creates a new SSL socket connection for the ESP8266/ESP32,
+// using link ID 0 of the multiple connections mode.
 func (d *Device) ConnectSSLSocket(addr, port string) error {
+	return d.ConnectSSLSocketID(0, addr, port)
+}
+
+// ConnectSSLSocketID creates a new SSL socket connection for the ESP8266/ESP32,
+// using link ID id of the multiple connections mode.
+func (d *Device) ConnectSSLSocketID(id int, addr, port string) error {
 	protocol := "SSL"
-	val := "\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
+	val := strconv.Itoa(id) + ",\"" + protocol + "\",\"" + addr + "\"," + port + ",120"
 	d.Set(TCPConnect, val)
 	// this operation takes longer, so wait up to 6 seconds to complete.
 	_, err := d.Response(6000)
@@ -77,9 +96,73 @@ func (d *Device) ConnectSSLSocket(addr, port string) error {
 	return nil
 }
 
-// DisconnectSocket disconnects the ESP8266/ESP32 from the current TCP/UDP connection.
+// SSL client authentication modes of SetSSLConfig
+const (
+	SSLAuthNone   = 0
+	SSLAuthClient = 1 // client provides its certificate
+	SSLAuthServer = 2 // client verifies the server certificate
+)
+
+// SetSSLConfig sets the authentication mode of the SSL connection with link
+// ID id, made afterwards. Certificates and keys are the ones of the
+// manufacturing data, see SetMfgData.
+func (d *Device) SetSSLConfig(id, authMode int) error {
+	val := strconv.Itoa(id) + "," + strconv.Itoa(authMode)
+	if authMode != SSLAuthNone {
+		// <PKI number>,<CA number>
+		val += ",0,0"
+	}
+	d.Set(SSLConfig, val)
+	_, err := d.Response(pause)
+	return err
+}
+
+// SetSSLServerName sets the Server Name Indication of the SSL connection
+// with link ID id, made afterwards.
+func (d *Device) SetSSLServerName(id int, name string) error {
+	d.Set(SSLServerName, strconv.Itoa(id)+",\""+name+"\"")
+	_, err := d.Response(pause)
+	return err
+}
+
+// Manufacturing data namespaces and keys of SSL certificates and keys
+const (
+	MfgClientCA   = "client_ca"
+	MfgClientCert = "client_cert"
+	MfgClientKey  = "client_key"
+)
+
+// SetMfgData writes binary manufacturing data, like certificates, of the
+// ESP32 to its flash. The key is the namespace followed by the index, as
+// "client_ca.0". It requires an ESP-AT firmware version 3 or later.
+func (d *Device) SetMfgData(namespace, key string, data []byte) error {
+	// <operation>,<namespace>,<key>,<type>,<length>: write, binary
+	val := "2,\"" + namespace + "\",\"" + key + "\",8," + strconv.Itoa(len(data))
+	d.Set(SystemMfg, val)
+
+	// ">" is received when ready to receive data
+	r, err := d.Response(2000)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(r), ">") {
+		return errors.New("SetMfgData error:" + string(r))
+	}
+	if _, err := d.Write(data); err != nil {
+		return err
+	}
+	_, err = d.Response(2000)
+	return err
+}
+
+// DisconnectSocket closes the TCP/UDP connection with link ID 0.
 func (d *Device) DisconnectSocket() error {
-	err := d.Execute(TCPClose)
+	return d.DisconnectSocketID(0)
+}
+
+// DisconnectSocketID closes the TCP/UDP connection with link ID id.
+func (d *Device) DisconnectSocketID(id int) error {
+	err := d.Set(TCPClose, strconv.Itoa(id))
 	if err != nil {
 		return err
 	}
@@ -90,8 +173,23 @@ func (d *Device) DisconnectSocket() error {
 	return nil
 }
 
+// SetTCPOptions sets the socket options of the TCP connection with link ID
+// id: TCP_NODELAY, and the interval in seconds of TCP keep-alive probes,
+// 0 disabling them.
+func (d *Device) SetTCPOptions(id int, noDelay bool, keepAlive int) error {
+	nd := "0"
+	if noDelay {
+		nd = "1"
+	}
+	// <link ID>,<so_linger>,<tcp_nodelay>,<so_sndtimeo>,<keep_alive>
+	val := strconv.Itoa(id) + ",-1," + nd + ",0," + strconv.Itoa(keepAlive)
+	d.Set(TCPOptions, val)
+	_, err := d.Response(pause)
+	return err
+}
+
 // SetMux sets the ESP8266/ESP32 current client TCP/UDP configuration for concurrent connections
-// either single TCPMuxSingle or multiple TCPMuxMultiple (up to 4).
+// either single TCPMuxSingle or multiple TCPMuxMultiple (up to 5).
 func (d *Device) SetMux(mode int) error {
 	val := strconv.Itoa(mode)
 	d.Set(TCPMultiple, val)
@@ -105,6 +203,29 @@ func (d *Device) GetMux() ([]byte, error) {
 	return d.Response(pause)
 }
 
+// StartServer starts the TCP server of the ESP8266/ESP32 on port. Multiple
+// connections mode must be set. Clients get their own link ID, reported by
+// a "<link ID>,CONNECT" event.
+func (d *Device) StartServer(port string) error {
+	d.Set(ServerConfig, "1,"+port)
+	_, err := d.Response(pause)
+	return err
+}
+
+// StopServer stops the TCP server of the ESP8266/ESP32.
+func (d *Device) StopServer() error {
+	d.Set(ServerConfig, "0")
+	_, err := d.Response(pause)
+	return err
+}
+
+// GetConnectionStatus returns the ESP8266/ESP32 connections status, one
+// "+CIPSTATUS:" line per connection.
+func (d *Device) GetConnectionStatus() ([]byte, error) {
+	d.Execute(TCPStatus)
+	return d.Response(pause)
+}
+
 // SetTCPTransferMode sets the ESP8266/ESP32 current client TCP/UDP transfer mode.
 // Either TCPTransferModeNormal or TCPTransferModeUnvarnished.
 func (d *Device) SetTCPTransferMode(mode int) error {
@@ -120,9 +241,16 @@ func (d *Device) GetTCPTransferMode() ([]byte, error) {
 	return d.Response(pause)
 }
 
-// StartSocketSend gets the ESP8266/ESP32 ready to receive TCP/UDP socket data.
+// StartSocketSend gets the ESP8266/ESP32 ready to receive TCP/UDP socket data
+// for the connection with link ID 0.
 func (d *Device) StartSocketSend(size int) error {
-	val := strconv.Itoa(size)
+	return d.StartSocketSendID(0, size)
+}
+
+// StartSocketSendID gets the ESP8266/ESP32 ready to receive TCP/UDP socket data
+// for the connection with link ID id.
+func (d *Device) StartSocketSendID(id, size int) error {
+	val := strconv.Itoa(id) + "," + strconv.Itoa(size)
 	d.Set(TCPSend, val)
 
 	// when ">" is received, it indicates
@@ -2,6 +2,7 @@ package espat
 
 import (
 	"strconv"
+	"strings"
 )
 
 const (
@@ -47,6 +48,12 @@ func (d *Device) ConnectToAP(ssid, pwd string, ws int) error {
 	return err
 }
 
+// ListAccessPoints returns the access points in range of the ESP8266/ESP32.
+func (d *Device) ListAccessPoints() ([]byte, error) {
+	d.Execute(ListAP)
+	return d.Response(10000)
+}
+
 // DisconnectFromAP disconnects the ESP8266/ESP32 from the current access point.
 func (d *Device) DisconnectFromAP() error {
 	d.Execute(Disconnect)
@@ -62,9 +69,47 @@ func (d *Device) GetClientIP() (string, error) {
 }
 
 // SetClientIP sets the ESP8266/ESP32 current client IP addess when connected to an Access Point.
+// It disables DHCP.
 func (d *Device) SetClientIP(ipaddr string) error {
 	val := "\"" + ipaddr + "\""
-	d.Set(ConnectAP, val)
+	d.Set(SetStationIP, val)
+	_, err := d.Response(500)
+	return err
+}
+
+// SetClientIPConfig sets the ESP8266/ESP32 client IP addess, gateway and
+// netmask. It disables DHCP.
+func (d *Device) SetClientIPConfig(ipaddr, gateway, netmask string) error {
+	val := "\"" + ipaddr + "\",\"" + gateway + "\",\"" + netmask + "\""
+	d.Set(SetStationIP, val)
+	_, err := d.Response(500)
+	return err
+}
+
+// EnableClientDHCP enables the DHCP client of the ESP8266/ESP32 station.
+func (d *Device) EnableClientDHCP() error {
+	// <operate>,<mode>: enable, for station
+	d.Set(DHCPConfig, "1,1")
+	_, err := d.Response(500)
+	return err
+}
+
+// SetDNS sets the DNS servers, up to 2, used by the ESP8266/ESP32. With
+// no servers, the DNS servers are the ones assigned by DHCP.
+func (d *Device) SetDNS(servers ...string) error {
+	if len(servers) == 0 {
+		d.Set(DNSConfig, "0")
+	} else {
+		d.Set(DNSConfig, "1,\""+strings.Join(servers, "\",\"")+"\"")
+	}
+	_, err := d.Response(500)
+	return err
+}
+
+// SetHostname sets the host name of the ESP8266/ESP32 station, sent to the
+// DHCP server. Station mode must be enabled.
+func (d *Device) SetHostname(hostname string) error {
+	d.Set(SetHostname, "\""+hostname+"\"")
 	_, err := d.Response(500)
 	return err
 }
@@ -48,10 +48,18 @@ func main() {
 	lorawan.UseRadio(radio)
 
 	switch reg {
+	case "AS923":
+		lorawan.UseRegionSettings(region.AS923(region.AS923_GROUP_1))
 	case "AU915":
 		lorawan.UseRegionSettings(region.AU915())
+	case "CN470":
+		lorawan.UseRegionSettings(region.CN470())
 	case "EU868":
 		lorawan.UseRegionSettings(region.EU868())
+	case "IN865":
+		lorawan.UseRegionSettings(region.IN865())
+	case "KR920":
+		lorawan.UseRegionSettings(region.KR920())
 	case "US915":
 		lorawan.UseRegionSettings(region.US915())
 	default:
@@ -71,10 +71,18 @@ func main() {
 	// Connect the lorawan with the Lora Radio device.
 	lorawan.UseRadio(radio)
 	switch reg {
+	case "AS923":
+		lorawan.UseRegionSettings(region.AS923(region.AS923_GROUP_1))
 	case "AU915":
 		lorawan.UseRegionSettings(region.AU915())
+	case "CN470":
+		lorawan.UseRegionSettings(region.CN470())
 	case "EU868":
 		lorawan.UseRegionSettings(region.EU868())
+	case "IN865":
+		lorawan.UseRegionSettings(region.IN865())
+	case "KR920":
+		lorawan.UseRegionSettings(region.KR920())
 	case "US915":
 		lorawan.UseRegionSettings(region.US915())
 	default:
@@ -111,6 +119,14 @@ func main() {
 			println("Uplink error:", err)
 		} else {
 			println("Uplink success, msg=", payload)
+
+			// Class A: the network may answer right after our uplink
+			fPort, data, err := lorawan.ListenDownlink(session)
+			if err != nil {
+				println("Downlink error:", err)
+			} else if data != nil {
+				println("Downlink received, port=", fPort, "msg=", string(data))
+			}
 		}
 
 		println("Sleeping for", LORAWAN_UPLINK_DELAY_SEC, "sec")
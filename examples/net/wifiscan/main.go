@@ -0,0 +1,47 @@
+// This example lists the Wifi networks in range, every 10 seconds.
+
+//go:build ninafw || wioterminal || challenger_rp2040
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"machine"
+	"time"
+
+	"tinygo.org/x/drivers/netlink"
+	"tinygo.org/x/drivers/netlink/probe"
+)
+
+func main() {
+
+	waitSerial()
+
+	link, _ := probe.Probe()
+
+	scanner, ok := link.(netlink.Scanner)
+	if !ok {
+		log.Fatal("device can't scan for Wifi networks")
+	}
+
+	for {
+		networks, err := scanner.NetScan()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%-32s %-17s %4s %3s %s\r\n", "SSID", "BSSID", "RSSI", "CH", "AUTH")
+		for _, n := range networks {
+			fmt.Printf("%-32s %-17s %4d %3d %d\r\n", n.Ssid, n.Bssid, n.Rssi, n.Channel, n.AuthType)
+		}
+		fmt.Printf("\r\n")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// Wait for user to open serial console
+func waitSerial() {
+	for !machine.Serial.DTR() {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,63 @@
+// This example advertises a Heart Rate service on the Wio Terminal, and
+// notifies a made up heart rate every second.
+package main
+
+import (
+	"machine"
+	"time"
+
+	"tinygo.org/x/drivers/rtl8720dn"
+)
+
+func main() {
+	rtl := rtl8720dn.New(&rtl8720dn.Config{
+		En:       machine.RTL8720D_CHIP_PU,
+		Uart:     machine.UART3,
+		Tx:       machine.PB24,
+		Rx:       machine.PC24,
+		Baudrate: 614400,
+	})
+
+	adapter := rtl.BLE()
+	must("enable BLE", adapter.Enable())
+
+	adapter.SetConnectHandler(func(device rtl8720dn.Device, connected bool) {
+		println("connected:", connected, device.Address.String())
+	})
+
+	var heartRate rtl8720dn.Characteristic
+	must("add service", adapter.AddService(&rtl8720dn.Service{
+		UUID: rtl8720dn.New16BitUUID(0x180D),
+		Characteristics: []rtl8720dn.CharacteristicConfig{
+			{
+				Handle: &heartRate,
+				UUID:   rtl8720dn.New16BitUUID(0x2A37),
+				Value:  []byte{0, 75},
+				Flags:  rtl8720dn.CharacteristicNotifyPermission | rtl8720dn.CharacteristicReadPermission,
+			},
+		},
+	}))
+
+	adv := adapter.DefaultAdvertisement()
+	must("configure advertisement", adv.Configure(rtl8720dn.AdvertisementOptions{
+		LocalName:    "Wio Terminal",
+		ServiceUUIDs: []rtl8720dn.UUID{rtl8720dn.New16BitUUID(0x180D)},
+	}))
+	must("start advertising", adv.Start())
+
+	bpm := uint8(75)
+	for {
+		time.Sleep(time.Second)
+		bpm = 70 + (bpm+3)%20
+		heartRate.Write([]byte{0, bpm})
+	}
+}
+
+func must(action string, err error) {
+	if err != nil {
+		for {
+			println("failed to " + action + ": " + err.Error())
+			time.Sleep(time.Second)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+
+	"tinygo.org/x/drivers/pixel"
+)
+
+var (
+	black = pixel.NewRGB888(0, 0, 0)
+	white = pixel.NewRGB888(0xff, 0xff, 0xff)
+	red   = pixel.NewRGB888(0xff, 0, 0)
+	green = pixel.NewRGB888(0, 0xff, 0)
+	blue  = pixel.NewRGB888(0, 0, 0xff)
+	grey  = pixel.NewRGB888(0x80, 0x80, 0x80) // Not drawn by the decoder
+)
+
+// palette is black, red, green, blue, white, as BGRX
+var palette = []byte{
+	0, 0, 0, 0,
+	0, 0, 0xff, 0,
+	0, 0xff, 0, 0,
+	0xff, 0, 0, 0,
+	0xff, 0xff, 0xff, 0,
+}
+
+// bmpFile returns a BMP file with an info header. extra is written after
+// the header, before the color table.
+func bmpFile(w, h, bpp, compression int, extra, palette, pix []byte) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	offset := 14 + 40 + len(extra) + len(palette)
+	buf.WriteString("BM")
+	binary.Write(&buf, le, uint32(offset+len(pix)))
+	binary.Write(&buf, le, uint32(0))
+	binary.Write(&buf, le, uint32(offset))
+	binary.Write(&buf, le, []int32{40, int32(w), int32(h)})
+	binary.Write(&buf, le, []uint16{1, uint16(bpp)})
+	binary.Write(&buf, le, []uint32{uint32(compression), uint32(len(pix)), 2835, 2835, uint32(len(palette) / 4), 0})
+	buf.Write(extra)
+	buf.Write(palette)
+	buf.Write(pix)
+	return buf.Bytes()
+}
+
+// decodeTest decodes data onto a grey image of the given size.
+func decodeTest(t *testing.T, data []byte, w, h int) pixel.Image[pixel.RGB888] {
+	img := pixel.NewImage[pixel.RGB888](w, h)
+	img.FillSolidColor(grey)
+	d := NewDecoder(func(part pixel.Image[pixel.RGB888], x, y, width, height int16) {
+		if int(width) != w || int(height) != h {
+			t.Fatalf("got image size %dx%d, want %dx%d", width, height, w, h)
+		}
+		pw, ph := part.Size()
+		for j := 0; j < ph; j++ {
+			for i := 0; i < pw; i++ {
+				img.Set(int(x)+i, int(y)+j, part.Get(i, j))
+			}
+		}
+	})
+	if err := d.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func checkImage(t *testing.T, name string, img pixel.Image[pixel.RGB888], want [][]pixel.RGB888) {
+	t.Helper()
+	for y := range want {
+		for x := range want[y] {
+			if got := img.Get(x, y); got != want[y][x] {
+				t.Errorf("%s: pixel %d,%d is %v, want %v", name, x, y, got, want[y][x])
+			}
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want [][]pixel.RGB888
+	}{{
+		// Bottom-up, rows padded to 4 bytes
+		name: "24-bit",
+		data: bmpFile(3, 2, 24, biRGB, nil, nil, []byte{
+			0xff, 0xff, 0xff, 0, 0, 0, 0xff, 0, 0, 0, 0, 0,
+			0, 0, 0xff, 0, 0xff, 0, 0xff, 0, 0, 0, 0, 0,
+		}),
+		want: [][]pixel.RGB888{{red, green, blue}, {white, black, blue}},
+	}, {
+		name: "32-bit",
+		data: bmpFile(2, 1, 32, biRGB, nil, nil, []byte{0, 0, 0xff, 0, 0xff, 0, 0, 0}),
+		want: [][]pixel.RGB888{{red, blue}},
+	}, {
+		// 5-5-5
+		name: "16-bit",
+		data: bmpFile(2, 1, 16, biRGB, nil, nil, []byte{0x00, 0x7C, 0x1F, 0x00}),
+		want: [][]pixel.RGB888{{red, blue}},
+	}, {
+		// 5-6-5 bit fields
+		name: "16-bit bitfields",
+		data: bmpFile(2, 1, 16, biBitfields, []byte{0, 0xF8, 0, 0, 0xE0, 0x07, 0, 0, 0x1F, 0, 0, 0}, nil, []byte{0xE0, 0x07, 0xFF, 0xFF}),
+		want: [][]pixel.RGB888{{green, white}},
+	}, {
+		// Top-down
+		name: "8-bit",
+		data: bmpFile(2, -2, 8, biRGB, nil, palette, []byte{1, 2, 0, 0, 3, 4, 0, 0}),
+		want: [][]pixel.RGB888{{red, green}, {blue, white}},
+	}, {
+		name: "4-bit",
+		data: bmpFile(3, 1, 4, biRGB, nil, palette, []byte{0x12, 0x30, 0, 0}),
+		want: [][]pixel.RGB888{{red, green, blue}},
+	}, {
+		name: "1-bit",
+		data: bmpFile(10, 1, 1, biRGB, nil, palette[:8], []byte{0b10100000, 0b01000000, 0, 0}),
+		want: [][]pixel.RGB888{{red, black, red, black, black, black, black, black, black, red}},
+	}, {
+		// Bottom-up: a run of 2 red, then absolute green, blue, blue cut at
+		// the edge, end of line, a delta of 1, 1, a white pixel and end of
+		// bitmap. Skipped pixels aren't drawn.
+		name: "RLE8",
+		data: bmpFile(4, 3, 8, biRLE8, nil, palette, []byte{
+			2, 1, 0, 3, 2, 3, 3, 0, 0, 0,
+			0, 2, 1, 1, 1, 4, 0, 1,
+		}),
+		want: [][]pixel.RGB888{{grey, white, grey, grey}, {grey, grey, grey, grey}, {red, red, green, blue}},
+	}, {
+		// A run of 3 alternating red and green, then absolute blue, white,
+		// black
+		name: "RLE4",
+		data: bmpFile(6, 1, 4, biRLE4, nil, palette, []byte{3, 0x12, 0, 3, 0x34, 0x00, 0, 1}),
+		want: [][]pixel.RGB888{{red, green, red, blue, white, black}},
+	}} {
+		w, h := len(tc.want[0]), len(tc.want)
+		checkImage(t, tc.name, decodeTest(t, tc.data, w, h), tc.want)
+	}
+}
+
+func TestDecoderRLEDelta(t *testing.T) {
+	// Red on the last row, a delta of 1, 1, then green on the first row
+	data := bmpFile(3, 2, 8, biRLE8, nil, palette, []byte{1, 1, 0, 2, 1, 1, 1, 2, 0, 1})
+	checkImage(t, "RLE8 delta", decodeTest(t, data, 3, 2), [][]pixel.RGB888{
+		{grey, grey, green},
+		{red, grey, grey},
+	})
+}
+
+func TestSetCallback(t *testing.T) {
+	var got []uint16
+	SetCallback(make([]uint16, 4), func(data []uint16, x, y, w, h, width, height int16) {
+		got = append(got, data[:w*h]...)
+	})
+	defer SetCallback(nil, func(data []uint16, x, y, w, h, width, height int16) {})
+
+	data := bmpFile(2, -2, 8, biRGB, nil, palette, []byte{1, 2, 0, 0, 3, 4, 0, 0})
+	if _, err := Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{0xF800, 0x07E0, 0x001F, 0xFFFF}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("got %#04x, want %#04x", got, want)
+		}
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 2 || cfg.Height != 2 || len(cfg.ColorModel.(color.Palette)) != 5 {
+		t.Errorf("got config %+v", cfg)
+	}
+
+	if _, err := Decode(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("decoded a truncated image")
+	}
+}
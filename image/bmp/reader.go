@@ -0,0 +1,424 @@
+// Package bmp implements a streaming BMP decoder that uses little RAM. As in
+// the png and jpeg packages, the image is passed to a callback a row at a
+// time instead of being returned as an image.Image.
+//
+// 1, 2, 4, 8, 16, 24 and 32-bit images are supported, uncompressed, with bit
+// fields, or RLE compressed.
+package bmp
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+// Compression methods
+const (
+	biRGB            = 0
+	biRLE8           = 1
+	biRLE4           = 2
+	biBitfields      = 3
+	biAlphaBitfields = 6
+)
+
+const (
+	fileHeaderLen = 14
+	coreHeaderLen = 12
+	infoHeaderLen = 40
+)
+
+var (
+	errNotBMP      = errors.New("bmp: can't recognize format")
+	errUnsupported = errors.New("bmp: unsupported BMP image")
+	errBadHeader   = errors.New("bmp: invalid header")
+	errBadPixel    = errors.New("bmp: invalid pixel value")
+)
+
+// bitfield extracts a color channel from a 16 or 32-bit pixel.
+type bitfield struct {
+	shift, bits uint8
+}
+
+func newBitfield(mask uint32) (bitfield, bool) {
+	shift := bits.TrailingZeros32(mask)
+	n := bits.OnesCount32(mask)
+	if mask != 0 && mask>>shift != 1<<n-1 {
+		// Not contiguous
+		return bitfield{}, false
+	}
+	return bitfield{uint8(shift % 32), uint8(n)}, true
+}
+
+// value returns the channel of pixel p, scaled to 8 bits.
+func (f bitfield) value(p uint32) uint8 {
+	v := p >> f.shift & (1<<f.bits - 1)
+	switch {
+	case f.bits == 0:
+		return 0
+	case f.bits >= 8:
+		return uint8(v >> (f.bits - 8))
+	}
+	return uint8(v * 255 / (1<<f.bits - 1))
+}
+
+type decoder struct {
+	r   io.Reader
+	out output
+	off int // Bytes read from r
+
+	width, height int
+	topDown       bool
+	bpp           int
+	compression   uint32
+	palette       []byte // As RGB
+	masks         [3]bitfield
+
+	row        []byte // A row of pixels, as stored, or color indices for RLE
+	set        []bool // The pixels of the row set by RLE
+	rgb        []byte // RGB pixels of a row
+	tmp        [256 * 4]byte
+	paletteBuf [256 * 3]byte
+}
+
+func (d *decoder) readFull(p []byte) error {
+	n, err := io.ReadFull(d.r, p)
+	d.off += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func le16(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// readHeader reads the headers and color table, up to the pixels.
+func (d *decoder) readHeader() error {
+	if err := d.readFull(d.tmp[:fileHeaderLen]); err != nil {
+		return err
+	}
+	if d.tmp[0] != 'B' || d.tmp[1] != 'M' {
+		return errNotBMP
+	}
+	pixOffset := int(le32(d.tmp[10:]))
+
+	// The DIB header starts with its size
+	if err := d.readFull(d.tmp[:4]); err != nil {
+		return err
+	}
+	headerLen := int(le32(d.tmp[:]))
+	if headerLen < coreHeaderLen || headerLen > len(d.tmp)-16 {
+		return errBadHeader
+	}
+	h := d.tmp[:headerLen]
+	if err := d.readFull(h[4:]); err != nil {
+		return err
+	}
+
+	paletteEntryLen := 4
+	var planes, clrUsed uint32
+	if headerLen == coreHeaderLen {
+		// OS/2 BITMAPCOREHEADER
+		d.width, d.height = int(le16(h[4:])), int(le16(h[6:]))
+		planes, d.bpp = le16(h[8:]), int(le16(h[10:]))
+		paletteEntryLen = 3
+	} else {
+		if headerLen < infoHeaderLen {
+			return errBadHeader
+		}
+		d.width, d.height = int(int32(le32(h[4:]))), int(int32(le32(h[8:])))
+		planes, d.bpp = le16(h[12:]), int(le16(h[14:]))
+		d.compression = le32(h[16:])
+		clrUsed = le32(h[32:])
+	}
+	if d.height < 0 {
+		d.height, d.topDown = -d.height, true
+	}
+	if planes != 1 || d.width <= 0 || d.height == 0 {
+		return errBadHeader
+	}
+	if d.width > 0x7FFF || d.height > 0x7FFF {
+		return errUnsupported
+	}
+
+	switch {
+	case d.compression == biRGB && (d.bpp == 1 || d.bpp == 2 || d.bpp == 4 || d.bpp == 8 || d.bpp == 24):
+	case d.compression == biRGB && d.bpp == 16:
+		d.masks = [3]bitfield{{10, 5}, {5, 5}, {0, 5}}
+	case d.compression == biRGB && d.bpp == 32:
+		d.masks = [3]bitfield{{16, 8}, {8, 8}, {0, 8}}
+	case d.compression == biRLE8 && d.bpp == 8, d.compression == biRLE4 && d.bpp == 4:
+		if d.topDown {
+			return errBadHeader
+		}
+	case (d.compression == biBitfields || d.compression == biAlphaBitfields) && (d.bpp == 16 || d.bpp == 32):
+		// The masks are in the V2 and later headers, or follow the info
+		// header
+		masks := h[infoHeaderLen:]
+		if headerLen < infoHeaderLen+12 {
+			masks = d.tmp[infoHeaderLen : infoHeaderLen+12]
+			if err := d.readFull(masks); err != nil {
+				return err
+			}
+			if d.compression == biAlphaBitfields {
+				if err := d.readFull(d.tmp[infoHeaderLen+12 : infoHeaderLen+16]); err != nil {
+					return err
+				}
+			}
+		}
+		for i := range d.masks {
+			var ok bool
+			if d.masks[i], ok = newBitfield(le32(masks[4*i:])); !ok {
+				return errUnsupported
+			}
+		}
+	default:
+		return errUnsupported
+	}
+
+	if d.bpp <= 8 {
+		n := 1 << d.bpp
+		if clrUsed > 0 && int(clrUsed) < n {
+			n = int(clrUsed)
+		}
+		entries := d.tmp[:n*paletteEntryLen]
+		if err := d.readFull(entries); err != nil {
+			return err
+		}
+		d.palette = d.paletteBuf[:3*n]
+		for i := 0; i < n; i++ {
+			e := entries[i*paletteEntryLen:]
+			d.palette[3*i], d.palette[3*i+1], d.palette[3*i+2] = e[2], e[1], e[0]
+		}
+	}
+
+	// Skip to the pixels
+	if pixOffset < d.off {
+		return errBadHeader
+	}
+	if pixOffset > d.off {
+		n, err := io.CopyN(io.Discard, d.r, int64(pixOffset-d.off))
+		d.off += int(n)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// y returns the image row of the i-th row of pixels.
+func (d *decoder) y(i int) int {
+	if d.topDown {
+		return i
+	}
+	return d.height - 1 - i
+}
+
+func (d *decoder) decode() error {
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	d.rgb = make([]byte, 3*d.width)
+	if d.compression == biRLE8 || d.compression == biRLE4 {
+		d.row = make([]byte, d.width)
+		d.set = make([]bool, d.width)
+		return d.decodeRLE()
+	}
+
+	// Rows are padded to 4 bytes
+	d.row = make([]byte, (d.width*d.bpp+31)/32*4)
+	for i := 0; i < d.height; i++ {
+		if err := d.readFull(d.row); err != nil {
+			return err
+		}
+		if err := d.convertRow(); err != nil {
+			return err
+		}
+		err := d.out.write(d.rgb, 3, 0, d.y(i), d.width, 1, d.width, d.height)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setIndex sets pixel x of rgb to color c of the palette.
+func (d *decoder) setIndex(x int, c byte) error {
+	i := 3 * int(c)
+	if i >= len(d.palette) {
+		return errBadPixel
+	}
+	copy(d.rgb[3*x:3*x+3], d.palette[i:i+3])
+	return nil
+}
+
+// convertRow converts the row of pixels to RGB.
+func (d *decoder) convertRow() error {
+	row, rgb := d.row, d.rgb
+	switch d.bpp {
+	case 1, 2, 4, 8:
+		perByte := 8 / d.bpp
+		mask := byte(1<<d.bpp - 1)
+		for x := 0; x < d.width; x++ {
+			shift := 8 - d.bpp*(x%perByte+1)
+			if err := d.setIndex(x, row[x/perByte]>>shift&mask); err != nil {
+				return err
+			}
+		}
+	case 16:
+		for x := 0; x < d.width; x++ {
+			p := le16(row[2*x:])
+			rgb[3*x], rgb[3*x+1], rgb[3*x+2] = d.masks[0].value(p), d.masks[1].value(p), d.masks[2].value(p)
+		}
+	case 24:
+		for x := 0; x < d.width; x++ {
+			rgb[3*x], rgb[3*x+1], rgb[3*x+2] = row[3*x+2], row[3*x+1], row[3*x]
+		}
+	case 32:
+		for x := 0; x < d.width; x++ {
+			p := le32(row[4*x:])
+			rgb[3*x], rgb[3*x+1], rgb[3*x+2] = d.masks[0].value(p), d.masks[1].value(p), d.masks[2].value(p)
+		}
+	}
+	return nil
+}
+
+// decodeRLE decodes RLE8 and RLE4 pixels. Pixels skipped by the end of line
+// and delta escapes aren't drawn.
+func (d *decoder) decodeRLE() error {
+	x, i := 0, 0
+	set := func(c byte) {
+		if x < d.width {
+			d.row[x] = c
+			d.set[x] = true
+		}
+		x++
+	}
+
+	for {
+		if err := d.readFull(d.tmp[:2]); err != nil {
+			return err
+		}
+		n, c := int(d.tmp[0]), d.tmp[1]
+
+		if n > 0 {
+			// Run of n pixels of color c, or alternating colors for RLE4
+			for j := 0; j < n; j++ {
+				if d.compression == biRLE4 {
+					set(c >> (4 * (1 - j%2)) & 0x0F)
+				} else {
+					set(c)
+				}
+			}
+			continue
+		}
+
+		switch c {
+		case 0, 1: // End of line, end of bitmap
+			if err := d.flushRLE(i); err != nil {
+				return err
+			}
+			if c == 1 {
+				return nil
+			}
+			x, i = 0, i+1
+		case 2: // Delta
+			if err := d.readFull(d.tmp[:2]); err != nil {
+				return err
+			}
+			dx, dy := int(d.tmp[0]), int(d.tmp[1])
+			if dy > 0 {
+				if err := d.flushRLE(i); err != nil {
+					return err
+				}
+				i += dy
+			}
+			x += dx
+		default: // Absolute run of c pixels, padded to 2 bytes
+			n := int(c)
+			size := n
+			if d.compression == biRLE4 {
+				size = (n + 1) / 2
+			}
+			data := d.tmp[:(size+1)&^1]
+			if err := d.readFull(data); err != nil {
+				return err
+			}
+			for j := 0; j < n; j++ {
+				if d.compression == biRLE4 {
+					set(data[j/2] >> (4 * (1 - j%2)) & 0x0F)
+				} else {
+					set(data[j])
+				}
+			}
+		}
+	}
+}
+
+// flushRLE draws the set pixels of the i-th row, in runs, and clears the
+// row.
+func (d *decoder) flushRLE(i int) error {
+	if i >= d.height {
+		return nil
+	}
+	start := 0
+	for x := 0; x <= d.width; x++ {
+		if x < d.width && d.set[x] {
+			if err := d.setIndex(x, d.row[x]); err != nil {
+				return err
+			}
+			d.set[x] = false
+			continue
+		}
+		if x > start {
+			err := d.out.write(d.rgb[3*start:], 3, start, d.y(i), x-start, 1, d.width, d.height)
+			if err != nil {
+				return err
+			}
+		}
+		start = x + 1
+	}
+	return nil
+}
+
+// Decode reads a BMP image from r. Different from the standard package, the
+// decoded result will be received by the callback set by SetCallback().
+func Decode(r io.Reader) (image.Image, error) {
+	d := &decoder{
+		r:   r,
+		out: &rgb565Output{buf: callbackBuf, fn: callback},
+	}
+	return nil, d.decode()
+}
+
+// DecodeConfig returns the color model and dimensions of a BMP image without
+// decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	d := &decoder{r: r}
+	if err := d.readHeader(); err != nil {
+		return image.Config{}, err
+	}
+	var cm color.Model = color.RGBAModel
+	if d.palette != nil {
+		palette := make(color.Palette, len(d.palette)/3)
+		for i := range palette {
+			palette[i] = color.RGBA{d.palette[3*i], d.palette[3*i+1], d.palette[3*i+2], 0xff}
+		}
+		cm = palette
+	}
+	return image.Config{
+		ColorModel: cm,
+		Width:      d.width,
+		Height:     d.height,
+	}, nil
+}
@@ -0,0 +1,61 @@
+package gif
+
+import "errors"
+
+var (
+	callback    Callback = func(data []uint16, x, y, w, h, width, height int16) {}
+	callbackBuf []uint16
+)
+
+// ErrBufferTooSmall is returned by Decode when a row of the image doesn't fit
+// in the buffer set by SetCallback.
+var ErrBufferTooSmall = errors.New("gif: callback buffer too small")
+
+// A portion of the image data consisting of data, x, y, w, and h is passed to
+// Callback. The size of the whole image is passed as width and height.
+type Callback func(data []uint16, x, y, w, h, width, height int16)
+
+// SetCallback registers the buffer and fn required for Callback. Callback can
+// be called multiple times by calling Decode().
+//
+// The buffer and callback are shared by all calls to Decode, use a Decoder
+// to decode several images at once.
+func SetCallback(buf []uint16, fn Callback) {
+	callbackBuf = buf
+	callback = fn
+}
+
+// output receives the decoded image, a part at a time.
+type output interface {
+	// write passes the w x h pixels at x, y, as RGB with bpp bytes per
+	// pixel. The size of the whole image is passed as width and height.
+	write(pix []byte, bpp, x, y, w, h, width, height int) error
+}
+
+// restorer is implemented by outputs keeping a copy of the image, without
+// the frames to dispose of with DisposalPrevious.
+type restorer interface {
+	// restore draws the w x h area at x, y of the copy again. It does
+	// nothing if there's no copy.
+	restore(x, y, w, h, width, height int) error
+}
+
+// rgb565Output passes the pixels to a Callback, in RGB565 format.
+type rgb565Output struct {
+	buf []uint16
+	fn  Callback
+}
+
+func (o *rgb565Output) write(pix []byte, bpp, x, y, w, h, width, height int) error {
+	if w*h > len(o.buf) {
+		return ErrBufferTooSmall
+	}
+	for i := 0; i < w*h; i++ {
+		r := uint16(pix[i*bpp+0]) << 8
+		g := uint16(pix[i*bpp+1]) << 8
+		b := uint16(pix[i*bpp+2]) << 8
+		o.buf[i] = uint16((r & 0xF800) + ((g & 0xFC00) >> 5) + ((b & 0xF800) >> 11))
+	}
+	o.fn(o.buf[:w*h], int16(x), int16(y), int16(w), int16(h), int16(width), int16(height))
+	return nil
+}
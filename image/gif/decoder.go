@@ -0,0 +1,154 @@
+package gif
+
+import (
+	"io"
+
+	"tinygo.org/x/drivers/image/internal/dither"
+	"tinygo.org/x/drivers/pixel"
+)
+
+// ImageCallback receives a decoded portion of the image, img, to draw at x,
+// y. The size of the whole image is passed as width and height. img is
+// reused by the decoder once the callback returns.
+type ImageCallback[T pixel.Color] func(img pixel.Image[T], x, y, width, height int16)
+
+// Decoder decodes GIF images in pixel format T, a frame at a time. Unlike
+// Decode, a Decoder owns its buffers and callback, so several images can be
+// decoded at once, with a Decoder each.
+//
+// Frames are passed to the callback a row at a time. Transparent pixels are
+// skipped, so that they show the previous frame, which splits rows in
+// several portions. Monochrome images are dithered.
+//
+// To play an animation:
+//
+//	d := gif.NewDecoder(func(img pixel.Image[pixel.RGB565BE], x, y, width, height int16) {
+//		display.DrawBitmap(x, y, img)
+//	})
+//	err := d.Reset(r)
+//	for err == nil {
+//		var frame gif.Frame
+//		frame, err = d.NextFrame()
+//		time.Sleep(frame.Delay)
+//	}
+type Decoder[T pixel.Color] struct {
+	d          decoder
+	callback   ImageCallback[T]
+	buf        pixel.Image[T] // A row of the image
+	restoreBuf pixel.Image[T] // Set by SetRestoreBuffer
+	canvas     pixel.Image[T] // restoreBuf, if the size of the image
+}
+
+// NewDecoder returns a decoder passing the frames to fn.
+func NewDecoder[T pixel.Color](fn ImageCallback[T]) *Decoder[T] {
+	d := &Decoder[T]{
+		callback: fn,
+	}
+	d.d.out = d
+	return d
+}
+
+// SetRestoreBuffer sets the buffer used to restore the area of frames with
+// DisposalPrevious. The decoder keeps in it a copy of the image drawn, so it
+// must have the size of the image, or it isn't used: set it after Reset, as
+// below, before drawing the first frame.
+//
+//	err := d.Reset(r)
+//	w, h := d.Size()
+//	d.SetRestoreBuffer(pixel.NewImage[pixel.RGB565BE](int(w), int(h)))
+func (d *Decoder[T]) SetRestoreBuffer(buf pixel.Image[T]) {
+	d.restoreBuf = buf
+	d.setCanvas()
+}
+
+// setCanvas uses the restore buffer if it has the size of the image.
+func (d *Decoder[T]) setCanvas() {
+	d.canvas = pixel.Image[T]{}
+	if w, h := d.restoreBuf.Size(); w == d.d.width && h == d.d.height && w > 0 && h > 0 {
+		d.canvas = d.restoreBuf
+	}
+}
+
+// Reset reads the header of the GIF image in r, to draw its frames with
+// NextFrame.
+func (d *Decoder[T]) Reset(r io.Reader) error {
+	err := d.d.reset(r)
+	d.setCanvas()
+	return err
+}
+
+// Size returns the size of the image, as read by Reset.
+func (d *Decoder[T]) Size() (width, height int16) {
+	return int16(d.d.width), int16(d.d.height)
+}
+
+// LoopCount returns how many times an animation is repeated: 0 to loop
+// forever, -1 to play it once, else to play it LoopCount+1 times. It's read
+// with the first frame.
+func (d *Decoder[T]) LoopCount() int {
+	return d.d.loopCount
+}
+
+// NextFrame applies the disposal method of the previous frame, if any, then
+// draws the next frame. Returns io.EOF after the last frame.
+//
+// DisposalBackground fills the area of the frame with the background color.
+// DisposalPrevious restores the area to what was drawn before the frame, with
+// the buffer set by SetRestoreBuffer, and is handled as DisposalNone without.
+func (d *Decoder[T]) NextFrame() (Frame, error) {
+	return d.d.nextFrame()
+}
+
+// Decode reads a GIF image from r, and draws its first frame.
+func (d *Decoder[T]) Decode(r io.Reader) error {
+	if err := d.Reset(r); err != nil {
+		return err
+	}
+	if _, err := d.NextFrame(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *Decoder[T]) write(pix []byte, bpp, x, y, w, h, width, height int) error {
+	if d.buf.Len() < w*h {
+		// Portions are one row high, and often split by transparency
+		d.buf = pixel.NewImage[T](width, 1)
+	}
+	img := d.buf.Rescale(w, h)
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			p := pix[(j*w+i)*bpp:]
+			img.Set(i, j, dither.Color[T](p[0], p[1], p[2], x+i, y+j))
+		}
+	}
+	if d.canvas.Len() > 0 && !d.d.drawingPrevious {
+		for j := 0; j < h; j++ {
+			for i := 0; i < w; i++ {
+				d.canvas.Set(x+i, y+j, img.Get(i, j))
+			}
+		}
+	}
+	d.callback(img, int16(x), int16(y), int16(width), int16(height))
+	return nil
+}
+
+func (d *Decoder[T]) restore(x, y, w, h, width, height int) error {
+	if d.canvas.Len() == 0 {
+		return nil
+	}
+	if d.buf.Len() < w {
+		d.buf = pixel.NewImage[T](width, 1)
+	}
+	img := d.buf.Rescale(w, 1)
+	for j := y; j < y+h; j++ {
+		for i := 0; i < w; i++ {
+			img.Set(i, 0, d.canvas.Get(x+i, j))
+		}
+		d.callback(img, int16(x), int16(j), int16(width), int16(height))
+	}
+	return nil
+}
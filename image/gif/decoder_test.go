@@ -0,0 +1,232 @@
+package gif
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	stdgif "image/gif"
+	"io"
+	"testing"
+	"time"
+
+	"tinygo.org/x/drivers/pixel"
+)
+
+var palette = color.Palette{
+	color.RGBA{0xff, 0, 0, 0xff},
+	color.RGBA{0, 0xff, 0, 0xff},
+	color.RGBA{0, 0, 0xff, 0xff},
+	color.RGBA{0, 0, 0, 0}, // Transparent
+}
+
+var (
+	red   = pixel.NewRGB888(0xff, 0, 0)
+	green = pixel.NewRGB888(0, 0xff, 0)
+	blue  = pixel.NewRGB888(0, 0, 0xff)
+)
+
+// encodeTest returns an animation of 3 frames on an 8x6 image:
+// a pattern of the 3 colors, then red squares over it, cleared to blue
+// afterwards, then a green pixel at 0, 0.
+func encodeTest(t *testing.T) []byte {
+	return encodeTestDisposal(t, DisposalBackground)
+}
+
+// encodeTestDisposal returns the animation of encodeTest, with disposal as
+// the disposal method of the red squares.
+func encodeTestDisposal(t *testing.T, disposal byte) []byte {
+	f0 := image.NewPaletted(image.Rect(0, 0, 8, 6), palette)
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			f0.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+	f1 := image.NewPaletted(image.Rect(2, 1, 6, 5), palette)
+	for y := 1; y < 5; y++ {
+		for x := 2; x < 6; x++ {
+			f1.SetColorIndex(x, y, uint8(3*((x+y)%2)))
+		}
+	}
+	f2 := image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+	f2.SetColorIndex(0, 0, 1)
+
+	var buf bytes.Buffer
+	err := stdgif.EncodeAll(&buf, &stdgif.GIF{
+		Image:           []*image.Paletted{f0, f1, f2},
+		Delay:           []int{10, 20, 0},
+		Disposal:        []byte{DisposalNone, disposal, 0},
+		Config:          image.Config{ColorModel: palette, Width: 8, Height: 6},
+		BackgroundIndex: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// want0 is the first frame
+func want0(x, y int) pixel.RGB888 {
+	return [3]pixel.RGB888{red, green, blue}[(x+y)%3]
+}
+
+func TestDecoder(t *testing.T) {
+	img := pixel.NewImage[pixel.RGB888](8, 6)
+	d := NewDecoder(func(part pixel.Image[pixel.RGB888], x, y, width, height int16) {
+		w, h := part.Size()
+		for j := 0; j < h; j++ {
+			for i := 0; i < w; i++ {
+				img.Set(int(x)+i, int(y)+j, part.Get(i, j))
+			}
+		}
+	})
+
+	check := func(frame int, want func(x, y int) pixel.RGB888) {
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 8; x++ {
+				if got, want := img.Get(x, y), want(x, y); got != want {
+					t.Fatalf("frame %d: pixel %d,%d is %v, want %v", frame, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	if err := d.Reset(bytes.NewReader(encodeTest(t))); err != nil {
+		t.Fatal(err)
+	}
+	if w, h := d.Size(); w != 8 || h != 6 {
+		t.Fatalf("got size %dx%d, want 8x6", w, h)
+	}
+
+	frame, err := d.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Frame{0, 0, 8, 6, 100 * time.Millisecond, DisposalNone}); frame != want {
+		t.Errorf("got frame %+v, want %+v", frame, want)
+	}
+	if d.LoopCount() != 0 {
+		t.Errorf("got loop count %d, want 0", d.LoopCount())
+	}
+	check(0, want0)
+
+	// Transparent pixels show the first frame
+	frame, err = d.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Frame{2, 1, 4, 4, 200 * time.Millisecond, DisposalBackground}); frame != want {
+		t.Errorf("got frame %+v, want %+v", frame, want)
+	}
+	check(1, func(x, y int) pixel.RGB888 {
+		if x >= 2 && x < 6 && y >= 1 && y < 5 && (x+y)%2 == 0 {
+			return red
+		}
+		return want0(x, y)
+	})
+
+	if _, err = d.NextFrame(); err != nil {
+		t.Fatal(err)
+	}
+	check(2, func(x, y int) pixel.RGB888 {
+		switch {
+		case x == 0 && y == 0:
+			return green
+		case x >= 2 && x < 6 && y >= 1 && y < 5:
+			return blue
+		}
+		return want0(x, y)
+	})
+
+	if _, err = d.NextFrame(); err != io.EOF {
+		t.Errorf("got %v after the last frame, want EOF", err)
+	}
+}
+
+func TestDecoderDisposalPrevious(t *testing.T) {
+	for _, restore := range []bool{false, true} {
+		img := pixel.NewImage[pixel.RGB888](8, 6)
+		d := NewDecoder(func(part pixel.Image[pixel.RGB888], x, y, width, height int16) {
+			w, h := part.Size()
+			for j := 0; j < h; j++ {
+				for i := 0; i < w; i++ {
+					img.Set(int(x)+i, int(y)+j, part.Get(i, j))
+				}
+			}
+		})
+		if err := d.Reset(bytes.NewReader(encodeTestDisposal(t, DisposalPrevious))); err != nil {
+			t.Fatal(err)
+		}
+		if restore {
+			// Not the size of the image, so not used
+			d.SetRestoreBuffer(pixel.NewImage[pixel.RGB888](6, 8))
+			if d.canvas.Len() != 0 {
+				t.Fatal("restore buffer used with the wrong size")
+			}
+			d.SetRestoreBuffer(pixel.NewImage[pixel.RGB888](8, 6))
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := d.NextFrame(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Without a restore buffer, the red squares are left in place
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 8; x++ {
+				want := want0(x, y)
+				switch {
+				case x == 0 && y == 0:
+					want = green
+				case !restore && x >= 2 && x < 6 && y >= 1 && y < 5 && (x+y)%2 == 0:
+					want = red
+				}
+				if got := img.Get(x, y); got != want {
+					t.Fatalf("restore %v: pixel %d,%d is %v, want %v", restore, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestInterlacedRow(t *testing.T) {
+	var got []int
+	for i := 0; i < 10; i++ {
+		got = append(got, interlacedRow(i, 10))
+	}
+	want := []int{0, 8, 4, 2, 6, 1, 3, 5, 7, 9}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got rows %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSetCallback(t *testing.T) {
+	var n int
+	SetCallback(make([]uint16, 8), func(data []uint16, x, y, w, h, width, height int16) {
+		for i, c := range data[:w*h] {
+			p := want0(int(x)+i, int(y))
+			if c != uint16(p.R&0xF8)<<8|uint16(p.G&0xFC)<<3|uint16(p.B)>>3 {
+				t.Fatalf("pixel %d,%d is %#04x, want %v", int(x)+i, y, c, p)
+			}
+			n++
+		}
+	})
+	defer SetCallback(nil, func(data []uint16, x, y, w, h, width, height int16) {})
+
+	// First frame only
+	if _, err := Decode(bytes.NewReader(encodeTest(t))); err != nil {
+		t.Fatal(err)
+	}
+	if n != 8*6 {
+		t.Errorf("got %d pixels, want %d", n, 8*6)
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(encodeTest(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 8 || cfg.Height != 6 || len(cfg.ColorModel.(color.Palette)) != 4 {
+		t.Errorf("got config %+v", cfg)
+	}
+}
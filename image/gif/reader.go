@@ -0,0 +1,489 @@
+// Package gif implements a streaming GIF decoder, including animations, that
+// uses little RAM. As in the png and jpeg packages, the image is passed to a
+// callback a portion at a time instead of being returned as an image.Image.
+//
+// The format is specified at https://www.w3.org/Graphics/GIF/spec-gif89a.txt
+package gif
+
+import (
+	"bufio"
+	"compress/lzw"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"time"
+)
+
+// Fields of the GIF blocks
+const (
+	fColorTable         = 1 << 7
+	fInterlace          = 1 << 6
+	fColorTableBitsMask = 7
+
+	gcTransparentColorSet = 1 << 0
+	gcDisposalMethodMask  = 7 << 2
+)
+
+// Block introducers and labels
+const (
+	sExtension       = 0x21
+	sImageDescriptor = 0x2C
+	sTrailer         = 0x3B
+
+	eText           = 0x01
+	eGraphicControl = 0xF9
+	eComment        = 0xFE
+	eApplication    = 0xFF
+)
+
+// Disposal methods, telling what to do with the area of a frame before
+// drawing the next one.
+const (
+	DisposalNone       = 0x01 // Leave the frame in place
+	DisposalBackground = 0x02 // Restore the area to the background color
+	DisposalPrevious   = 0x03 // Restore the area to what was there before
+)
+
+var (
+	errNotGIF      = errors.New("gif: can't recognize format")
+	errNoPalette   = errors.New("gif: no color table")
+	errBadPixel    = errors.New("gif: invalid pixel value")
+	errBadFrame    = errors.New("gif: frame bounds larger than image bounds")
+	errBadLZWWidth = errors.New("gif: pixel size in decode out of range")
+	errBadBlock    = errors.New("gif: invalid block")
+)
+
+// Frame describes a frame drawn by Decoder.NextFrame.
+type Frame struct {
+	X, Y          int16 // Top left corner of the frame in the image
+	Width, Height int16
+	// Delay is how long to show the frame before the next one.
+	Delay time.Duration
+	// Disposal is the disposal method of the frame, applied by the next call
+	// to NextFrame, or zero if not specified.
+	Disposal byte
+}
+
+// reader is the io.Reader of the decoder, with ReadByte for the LZW reader.
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type decoder struct {
+	r   reader
+	buf *bufio.Reader
+	out output
+
+	width, height int
+	background    byte
+	loopCount     int
+	global        []byte // Global color table, as RGB
+
+	// Graphic control of the next frame
+	delay       time.Duration
+	disposal    byte
+	transparent int // Transparent color index, or -1
+
+	// The frame being drawn is to be restored with DisposalPrevious
+	drawingPrevious bool
+
+	prev      Frame
+	blocks    blockReader
+	lzw       *lzw.Reader
+	row       []byte // Color indices of a row of the frame
+	rgb       []byte // RGB pixels of a row
+	tmp       [256 * 3]byte
+	globalBuf [256 * 3]byte
+}
+
+// reset reads the header of a GIF image from r, up to the first frame.
+func (d *decoder) reset(r io.Reader) error {
+	*d = decoder{
+		buf:       d.buf,
+		out:       d.out,
+		lzw:       d.lzw,
+		row:       d.row,
+		rgb:       d.rgb,
+		loopCount: -1,
+	}
+	if rr, ok := r.(reader); ok {
+		d.r = rr
+	} else {
+		if d.buf == nil {
+			d.buf = bufio.NewReader(r)
+		} else {
+			d.buf.Reset(r)
+		}
+		d.r = d.buf
+	}
+	d.blocks.r = d.r
+	d.transparent = -1
+
+	if err := d.readFull(d.tmp[:13]); err != nil {
+		return err
+	}
+	version := string(d.tmp[:6])
+	if version != "GIF87a" && version != "GIF89a" {
+		return errNotGIF
+	}
+	d.width = int(d.tmp[6]) | int(d.tmp[7])<<8
+	d.height = int(d.tmp[8]) | int(d.tmp[9])<<8
+	if fields := d.tmp[10]; fields&fColorTable != 0 {
+		d.background = d.tmp[11]
+		n := 1 << (1 + fields&fColorTableBitsMask)
+		d.global = d.globalBuf[:3*n]
+		if err := d.readFull(d.global); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) readFull(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// nextFrame draws the next frame of the image, after disposing of the
+// previous one. Returns io.EOF after the last frame.
+func (d *decoder) nextFrame() (Frame, error) {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+		switch c {
+		case sExtension:
+			if err := d.readExtension(); err != nil {
+				return Frame{}, err
+			}
+		case sImageDescriptor:
+			if err := d.dispose(); err != nil {
+				return Frame{}, err
+			}
+			frame, err := d.readFrame()
+			if err != nil {
+				return Frame{}, err
+			}
+			d.prev = frame
+			return frame, nil
+		case sTrailer:
+			return Frame{}, io.EOF
+		default:
+			return Frame{}, errBadBlock
+		}
+	}
+}
+
+func (d *decoder) readExtension() error {
+	label, err := d.r.ReadByte()
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	switch label {
+	case eGraphicControl:
+		if err := d.readFull(d.tmp[:6]); err != nil {
+			return err
+		}
+		if d.tmp[0] != 4 || d.tmp[5] != 0 {
+			return errBadBlock
+		}
+		flags := d.tmp[1]
+		d.disposal = (flags & gcDisposalMethodMask) >> 2
+		d.delay = time.Duration(int(d.tmp[2])|int(d.tmp[3])<<8) * 10 * time.Millisecond
+		if flags&gcTransparentColorSet != 0 {
+			d.transparent = int(d.tmp[4])
+		}
+		return nil
+	case eApplication:
+		// Application identifier, then the loop count sub-block for
+		// NETSCAPE2.0
+		d.blocks.reset()
+		n, err := io.ReadFull(&d.blocks, d.tmp[:11])
+		if n == 11 && string(d.tmp[:11]) == "NETSCAPE2.0" {
+			n, err = io.ReadFull(&d.blocks, d.tmp[:3])
+			if n == 3 && d.tmp[0] == 1 {
+				d.loopCount = int(d.tmp[1]) | int(d.tmp[2])<<8
+			}
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		return d.blocks.drain()
+	}
+	// Text, comments and unknown extensions are skipped
+	d.blocks.reset()
+	return d.blocks.drain()
+}
+
+// readFrame reads an image descriptor and draws the image data.
+func (d *decoder) readFrame() (Frame, error) {
+	if err := d.readFull(d.tmp[:9]); err != nil {
+		return Frame{}, err
+	}
+	x := int(d.tmp[0]) | int(d.tmp[1])<<8
+	y := int(d.tmp[2]) | int(d.tmp[3])<<8
+	w := int(d.tmp[4]) | int(d.tmp[5])<<8
+	h := int(d.tmp[6]) | int(d.tmp[7])<<8
+	flags := d.tmp[8]
+	if x+w > d.width || y+h > d.height {
+		return Frame{}, errBadFrame
+	}
+
+	palette := d.global
+	if flags&fColorTable != 0 {
+		n := 1 << (1 + flags&fColorTableBitsMask)
+		palette = d.tmp[:3*n]
+		if err := d.readFull(palette); err != nil {
+			return Frame{}, err
+		}
+	}
+	if palette == nil {
+		return Frame{}, errNoPalette
+	}
+
+	// Buffers for the widest frame
+	if len(d.row) < d.width {
+		d.row = make([]byte, d.width)
+		d.rgb = make([]byte, 3*d.width)
+	}
+
+	litWidth, err := d.r.ReadByte()
+	if err != nil {
+		return Frame{}, io.ErrUnexpectedEOF
+	}
+	if litWidth < 2 || litWidth > 8 {
+		return Frame{}, errBadLZWWidth
+	}
+	d.blocks.reset()
+	if d.lzw == nil {
+		d.lzw = lzw.NewReader(&d.blocks, lzw.LSB, int(litWidth)).(*lzw.Reader)
+	} else {
+		d.lzw.Reset(&d.blocks, lzw.LSB, int(litWidth))
+	}
+
+	frame := Frame{
+		X:        int16(x),
+		Y:        int16(y),
+		Width:    int16(w),
+		Height:   int16(h),
+		Delay:    d.delay,
+		Disposal: d.disposal,
+	}
+
+	d.drawingPrevious = d.disposal == DisposalPrevious
+	defer func() { d.drawingPrevious = false }()
+
+	interlaced := flags&fInterlace != 0
+	for i := 0; i < h; i++ {
+		row := i
+		if interlaced {
+			row = interlacedRow(i, h)
+		}
+		if _, err := io.ReadFull(d.lzw, d.row[:w]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+		if err := d.drawRow(palette, x, y+row, w); err != nil {
+			return Frame{}, err
+		}
+	}
+
+	// Skip what's left of the image data
+	if err := d.blocks.drain(); err != nil {
+		return Frame{}, err
+	}
+
+	// Graphic control only applies to one frame
+	d.delay, d.disposal, d.transparent = 0, 0, -1
+
+	return frame, nil
+}
+
+// interlacedRow returns the row of the i-th interlaced row of an image of
+// height h: every 8th row from 0, every 8th from 4, every 4th from 2, then
+// every 2nd from 1.
+func interlacedRow(i, h int) int {
+	for _, pass := range [4]struct{ start, step int }{{0, 8}, {4, 8}, {2, 4}, {1, 2}} {
+		n := (h - pass.start + pass.step - 1) / pass.step
+		if i < n {
+			return pass.start + i*pass.step
+		}
+		i -= n
+	}
+	return 0
+}
+
+// drawRow passes the opaque runs of pixels of the row at x, y to the output,
+// so transparent pixels show what was drawn before.
+func (d *decoder) drawRow(palette []byte, x, y, w int) error {
+	start := 0
+	for i := 0; i <= w; i++ {
+		if i < w && int(d.row[i]) != d.transparent {
+			c := int(d.row[i]) * 3
+			if c >= len(palette) {
+				return errBadPixel
+			}
+			copy(d.rgb[3*i:3*i+3], palette[c:c+3])
+			continue
+		}
+		if i > start {
+			err := d.out.write(d.rgb[3*start:], 3, x+start, y, i-start, 1, d.width, d.height)
+			if err != nil {
+				return err
+			}
+		}
+		start = i + 1
+	}
+	return nil
+}
+
+// dispose applies the disposal method of the previous frame. Restoring to
+// the previous pixels needs an output keeping a copy of the image, else
+// DisposalPrevious leaves the frame in place.
+func (d *decoder) dispose() error {
+	if d.prev.Disposal == DisposalPrevious {
+		if r, ok := d.out.(restorer); ok {
+			return r.restore(int(d.prev.X), int(d.prev.Y), int(d.prev.Width), int(d.prev.Height), d.width, d.height)
+		}
+		return nil
+	}
+	if d.prev.Disposal != DisposalBackground {
+		return nil
+	}
+	var r, g, b byte
+	if c := int(d.background) * 3; c < len(d.global) {
+		r, g, b = d.global[c], d.global[c+1], d.global[c+2]
+	}
+	w := int(d.prev.Width)
+	for i := 0; i < w; i++ {
+		d.rgb[3*i], d.rgb[3*i+1], d.rgb[3*i+2] = r, g, b
+	}
+	for y := int(d.prev.Y); y < int(d.prev.Y+d.prev.Height); y++ {
+		if err := d.out.write(d.rgb, 3, int(d.prev.X), y, w, 1, d.width, d.height); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockReader reads data sub-blocks as a stream, up to the block
+// terminator.
+type blockReader struct {
+	r   reader
+	n   int // Bytes left in the sub-block
+	eof bool
+}
+
+func (b *blockReader) reset() {
+	b.n, b.eof = 0, false
+}
+
+// next moves to the next sub-block if the current one is done, returning
+// io.EOF at the terminator.
+func (b *blockReader) next() error {
+	for b.n == 0 {
+		if b.eof {
+			return io.EOF
+		}
+		n, err := b.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if n == 0 {
+			b.eof = true
+			return io.EOF
+		}
+		b.n = int(n)
+	}
+	return nil
+}
+
+func (b *blockReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := b.next(); err != nil {
+		return 0, err
+	}
+	if len(p) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (b *blockReader) ReadByte() (byte, error) {
+	if err := b.next(); err != nil {
+		return 0, err
+	}
+	c, err := b.r.ReadByte()
+	if err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b.n--
+	return c, nil
+}
+
+// drain skips the sub-blocks up to the terminator.
+func (b *blockReader) drain() error {
+	for {
+		if _, err := b.ReadByte(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Decode reads the first frame of a GIF image from r. Different from the
+// standard package, the decoded result will be received by the callback set
+// by SetCallback(). Use a Decoder for animations.
+func Decode(r io.Reader) (image.Image, error) {
+	d := &decoder{out: &rgb565Output{buf: callbackBuf, fn: callback}}
+	if err := d.reset(r); err != nil {
+		return nil, err
+	}
+	if _, err := d.nextFrame(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// DecodeConfig returns the global color table and dimensions of a GIF image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	var d decoder
+	if err := d.reset(r); err != nil {
+		return image.Config{}, err
+	}
+	var palette color.Palette
+	for i := 0; i < len(d.global); i += 3 {
+		palette = append(palette, color.RGBA{d.global[i], d.global[i+1], d.global[i+2], 0xff})
+	}
+	return image.Config{
+		ColorModel: palette,
+		Width:      d.width,
+		Height:     d.height,
+	}, nil
+}
@@ -823,3 +823,19 @@ func NewReaderDict(r io.Reader, dict []byte) io.ReadCloser {
 	f.dict.init(maxMatchOffset, dict)
 	return &f
 }
+
+// NewReaderWindow is like NewReaderDict but uses window, of at least 32KiB,
+// for the history, instead of a buffer shared by all readers. This allows
+// decompressing several streams at once.
+func NewReaderWindow(r io.Reader, dict, window []byte) io.ReadCloser {
+	fixedHuffmanDecoderInit()
+
+	var f decompressor
+	f.r = makeReader(r)
+	f.bits = new([maxNumLit + maxNumDist]int)
+	f.codebits = new([numCodes]int)
+	f.step = (*decompressor).nextBlock
+	f.dict.hist = window[:0]
+	f.dict.init(maxMatchOffset, dict)
+	return &f
+}
@@ -50,6 +50,7 @@ type reader struct {
 	digest       hash.Hash32
 	err          error
 	scratch      [4]byte
+	window       []byte
 }
 
 // Resetter resets a ReadCloser returned by NewReader or NewReaderDict
@@ -86,6 +87,17 @@ func NewReaderDict(r io.Reader, dict []byte) (io.ReadCloser, error) {
 	return z, nil
 }
 
+// NewReaderWindow is like NewReader but uses window, of at least 32KiB, for
+// the history of the decompressor, instead of a buffer shared by all readers.
+func NewReaderWindow(r io.Reader, window []byte) (io.ReadCloser, error) {
+	z := &reader{window: window}
+	err := z.Reset(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
 func (z *reader) Read(p []byte) (int, error) {
 	if z.err != nil {
 		return 0, z.err
@@ -128,7 +140,7 @@ func (z *reader) Close() error {
 }
 
 func (z *reader) Reset(r io.Reader, dict []byte) error {
-	*z = reader{decompressor: z.decompressor}
+	*z = reader{decompressor: z.decompressor, window: z.window}
 	if fr, ok := r.(flate.Reader); ok {
 		z.r = fr
 	} else {
@@ -165,11 +177,10 @@ func (z *reader) Reset(r io.Reader, dict []byte) error {
 	}
 
 	if z.decompressor == nil {
-		if haveDict {
-			z.decompressor = flate.NewReaderDict(z.r, dict)
-		} else {
-			z.decompressor = flate.NewReader(z.r)
+		if !haveDict {
+			dict = nil
 		}
+		z.decompressor = flate.NewReaderWindow(z.r, dict, z.window)
 	} else {
 		z.decompressor.(flate.Resetter).Reset(z.r, dict)
 	}
@@ -0,0 +1,29 @@
+// Package dither converts the RGB colors of decoded images to pixel formats.
+// Monochrome colors are dithered, so that photos and gradients remain
+// recognizable on black and white displays.
+package dither
+
+import "tinygo.org/x/drivers/pixel"
+
+// 4x4 Bayer matrix, for ordered dithering. Unlike error diffusion, it needs
+// no state, so images can be converted a block at a time in any order.
+var bayer = [4][4]uint8{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// Color returns the color r, g, b at x, y of an image, in pixel format T.
+func Color[T pixel.Color](r, g, b uint8, x, y int) T {
+	var value T
+	switch any(value).(type) {
+	case pixel.Monochrome:
+		// Rec. 601 luma
+		luma := (uint16(r)*77 + uint16(g)*150 + uint16(b)*29) >> 8
+		threshold := uint16(bayer[y&3][x&3])*16 + 8
+		return any(pixel.Monochrome(luma > threshold)).(T)
+	default:
+		return pixel.NewColor[T](r, g, b)
+	}
+}
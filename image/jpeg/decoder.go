@@ -0,0 +1,89 @@
+package jpeg
+
+import (
+	"image"
+	"io"
+
+	"tinygo.org/x/drivers/image/internal/dither"
+	"tinygo.org/x/drivers/pixel"
+)
+
+// ImageCallback receives a decoded portion of the image, img, to draw at x,
+// y. The size of the whole image is passed as width and height. img is
+// reused by the decoder once the callback returns.
+type ImageCallback[T pixel.Color] func(img pixel.Image[T], x, y, width, height int16)
+
+// Scale is the size at which a Decoder decodes images.
+type Scale uint8
+
+const (
+	ScaleFull    Scale = iota // Full size
+	ScaleHalf                 // 1/2 of the width and height
+	ScaleQuarter              // 1/4 of the width and height
+	ScaleEighth               // 1/8 of the width and height
+)
+
+// Decoder decodes JPEG images in pixel format T. Unlike Decode, a Decoder
+// owns its buffer and callback, so several images can be decoded at once,
+// with a Decoder each.
+//
+// Monochrome images are dithered.
+type Decoder[T pixel.Color] struct {
+	callback ImageCallback[T]
+	buf      pixel.Image[T] // A 16x16 MCU
+	scale    Scale
+	region   image.Rectangle
+}
+
+// NewDecoder returns a decoder passing the image to fn, 16x16 pixels at a
+// time. At the right and bottom edges, these portions may extend beyond the
+// image.
+func NewDecoder[T pixel.Color](fn ImageCallback[T]) *Decoder[T] {
+	return &Decoder[T]{
+		callback: fn,
+		buf:      pixel.NewImage[T](16, 16),
+	}
+}
+
+// SetScale sets the size of the decoded images. Downscaling is done while
+// decoding, which is faster than decoding the full image, and portions are
+// 16x16 pixels scaled down as well: 8x8 at ScaleHalf, down to 2x2 at
+// ScaleEighth. The width and height passed to the callback are the scaled
+// size of the image, rounded up.
+func (d *Decoder[T]) SetScale(scale Scale) {
+	d.scale = scale
+}
+
+// SetRegion sets the area of the images to decode, in pixels of the full size
+// image. The callback receives portions of this area only, clipped to it,
+// with x and y relative to its top left corner, and its (scaled) size as
+// width and height. Parts of the image outside of it are skipped, which is
+// faster than decoding them.
+//
+// A width or height of 0 decodes whole images, the default.
+func (d *Decoder[T]) SetRegion(x, y, width, height int16) {
+	d.region = image.Rect(int(x), int(y), int(x)+int(width), int(y)+int(height))
+}
+
+// Decode reads a JPEG image from r, passing it to the callback of the
+// decoder.
+func (d *Decoder[T]) Decode(r io.Reader) error {
+	if d.scale > ScaleEighth {
+		return UnsupportedError("scale")
+	}
+	dec := &decoder{out: d, scale: d.scale, region: d.region}
+	_, err := dec.decode(r, false)
+	return err
+}
+
+func (d *Decoder[T]) write(pix []byte, bpp, x, y, w, h, width, height int) error {
+	img := d.buf.Rescale(w, h)
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			p := pix[(j*w+i)*bpp:]
+			img.Set(i, j, dither.Color[T](p[0], p[1], p[2], x+i, y+j))
+		}
+	}
+	d.callback(img, int16(x), int16(y), int16(width), int16(height))
+	return nil
+}
@@ -0,0 +1,298 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	stdjpeg "image/jpeg"
+	"sync"
+	"testing"
+
+	"tinygo.org/x/drivers/pixel"
+)
+
+// encodeTest returns a JPEG of a w x h gradient.
+func encodeTest(t *testing.T, w, h int) []byte {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 0x80, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, &Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodeTest decodes data into an image of format T, rounded up to whole
+// MCUs.
+func decodeTest[T pixel.Color](data []byte) (pixel.Image[T], error) {
+	var img pixel.Image[T]
+	d := NewDecoder(func(part pixel.Image[T], x, y, width, height int16) {
+		if img.Len() == 0 {
+			img = pixel.NewImage[T]((int(width)+15)&^15, (int(height)+15)&^15)
+		}
+		w, h := part.Size()
+		for j := 0; j < h; j++ {
+			for i := 0; i < w; i++ {
+				img.Set(int(x)+i, int(y)+j, part.Get(i, j))
+			}
+		}
+	})
+	err := d.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func TestDecoder(t *testing.T) {
+	data := encodeTest(t, 40, 24)
+	img, err := decodeTest[pixel.RGB888](data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := img.Size(); w != 48 || h != 32 {
+		t.Fatalf("got size %dx%d, want 48x32", w, h)
+	}
+
+	// Close to the standard library, give or take IDCT rounding
+	want, err := stdjpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	near := func(a uint8, b uint32) bool {
+		d := int(a) - int(b>>8)
+		return d >= -2 && d <= 2
+	}
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 40; x++ {
+			got := img.Get(x, y)
+			r, g, b, _ := want.At(x, y).RGBA()
+			if !near(got.R, r) || !near(got.G, g) || !near(got.B, b) {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want.At(x, y))
+			}
+		}
+	}
+}
+
+func TestDecoderDither(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(m.Pix); i += 4 {
+		m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3] = 0x80, 0x80, 0x80, 0xff
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, &Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := decodeTest[pixel.Monochrome](buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var white int
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if img.Get(x, y) {
+				white++
+			}
+		}
+	}
+	if white != 128 {
+		t.Errorf("got %d white pixels of 256 for 50%% gray, want 128", white)
+	}
+}
+
+func TestDecoderConcurrent(t *testing.T) {
+	images := [][]byte{encodeTest(t, 40, 24), encodeTest(t, 17, 50)}
+	want := make([]pixel.Image[pixel.RGB565BE], len(images))
+	for i, data := range images {
+		var err error
+		want[i], err = decodeTest[pixel.RGB565BE](data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := i % len(images)
+			img, err := decodeTest[pixel.RGB565BE](images[n])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(img.RawBuffer(), want[n].RawBuffer()) {
+				t.Errorf("image %d differs when decoded concurrently", n)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSetCallback(t *testing.T) {
+	data := encodeTest(t, 40, 24)
+	want, err := decodeTest[pixel.RGB888](data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	SetCallback(make([]uint16, 256), func(data []uint16, x, y, w, h, width, height int16) {
+		for i, c := range data[:w*h] {
+			p := want.Get(int(x)+i%int(w), int(y)+i/int(w))
+			if c != uint16(p.R&0xF8)<<8|uint16(p.G&0xFC)<<3|uint16(p.B)>>3 {
+				t.Fatalf("pixel %d,%d is %#04x, want %v", int(x)+i%int(w), int(y)+i/int(w), c, p)
+			}
+		}
+		n++
+	})
+	defer SetCallback(nil, func(data []uint16, x, y, w, h, width, height int16) {})
+
+	if _, err := Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3*2 {
+		t.Errorf("got %d MCUs, want 6", n)
+	}
+
+	SetCallback(make([]uint16, 255), func(data []uint16, x, y, w, h, width, height int16) {})
+	if _, err := Decode(bytes.NewReader(data)); err != ErrBufferTooSmall {
+		t.Errorf("got %v, want %v", err, ErrBufferTooSmall)
+	}
+}
+
+// decodeRegion decodes data with the given scale and region, checking that
+// the portions are within the image.
+func decodeRegion(t *testing.T, data []byte, scale Scale, x, y, w, h int16) (img pixel.Image[pixel.RGB888], width, height int) {
+	t.Helper()
+	var n int
+	d := NewDecoder(func(part pixel.Image[pixel.RGB888], x, y, w, h int16) {
+		if img.Len() == 0 {
+			width, height = int(w), int(h)
+			img = pixel.NewImage[pixel.RGB888](width, height)
+		}
+		pw, ph := part.Size()
+		if x < 0 || y < 0 || int(x)+pw > width || int(y)+ph > height {
+			t.Fatalf("got %dx%d pixels at %d,%d, outside of %dx%d", pw, ph, x, y, width, height)
+		}
+		for j := 0; j < ph; j++ {
+			for i := 0; i < pw; i++ {
+				img.Set(int(x)+i, int(y)+j, part.Get(i, j))
+			}
+		}
+		n += pw * ph
+	})
+	d.SetScale(scale)
+	d.SetRegion(x, y, w, h)
+	if err := d.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if n != width*height {
+		t.Errorf("got %d pixels, want %d", n, width*height)
+	}
+	return img, width, height
+}
+
+func TestDecoderScale(t *testing.T) {
+	// Grey, as YCbCr: single component images aren't supported
+	grey := image.NewRGBA(image.Rect(0, 0, 160, 96))
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 160; x++ {
+			c := uint8((x + y) * 255 / 256)
+			grey.SetRGBA(x, y, color.RGBA{c, c, c, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, grey, &Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		delta int
+	}{
+		{"grey", buf.Bytes(), 2},
+		// Chroma is subsampled over twice as many pixels as the scale, so
+		// colors are off by a few steps along the gradient.
+		{"color", encodeTest(t, 160, 96), 12},
+	} {
+		full, err := decodeTest[pixel.RGB888](tc.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, scale := range []Scale{ScaleHalf, ScaleQuarter, ScaleEighth} {
+			s := 1 << scale
+			img, width, height := decodeRegion(t, tc.data, scale, 0, 0, 160, 96)
+			if width != 160/s || height != 96/s {
+				t.Errorf("%s 1/%d: got size %dx%d, want %dx%d", tc.name, s, width, height, 160/s, 96/s)
+				continue
+			}
+
+			// Close to the average of the full size pixels
+			near := func(a, b uint8) bool {
+				d := int(a) - int(b)
+				return d >= -tc.delta && d <= tc.delta
+			}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					var r, g, b int
+					for j := 0; j < s; j++ {
+						for i := 0; i < s; i++ {
+							p := full.Get(x*s+i, y*s+j)
+							r, g, b = r+int(p.R), g+int(p.G), b+int(p.B)
+						}
+					}
+					want := pixel.NewRGB888(uint8(r/(s*s)), uint8(g/(s*s)), uint8(b/(s*s)))
+					if got := img.Get(x, y); !near(got.R, want.R) || !near(got.G, want.G) || !near(got.B, want.B) {
+						t.Fatalf("%s 1/%d: pixel %d,%d is %v, want %v", tc.name, s, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecoderRegion(t *testing.T) {
+	data := encodeTest(t, 40, 24)
+	full, err := decodeTest[pixel.RGB888](data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half, _, _ := decodeRegion(t, data, ScaleHalf, 0, 0, 40, 24)
+
+	for _, tc := range []struct {
+		scale       Scale
+		x, y, w, h  int16
+		want        pixel.Image[pixel.RGB888]
+		x0, y0      int
+		width, high int
+	}{
+		{ScaleFull, 13, 5, 20, 12, full, 13, 5, 20, 12},
+		// Clipped to the image
+		{ScaleFull, 30, -4, 20, 12, full, 30, 0, 10, 8},
+		// Rounded to whole scaled pixels
+		{ScaleHalf, 13, 5, 20, 12, half, 6, 2, 11, 7},
+	} {
+		img, width, height := decodeRegion(t, data, tc.scale, tc.x, tc.y, tc.w, tc.h)
+		if width != tc.width || height != tc.high {
+			t.Errorf("region %d,%d %dx%d: got size %dx%d, want %dx%d", tc.x, tc.y, tc.w, tc.h, width, height, tc.width, tc.high)
+			continue
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if got, want := img.Get(x, y), tc.want.Get(tc.x0+x, tc.y0+y); got != want {
+					t.Fatalf("region %d,%d %dx%d: pixel %d,%d is %v, want %v", tc.x, tc.y, tc.w, tc.h, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	// Outside of the image
+	if _, width, height := decodeRegion(t, data, ScaleFull, 40, 0, 8, 8); width != 0 || height != 0 {
+		t.Errorf("got %dx%d pixels outside of the image", width, height)
+	}
+}
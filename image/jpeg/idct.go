@@ -192,3 +192,65 @@ func idct(src *block) {
 		s[8*7] = (y7 - y1) >> 14
 	}
 }
+
+// idctScaled4 and idctScaled2 hold 4096*C(u)/2*cos((2k+1)*u*pi/(2*n)), at
+// [k*n+u], to compute n pixels from the first n coefficients of a row or
+// column. C(0) is 1/sqrt(2), C(u) is 1 otherwise.
+var (
+	idctScaled4 = [4 * 4]int32{
+		1448, 1892, 1448, 784,
+		1448, 784, -1448, -1892,
+		1448, -784, -1448, 1892,
+		1448, -1892, 1448, -784,
+	}
+	idctScaled2 = [2 * 2]int32{
+		1448, 1448,
+		1448, -1448,
+	}
+)
+
+// idctScaled performs a 2-D Inverse Discrete Cosine Transformation to n x n
+// pixels instead of 8 x 8, n being 1, 2 or 4, for a 1/2, 1/4 or 1/8 scaled
+// image. Only the n x n lowest frequency coefficients are used, the pixels are
+// stored at the top left of src, with a stride of 8.
+//
+// This is much cheaper than idct followed by downscaling: at 1/8, a pixel is
+// only the DC coefficient.
+func idctScaled(src *block, n int) {
+	if n == 1 {
+		src[0] = (src[0] + 4) >> 3
+		return
+	}
+	c := idctScaled4[:]
+	if n == 2 {
+		c = idctScaled2[:]
+	}
+	var t [4]int32
+
+	// Horizontal 1-D IDCT, keeping 2 fractional bits.
+	for y := 0; y < n; y++ {
+		s := src[y*8 : y*8+n]
+		for k := 0; k < n; k++ {
+			var sum int32
+			for u, x := range s {
+				sum += x * c[k*n+u]
+			}
+			t[k] = (sum + 1<<9) >> 10
+		}
+		copy(s, t[:n])
+	}
+
+	// Vertical 1-D IDCT.
+	for x := 0; x < n; x++ {
+		for k := 0; k < n; k++ {
+			var sum int32
+			for v := 0; v < n; v++ {
+				sum += src[v*8+x] * c[k*n+v]
+			}
+			t[k] = (sum + 1<<13) >> 14
+		}
+		for k := 0; k < n; k++ {
+			src[k*8+x] = t[k]
+		}
+	}
+}
@@ -146,6 +146,22 @@ type decoder struct {
 	huff       [maxTc + 1][maxTh + 1]huffman
 	quant      [maxTq + 1]block // Quantization tables, in zig-zag order.
 	tmp        [2 * blockSize]byte
+
+	// blockBuf holds the 8x8 pix data of reconstructBlock, mcuBuf the
+	// 16x16 pix 24bit color image of a MCU, as built by processSOS.
+	blockBuf [blockSize]byte
+	mcuBuf   [3 * 16 * 16]byte
+	out      output
+
+	// scale and region are the size and area of the image to decode, see
+	// Decoder. clip is region in the scaled image, crop is set when it is
+	// used, and outWidth and outHeight are the size of the decoded image.
+	scale     Scale
+	region    image.Rectangle
+	clip      image.Rectangle
+	crop      bool
+	outWidth  int
+	outHeight int
 }
 
 // fill fills up the d.bytes.buf buffer from the underlying io.Reader. It
@@ -774,9 +790,14 @@ func (d *decoder) convertToRGB() (image.Image, error) {
 // Decode reads a JPEG image from r. Different from the standard package, the
 // decoded result will be received by the callback set by SetCallback().
 func Decode(r io.Reader) (image.Image, error) {
-	var d decoder
+	return nil, decode(r, &rgb565Output{buf: callbackBuf, fn: callback})
+}
+
+// decode reads a JPEG image from r, passing the pixels to out.
+func decode(r io.Reader, out output) error {
+	d := &decoder{out: out}
 	_, err := d.decode(r, false)
-	return nil, err
+	return err
 }
 
 // DecodeConfig returns the color model and dimensions of a JPEG image without
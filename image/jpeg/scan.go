@@ -48,10 +48,6 @@ func (d *decoder) makeImg(mxx, myy int) {
 	}
 }
 
-// processSOSBuf is a Buffer for creating RGBBitmap in processSOS. It needs to
-// hold four 8 x 8 pix 24bit color images.
-var processSOSBuf [3 * 8 * 8 * 4]byte
-
 // Specified in section B.2.3.
 func (d *decoder) processSOS(n int) error {
 	if d.nComp == 0 {
@@ -166,6 +162,11 @@ func (d *decoder) processSOS(n int) error {
 		}
 	}
 
+	// bw is the width of the scaled blocks, mw of the scaled MCUs.
+	bw := 8 >> d.scale
+	mw := 2 * bw
+	d.setClip()
+
 	d.bits = bits{}
 	mcu, expectedRST := 0, uint8(rst0Marker)
 	var (
@@ -306,62 +307,46 @@ func (d *decoder) processSOS(n int) error {
 						// SOS markers are processed.
 						continue
 					}
-					if dst, err := d.reconstructBlock(&b, bx, by, int(compIndex)); err != nil {
+					if d.crop && !image.Rect(mx*mw, my*mw, mx*mw+mw, my*mw+mw).Overlaps(d.clip) {
+						// Outside of the decoded region, the coefficients are
+						// only needed for the DC prediction.
+						continue
+					}
+					dst, err := d.reconstructBlock(&b, bx, by, int(compIndex))
+					if err != nil {
 						return err
-					} else {
-						// Currently, only the YCbCr422 format is supported.
-						switch compIndex {
-						case 0: // Y
-							bx8 := bx * 8
-							by8 := by * 8
-							bx16 := bx8 % 16
-							by16 := by8 % 16
-							for cy := 0; cy < 8; cy++ {
-								for cx := 0; cx < 8; cx++ {
-									processSOSBuf[((cy+by16)*16+(cx+bx16))*3+0] = dst[cy*8+cx]
-								}
+					}
+					// Currently, only the YCbCr420 format is supported. The
+					// MCU is mw x mw pixels, made of 4 Y blocks and a Cb and Cr
+					// block of bw x bw pixels.
+					switch compIndex {
+					case 0: // Y
+						ox := (bx % 2) * bw
+						oy := (by % 2) * bw
+						for cy := 0; cy < bw; cy++ {
+							for cx := 0; cx < bw; cx++ {
+								d.mcuBuf[((cy+oy)*mw+(cx+ox))*3] = dst[cy*bw+cx]
 							}
-						case 1: // Cb
-							bx8 := bx * 8 * 2
-							by8 := by * 8 * 2
-							bx16 := bx8 % 16
-							by16 := by8 % 16
-
-							for cy := 0; cy < 8; cy++ {
-								for cx := 0; cx < 8; cx++ {
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+0+bx16))*3+1] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+1+bx16))*3+1] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+0+bx16))*3+1] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+1+bx16))*3+1] = dst[cy*8+cx]
-								}
+						}
+					case 1, 2: // Cb, Cr
+						for cy := 0; cy < bw; cy++ {
+							for cx := 0; cx < bw; cx++ {
+								i := ((cy*2)*mw+cx*2)*3 + int(compIndex)
+								d.mcuBuf[i] = dst[cy*bw+cx]
+								d.mcuBuf[i+3] = dst[cy*bw+cx]
+								d.mcuBuf[i+mw*3] = dst[cy*bw+cx]
+								d.mcuBuf[i+mw*3+3] = dst[cy*bw+cx]
 							}
-						case 2: // Cr
-							bx8 := bx * 8 * 2
-							by8 := by * 8 * 2
-							bx16 := bx8 % 16
-							by16 := by8 % 16
-
-							for cy := 0; cy < 8; cy++ {
-								for cx := 0; cx < 8; cx++ {
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+0+bx16))*3+2] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+0+by16)*16+(cx*2+1+bx16))*3+2] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+0+bx16))*3+2] = dst[cy*8+cx]
-									processSOSBuf[((cy*2+1+by16)*16+(cx*2+1+bx16))*3+2] = dst[cy*8+cx]
-								}
+						}
+						if compIndex == 2 {
+							// Convert to RGB in place
+							for i := 0; i < mw*mw*3; i += 3 {
+								p := d.mcuBuf[i : i+3]
+								p[0], p[1], p[2] = color.YCbCrToRGB(p[0], p[1], p[2])
 							}
-
-							for cy := 0; cy < 16; cy++ {
-								for cx := 0; cx < 16; cx++ {
-									yy := processSOSBuf[(cy*16+cx)*3+0]
-									cb := processSOSBuf[(cy*16+cx)*3+1]
-									cr := processSOSBuf[(cy*16+cx)*3+2]
-									r, g, b := color.YCbCrToRGB(yy, cb, cr)
-									callbackBuf[cy*16+cx] = uint16(((uint16(r) << 8) & 0xF800) +
-										(((uint16(g) << 8) & 0xFC00) >> 5) +
-										(((uint16(b) << 8) & 0xF800) >> 11))
-								}
+							if err := d.writeMCU(mx*mw, my*mw, mw); err != nil {
+								return err
 							}
-							callback(callbackBuf[:8*8*4], int16(bx8-bx16), int16(by8-by16), 16, 16, int16(d.width), int16(d.height))
 						}
 					}
 				} // for j
@@ -541,10 +526,42 @@ func (d *decoder) reconstructProgressiveImage() error {
 	return nil
 }
 
-// reconstructBlockBuf is a Buffer for the 8x8 pix data to be processed by
-// reconstructBlock. It is defined and used as a package variable to reduce
-// memory usage.
-var reconstructBlockBuf [64]byte
+// setClip computes the scaled size of the image, and of the decoded region.
+func (d *decoder) setClip() {
+	s := 1 << d.scale
+	d.outWidth = (d.width + s - 1) / s
+	d.outHeight = (d.height + s - 1) / s
+	d.crop = !d.region.Empty()
+	if !d.crop {
+		return
+	}
+	r := d.region.Intersect(image.Rect(0, 0, d.width, d.height))
+	d.clip = image.Rect(r.Min.X/s, r.Min.Y/s, (r.Max.X+s-1)/s, (r.Max.Y+s-1)/s)
+	d.outWidth = d.clip.Dx()
+	d.outHeight = d.clip.Dy()
+}
+
+// writeMCU passes the m x m pixels in mcuBuf, of the MCU at x, y in the scaled
+// image, to the output. When decoding a region, they are clipped to it, and
+// positioned relative to it.
+func (d *decoder) writeMCU(x, y, m int) error {
+	if !d.crop {
+		return d.out.write(d.mcuBuf[:], 3, x, y, m, m, d.outWidth, d.outHeight)
+	}
+	r := image.Rect(x, y, x+m, y+m).Intersect(d.clip)
+	if r.Empty() {
+		return nil
+	}
+	w, h := r.Dx(), r.Dy()
+	if w != m || h != m {
+		// Move the rows in place, so that they are w pixels wide
+		for j := 0; j < h; j++ {
+			src := ((r.Min.Y-y+j)*m + r.Min.X - x) * 3
+			copy(d.mcuBuf[j*w*3:(j+1)*w*3], d.mcuBuf[src:src+w*3])
+		}
+	}
+	return d.out.write(d.mcuBuf[:], 3, r.Min.X-d.clip.Min.X, r.Min.Y-d.clip.Min.Y, w, h, d.outWidth, d.outHeight)
+}
 
 // reconstructBlock dequantizes, performs the inverse DCT and stores the block
 // to the image.
@@ -556,12 +573,18 @@ func (d *decoder) reconstructBlock(b *block, bx, by, compIndex int) ([]byte, err
 	for zig := 0; zig < blockSize; zig++ {
 		b[unzig[zig]] *= qt[zig]
 	}
-	idct(b)
+	// A scaled block is n x n pixels.
+	n := 8 >> d.scale
+	if n == 8 {
+		idct(b)
+	} else {
+		idctScaled(b, n)
+	}
 	// Level shift by +128, clip to [0, 255], and write to dst.
-	var buf = reconstructBlockBuf[:]
-	for y := 0; y < 8; y++ {
+	var buf = d.blockBuf[:n*n]
+	for y := 0; y < n; y++ {
 		y8 := y * 8
-		for x := 0; x < 8; x++ {
+		for x := 0; x < n; x++ {
 			c := b[y8+x]
 			if c < -128 {
 				c = 0
@@ -570,7 +593,7 @@ func (d *decoder) reconstructBlock(b *block, bx, by, compIndex int) ([]byte, err
 			} else {
 				c += 128
 			}
-			buf[y*8+x] = uint8(c)
+			buf[y*n+x] = uint8(c)
 		}
 	}
 	return buf, nil
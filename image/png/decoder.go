@@ -0,0 +1,55 @@
+package png
+
+import (
+	"io"
+
+	"tinygo.org/x/drivers/image/internal/dither"
+	"tinygo.org/x/drivers/pixel"
+)
+
+// ImageCallback receives a decoded portion of the image, img, to draw at x,
+// y. The size of the whole image is passed as width and height. img is
+// reused by the decoder once the callback returns.
+type ImageCallback[T pixel.Color] func(img pixel.Image[T], x, y, width, height int16)
+
+// Decoder decodes PNG images in pixel format T. Unlike Decode, a Decoder
+// owns its buffers and callback, so several images can be decoded at once,
+// with a Decoder each.
+//
+// Monochrome images are dithered.
+type Decoder[T pixel.Color] struct {
+	callback ImageCallback[T]
+	buf      pixel.Image[T] // A row of the image
+	window   []byte         // Decompression history
+}
+
+// NewDecoder returns a decoder passing the image to fn, a row at a time. The
+// decoder needs a 32KiB decompression buffer and a row buffer, which are
+// reused to decode the next images.
+func NewDecoder[T pixel.Color](fn ImageCallback[T]) *Decoder[T] {
+	return &Decoder[T]{
+		callback: fn,
+		window:   make([]byte, 1<<15),
+	}
+}
+
+// Decode reads a PNG image from r, passing it to the callback of the
+// decoder.
+func (d *Decoder[T]) Decode(r io.Reader) error {
+	return decode(r, d, d.window)
+}
+
+func (d *Decoder[T]) write(pix []byte, bpp, x, y, w, h, width, height int) error {
+	if d.buf.Len() < w*h {
+		d.buf = pixel.NewImage[T](w, h)
+	}
+	img := d.buf.Rescale(w, h)
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			p := pix[(j*w+i)*bpp:]
+			img.Set(i, j, dither.Color[T](p[0], p[1], p[2], x+i, y+j))
+		}
+	}
+	d.callback(img, int16(x), int16(y), int16(width), int16(height))
+	return nil
+}
@@ -0,0 +1,147 @@
+package png
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+
+	"tinygo.org/x/drivers/pixel"
+)
+
+// encodeTest returns a PNG of a w x h gradient, with alpha if alpha is not
+// 0xff.
+func encodeTest(t *testing.T, w, h int, alpha uint8) []byte {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 0x80, alpha})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodeTest decodes data into an image of format T.
+func decodeTest[T pixel.Color](data []byte) (pixel.Image[T], error) {
+	var img pixel.Image[T]
+	d := NewDecoder(func(part pixel.Image[T], x, y, width, height int16) {
+		if img.Len() == 0 {
+			img = pixel.NewImage[T](int(width), int(height))
+		}
+		w, h := part.Size()
+		for j := 0; j < h; j++ {
+			for i := 0; i < w; i++ {
+				img.Set(int(x)+i, int(y)+j, part.Get(i, j))
+			}
+		}
+	})
+	err := d.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func TestDecoder(t *testing.T) {
+	for _, alpha := range []uint8{0xff, 0x80} {
+		img, err := decodeTest[pixel.RGB888](encodeTest(t, 20, 6, alpha))
+		if err != nil {
+			t.Fatalf("alpha %#x: %v", alpha, err)
+		}
+		if w, h := img.Size(); w != 20 || h != 6 {
+			t.Fatalf("alpha %#x: got size %dx%d, want 20x6", alpha, w, h)
+		}
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 20; x++ {
+				want := pixel.NewRGB888(uint8(x*255/20), uint8(y*255/6), 0x80)
+				if got := img.Get(x, y); got != want {
+					t.Fatalf("alpha %#x: pixel %d,%d is %v, want %v", alpha, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecoderDither(t *testing.T) {
+	rgb := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(rgb.Pix); i += 4 {
+		rgb.Pix[i], rgb.Pix[i+1], rgb.Pix[i+2], rgb.Pix[i+3] = 0x80, 0x80, 0x80, 0xff
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, rgb); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := decodeTest[pixel.Monochrome](buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var white int
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if img.Get(x, y) {
+				white++
+			}
+		}
+	}
+	if white != 32 {
+		t.Errorf("got %d white pixels of 64 for 50%% gray, want 32", white)
+	}
+}
+
+func TestDecoderConcurrent(t *testing.T) {
+	images := [][]byte{encodeTest(t, 20, 6, 0xff), encodeTest(t, 7, 30, 0xff)}
+	want := make([]pixel.Image[pixel.RGB565BE], len(images))
+	for i, data := range images {
+		var err error
+		want[i], err = decodeTest[pixel.RGB565BE](data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := i % len(images)
+			img, err := decodeTest[pixel.RGB565BE](images[n])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(img.RawBuffer(), want[n].RawBuffer()) {
+				t.Errorf("image %d differs when decoded concurrently", n)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSetCallback(t *testing.T) {
+	var got []uint16
+	SetCallback(make([]uint16, 20), func(data []uint16, x, y, w, h, width, height int16) {
+		got = append(got, data[:w*h]...)
+	})
+	defer SetCallback(nil, func(data []uint16, x, y, w, h, width, height int16) {})
+
+	data := encodeTest(t, 20, 6, 0xff)
+	if _, err := Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 20*6 {
+		t.Fatalf("got %d pixels, want %d", len(got), 20*6)
+	}
+	// Last pixel: r 0xf2, g 0xd4, b 0x80
+	if want := uint16(0xf2>>3<<11 | 0xd4>>2<<5 | 0x80>>3); got[len(got)-1] != want {
+		t.Errorf("got %#04x, want %#04x", got[len(got)-1], want)
+	}
+
+	SetCallback(make([]uint16, 19), func(data []uint16, x, y, w, h, width, height int16) {})
+	if _, err := Decode(bytes.NewReader(data)); err != ErrBufferTooSmall {
+		t.Errorf("got %v, want %v", err, ErrBufferTooSmall)
+	}
+}
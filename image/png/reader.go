@@ -119,6 +119,9 @@ type decoder struct {
 	// transparency, as opposed to palette transparency.
 	useTransparent bool
 	transparent    [6]byte
+
+	out    output
+	window []byte
 }
 
 // A FormatError reports that the input is not a valid PNG.
@@ -365,7 +368,7 @@ func (d *decoder) Read(p []byte) (int, error) {
 
 // decode decodes the IDAT data into an image.
 func (d *decoder) decode() (image.Image, error) {
-	r, err := zlib.NewReader(d)
+	r, err := zlib.NewReaderWindow(d, d.window)
 	if err != nil {
 		return nil, err
 	}
@@ -672,13 +675,9 @@ func (d *decoder) readImagePass(r io.Reader, pass int, allocateOnly bool) (image
 				}
 				pixOffset += nrgba.Stride
 			} else {
-				for x := 0; x < width; x++ {
-					r := uint16(cdat[x*3+0]) << 8
-					g := uint16(cdat[x*3+1]) << 8
-					b := uint16(cdat[x*3+2]) << 8
-					callbackBuf[x] = uint16((r & 0xF800) + ((g & 0xFC00) >> 5) + ((b & 0xF800) >> 11))
+				if err := d.out.write(cdat, 3, 0, y, width, 1, width, height); err != nil {
+					return nil, err
 				}
-				callback(callbackBuf[:width*1], 0, int16(y), int16(width), 1, int16(width), int16(height))
 				pixOffset += rgba.Stride
 			}
 		case cbP1:
@@ -729,13 +728,9 @@ func (d *decoder) readImagePass(r io.Reader, pass int, allocateOnly bool) (image
 			pixOffset += paletted.Stride
 		case cbTCA8:
 			copy(nrgba.Pix[:], cdat)
-			for x := 0; x < width; x++ {
-				r := uint16(cdat[x*3+0]) << 8
-				g := uint16(cdat[x*3+1]) << 8
-				b := uint16(cdat[x*3+2]) << 8
-				callbackBuf[x] = uint16((r & 0xF800) + ((g & 0xFC00) >> 5) + ((b & 0xF800) >> 11))
+			if err := d.out.write(cdat, 4, 0, y, width, 1, width, height); err != nil {
+				return nil, err
 			}
-			callback(callbackBuf[:width*1], 0, int16(y), int16(width), 1, int16(width), int16(height))
 			pixOffset += nrgba.Stride
 		case cbG16:
 			if d.useTransparent {
@@ -968,25 +963,33 @@ func (d *decoder) checkHeader() error {
 // Decode reads a PNG image from r. Different from the standard package, the
 // decoded result will be received by the callback set by SetCallback().
 func Decode(r io.Reader) (image.Image, error) {
+	return nil, decode(r, &rgb565Output{buf: callbackBuf, fn: callback}, nil)
+}
+
+// decode reads a PNG image from r, passing the pixels to out. The
+// decompression history is kept in window, or in a shared buffer if nil.
+func decode(r io.Reader, out output, window []byte) error {
 	d := &decoder{
-		r:   r,
-		crc: crc32.NewIEEE(),
+		r:      r,
+		crc:    crc32.NewIEEE(),
+		out:    out,
+		window: window,
 	}
 	if err := d.checkHeader(); err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
-		return nil, err
+		return err
 	}
 	for d.stage != dsSeenIEND {
 		if err := d.parseChunk(); err != nil {
 			if err == io.EOF {
 				err = io.ErrUnexpectedEOF
 			}
-			return nil, err
+			return err
 		}
 	}
-	return nil, nil
+	return nil
 }
 
 // DecodeConfig returns the color model and dimensions of a PNG image without
@@ -0,0 +1,56 @@
+package lora
+
+import "time"
+
+// bandwidthHz holds the bandwidth in Hz of each Bandwidth_* setting
+var bandwidthHz = [...]uint32{7810, 10420, 15630, 20830, 31250, 41670, 62500, 125000, 250000, 500000}
+
+// BandwidthHz returns the bandwidth in Hz of a Bandwidth_* setting, or 0
+// if unknown
+func BandwidthHz(bw uint8) uint32 {
+	if int(bw) >= len(bandwidthHz) {
+		return 0
+	}
+	return bandwidthHz[bw]
+}
+
+// SymbolTime returns the duration of a LoRa symbol
+func SymbolTime(sf uint8, bw uint8) time.Duration {
+	hz := BandwidthHz(bw)
+	if hz == 0 {
+		return 0
+	}
+	return time.Duration((int64(1) << sf) * int64(time.Second) / int64(hz))
+}
+
+// TimeOnAir returns the transmission duration of a payload of payloadLen
+// bytes with the given LoRa configuration (see Semtech AN1200.13)
+func TimeOnAir(conf Config, payloadLen int) time.Duration {
+	tSym := SymbolTime(conf.Sf, conf.Bw)
+	if tSym == 0 || conf.Sf < SpreadingFactor5 || conf.Sf > SpreadingFactor12 {
+		return 0
+	}
+
+	sf := int64(conf.Sf)
+	crc, ih, de := int64(0), int64(0), int64(0)
+	if conf.Crc == CRCOn {
+		crc = 1
+	}
+	if conf.HeaderType == HeaderImplicit {
+		ih = 1
+	}
+	if conf.Ldr == LowDataRateOptimizeOn {
+		de = 1
+	}
+
+	// Payload symbols: 8 + max(ceil((8PL-4SF+28+16CRC-20IH)/(4(SF-2DE)))*(CR+4), 0)
+	nPayload := int64(8)
+	num := 8*int64(payloadLen) - 4*sf + 28 + 16*crc - 20*ih
+	if num > 0 {
+		den := 4 * (sf - 2*de)
+		nPayload += (num + den - 1) / den * (int64(conf.Cr) + 4)
+	}
+
+	// Preamble is Preamble + 4.25 symbols
+	return time.Duration((4*int64(conf.Preamble)+17)*int64(tSym)/4 + nPayload*int64(tSym))
+}
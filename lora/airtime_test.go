@@ -0,0 +1,49 @@
+package lora
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeOnAir(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       Config
+		payloadLen int
+		want       time.Duration
+	}{
+		{
+			name:       "SF7 125kHz",
+			conf:       Config{Sf: SpreadingFactor7, Bw: Bandwidth_125_0, Cr: CodingRate4_5, Preamble: 8, Crc: CRCOn},
+			payloadLen: 13,
+			want:       46336 * time.Microsecond,
+		},
+		{
+			name:       "SF12 125kHz LDR",
+			conf:       Config{Sf: SpreadingFactor12, Bw: Bandwidth_125_0, Cr: CodingRate4_5, Preamble: 8, Crc: CRCOn, Ldr: LowDataRateOptimizeOn},
+			payloadLen: 13,
+			want:       1155072 * time.Microsecond,
+		},
+		{
+			name:       "SF9 125kHz no CRC",
+			conf:       Config{Sf: SpreadingFactor9, Bw: Bandwidth_125_0, Cr: CodingRate4_5, Preamble: 8},
+			payloadLen: 33,
+			want:       246784 * time.Microsecond,
+		},
+		{
+			name:       "SF8 500kHz",
+			conf:       Config{Sf: SpreadingFactor8, Bw: Bandwidth_500_0, Cr: CodingRate4_5, Preamble: 8, Crc: CRCOn},
+			payloadLen: 20,
+			want:       25728 * time.Microsecond,
+		},
+	}
+	for _, tc := range tests {
+		if got := TimeOnAir(tc.conf, tc.payloadLen); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if got := TimeOnAir(Config{Sf: SpreadingFactor7, Bw: 42}, 10); got != 0 {
+		t.Errorf("invalid bandwidth: got %v, want 0", got)
+	}
+}
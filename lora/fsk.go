@@ -0,0 +1,59 @@
+package lora
+
+import "errors"
+
+var (
+	ErrUnsupportedFskConfig = errors.New("unsupported FSK configuration")
+	ErrUnsupportedModem     = errors.New("operation not supported by current modem")
+	ErrPacketTooLong        = errors.New("packet too long")
+)
+
+// Modems (packet types) of a radio
+const (
+	ModemLoRa = iota
+	ModemFSK
+)
+
+// FSK pulse shaping
+const (
+	FskShapingNone = iota
+	FskShapingGaussBT0_3
+	FskShapingGaussBT0_5
+	FskShapingGaussBT0_7 // sx126x only
+	FskShapingGaussBT1_0
+)
+
+// FSK packet CRC
+const (
+	FskCrcOff    = iota
+	FskCrcCCITT  // 2 bytes, polynomial 0x1021, initial value 0x1D0F, inverted
+	FskCrcIBM    // 2 bytes, polynomial 0x8005, initial value 0xFFFF
+	FskCrcCustom // 2 bytes, CrcPolynomial and CrcInit (sx126x only)
+)
+
+// FskConfig holds the FSK/GFSK configuration parameters
+type FskConfig struct {
+	Freq          uint32  // Frequency, in Hz
+	Bitrate       uint32  // Bit rate, in bit/s
+	Fdev          uint32  // Frequency deviation, in Hz
+	RxBw          uint32  // Receiver double side bandwidth in Hz, rounded up to a supported value, 0 for Fdev*2 + Bitrate
+	Shaping       uint8   // Pulse shaping: FskShaping*
+	Preamble      uint16  // Preamble length, in bytes
+	SyncWord      []uint8 // Sync word, up to 8 bytes
+	FixedLength   bool    // Fixed length packets, or variable length packets starting with their length
+	PayloadLength uint8   // Length of fixed length packets, maximum length of variable length ones
+	Crc           uint8   // Packet CRC: FskCrc*
+	CrcPolynomial uint16  // Polynomial of FskCrcCustom
+	CrcInit       uint16  // Initial value of FskCrcCustom
+	Whitening     bool    // PN9 data whitening
+	TxPowerDBm    int8    // Tx power in Dbm
+}
+
+// RxBandwidth returns the receiver bandwidth, or Fdev*2 + Bitrate when
+// RxBw is 0
+func (c FskConfig) RxBandwidth() uint32 {
+	if c.RxBw != 0 {
+		return c.RxBw
+	}
+	return 2*c.Fdev + c.Bitrate
+}
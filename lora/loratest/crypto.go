@@ -0,0 +1,104 @@
+package loratest
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+)
+
+// cmac computes the AES-CMAC (RFC 4493) of data
+func cmac(key [16]uint8, data []uint8) [16]uint8 {
+	block, _ := aes.NewCipher(key[:])
+
+	// Subkeys
+	var l, k1, k2 [16]uint8
+	block.Encrypt(l[:], l[:])
+	shiftXor(&k1, l)
+	shiftXor(&k2, k1)
+
+	n := (len(data) + 15) / 16
+	complete := n > 0 && len(data)%16 == 0
+	if n == 0 {
+		n = 1
+	}
+
+	var x, last [16]uint8
+	for i := 0; i < n-1; i++ {
+		xor(x[:], data[i*16:(i+1)*16])
+		block.Encrypt(x[:], x[:])
+	}
+	rest := data[(n-1)*16:]
+	copy(last[:], rest)
+	if complete {
+		xor(last[:], k1[:])
+	} else {
+		last[len(rest)] = 0x80
+		xor(last[:], k2[:])
+	}
+	xor(x[:], last[:])
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+// xor sets dst to dst ^ src
+func xor(dst, src []uint8) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// shiftXor sets dst to src shifted left by one bit, xored with Rb when the
+// MSB of src is set
+func shiftXor(dst *[16]uint8, src [16]uint8) {
+	for i := 0; i < 16; i++ {
+		dst[i] = src[i] << 1
+		if i < 15 {
+			dst[i] |= src[i+1] >> 7
+		}
+	}
+	if src[0]&0x80 != 0 {
+		dst[15] ^= 0x87
+	}
+}
+
+// messageMIC computes the LoRaWAN 1.0 MIC of a data message
+func messageMIC(key [16]uint8, dir uint8, devAddr [4]uint8, fCnt uint32, msg []uint8) [4]uint8 {
+	b0 := make([]uint8, 16, 16+len(msg))
+	b0[0] = 0x49
+	b0[5] = dir
+	copy(b0[6:10], devAddr[:])
+	binary.LittleEndian.PutUint32(b0[10:14], fCnt)
+	b0[15] = uint8(len(msg))
+	full := cmac(key, append(b0, msg...))
+	var mic [4]uint8
+	copy(mic[:], full[:4])
+	return mic
+}
+
+// encryptFRMPayload encrypts (or decrypts) a LoRaWAN FRMPayload
+func encryptFRMPayload(key [16]uint8, dir uint8, devAddr [4]uint8, fCnt uint32, payload []uint8) []uint8 {
+	block, _ := aes.NewCipher(key[:])
+	out := make([]uint8, len(payload))
+	var a, s [16]uint8
+	a[0] = 0x01
+	a[5] = dir
+	copy(a[6:10], devAddr[:])
+	binary.LittleEndian.PutUint32(a[10:14], fCnt)
+	for i := 0; i < len(payload); i += 16 {
+		a[15] = uint8(i/16 + 1)
+		block.Encrypt(s[:], a[:])
+		for j := i; j < len(payload) && j < i+16; j++ {
+			out[j] = payload[j] ^ s[j-i]
+		}
+	}
+	return out
+}
+
+// sessionKey computes aes128_encrypt(key, prefix | data | pad16)
+func sessionKey(key [16]uint8, prefix uint8, data []uint8) [16]uint8 {
+	var in, out [16]uint8
+	in[0] = prefix
+	copy(in[1:], data)
+	block, _ := aes.NewCipher(key[:])
+	block.Encrypt(out[:], in[:])
+	return out
+}
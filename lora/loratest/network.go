@@ -0,0 +1,318 @@
+package loratest
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidFrame   = errors.New("invalid LoRaWAN frame")
+	ErrInvalidMIC     = errors.New("invalid MIC")
+	ErrUnknownDevAddr = errors.New("unknown DevAddr")
+	ErrNotJoined      = errors.New("device not joined")
+	ErrFCntReplay     = errors.New("uplink FCnt replayed")
+)
+
+// LoRaWAN message types (MHDR)
+const (
+	MTYPE_JOIN_REQUEST     = 0x00
+	MTYPE_JOIN_ACCEPT      = 0x20
+	MTYPE_UNCONFIRMED_UP   = 0x40
+	MTYPE_UNCONFIRMED_DOWN = 0x60
+	MTYPE_CONFIRMED_UP     = 0x80
+	MTYPE_CONFIRMED_DOWN   = 0xA0
+)
+
+// JoinRequest is a join request received by the network server
+type JoinRequest struct {
+	JoinEUI  [8]uint8 // MSB first
+	DevEUI   [8]uint8 // MSB first
+	DevNonce uint16
+}
+
+// Uplink is a data uplink received by the network server
+type Uplink struct {
+	Confirmed bool
+	ADR       bool
+	ACK       bool
+	FCnt      uint32
+	FOpts     []uint8
+	HasFPort  bool
+	FPort     uint8
+	Payload   []uint8 // decrypted FRMPayload
+}
+
+// Downlink is a data downlink queued on the network server
+type Downlink struct {
+	Confirmed bool
+	FPort     uint8
+	Payload   []uint8
+}
+
+// NetworkServer is a minimal LoRaWAN 1.0.x network server serving a single
+// device. Attached to a Radio, it answers join requests and data uplinks
+// sent by the device, in the first receive window by default.
+type NetworkServer struct {
+	AppKey     [16]uint8
+	NetID      [3]uint8
+	DevAddr    [4]uint8 // as sent over the air, LSB first
+	DLSettings uint8
+	RXDelay    uint8
+	CFList     []uint8 // 16 bytes, or nil
+
+	// RX2 makes the server answer in the second receive window
+	RX2 bool
+	// RSSI and SNR of downlinks received by the device
+	RSSI int16
+	SNR  int8
+
+	// JoinRequests and Uplinks hold the valid frames received
+	JoinRequests []JoinRequest
+	Uplinks      []Uplink
+	// Errors holds the reasons of rejected frames
+	Errors []error
+
+	joinNonce  uint32
+	joined     bool
+	nwkSKey    [16]uint8
+	appSKey    [16]uint8
+	fCntUp     uint32
+	fCntDown   uint32
+	uplinkSeen bool
+	ackNeeded  bool
+	downlinks  []Downlink
+	macCmds    []uint8
+}
+
+// NewNetworkServer returns a network server accepting devices using appKey
+func NewNetworkServer(appKey [16]uint8) *NetworkServer {
+	return &NetworkServer{
+		AppKey:  appKey,
+		NetID:   [3]uint8{0x13, 0x00, 0x00},
+		DevAddr: [4]uint8{0x04, 0x03, 0x02, 0x01},
+		RXDelay: 1,
+	}
+}
+
+// Attach makes the network server answer the frames sent by r
+func (ns *NetworkServer) Attach(r *Radio) {
+	r.OnTx = func(p Packet) {
+		reply, err := ns.HandleUplink(p.Payload)
+		if err != nil {
+			ns.Errors = append(ns.Errors, err)
+			return
+		}
+		if reply == nil {
+			return
+		}
+		if ns.RX2 {
+			// Nothing in RX1
+			r.QueueRx(Reply{})
+		}
+		r.QueueRx(Reply{Payload: reply, RSSI: ns.RSSI, SNR: ns.SNR})
+	}
+}
+
+// QueueDownlink adds a downlink sent in answer to the next uplinks
+func (ns *NetworkServer) QueueDownlink(fPort uint8, payload []uint8, confirmed bool) {
+	ns.downlinks = append(ns.downlinks, Downlink{Confirmed: confirmed, FPort: fPort, Payload: payload})
+}
+
+// QueueMACCommand adds MAC commands sent in FOpts of the next downlink
+func (ns *NetworkServer) QueueMACCommand(cmd ...uint8) {
+	ns.macCmds = append(ns.macCmds, cmd...)
+}
+
+// SessionKeys returns the NwkSKey and AppSKey of the last join
+func (ns *NetworkServer) SessionKeys() (nwkSKey, appSKey [16]uint8) {
+	return ns.nwkSKey, ns.appSKey
+}
+
+// FCntDown returns the next downlink frame counter
+func (ns *NetworkServer) FCntDown() uint32 {
+	return ns.fCntDown
+}
+
+// HandleUplink processes a frame sent by the device and returns the frame
+// to send back, or nil when there is nothing to answer
+func (ns *NetworkServer) HandleUplink(phy []uint8) ([]uint8, error) {
+	if len(phy) < 1 {
+		return nil, ErrInvalidFrame
+	}
+	switch phy[0] & 0xE0 {
+	case MTYPE_JOIN_REQUEST:
+		return ns.handleJoinRequest(phy)
+	case MTYPE_UNCONFIRMED_UP, MTYPE_CONFIRMED_UP:
+		return ns.handleData(phy)
+	}
+	return nil, ErrInvalidFrame
+}
+
+func (ns *NetworkServer) handleJoinRequest(phy []uint8) ([]uint8, error) {
+	if len(phy) != 23 {
+		return nil, ErrInvalidFrame
+	}
+	if mic := cmac(ns.AppKey, phy[:19]); !checkMIC(mic[:], phy[19:]) {
+		return nil, ErrInvalidMIC
+	}
+	var req JoinRequest
+	for i := 0; i < 8; i++ {
+		req.JoinEUI[i] = phy[8-i]
+		req.DevEUI[i] = phy[16-i]
+	}
+	req.DevNonce = binary.LittleEndian.Uint16(phy[17:19])
+	ns.JoinRequests = append(ns.JoinRequests, req)
+
+	ns.joinNonce++
+	accept := []uint8{MTYPE_JOIN_ACCEPT,
+		uint8(ns.joinNonce), uint8(ns.joinNonce >> 8), uint8(ns.joinNonce >> 16)}
+	accept = append(accept, ns.NetID[:]...)
+	accept = append(accept, ns.DevAddr[:]...)
+	accept = append(accept, ns.DLSettings, ns.RXDelay)
+	accept = append(accept, ns.CFList...)
+	mic := cmac(ns.AppKey, accept)
+	accept = append(accept, mic[:4]...)
+
+	// The device encrypts the join accept to decrypt it
+	block, _ := aes.NewCipher(ns.AppKey[:])
+	for i := 1; i+aes.BlockSize <= len(accept); i += aes.BlockSize {
+		block.Decrypt(accept[i:], accept[i:i+aes.BlockSize])
+	}
+
+	// NwkSKey and AppSKey are derived from JoinNonce | NetID | DevNonce
+	ctx := []uint8{uint8(ns.joinNonce), uint8(ns.joinNonce >> 8), uint8(ns.joinNonce >> 16)}
+	ctx = append(ctx, ns.NetID[:]...)
+	ctx = append(ctx, phy[17:19]...)
+	ns.nwkSKey = sessionKey(ns.AppKey, 0x01, ctx)
+	ns.appSKey = sessionKey(ns.AppKey, 0x02, ctx)
+	ns.joined = true
+	ns.uplinkSeen = false
+	ns.fCntUp = 0
+	ns.fCntDown = 0
+	ns.ackNeeded = false
+	return accept, nil
+}
+
+func (ns *NetworkServer) handleData(phy []uint8) ([]uint8, error) {
+	if !ns.joined {
+		return nil, ErrNotJoined
+	}
+	if len(phy) < 12 {
+		return nil, ErrInvalidFrame
+	}
+	var devAddr [4]uint8
+	copy(devAddr[:], phy[1:5])
+	if devAddr != ns.DevAddr {
+		return nil, ErrUnknownDevAddr
+	}
+	fCtrl := phy[5]
+	fOptsLen := int(fCtrl & 0x0F)
+	if len(phy) < 12+fOptsLen {
+		return nil, ErrInvalidFrame
+	}
+
+	// Rebuild the 32 bits frame counter from its 16 LSB
+	// Retransmissions reuse the frame counter of the last uplink
+	fCnt := uint32(binary.LittleEndian.Uint16(phy[6:8]))
+	last := ns.fCntUp - 1
+	if ns.uplinkSeen {
+		fCnt |= last & 0xFFFF0000
+	}
+	if ns.uplinkSeen && fCnt < last {
+		fCnt += 0x10000
+	}
+	msg := phy[:len(phy)-4]
+	mic := messageMIC(ns.nwkSKey, 0, ns.DevAddr, fCnt, msg)
+	if !checkMIC(mic[:], phy[len(phy)-4:]) {
+		return nil, ErrInvalidMIC
+	}
+	if ns.uplinkSeen && fCnt < last {
+		return nil, ErrFCntReplay
+	}
+	ns.fCntUp = fCnt + 1
+	ns.uplinkSeen = true
+
+	up := Uplink{
+		Confirmed: phy[0]&0xE0 == MTYPE_CONFIRMED_UP,
+		ADR:       fCtrl&0x80 != 0,
+		ACK:       fCtrl&0x20 != 0,
+		FCnt:      fCnt,
+		FOpts:     append([]uint8(nil), phy[8:8+fOptsLen]...),
+	}
+	if rest := msg[8+fOptsLen:]; len(rest) > 0 {
+		up.HasFPort = true
+		up.FPort = rest[0]
+		key := ns.appSKey
+		if up.FPort == 0 {
+			key = ns.nwkSKey
+		}
+		up.Payload = encryptFRMPayload(key, 0, ns.DevAddr, fCnt, rest[1:])
+	}
+	ns.Uplinks = append(ns.Uplinks, up)
+	ns.ackNeeded = up.Confirmed
+
+	return ns.nextDownlink(), nil
+}
+
+// nextDownlink builds the answer to the last uplink, if any
+func (ns *NetworkServer) nextDownlink() []uint8 {
+	if !ns.ackNeeded && len(ns.downlinks) == 0 && len(ns.macCmds) == 0 {
+		return nil
+	}
+
+	mType := uint8(MTYPE_UNCONFIRMED_DOWN)
+	var dl *Downlink
+	if len(ns.downlinks) > 0 {
+		dl = &ns.downlinks[0]
+		ns.downlinks = ns.downlinks[1:]
+		if dl.Confirmed {
+			mType = MTYPE_CONFIRMED_DOWN
+		}
+	}
+
+	// MAC commands are sent in FOpts, or in a port 0 payload when too long
+	fOpts := ns.macCmds
+	if len(fOpts) > 15 {
+		if dl == nil {
+			dl = &Downlink{FPort: 0, Payload: fOpts}
+			ns.macCmds = nil
+		}
+		fOpts = nil
+	} else {
+		ns.macCmds = nil
+	}
+
+	fCtrl := uint8(len(fOpts))
+	if ns.ackNeeded {
+		fCtrl |= 0x20
+		ns.ackNeeded = false
+	}
+
+	buf := []uint8{mType}
+	buf = append(buf, ns.DevAddr[:]...)
+	buf = append(buf, fCtrl, uint8(ns.fCntDown), uint8(ns.fCntDown>>8))
+	buf = append(buf, fOpts...)
+	if dl != nil {
+		key := ns.appSKey
+		if dl.FPort == 0 {
+			key = ns.nwkSKey
+		}
+		buf = append(buf, dl.FPort)
+		buf = append(buf, encryptFRMPayload(key, 1, ns.DevAddr, ns.fCntDown, dl.Payload)...)
+	}
+	mic := messageMIC(ns.nwkSKey, 1, ns.DevAddr, ns.fCntDown, buf)
+	buf = append(buf, mic[:]...)
+	ns.fCntDown++
+	return buf
+}
+
+// checkMIC compares the first bytes of a computed CMAC to a received MIC
+func checkMIC(computed []uint8, mic []uint8) bool {
+	for i := range mic {
+		if computed[i] != mic[i] {
+			return false
+		}
+	}
+	return true
+}
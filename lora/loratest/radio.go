@@ -0,0 +1,269 @@
+// Package loratest provides an in-memory LoRa radio and a minimal LoRaWAN
+// network server, to test code using lora.Radio with go test on the host.
+package loratest // import "tinygo.org/x/drivers/lora/loratest"
+
+import (
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+)
+
+// Packet is a LoRa packet sent or received by the simulated radio, with the
+// modulation in use at that time
+type Packet struct {
+	Frequency       uint32
+	Bandwidth       uint8
+	SpreadingFactor uint8
+	CodingRate      uint8
+	IqMode          uint8
+	TxPowerDBm      int8
+	Payload         []uint8 // nil for receptions that timed out
+}
+
+// Reply is a scripted result of a Rx call
+type Reply struct {
+	Frequency       uint32  // listening frequency required to receive it, 0 for any
+	SpreadingFactor uint8   // spreading factor required to receive it, 0 for any
+	Payload         []uint8 // nil simulates a timeout
+	Err             error   // returned by Rx when not nil
+	RSSI            int16   // reception metadata returned by ReadPacket
+	SNR             int8
+	FreqError       int32
+}
+
+// Radio is a simulated lora.CadRadio. Transmissions are recorded in Sent,
+// and receptions return the replies queued by QueueRx, or time out.
+// Asynchronous operations complete immediately, their event is available on
+// the event channel when StartTx or StartRx returns.
+type Radio struct {
+	// Sent holds the transmitted packets
+	Sent []Packet
+	// Received holds the listening configuration and result of Rx calls
+	Received []Packet
+	// Cads holds the configuration of channel activity detections
+	Cads []Packet
+	// OnTx is called after each transmission, for example to queue replies
+	OnTx func(p Packet)
+	// TxErr is returned by Tx when not nil
+	TxErr error
+
+	conf          lora.Config
+	publicNetwork bool
+	replies       []Reply
+	activity      []bool
+	cadConf       lora.CadConfig
+	events        chan lora.RadioEvent
+	lastPacket    lora.Packet
+}
+
+// NewRadio returns a new simulated radio
+func NewRadio() *Radio {
+	return &Radio{
+		conf: lora.Config{
+			Preamble:   8,
+			HeaderType: lora.HeaderExplicit,
+			Crc:        lora.CRCOn,
+		},
+		events: make(chan lora.RadioEvent, 1),
+	}
+}
+
+// QueueRx adds replies returned in order by next Rx calls
+func (r *Radio) QueueRx(replies ...Reply) {
+	r.replies = append(r.replies, replies...)
+}
+
+// QueueCad adds results returned in order by next channel activity
+// detections. Once all are used, the channel is free.
+func (r *Radio) QueueCad(busy ...bool) {
+	r.activity = append(r.activity, busy...)
+}
+
+// CadConfig returns the channel activity detection configuration
+func (r *Radio) CadConfig() lora.CadConfig {
+	return r.cadConf
+}
+
+// PendingRx returns the number of queued replies not yet received
+func (r *Radio) PendingRx() int {
+	return len(r.replies)
+}
+
+// Config returns the current radio configuration
+func (r *Radio) Config() lora.Config {
+	return r.conf
+}
+
+// PublicNetwork reports if the public network sync word is set
+func (r *Radio) PublicNetwork() bool {
+	return r.publicNetwork
+}
+
+// packet returns a packet with the current modulation
+func (r *Radio) packet(payload []uint8) Packet {
+	return Packet{
+		Frequency:       r.conf.Freq,
+		Bandwidth:       r.conf.Bw,
+		SpreadingFactor: r.conf.Sf,
+		CodingRate:      r.conf.Cr,
+		IqMode:          r.conf.Iq,
+		TxPowerDBm:      r.conf.LoraTxPowerDBm,
+		Payload:         payload,
+	}
+}
+
+func (r *Radio) Reset() {}
+
+// Tx records the packet and calls OnTx
+func (r *Radio) Tx(pkt []uint8, timeoutMs uint32) error {
+	if r.conf.Freq == 0 {
+		return lora.ErrUndefinedLoraConf
+	}
+	if r.TxErr != nil {
+		return r.TxErr
+	}
+	p := r.packet(append([]uint8(nil), pkt...))
+	r.Sent = append(r.Sent, p)
+	if r.OnTx != nil {
+		r.OnTx(p)
+	}
+	return nil
+}
+
+// Rx returns the next queued reply. It times out, returning nil, when no
+// reply is queued or when the radio doesn't listen with the modulation
+// required by the reply.
+func (r *Radio) Rx(timeoutMs uint32) ([]uint8, error) {
+	pkt, err := r.receive()
+	return pkt.Payload, err
+}
+
+// GetRadioEventChan returns the channel of asynchronous operation events
+func (r *Radio) GetRadioEventChan() chan lora.RadioEvent {
+	return r.events
+}
+
+// StartTx transmits like Tx, then sends RadioEventTxDone
+func (r *Radio) StartTx(pkt []uint8, timeoutMs uint32) error {
+	if err := r.Tx(pkt, timeoutMs); err != nil {
+		return err
+	}
+	r.sendEvent(lora.RadioEventTxDone)
+	return nil
+}
+
+// StartRx receives like Rx, then sends RadioEventRxDone or
+// RadioEventTimeout
+func (r *Radio) StartRx(timeoutMs uint32) error {
+	pkt, err := r.receive()
+	if err != nil {
+		return err
+	}
+	if pkt.Payload == nil {
+		r.sendEvent(lora.RadioEventTimeout)
+		return nil
+	}
+	r.lastPacket = pkt
+	r.sendEvent(lora.RadioEventRxDone)
+	return nil
+}
+
+// ReadPacket returns the packet received by the last StartRx
+func (r *Radio) ReadPacket() (lora.Packet, error) {
+	return r.lastPacket, nil
+}
+
+func (r *Radio) SetCadConfig(cnf lora.CadConfig) {
+	r.cadConf = cnf
+}
+
+// StartCad returns the next queued activity with RadioEventCadDetected or
+// RadioEventCadDone
+func (r *Radio) StartCad() error {
+	if r.conf.Freq == 0 {
+		return lora.ErrUndefinedLoraConf
+	}
+	r.Cads = append(r.Cads, r.packet(nil))
+	busy := false
+	if len(r.activity) > 0 {
+		busy = r.activity[0]
+		r.activity = r.activity[1:]
+	}
+	if busy {
+		r.sendEvent(lora.RadioEventCadDetected)
+	} else {
+		r.sendEvent(lora.RadioEventCadDone)
+	}
+	return nil
+}
+
+// ChannelActivity returns the next queued activity
+func (r *Radio) ChannelActivity() (bool, error) {
+	if err := r.StartCad(); err != nil {
+		return false, err
+	}
+	return (<-r.events).EventType == lora.RadioEventCadDetected, nil
+}
+
+// sendEvent replaces the pending event, if any, by a new one
+func (r *Radio) sendEvent(eType int) {
+	select {
+	case <-r.events:
+	default:
+	}
+	r.events <- lora.NewRadioEvent(eType, 0, nil)
+}
+
+// receive pops the next queued reply
+func (r *Radio) receive() (lora.Packet, error) {
+	if r.conf.Freq == 0 {
+		return lora.Packet{}, lora.ErrUndefinedLoraConf
+	}
+	p := r.packet(nil)
+	var pkt lora.Packet
+	if len(r.replies) > 0 {
+		reply := r.replies[0]
+		r.replies = r.replies[1:]
+		if reply.Err != nil {
+			r.Received = append(r.Received, p)
+			return pkt, reply.Err
+		}
+		if reply.Payload != nil &&
+			(reply.Frequency == 0 || reply.Frequency == p.Frequency) &&
+			(reply.SpreadingFactor == 0 || reply.SpreadingFactor == p.SpreadingFactor) {
+			p.Payload = append([]uint8{}, reply.Payload...)
+			pkt = lora.Packet{
+				Payload:   p.Payload,
+				RSSI:      reply.RSSI,
+				SNR:       reply.SNR,
+				FreqError: reply.FreqError,
+				Timestamp: time.Now(),
+			}
+		}
+	}
+	r.Received = append(r.Received, p)
+	return pkt, nil
+}
+
+func (r *Radio) SetFrequency(freq uint32)       { r.conf.Freq = freq }
+func (r *Radio) SetIqMode(mode uint8)           { r.conf.Iq = mode }
+func (r *Radio) SetCodingRate(cr uint8)         { r.conf.Cr = cr }
+func (r *Radio) SetBandwidth(bw uint8)          { r.conf.Bw = bw }
+func (r *Radio) SetSpreadingFactor(sf uint8)    { r.conf.Sf = sf }
+func (r *Radio) SetPreambleLength(pLen uint16)  { r.conf.Preamble = pLen }
+func (r *Radio) SetTxPower(txPower int8)        { r.conf.LoraTxPowerDBm = txPower }
+func (r *Radio) SetSyncWord(syncWord uint16)    { r.conf.SyncWord = syncWord }
+func (r *Radio) SetHeaderType(headerType uint8) { r.conf.HeaderType = headerType }
+func (r *Radio) LoraConfig(cnf lora.Config)     { r.conf = cnf }
+
+func (r *Radio) SetCrc(enable bool) {
+	if enable {
+		r.conf.Crc = lora.CRCOn
+	} else {
+		r.conf.Crc = lora.CRCOff
+	}
+}
+
+func (r *Radio) SetPublicNetwork(enabled bool) {
+	r.publicNetwork = enabled
+}
@@ -0,0 +1,88 @@
+package loratest
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/lora"
+)
+
+func TestRadio(t *testing.T) {
+	c := qt.New(t)
+
+	r := NewRadio()
+	c.Assert(r.Tx([]uint8{0x01}, 1000), qt.Equals, lora.ErrUndefinedLoraConf)
+
+	r.SetFrequency(868100000)
+	r.SetSpreadingFactor(lora.SpreadingFactor9)
+	c.Assert(r.Tx([]uint8{0x01}, 1000), qt.IsNil)
+	c.Assert(r.Sent, qt.DeepEquals, []Packet{{Frequency: 868100000, SpreadingFactor: lora.SpreadingFactor9, Payload: []uint8{0x01}}})
+
+	errRx := errors.New("rx error")
+	r.QueueRx(
+		Reply{Payload: []uint8{0x02}},
+		Reply{Frequency: 869525000, Payload: []uint8{0x03}},
+		Reply{Err: errRx},
+	)
+	pkt, err := r.Rx(1000)
+	c.Assert(err, qt.IsNil)
+	c.Assert(pkt, qt.DeepEquals, []uint8{0x02})
+
+	// Not listening on the reply frequency
+	pkt, err = r.Rx(1000)
+	c.Assert(err, qt.IsNil)
+	c.Assert(pkt, qt.IsNil)
+
+	_, err = r.Rx(1000)
+	c.Assert(err, qt.Equals, errRx)
+
+	// Nothing queued: timeout
+	pkt, err = r.Rx(1000)
+	c.Assert(err, qt.IsNil)
+	c.Assert(pkt, qt.IsNil)
+	c.Assert(r.Received, qt.HasLen, 4)
+	c.Assert(r.PendingRx(), qt.Equals, 0)
+}
+
+func TestRadioAsync(t *testing.T) {
+	c := qt.New(t)
+
+	r := NewRadio()
+	r.SetFrequency(868100000)
+	events := r.GetRadioEventChan()
+
+	c.Assert(r.StartTx([]uint8{0x01}, 1000), qt.IsNil)
+	c.Assert((<-events).EventType, qt.Equals, lora.RadioEventTxDone)
+
+	c.Assert(r.StartRx(1000), qt.IsNil)
+	c.Assert((<-events).EventType, qt.Equals, lora.RadioEventTimeout)
+
+	r.QueueRx(Reply{Payload: []uint8{0x02}, RSSI: -80, SNR: 7, FreqError: -1200})
+	c.Assert(r.StartRx(1000), qt.IsNil)
+	c.Assert((<-events).EventType, qt.Equals, lora.RadioEventRxDone)
+	pkt, err := r.ReadPacket()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pkt.Payload, qt.DeepEquals, []uint8{0x02})
+	c.Assert(pkt.RSSI, qt.Equals, int16(-80))
+	c.Assert(pkt.SNR, qt.Equals, int8(7))
+	c.Assert(pkt.FreqError, qt.Equals, int32(-1200))
+}
+
+func TestRadioCad(t *testing.T) {
+	c := qt.New(t)
+
+	r := NewRadio()
+	r.SetFrequency(868100000)
+	r.QueueCad(true, false)
+	busy, err := r.ChannelActivity()
+	c.Assert(err, qt.IsNil)
+	c.Assert(busy, qt.IsTrue)
+	busy, err = r.ChannelActivity()
+	c.Assert(err, qt.IsNil)
+	c.Assert(busy, qt.IsFalse)
+
+	c.Assert(r.StartCad(), qt.IsNil)
+	c.Assert((<-r.GetRadioEventChan()).EventType, qt.Equals, lora.RadioEventCadDone)
+	c.Assert(r.Cads, qt.HasLen, 3)
+}
@@ -0,0 +1,29 @@
+package lorawan
+
+// ActivateABP provisions the session for Activation By Personalization.
+// DevAddr is given most significant byte first, as displayed by network
+// servers. Frame counters start from 0: they must be saved and restored
+// across reboots, as the network rejects replayed counters.
+func (s *Session) ActivateABP(devAddr []uint8, nwkSKey []uint8, appSKey []uint8) error {
+	if len(devAddr) != 4 {
+		return ErrInvalidDevAddrLength
+	}
+	if err := s.SetNwkSKey(nwkSKey); err != nil {
+		return err
+	}
+	if err := s.SetAppSKey(appSKey); err != nil {
+		return err
+	}
+
+	copy(s.DevAddr[:], reverseBytes(devAddr))
+	s.Version = LORAWAN_1_0_2
+	s.FNwkSIntKey = s.NwkSKey
+	s.SNwkSIntKey = s.NwkSKey
+	s.NwkSEncKey = s.NwkSKey
+	s.CFList = [16]uint8{}
+	s.RXDelay = 0
+	s.DLSettings = 0
+	s.reset()
+
+	return nil
+}
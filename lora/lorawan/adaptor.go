@@ -2,37 +2,80 @@ package lorawan
 
 import (
 	"errors"
+	"time"
 
 	"tinygo.org/x/drivers/lora"
 	"tinygo.org/x/drivers/lora/lorawan/region"
 )
 
 var (
-	ErrNoJoinAcceptReceived    = errors.New("no JoinAccept packet received")
-	ErrNoRadioAttached         = errors.New("no LoRa radio attached")
-	ErrInvalidEuiLength        = errors.New("invalid EUI length")
-	ErrInvalidAppKeyLength     = errors.New("invalid AppKey length")
-	ErrInvalidPacketLength     = errors.New("invalid packet length")
-	ErrInvalidDevAddrLength    = errors.New("invalid DevAddr length")
-	ErrInvalidMic              = errors.New("invalid Mic")
-	ErrFrmPayloadTooLarge      = errors.New("FRM payload too large")
-	ErrInvalidNetIDLength      = errors.New("invalid NetID length")
-	ErrInvalidNwkSKeyLength    = errors.New("invalid NwkSKey length")
-	ErrInvalidAppSKeyLength    = errors.New("invalid AppSKey length")
-	ErrUndefinedRegionSettings = errors.New("undefined Regionnal Settings ")
+	ErrNoJoinAcceptReceived       = errors.New("no JoinAccept packet received")
+	ErrNoRadioAttached            = errors.New("no LoRa radio attached")
+	ErrInvalidEuiLength           = errors.New("invalid EUI length")
+	ErrInvalidAppKeyLength        = errors.New("invalid AppKey length")
+	ErrInvalidPacketLength        = errors.New("invalid packet length")
+	ErrInvalidDevAddrLength       = errors.New("invalid DevAddr length")
+	ErrInvalidMic                 = errors.New("invalid Mic")
+	ErrFrmPayloadTooLarge         = errors.New("FRM payload too large")
+	ErrInvalidNetIDLength         = errors.New("invalid NetID length")
+	ErrInvalidNwkSKeyLength       = errors.New("invalid NwkSKey length")
+	ErrInvalidAppSKeyLength       = errors.New("invalid AppSKey length")
+	ErrUndefinedRegionSettings    = errors.New("undefined Regionnal Settings ")
+	ErrInvalidMessageType         = errors.New("invalid message type")
+	ErrInvalidDevAddr             = errors.New("message not addressed to this device")
+	ErrInvalidFCntDown            = errors.New("invalid FCntDown")
+	ErrNoUplinkSent               = errors.New("no uplink sent before listening downlink")
+	ErrNoAckReceived              = errors.New("no ACK received for confirmed uplink")
+	ErrInvalidEncodedLength       = errors.New("invalid encoded data length")
+	ErrUnsupportedEncodingVersion = errors.New("unsupported encoding version")
+	ErrDutyCycleRestricted        = errors.New("transmission restricted by duty cycle")
+	ErrDwellTimeExceeded          = errors.New("transmission exceeds max dwell time")
+	ErrInvalidNwkKeyLength        = errors.New("invalid NwkKey length")
+	ErrInvalidJoinNonce           = errors.New("JoinNonce not greater than previous one")
+	ErrDevNonceExhausted          = errors.New("all DevNonce values have been used")
+	ErrChannelBusy                = errors.New("channel busy, listen before talk failed")
+	ErrRxEventTimeout             = errors.New("radio reported no event for the receive window")
 )
 
 const (
-	LORA_TX_TIMEOUT = 2000
-	LORA_RX_TIMEOUT = 10000
+	LORA_TX_TIMEOUT  = 2000
+	LORA_RX_TIMEOUT  = 10000
+	LORA_RX1_TIMEOUT = 900 // RX1 must be closed before RX2 opens one second later
+	LORA_RX2_TIMEOUT = 3000
+
+	// Confirmed uplinks are retransmitted ACK_TIMEOUT after RX2 window
+	ACK_TIMEOUT_MIN  = 1 * time.Second
+	ACK_TIMEOUT_SPAN = 2 * time.Second
+
+	// Listen before talk tries LBT_MAX_ATTEMPTS channels, waiting a random
+	// backoff of LBT_BACKOFF_MIN to LBT_BACKOFF_MIN+LBT_BACKOFF_SPAN when busy
+	LBT_MAX_ATTEMPTS = 5
+	LBT_BACKOFF_MIN  = 10 * time.Millisecond
+	LBT_BACKOFF_SPAN = 90 * time.Millisecond
 )
 
 var (
-	ActiveRadio    lora.Radio
-	Retries        = 15
-	regionSettings region.Settings
+	ActiveRadio          lora.Radio
+	Retries              = 15
+	ConfirmedUplinkTrans = 8 // max transmissions of a confirmed uplink
+	regionSettings       region.Settings
+	uplinkChannel        region.Channel // channel of the last uplink, used to open RX windows
+	uplinkDone           time.Time      // end of the last uplink transmission
+	rxWindowsDone        bool           // RX windows of the last uplink were already opened
+	pendingDownlink      *downlink      // downlink received while sending uplink
+	downlinkSNR          int8           // SNR of the last received downlink, if known
+
+	// Time functions, replaced by tests to run without waiting
+	timeNow   = time.Now
+	timeSleep = time.Sleep
+	timeAfter = time.After
 )
 
+// rxEventMargin is how long to wait for the radio after the end of a receive
+// window: a downlink whose preamble is detected at the end of the window is
+// still received, the longest take about 2.5 s at SF12.
+const rxEventMargin = 3 * time.Second
+
 // UseRegionSettings sets current Lorawan Regional parameters
 func UseRegionSettings(rs region.Settings) {
 	regionSettings = rs
@@ -84,17 +127,33 @@ func Join(otaa *Otaa, session *Session) error {
 		return err
 	}
 
+	lbtAttempts := 0
 	for {
 		joinRequestChannel := regionSettings.JoinRequestChannel()
 		joinAcceptChannel := regionSettings.JoinAcceptChannel()
 
 		// Prepare radio for Join Tx
+		airtime := TimeOnAir(joinRequestChannel, len(payload))
+		if err := waitTxAllowed(joinRequestChannel, airtime, false); err != nil {
+			return err
+		}
 		applyChannelConfig(joinRequestChannel)
 		ActiveRadio.SetIqMode(lora.IQStandard)
-		ActiveRadio.Tx(payload, LORA_TX_TIMEOUT)
+		var busy bool
+		busy, err = channelBusy(lbtAttempts)
+		if err != nil {
+			return err
+		}
+		if busy {
+			lbtAttempts++
+			continue
+		}
+		lbtAttempts = 0
+		err = ActiveRadio.Tx(payload, LORA_TX_TIMEOUT)
 		if err != nil {
 			return err
 		}
+		registerTx(joinRequestChannel, airtime, 0)
 
 		// Wait for JoinAccept
 		if joinAcceptChannel.Frequency() != 0 {
@@ -115,30 +174,333 @@ func Join(otaa *Otaa, session *Session) error {
 		return err
 	}
 
+	// Extra channels (or channel mask) provided by the network
+	regionSettings.ApplyCFList(session.CFList)
+
 	return nil
 }
 
-// SendUplink sends Lorawan Uplink message
-func SendUplink(data []uint8, session *Session) error {
-
+// RestoreSession applies a session restored by UnmarshalBinary to the region
+// settings: the extra channels or channel mask of the JoinAccept CFList, then
+// the channel mask, data rate and TX power set by the network, if saved.
+// Call it after UseRegionSettings, instead of Join. Values the region doesn't
+// support are left to their default.
+func RestoreSession(session *Session) error {
 	if regionSettings == nil {
 		return ErrUndefinedRegionSettings
 	}
+	regionSettings.ApplyCFList(session.CFList)
+	if session.regionSaved {
+		// The data rate must be supported by an enabled channel
+		regionSettings.SetChannelMask(session.channelMask)
+		regionSettings.SetDataRate(session.dataRate)
+		regionSettings.SetTxPower(session.txPower)
+	}
+	return nil
+}
 
-	payload, err := session.GenMessage(0, []byte(data))
+// SendUplink sends Lorawan Uplink message.
+// The message is repeated up to Session.NbTrans times, unless a downlink is
+// received in between. Call ListenDownlink afterwards to receive downlinks.
+func SendUplink(data []uint8, session *Session) error {
+	if err := checkSetup(); err != nil {
+		return err
+	}
+
+	// Duty cycle is checked before the frame counter is consumed
+	ch, err := uplinkAllowed(session, len(data))
 	if err != nil {
 		return err
 	}
 
-	applyChannelConfig(regionSettings.UplinkChannel())
-	ActiveRadio.SetIqMode(lora.IQStandard)
-	ActiveRadio.Tx(payload, LORA_TX_TIMEOUT)
+	adrBackoff(session)
+	payload, err := session.GenMessage(0, data)
 	if err != nil {
 		return err
 	}
+
+	nbTrans := session.NbTrans
+	if nbTrans == 0 {
+		nbTrans = 1
+	}
+	for trans := uint8(1); ; trans++ {
+		if trans > 1 {
+			ch = regionSettings.UplinkChannel(timeNow())
+		}
+		if err := transmit(session, ch, payload); err != nil {
+			return err
+		}
+		if trans >= nbTrans {
+			return nil
+		}
+
+		// Repetitions stop as soon as a downlink is received
+		dl, err := rxWindows(session)
+		if err != nil {
+			return err
+		}
+		if dl != nil {
+			pendingDownlink = dl
+			return nil
+		}
+	}
+}
+
+// SendConfirmedUplink sends a Lorawan Uplink message that must be
+// acknowledged by the network. Without acknowledgement, it is retransmitted
+// after a random delay of 1 to 3 seconds, up to ConfirmedUplinkTrans times.
+// The data rate is lowered every 2 transmissions.
+// It returns ErrNoAckReceived when all transmissions failed. Application data
+// sent with the acknowledgement is returned by next ListenDownlink call.
+func SendConfirmedUplink(data []uint8, session *Session) error {
+	if err := checkSetup(); err != nil {
+		return err
+	}
+
+	ch, err := uplinkAllowed(session, len(data))
+	if err != nil {
+		return err
+	}
+
+	adrBackoff(session)
+	// Retransmissions are the same frame, sharing the same frame counter
+	payload, err := session.GenConfirmedMessage(data)
+	if err != nil {
+		return err
+	}
+
+	for trans := 1; trans <= ConfirmedUplinkTrans; trans++ {
+		if trans > 1 {
+			rnd, _ := GetRand16()
+			timeSleep(ACK_TIMEOUT_MIN + time.Duration(uint16(rnd[0])<<8|uint16(rnd[1]))*ACK_TIMEOUT_SPAN/0x10000)
+			if trans%2 == 1 && regionSettings.DataRate() > 0 {
+				regionSettings.SetDataRate(regionSettings.DataRate() - 1)
+			}
+			ch = regionSettings.UplinkChannel(timeNow())
+		}
+
+		if err := transmit(session, ch, payload); err != nil {
+			return err
+		}
+		dl, err := rxWindows(session)
+		if err != nil {
+			return err
+		}
+		if dl != nil {
+			pendingDownlink = dl
+			if dl.fCtrl&fCtrlACK != 0 {
+				return nil
+			}
+		}
+	}
+	return ErrNoAckReceived
+}
+
+// checkSetup checks a radio and regional settings are defined
+func checkSetup() error {
+	if ActiveRadio == nil {
+		return ErrNoRadioAttached
+	}
+	if regionSettings == nil {
+		return ErrUndefinedRegionSettings
+	}
 	return nil
 }
 
-func ListenDownlink() error {
+// uplinkAllowed selects the channel of a new uplink carrying dataLen bytes
+// and checks duty cycle allows it within DutyCycleMaxWait
+func uplinkAllowed(session *Session, dataLen int) (region.Channel, error) {
+	ch := regionSettings.UplinkChannel(timeNow())
+	// MHDR, FHDR, FPort and MIC take 13 bytes, plus FOpts
+	phyLen := 13 + len(session.macStickyAnswers) + len(session.macAnswers) + dataLen
+	if session.rekeyPending {
+		phyLen += 2
+	}
+	if err := waitTxAllowed(ch, TimeOnAir(ch, phyLen), false); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// transmit sends an uplink PHYPayload on ch, once allowed by duty cycle,
+// and records when and where RX windows must be opened
+func transmit(session *Session, ch region.Channel, payload []uint8) error {
+	pendingDownlink = nil
+	uplinkChannel = nil
+
+	var airtime time.Duration
+	for attempt := 0; ; attempt++ {
+		airtime = TimeOnAir(ch, len(payload))
+		if err := waitTxAllowed(ch, airtime, true); err != nil {
+			return err
+		}
+		applyChannelConfig(ch)
+		ActiveRadio.SetIqMode(lora.IQStandard)
+		busy, err := channelBusy(attempt)
+		if err != nil {
+			return err
+		}
+		if !busy {
+			break
+		}
+		ch = regionSettings.UplinkChannel(timeNow())
+	}
+	// LoRaWAN 1.1 MIC depends on the transmission data rate and channel
+	session.signMessage(payload, 0, regionSettings.DataRate(), regionSettings.UplinkChannelIndex())
+	if err := ActiveRadio.Tx(payload, LORA_TX_TIMEOUT); err != nil {
+		return err
+	}
+	registerTx(ch, airtime, session.MaxDCycle)
+	uplinkChannel = ch
+	uplinkDone = timeNow()
+	rxWindowsDone = false
 	return nil
 }
+
+// channelBusy checks the channel configured on the radio is free when listen
+// before talk is required by the region and supported by the radio (see
+// lora.CadRadio). When busy, it waits a random backoff before another
+// attempt, or returns ErrChannelBusy once LBT_MAX_ATTEMPTS failed.
+func channelBusy(attempt int) (bool, error) {
+	if !regionSettings.ListenBeforeTalk() {
+		return false, nil
+	}
+	r, ok := ActiveRadio.(lora.CadRadio)
+	if !ok {
+		return false, nil
+	}
+	busy, err := r.ChannelActivity()
+	if err != nil || !busy {
+		return false, err
+	}
+	if attempt+1 >= LBT_MAX_ATTEMPTS {
+		return true, ErrChannelBusy
+	}
+	rnd, _ := GetRand16()
+	timeSleep(LBT_BACKOFF_MIN + time.Duration(uint16(rnd[0])<<8|uint16(rnd[1]))*LBT_BACKOFF_SPAN/0x10000)
+	return true, nil
+}
+
+// ListenDownlink opens the Class A RX1 and RX2 receive windows following the
+// last uplink sent with SendUplink, so it must be called right after it.
+// It returns the FPort and decrypted payload of the received application
+// downlink, or a nil payload when nothing was received for the application.
+func ListenDownlink(session *Session) (uint8, []uint8, error) {
+	if err := checkSetup(); err != nil {
+		return 0, nil, err
+	}
+
+	// Downlink already received while sending the uplink
+	if pendingDownlink != nil {
+		dl := pendingDownlink
+		pendingDownlink = nil
+		return downlinkPayload(dl)
+	}
+
+	if uplinkChannel == nil {
+		return 0, nil, ErrNoUplinkSent
+	}
+	if rxWindowsDone {
+		return 0, nil, nil
+	}
+
+	dl, err := rxWindows(session)
+	if err != nil || dl == nil {
+		return 0, nil, err
+	}
+	return downlinkPayload(dl)
+}
+
+// rxWindows opens RX1 and RX2 windows after the last uplink, and returns
+// the received downlink, once its MAC commands are processed
+func rxWindows(session *Session) (*downlink, error) {
+	rxWindowsDone = true
+
+	// RXDelay is expressed in seconds, 0 meaning 1 second
+	delay := time.Duration(session.RXDelay&0x0F) * time.Second
+	if delay == 0 {
+		delay = time.Second
+	}
+
+	// RX1 : frequency and data rate derived from the uplink
+	rx1DROffset := (session.DLSettings >> 4) & 0x07
+	rx1 := regionSettings.Rx1Channel(uplinkChannel, rx1DROffset)
+	resp, err := receiveWindow(rx1, uplinkDone.Add(delay), LORA_RX1_TIMEOUT)
+	if err == nil && resp != nil {
+		dl, err := session.decodeDownlink(resp)
+		if err == nil {
+			handleDownlinkMAC(session, dl)
+			return dl, nil
+		}
+	}
+
+	// RX2 : fixed frequency and data rate, one second after RX1
+	rx2 := regionSettings.Rx2Channel(session.DLSettings & 0x0F)
+	if session.RX2Frequency != 0 {
+		rx2.SetFrequency(session.RX2Frequency)
+	}
+	resp, err = receiveWindow(rx2, uplinkDone.Add(delay+time.Second), LORA_RX2_TIMEOUT)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	dl, err := session.decodeDownlink(resp)
+	if err != nil {
+		return nil, err
+	}
+	handleDownlinkMAC(session, dl)
+	return dl, nil
+}
+
+// receiveWindow waits for the window start time and listens for a downlink
+func receiveWindow(ch region.Channel, start time.Time, timeoutMs uint32) ([]uint8, error) {
+	applyChannelConfig(ch)
+	// Downlinks are sent with inverted IQ and no payload CRC
+	ActiveRadio.SetIqMode(lora.IQInverted)
+	ActiveRadio.SetCrc(false)
+	timeSleep(start.Sub(timeNow()))
+	if r, ok := ActiveRadio.(lora.AsyncRadio); ok {
+		return receiveAsync(r, timeoutMs)
+	}
+	return ActiveRadio.Rx(timeoutMs)
+}
+
+// receiveAsync listens for a downlink with a radio reporting the reception
+// quality, kept for DevStatusAns. ErrRxEventTimeout is returned if the radio
+// doesn't report the end of the window, after a missed interrupt for example.
+func receiveAsync(r lora.AsyncRadio, timeoutMs uint32) ([]uint8, error) {
+	if err := r.StartRx(timeoutMs); err != nil {
+		return nil, err
+	}
+	var ev lora.RadioEvent
+	select {
+	case ev = <-r.GetRadioEventChan():
+	case <-timeAfter(time.Duration(timeoutMs)*time.Millisecond + rxEventMargin):
+		return nil, ErrRxEventTimeout
+	}
+	if ev.EventType != lora.RadioEventRxDone {
+		return nil, nil
+	}
+	pkt, err := r.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	downlinkSNR = pkt.SNR
+	return pkt.Payload, nil
+}
+
+// handleDownlinkMAC processes MAC commands found in FOpts or port 0 payload
+func handleDownlinkMAC(session *Session, dl *downlink) {
+	// Unknown MAC commands can't be skipped, remaining ones are ignored
+	handleMACCommands(session, dl.fOpts)
+	if dl.hasFPort && dl.fPort == 0 {
+		handleMACCommands(session, dl.payload)
+	}
+}
+
+// downlinkPayload returns the application part of a downlink
+func downlinkPayload(dl *downlink) (uint8, []uint8, error) {
+	if !dl.hasFPort || dl.fPort == 0 {
+		return 0, nil, nil
+	}
+	return dl.fPort, dl.payload, nil
+}
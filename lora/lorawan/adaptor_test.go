@@ -0,0 +1,226 @@
+package lorawan
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/loratest"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+// setupNetwork attaches a simulated radio answered by a network server, and
+// replaces the clock so that RX windows and duty cycle don't wait
+func setupNetwork(c *qt.C) (*loratest.Radio, *loratest.NetworkServer) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	timeSleep = func(d time.Duration) {
+		if d > 0 {
+			now = now.Add(d)
+		}
+	}
+	DutyCycleMaxWait = time.Hour
+	aggregatedAvailableAt = time.Time{}
+
+	radio := loratest.NewRadio()
+	ns := loratest.NewNetworkServer(testAppKey)
+	ns.Attach(radio)
+	ActiveRadio = nil
+	UseRadio(radio)
+	UseRegionSettings(region.EU868())
+
+	c.Cleanup(func() {
+		timeNow = time.Now
+		timeSleep = time.Sleep
+		DutyCycleMaxWait = 0
+		ActiveRadio = nil
+		UseRegionSettings(nil)
+		uplinkChannel = nil
+		pendingDownlink = nil
+		downlinkSNR = 0
+	})
+	return radio, ns
+}
+
+// join activates a session with the network server
+func join(c *qt.C) *Session {
+	otaa := &Otaa{}
+	otaa.Set([]uint8{0x70, 0xB3, 0xD5, 0x7E, 0xD0, 0x00, 0x00, 0x01},
+		[]uint8{0x00, 0x80, 0xE1, 0x15, 0x00, 0x0A, 0x00, 0x01}, testAppKey[:])
+	session := &Session{}
+	c.Assert(Join(otaa, session), qt.IsNil)
+	return session
+}
+
+func TestJoin(t *testing.T) {
+	c := qt.New(t)
+	radio, ns := setupNetwork(c)
+
+	session := join(c)
+	c.Assert(ns.JoinRequests, qt.HasLen, 1)
+	c.Assert(ns.JoinRequests[0].DevEUI, qt.Equals, [8]uint8{0x00, 0x80, 0xE1, 0x15, 0x00, 0x0A, 0x00, 0x01})
+	c.Assert(session.DevAddr, qt.Equals, ns.DevAddr)
+	nwkSKey, appSKey := ns.SessionKeys()
+	c.Assert(session.NwkSKey, qt.Equals, nwkSKey)
+	c.Assert(session.AppSKey, qt.Equals, appSKey)
+
+	// Join request is sent on a default channel, join accept received with inverted IQ
+	c.Assert(radio.Sent, qt.HasLen, 1)
+	c.Assert(radio.Sent[0].IqMode, qt.Equals, uint8(lora.IQStandard))
+	c.Assert(radio.Received, qt.HasLen, 1)
+	c.Assert(radio.Received[0].IqMode, qt.Equals, uint8(lora.IQInverted))
+
+	// No answer
+	radio.OnTx = nil
+	c.Assert(Join(&Otaa{AppKey: testAppKey}, &Session{}), qt.Equals, ErrNoJoinAcceptReceived)
+}
+
+func TestSendUplink(t *testing.T) {
+	c := qt.New(t)
+	radio, ns := setupNetwork(c)
+	session := join(c)
+
+	// Nothing sent back
+	c.Assert(SendUplink([]uint8("hello"), session), qt.IsNil)
+	port, payload, err := ListenDownlink(session)
+	c.Assert(err, qt.IsNil)
+	c.Assert(payload, qt.IsNil)
+	c.Assert(ns.Uplinks, qt.HasLen, 1)
+	c.Assert(ns.Uplinks[0].FPort, qt.Equals, uint8(1))
+	c.Assert(ns.Uplinks[0].Payload, qt.DeepEquals, []uint8("hello"))
+	c.Assert(ns.Errors, qt.HasLen, 0)
+
+	// Downlink in RX1, on the uplink frequency
+	ns.QueueDownlink(10, []uint8("world"), false)
+	c.Assert(SendUplink([]uint8("hello"), session), qt.IsNil)
+	port, payload, err = ListenDownlink(session)
+	c.Assert(err, qt.IsNil)
+	c.Assert(port, qt.Equals, uint8(10))
+	c.Assert(payload, qt.DeepEquals, []uint8("world"))
+	c.Assert(ns.Uplinks[1].FCnt, qt.Equals, uint32(1))
+	last := len(radio.Received) - 1
+	c.Assert(radio.Received[last].Frequency, qt.Equals, radio.Sent[len(radio.Sent)-1].Frequency)
+
+	// Downlink in RX2 at 869.525 MHz
+	ns.RX2 = true
+	ns.QueueDownlink(11, []uint8("rx2"), false)
+	c.Assert(SendUplink([]uint8("hello"), session), qt.IsNil)
+	port, payload, err = ListenDownlink(session)
+	c.Assert(err, qt.IsNil)
+	c.Assert(port, qt.Equals, uint8(11))
+	c.Assert(payload, qt.DeepEquals, []uint8("rx2"))
+	last = len(radio.Received) - 1
+	c.Assert(radio.Received[last].Frequency, qt.Equals, uint32(869525000))
+
+	// Windows are opened once per uplink
+	port, payload, err = ListenDownlink(session)
+	c.Assert(err, qt.IsNil)
+	c.Assert(payload, qt.IsNil)
+}
+
+func TestSendConfirmedUplink(t *testing.T) {
+	c := qt.New(t)
+	radio, ns := setupNetwork(c)
+	session := join(c)
+
+	c.Assert(SendConfirmedUplink([]uint8{0x01}, session), qt.IsNil)
+	c.Assert(ns.Uplinks, qt.HasLen, 1)
+	c.Assert(ns.Uplinks[0].Confirmed, qt.IsTrue)
+
+	// The acknowledgement is lost once, the frame is retransmitted
+	sent := len(radio.Sent)
+	radio.OnTx = func(p loratest.Packet) {
+		radio.OnTx = nil
+		ns.HandleUplink(p.Payload)
+		ns.Attach(radio)
+	}
+	c.Assert(SendConfirmedUplink([]uint8{0x02}, session), qt.IsNil)
+	c.Assert(radio.Sent, qt.HasLen, sent+2)
+	c.Assert(radio.Sent[sent].Payload, qt.DeepEquals, radio.Sent[sent+1].Payload)
+
+	// No network
+	radio.OnTx = nil
+	c.Assert(SendConfirmedUplink([]uint8{0x03}, session), qt.Equals, ErrNoAckReceived)
+	c.Assert(radio.Sent, qt.HasLen, sent+2+ConfirmedUplinkTrans)
+}
+
+func TestDownlinkMACCommands(t *testing.T) {
+	c := qt.New(t)
+	radio, ns := setupNetwork(c)
+	session := join(c)
+
+	// LinkADRReq DR5, channels 0-2, then DevStatusReq received with a -5 dB SNR
+	ns.SNR = -5
+	ns.QueueMACCommand(0x03, 0x51, 0x07, 0x00, 0x01, 0x06)
+	c.Assert(SendUplink([]uint8{0x01}, session), qt.IsNil)
+	_, payload, err := ListenDownlink(session)
+	c.Assert(err, qt.IsNil)
+	c.Assert(payload, qt.IsNil)
+	c.Assert(regionSettings.DataRate(), qt.Equals, uint8(5))
+
+	// Answers are sent with the next uplink, at the new data rate
+	c.Assert(SendUplink([]uint8{0x02}, session), qt.IsNil)
+	c.Assert(ns.Uplinks[1].FOpts, qt.DeepEquals, []uint8{0x03, 0x07, 0x06, BATTERY_LEVEL_UNKNOWN, 0x3B})
+	c.Assert(radio.Sent[len(radio.Sent)-1].SpreadingFactor, qt.Equals, uint8(lora.SpreadingFactor7))
+}
+
+func TestListenBeforeTalk(t *testing.T) {
+	c := qt.New(t)
+	radio, ns := setupNetwork(c)
+	rs := region.KR920()
+	UseRegionSettings(rs)
+
+	// The join request channel is busy once
+	radio.QueueCad(true)
+	session := join(c)
+	c.Assert(radio.Cads, qt.HasLen, 2)
+	c.Assert(ns.JoinRequests, qt.HasLen, 1)
+
+	// Busy uplink channel: another one is tried
+	radio.QueueCad(true)
+	c.Assert(SendUplink([]uint8{0x01}, session), qt.IsNil)
+	c.Assert(radio.Cads, qt.HasLen, 4)
+	c.Assert(radio.Cads[2].Frequency, qt.Not(qt.Equals), radio.Cads[3].Frequency)
+	c.Assert(radio.Sent[len(radio.Sent)-1].Frequency, qt.Equals, radio.Cads[3].Frequency)
+
+	// Always busy
+	sent := len(radio.Sent)
+	radio.QueueCad(true, true, true, true, true)
+	c.Assert(SendUplink([]uint8{0x02}, session), qt.Equals, ErrChannelBusy)
+	c.Assert(radio.Sent, qt.HasLen, sent)
+
+	// Not required by EU868
+	UseRegionSettings(region.EU868())
+	cads := len(radio.Cads)
+	c.Assert(SendUplink([]uint8{0x03}, session), qt.IsNil)
+	c.Assert(radio.Cads, qt.HasLen, cads)
+}
+
+// silentRadio misses the end of receive windows, like after a lost interrupt
+type silentRadio struct {
+	*loratest.Radio
+}
+
+func (r silentRadio) StartRx(timeoutMs uint32) error {
+	return nil
+}
+
+func TestReceiveAsyncTimeout(t *testing.T) {
+	c := qt.New(t)
+	radio, _ := setupNetwork(c)
+
+	var waited time.Duration
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		waited = d
+		ch := make(chan time.Time, 1)
+		ch <- timeNow().Add(d)
+		return ch
+	}
+	c.Cleanup(func() { timeAfter = time.After })
+
+	payload, err := receiveAsync(silentRadio{radio}, LORA_RX1_TIMEOUT)
+	c.Assert(err, qt.Equals, ErrRxEventTimeout)
+	c.Assert(payload, qt.IsNil)
+	c.Assert(waited, qt.Equals, LORA_RX1_TIMEOUT*time.Millisecond+rxEventMargin)
+}
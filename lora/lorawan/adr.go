@@ -0,0 +1,47 @@
+package lorawan
+
+const (
+	// ADR_ACK_LIMIT is the number of uplinks without downlink after which
+	// the device asks the network to answer (ADRACKReq)
+	ADR_ACK_LIMIT = 64
+	// ADR_ACK_DELAY is the number of extra uplinks without answer before the
+	// device falls back to a more robust configuration
+	ADR_ACK_DELAY = 32
+)
+
+// adrBackoff runs the device side of the ADR algorithm before each new uplink.
+// When the network stays silent, the device first sets TX power to maximum,
+// then lowers the data rate step by step. Default channels are enabled again
+// with the lowest data rate.
+func adrBackoff(s *Session) {
+	s.adrAckReq = false
+	if !s.ADR {
+		return
+	}
+
+	s.ADRAckCnt++
+	if s.ADRAckCnt < ADR_ACK_LIMIT || adrAtDefaults() {
+		return
+	}
+	s.adrAckReq = true
+
+	if s.ADRAckCnt < ADR_ACK_LIMIT+ADR_ACK_DELAY || (s.ADRAckCnt-ADR_ACK_LIMIT)%ADR_ACK_DELAY != 0 {
+		return
+	}
+
+	switch {
+	case regionSettings.TxPower() != 0:
+		regionSettings.SetTxPower(0)
+	case regionSettings.DataRate() > 0:
+		dr := regionSettings.DataRate() - 1
+		if dr == 0 || !regionSettings.SetDataRate(dr) {
+			regionSettings.EnableDefaultChannels()
+			regionSettings.SetDataRate(dr)
+		}
+	}
+}
+
+// adrAtDefaults reports if the most robust configuration is in use
+func adrAtDefaults() bool {
+	return regionSettings.TxPower() == 0 && regionSettings.DataRate() == 0
+}
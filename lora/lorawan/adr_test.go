@@ -0,0 +1,52 @@
+package lorawan
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+func TestADRBackoff(t *testing.T) {
+	c := qt.New(t)
+
+	rs := region.EU868()
+	UseRegionSettings(rs)
+	defer UseRegionSettings(nil)
+	rs.SetDataRate(5)
+	rs.SetTxPower(3)
+	s := &Session{ADR: true}
+
+	// ADRACKReq is set once ADR_ACK_LIMIT uplinks got no answer
+	for i := 0; i < ADR_ACK_LIMIT-1; i++ {
+		adrBackoff(s)
+	}
+	c.Assert(s.adrAckReq, qt.IsFalse)
+	adrBackoff(s)
+	c.Assert(s.adrAckReq, qt.IsTrue)
+	c.Assert(rs.TxPower(), qt.Equals, uint8(3))
+
+	// TX power is restored first, then data rate is lowered
+	for i := 0; i < ADR_ACK_DELAY; i++ {
+		adrBackoff(s)
+	}
+	c.Assert(rs.TxPower(), qt.Equals, uint8(0))
+	c.Assert(rs.DataRate(), qt.Equals, uint8(5))
+	for i := 0; i < ADR_ACK_DELAY; i++ {
+		adrBackoff(s)
+	}
+	c.Assert(rs.DataRate(), qt.Equals, uint8(4))
+
+	// Once at defaults, the network is not asked anymore
+	for i := 0; i < 4*ADR_ACK_DELAY; i++ {
+		adrBackoff(s)
+	}
+	c.Assert(rs.DataRate(), qt.Equals, uint8(0))
+	adrBackoff(s)
+	c.Assert(s.adrAckReq, qt.IsFalse)
+
+	// ADR disabled
+	s = &Session{}
+	adrBackoff(s)
+	c.Assert(s.ADRAckCnt, qt.Equals, uint32(0))
+}
@@ -0,0 +1,69 @@
+package lorawan
+
+import (
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+var (
+	// DutyCycleMaxWait is how long an uplink may be delayed to respect the
+	// regional duty cycle. Longer delays make SendUplink and Join fail with
+	// ErrDutyCycleRestricted. Retransmissions of an accepted uplink always
+	// wait as long as needed.
+	DutyCycleMaxWait time.Duration = 0
+
+	aggregatedAvailableAt time.Time // end of the off period set by DutyCycleReq
+)
+
+// TimeOnAir returns the transmission duration of a PHYPayload of
+// payloadLen bytes on a channel
+func TimeOnAir(ch region.Channel, payloadLen int) time.Duration {
+	conf := lora.Config{
+		Sf:         ch.SpreadingFactor(),
+		Bw:         ch.Bandwidth(),
+		Cr:         ch.CodingRate(),
+		Preamble:   ch.PreambleLength(),
+		HeaderType: lora.HeaderExplicit,
+		Crc:        lora.CRCOn,
+	}
+	// LoRaWAN enables low data rate optimization for symbols of 16 ms or more
+	if lora.SymbolTime(conf.Sf, conf.Bw) >= 16*time.Millisecond {
+		conf.Ldr = lora.LowDataRateOptimizeOn
+	}
+	return lora.TimeOnAir(conf, payloadLen)
+}
+
+// waitTxAllowed checks the dwell time limit and waits until duty cycle
+// allows to transmit airtime on ch. Unless retry is set, it fails if the
+// wait is longer than DutyCycleMaxWait.
+func waitTxAllowed(ch region.Channel, airtime time.Duration, retry bool) error {
+	if max := regionSettings.MaxDwellTime(); max != 0 && airtime > max {
+		return ErrDwellTimeExceeded
+	}
+
+	at := regionSettings.TxAvailableAt(ch.Frequency())
+	if aggregatedAvailableAt.After(at) {
+		at = aggregatedAvailableAt
+	}
+	wait := at.Sub(timeNow())
+	if wait <= 0 {
+		return nil
+	}
+	if !retry && wait > DutyCycleMaxWait {
+		return ErrDutyCycleRestricted
+	}
+	timeSleep(wait)
+	return nil
+}
+
+// registerTx starts the off period following a transmission that just ended.
+// maxDCycle is the aggregated duty cycle set by the network (1/2^maxDCycle).
+func registerTx(ch region.Channel, airtime time.Duration, maxDCycle uint8) {
+	now := timeNow()
+	regionSettings.RegisterTx(ch.Frequency(), now, airtime)
+	if maxDCycle != 0 {
+		aggregatedAvailableAt = now.Add(airtime * time.Duration((1<<maxDCycle)-1))
+	}
+}
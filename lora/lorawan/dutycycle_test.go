@@ -0,0 +1,40 @@
+package lorawan
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+func TestTimeOnAirChannel(t *testing.T) {
+	c := qt.New(t)
+
+	rs := region.EU868()
+	rs.SetDataRate(0)
+	// SF12 uses low data rate optimization, EU868 uplinks use CR 4/7
+	c.Assert(TimeOnAir(rs.UplinkChannel(time.Now()), 13), qt.Equals, 1351680*time.Microsecond)
+	rs.SetDataRate(5)
+	c.Assert(TimeOnAir(rs.UplinkChannel(time.Now()), 13), qt.Equals, 56576*time.Microsecond)
+}
+
+func TestWaitTxAllowed(t *testing.T) {
+	c := qt.New(t)
+
+	rs := region.EU868()
+	UseRegionSettings(rs)
+	defer UseRegionSettings(nil)
+
+	ch := rs.UplinkChannel(time.Now())
+	c.Assert(waitTxAllowed(ch, 50*time.Millisecond, false), qt.IsNil)
+	registerTx(ch, 50*time.Millisecond, 0)
+	c.Assert(waitTxAllowed(ch, 50*time.Millisecond, false), qt.Equals, ErrDutyCycleRestricted)
+
+	us := region.US915()
+	UseRegionSettings(us)
+	us.SetDataRate(0)
+	ch = us.UplinkChannel(time.Now())
+	c.Assert(waitTxAllowed(ch, TimeOnAir(ch, 13), false), qt.IsNil)
+	c.Assert(waitTxAllowed(ch, TimeOnAir(ch, 64), false), qt.Equals, ErrDwellTimeExceeded)
+}
@@ -0,0 +1,172 @@
+package lorawan
+
+import "encoding/binary"
+
+// Binary encoding of Session and Otaa, to keep them in non-volatile memory
+// (flash, EEPROM...) across deep sleep or reboot.
+// The first byte is the encoding version, so that data saved by older
+// versions can still be restored once the encoding changes.
+const (
+	sessionEncodingVersion = 2
+	otaaEncodingVersion    = 1
+
+	// SESSION_ENCODED_SIZE is the size of an encoded Session
+	SESSION_ENCODED_SIZE = 141
+	// sessionEncodedSizeV1 is the size of a Session encoded by version 1,
+	// without the region state
+	sessionEncodedSizeV1 = 127
+	// OTAA_ENCODED_SIZE is the size of encoded Otaa
+	OTAA_ENCODED_SIZE = 59
+)
+
+// MarshalBinary encodes the session, including frame counters and MAC state.
+// The data rate, TX power and channel mask of the region settings in use,
+// set by the network, are saved too, see RestoreSession.
+// Pending MAC answers are not saved.
+func (s *Session) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, SESSION_ENCODED_SIZE)
+	buf = append(buf, sessionEncodingVersion)
+	buf = append(buf, s.NwkSKey[:]...)
+	buf = append(buf, s.AppSKey[:]...)
+	buf = append(buf, s.DevAddr[:]...)
+	buf = appendUint32(buf, s.FCntUp)
+	buf = appendUint32(buf, s.FCntDown)
+	buf = append(buf, s.CFList[:]...)
+	buf = append(buf, s.RXDelay, s.DLSettings)
+	buf = appendUint32(buf, s.RX2Frequency)
+	buf = append(buf, s.MaxDCycle, s.NbTrans)
+	var flags uint8
+	if s.ADR {
+		flags |= 0x01
+	}
+	if s.rekeyPending {
+		flags |= 0x02
+	}
+	if regionSettings != nil {
+		s.saveRegion(regionSettings)
+	}
+	if s.regionSaved {
+		flags |= 0x04
+	}
+	buf = append(buf, flags)
+	buf = appendUint32(buf, s.ADRAckCnt)
+	buf = append(buf, s.Version)
+	buf = append(buf, s.FNwkSIntKey[:]...)
+	buf = append(buf, s.SNwkSIntKey[:]...)
+	buf = append(buf, s.NwkSEncKey[:]...)
+	buf = appendUint32(buf, s.AFCntDown)
+	buf = append(buf, s.dataRate, s.txPower)
+	for _, m := range s.channelMask {
+		buf = append(buf, uint8(m), uint8(m>>8))
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores a session encoded by MarshalBinary. Call
+// RestoreSession afterwards to apply it to the region settings.
+// Trailing bytes are ignored, so a whole flash block can be given.
+func (s *Session) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return ErrInvalidEncodedLength
+	}
+	version := data[0]
+	size := SESSION_ENCODED_SIZE
+	switch version {
+	case sessionEncodingVersion:
+	case 1:
+		size = sessionEncodedSizeV1
+	default:
+		return ErrUnsupportedEncodingVersion
+	}
+	if len(data) < size {
+		return ErrInvalidEncodedLength
+	}
+
+	s.reset()
+	data = data[1:]
+	data = data[copy(s.NwkSKey[:], data):]
+	data = data[copy(s.AppSKey[:], data):]
+	data = data[copy(s.DevAddr[:], data):]
+	s.FCntUp = binary.LittleEndian.Uint32(data)
+	s.FCntDown = binary.LittleEndian.Uint32(data[4:])
+	data = data[8:]
+	data = data[copy(s.CFList[:], data):]
+	s.RXDelay = data[0]
+	s.DLSettings = data[1]
+	s.RX2Frequency = binary.LittleEndian.Uint32(data[2:])
+	s.MaxDCycle = data[6]
+	s.NbTrans = data[7]
+	s.ADR = data[8]&0x01 != 0
+	s.rekeyPending = data[8]&0x02 != 0
+	s.regionSaved = data[8]&0x04 != 0 && version >= 2
+	s.ADRAckCnt = binary.LittleEndian.Uint32(data[9:])
+	data = data[13:]
+	s.Version = data[0]
+	data = data[1:]
+	data = data[copy(s.FNwkSIntKey[:], data):]
+	data = data[copy(s.SNwkSIntKey[:], data):]
+	data = data[copy(s.NwkSEncKey[:], data):]
+	s.AFCntDown = binary.LittleEndian.Uint32(data)
+	if !s.regionSaved {
+		return nil
+	}
+	data = data[4:]
+	s.dataRate = data[0]
+	s.txPower = data[1]
+	data = data[2:]
+	for i := range s.channelMask {
+		s.channelMask[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return nil
+}
+
+// MarshalBinary encodes OTAA identifiers, root keys and nonces
+func (o *Otaa) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, OTAA_ENCODED_SIZE)
+	buf = append(buf, otaaEncodingVersion)
+	buf = append(buf, o.DevEUI[:]...)
+	buf = append(buf, o.AppEUI[:]...)
+	buf = append(buf, o.AppKey[:]...)
+	buf = append(buf, o.devNonce[:]...)
+	buf = append(buf, o.appNonce[:]...)
+	buf = append(buf, o.NetID[:]...)
+	buf = append(buf, o.NwkKey[:]...)
+	buf = append(buf, o.Version)
+	var flags uint8
+	if o.joinNonceSet {
+		flags |= 0x01
+	}
+	buf = append(buf, flags)
+	return buf, nil
+}
+
+// UnmarshalBinary restores OTAA data encoded by MarshalBinary.
+// Trailing bytes are ignored, so a whole flash block can be given.
+func (o *Otaa) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return ErrInvalidEncodedLength
+	}
+	if data[0] != otaaEncodingVersion {
+		return ErrUnsupportedEncodingVersion
+	}
+	if len(data) < OTAA_ENCODED_SIZE {
+		return ErrInvalidEncodedLength
+	}
+
+	data = data[1:]
+	data = data[copy(o.DevEUI[:], data):]
+	data = data[copy(o.AppEUI[:], data):]
+	data = data[copy(o.AppKey[:], data):]
+	data = data[copy(o.devNonce[:], data):]
+	data = data[copy(o.appNonce[:], data):]
+	data = data[copy(o.NetID[:], data):]
+	data = data[copy(o.NwkKey[:], data):]
+	o.Version = data[0]
+	o.joinNonceSet = data[1]&0x01 != 0
+	return nil
+}
+
+// appendUint32 appends v in little endian order
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, uint8(v), uint8(v>>8), uint8(v>>16), uint8(v>>24))
+}
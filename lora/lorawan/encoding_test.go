@@ -0,0 +1,135 @@
+package lorawan
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+func TestSessionEncoding(t *testing.T) {
+	c := qt.New(t)
+
+	s := &Session{}
+	err := s.ActivateABP(
+		[]uint8{0x26, 0x0B, 0x12, 0x34},
+		[]uint8{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10},
+		[]uint8{0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E, 0x1F, 0x20},
+	)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.DevAddr, qt.Equals, [4]uint8{0x34, 0x12, 0x0B, 0x26})
+
+	s.FCntUp = 1234
+	s.FCntDown = 56
+	s.RXDelay = 5
+	s.DLSettings = 0x13
+	s.RX2Frequency = 869525000
+	s.NbTrans = 2
+	s.ADR = true
+	s.ADRAckCnt = 70
+
+	data, err := s.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.HasLen, SESSION_ENCODED_SIZE)
+
+	// Trailing bytes of a flash page are ignored
+	data = append(data, 0xFF, 0xFF)
+	r := &Session{}
+	c.Assert(r.UnmarshalBinary(data), qt.IsNil)
+	c.Assert(r.DevAddr, qt.Equals, s.DevAddr)
+	c.Assert(r.NwkSKey, qt.Equals, s.NwkSKey)
+	c.Assert(r.AppSKey, qt.Equals, s.AppSKey)
+	c.Assert(r.FCntUp, qt.Equals, uint32(1234))
+	c.Assert(r.FCntDown, qt.Equals, uint32(56))
+	c.Assert(r.RXDelay, qt.Equals, uint8(5))
+	c.Assert(r.DLSettings, qt.Equals, uint8(0x13))
+	c.Assert(r.RX2Frequency, qt.Equals, uint32(869525000))
+	c.Assert(r.NbTrans, qt.Equals, uint8(2))
+	c.Assert(r.ADR, qt.IsTrue)
+	c.Assert(r.ADRAckCnt, qt.Equals, uint32(70))
+
+	c.Assert(r.UnmarshalBinary(data[:10]), qt.Equals, ErrInvalidEncodedLength)
+	c.Assert(r.UnmarshalBinary([]uint8{0xFF, 0xFF}), qt.Equals, ErrUnsupportedEncodingVersion)
+
+	c.Assert(s.ActivateABP([]uint8{0x26}, s.NwkSKey[:], s.AppSKey[:]), qt.Equals, ErrInvalidDevAddrLength)
+}
+
+func TestRestoreSession(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(RestoreSession(&Session{}), qt.Equals, ErrUndefinedRegionSettings)
+
+	s := &Session{}
+	c.Assert(s.ActivateABP([]uint8{0x26, 0x0B, 0x12, 0x34}, make([]uint8, 16), make([]uint8, 16)), qt.IsNil)
+	// Extra channels 867.1 and 867.3 MHz
+	s.CFList = [16]uint8{0x18, 0x4F, 0x84, 0xE8, 0x56, 0x84}
+
+	// State set by the network after the join: LinkADRReq only enables the
+	// extra channels
+	rs := region.EU868()
+	UseRegionSettings(rs)
+	defer UseRegionSettings(nil)
+	c.Assert(RestoreSession(s), qt.IsNil)
+	c.Assert(rs.SetChannelMask(region.ChannelMask{0b11000}), qt.IsTrue)
+	c.Assert(rs.SetDataRate(4), qt.IsTrue)
+	c.Assert(rs.SetTxPower(3), qt.IsTrue)
+
+	data, err := s.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.HasLen, SESSION_ENCODED_SIZE)
+
+	// After a reboot
+	rs = region.EU868()
+	UseRegionSettings(rs)
+	r := &Session{}
+	c.Assert(r.UnmarshalBinary(data), qt.IsNil)
+	c.Assert(r.CFList, qt.Equals, s.CFList)
+	c.Assert(RestoreSession(r), qt.IsNil)
+	c.Assert(rs.ChannelMask(), qt.Equals, region.ChannelMask{0b11000})
+	c.Assert(rs.DataRate(), qt.Equals, uint8(4))
+	c.Assert(rs.TxPower(), qt.Equals, uint8(3))
+	c.Assert(rs.UplinkChannel(time.Time{}).Frequency(), qt.Equals, uint32(867100000))
+	c.Assert(rs.UplinkChannel(time.Time{}).Frequency(), qt.Equals, uint32(867300000))
+
+	// Version 1 has no region state, only the CFList is applied
+	v1 := append([]byte{1}, data[1:sessionEncodedSizeV1]...)
+	rs = region.EU868()
+	UseRegionSettings(rs)
+	r = &Session{}
+	c.Assert(r.UnmarshalBinary(v1[:sessionEncodedSizeV1-1]), qt.Equals, ErrInvalidEncodedLength)
+	c.Assert(r.UnmarshalBinary(v1), qt.IsNil)
+	c.Assert(r.FCntUp, qt.Equals, s.FCntUp)
+	c.Assert(RestoreSession(r), qt.IsNil)
+	c.Assert(rs.ChannelMask(), qt.Equals, region.ChannelMask{0b11111})
+	c.Assert(rs.DataRate(), qt.Equals, region.EU868().DataRate())
+	c.Assert(rs.TxPower(), qt.Equals, region.EU868().TxPower())
+}
+
+func TestOtaaEncoding(t *testing.T) {
+	c := qt.New(t)
+
+	o := &Otaa{}
+	o.Set(
+		[]uint8{0x70, 0xB3, 0xD5, 0x7E, 0xD0, 0x00, 0x00, 0x01},
+		[]uint8{0x00, 0x04, 0xA3, 0x0B, 0x00, 0x1C, 0x05, 0x30},
+		[]uint8{0x2B, 0x7E, 0x15, 0x16, 0x28, 0xAE, 0xD2, 0xA6, 0xAB, 0xF7, 0x15, 0x88, 0x09, 0xCF, 0x4F, 0x3C},
+	)
+	o.devNonce = [2]uint8{0x12, 0x34}
+	o.appNonce = [3]uint8{0x01, 0x02, 0x03}
+	o.SetNetID([]uint8{0x00, 0x00, 0x13})
+
+	data, err := o.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.HasLen, OTAA_ENCODED_SIZE)
+
+	r := &Otaa{}
+	c.Assert(r.UnmarshalBinary(data), qt.IsNil)
+	c.Assert(r.DevEUI, qt.Equals, o.DevEUI)
+	c.Assert(r.AppEUI, qt.Equals, o.AppEUI)
+	c.Assert(r.AppKey, qt.Equals, o.AppKey)
+	c.Assert(r.devNonce, qt.Equals, o.devNonce)
+	c.Assert(r.appNonce, qt.Equals, o.appNonce)
+	c.Assert(r.NetID, qt.Equals, o.NetID)
+	c.Assert(r.UnmarshalBinary(data[:OTAA_ENCODED_SIZE-1]), qt.Equals, ErrInvalidEncodedLength)
+}
@@ -0,0 +1,264 @@
+package lorawan
+
+import "errors"
+
+var (
+	ErrUnknownMACCommand = errors.New("unknown MAC command")
+)
+
+// MAC command identifiers (CID)
+const (
+	MAC_LINK_CHECK     = 0x02
+	MAC_LINK_ADR       = 0x03
+	MAC_DUTY_CYCLE     = 0x04
+	MAC_RX_PARAM_SETUP = 0x05
+	MAC_DEV_STATUS     = 0x06
+	MAC_NEW_CHANNEL    = 0x07
+	MAC_RX_TIMING      = 0x08
+	MAC_TX_PARAM_SETUP = 0x09
+	MAC_DL_CHANNEL     = 0x0A
+	MAC_REKEY          = 0x0B // LoRaWAN 1.1 RekeyInd / RekeyConf
+	MAC_DEVICE_TIME    = 0x0D
+)
+
+const (
+	// maxFOptsLen is the maximum size of MAC commands piggybacked in FOpts
+	maxFOptsLen = 15
+
+	// LORAWAN_1_1_MINOR is the LoRaWAN minor version sent in RekeyInd
+	LORAWAN_1_1_MINOR = 1
+
+	// BATTERY_LEVEL_EXTERNAL means the device is connected to an external power source
+	BATTERY_LEVEL_EXTERNAL = 0
+	// BATTERY_LEVEL_UNKNOWN means the device is not able to measure its battery level
+	BATTERY_LEVEL_UNKNOWN = 255
+)
+
+// maxEIRPTable maps the MaxEIRP index of TxParamSetupReq to dBm
+var maxEIRPTable = [16]int8{8, 10, 12, 13, 14, 16, 18, 20, 21, 24, 26, 27, 29, 30, 33, 36}
+
+// BatteryLevel is called to answer DevStatusReq. It returns 1 (min) to 254
+// (max), or one of BATTERY_LEVEL_EXTERNAL, BATTERY_LEVEL_UNKNOWN
+var BatteryLevel = func() uint8 {
+	return BATTERY_LEVEL_UNKNOWN
+}
+
+// MACCommand holds a MAC command identifier and its payload
+type MACCommand struct {
+	CID     uint8
+	Payload []uint8
+}
+
+// AppendTo appends the encoded MAC command to buf
+func (c MACCommand) AppendTo(buf []uint8) []uint8 {
+	buf = append(buf, c.CID)
+	return append(buf, c.Payload...)
+}
+
+// macPayloadLen returns the payload length of a MAC command
+// dir is 0 for commands sent by the device, 1 for commands sent by the network
+func macPayloadLen(cid uint8, dir uint8) (int, bool) {
+	if dir == 0 {
+		switch cid {
+		case MAC_LINK_CHECK, MAC_DUTY_CYCLE, MAC_RX_TIMING, MAC_TX_PARAM_SETUP, MAC_DEVICE_TIME:
+			return 0, true
+		case MAC_LINK_ADR, MAC_RX_PARAM_SETUP, MAC_NEW_CHANNEL, MAC_DL_CHANNEL, MAC_REKEY:
+			return 1, true
+		case MAC_DEV_STATUS:
+			return 2, true
+		}
+		return 0, false
+	}
+
+	switch cid {
+	case MAC_DEV_STATUS:
+		return 0, true
+	case MAC_DUTY_CYCLE, MAC_RX_TIMING, MAC_TX_PARAM_SETUP, MAC_REKEY:
+		return 1, true
+	case MAC_LINK_CHECK:
+		return 2, true
+	case MAC_LINK_ADR, MAC_RX_PARAM_SETUP, MAC_DL_CHANNEL:
+		return 4, true
+	case MAC_NEW_CHANNEL, MAC_DEVICE_TIME:
+		return 5, true
+	}
+	return 0, false
+}
+
+// DecodeMACCommands splits MAC commands found in FOpts or in a port 0
+// FRMPayload. dir is 0 for uplink, 1 for downlink.
+// Decoding stops at the first unknown command, as its length can't be known.
+func DecodeMACCommands(buf []uint8, dir uint8) ([]MACCommand, error) {
+	var cmds []MACCommand
+	for len(buf) > 0 {
+		l, ok := macPayloadLen(buf[0], dir)
+		if !ok {
+			return cmds, ErrUnknownMACCommand
+		}
+		if len(buf) < 1+l {
+			return cmds, ErrInvalidPacketLength
+		}
+		cmds = append(cmds, MACCommand{CID: buf[0], Payload: buf[1 : 1+l]})
+		buf = buf[1+l:]
+	}
+	return cmds, nil
+}
+
+// RequestLinkCheck asks the network for link margin and gateway count with
+// the next uplink. Result is available in LinkMargin and LinkGwCnt once the
+// answer is received.
+func (s *Session) RequestLinkCheck() {
+	s.queueMACAnswer(false, MAC_LINK_CHECK)
+}
+
+// queueMACAnswer adds a MAC command to the FOpts of the next uplink.
+// Sticky answers are repeated in every uplink until a downlink is received.
+func (s *Session) queueMACAnswer(sticky bool, cid uint8, payload ...uint8) {
+	if len(s.macAnswers)+len(s.macStickyAnswers)+1+len(payload) > maxFOptsLen {
+		return
+	}
+	cmd := MACCommand{CID: cid, Payload: payload}
+	if sticky {
+		s.macStickyAnswers = cmd.AppendTo(s.macStickyAnswers)
+	} else {
+		s.macAnswers = cmd.AppendTo(s.macAnswers)
+	}
+}
+
+// handleMACCommands processes MAC commands sent by the network, updates
+// session and region settings and queues answers for next uplink
+func handleMACCommands(s *Session, buf []uint8) error {
+	cmds, err := DecodeMACCommands(buf, 1)
+	for i := 0; i < len(cmds); i++ {
+		p := cmds[i].Payload
+		switch cmds[i].CID {
+		case MAC_LINK_CHECK:
+			s.LinkMargin = p[0]
+			s.LinkGwCnt = p[1]
+
+		case MAC_LINK_ADR:
+			// Contiguous LinkADRReq are processed as a single block
+			j := i
+			for j+1 < len(cmds) && cmds[j+1].CID == MAC_LINK_ADR {
+				j++
+			}
+			status := linkADR(s, cmds[i:j+1])
+			for k := i; k <= j; k++ {
+				s.queueMACAnswer(false, MAC_LINK_ADR, status)
+			}
+			i = j
+
+		case MAC_DUTY_CYCLE:
+			s.MaxDCycle = p[0] & 0x0F
+			s.queueMACAnswer(false, MAC_DUTY_CYCLE)
+
+		case MAC_RX_PARAM_SETUP:
+			rx1DROffset := (p[0] >> 4) & 0x07
+			rx2DataRate := p[0] & 0x0F
+			freq := (uint32(p[1]) | uint32(p[2])<<8 | uint32(p[3])<<16) * 100
+			status := uint8(0)
+			if regionSettings.ValidRx1DROffset(rx1DROffset) {
+				status |= 0x04
+			}
+			if regionSettings.ValidDownlinkDataRate(rx2DataRate) {
+				status |= 0x02
+			}
+			if regionSettings.ValidFrequency(freq) {
+				status |= 0x01
+			}
+			if status == 0x07 {
+				s.DLSettings = p[0] & 0x7F
+				s.RX2Frequency = freq
+			}
+			s.queueMACAnswer(true, MAC_RX_PARAM_SETUP, status)
+
+		case MAC_DEV_STATUS:
+			// Margin is the SNR of the request, a 6 bits signed value
+			margin := downlinkSNR
+			if margin < -32 {
+				margin = -32
+			} else if margin > 31 {
+				margin = 31
+			}
+			s.queueMACAnswer(false, MAC_DEV_STATUS, BatteryLevel(), uint8(margin)&0x3F)
+
+		case MAC_NEW_CHANNEL:
+			freq := (uint32(p[1]) | uint32(p[2])<<8 | uint32(p[3])<<16) * 100
+			maxDR, minDR := p[4]>>4, p[4]&0x0F
+			freqOK, drOK := regionSettings.SetChannel(p[0], freq, minDR, maxDR)
+			status := uint8(0)
+			if drOK {
+				status |= 0x02
+			}
+			if freqOK {
+				status |= 0x01
+			}
+			s.queueMACAnswer(false, MAC_NEW_CHANNEL, status)
+
+		case MAC_RX_TIMING:
+			s.RXDelay = p[0] & 0x0F
+			s.queueMACAnswer(true, MAC_RX_TIMING)
+
+		case MAC_TX_PARAM_SETUP:
+			// Not answered by regions not implementing it
+			if regionSettings.SetTxParams(p[0]&0x10 != 0, p[0]&0x20 != 0, maxEIRPTable[p[0]&0x0F]) {
+				s.queueMACAnswer(false, MAC_TX_PARAM_SETUP)
+			}
+
+		case MAC_REKEY:
+			// RekeyConf: the network uses the keys of the last join
+			s.rekeyPending = false
+
+		case MAC_DL_CHANNEL:
+			// Downlink channel frequency can't be changed
+			s.queueMACAnswer(true, MAC_DL_CHANNEL, 0x00)
+		}
+	}
+	return err
+}
+
+// linkADR applies a block of LinkADRReq. Changes are applied only if all
+// requested settings are accepted. It returns the LinkADRAns status.
+func linkADR(s *Session, block []MACCommand) uint8 {
+	oldMask := regionSettings.ChannelMask()
+	oldDR := regionSettings.DataRate()
+	oldTxPower := regionSettings.TxPower()
+
+	// Channel masks are cumulative, data rate and power come from last command
+	mask := oldMask
+	maskOK := true
+	for _, c := range block {
+		chMask := uint16(c.Payload[1]) | uint16(c.Payload[2])<<8
+		chMaskCntl := (c.Payload[3] >> 4) & 0x07
+		if !regionSettings.UpdateChannelMask(&mask, chMaskCntl, chMask) {
+			maskOK = false
+		}
+	}
+	last := block[len(block)-1].Payload
+	dr, txPower := last[0]>>4, last[0]&0x0F
+
+	// 0xF keeps current data rate or TX power
+	maskOK = maskOK && regionSettings.SetChannelMask(mask)
+	drOK := dr == 0x0F || regionSettings.SetDataRate(dr)
+	powerOK := txPower == 0x0F || regionSettings.SetTxPower(txPower)
+
+	if !maskOK || !drOK || !powerOK {
+		regionSettings.SetChannelMask(oldMask)
+		regionSettings.SetDataRate(oldDR)
+		regionSettings.SetTxPower(oldTxPower)
+	} else if nbTrans := last[3] & 0x0F; nbTrans != 0 {
+		s.NbTrans = nbTrans
+	}
+
+	status := uint8(0)
+	if powerOK {
+		status |= 0x04
+	}
+	if drOK {
+		status |= 0x02
+	}
+	if maskOK {
+		status |= 0x01
+	}
+	return status
+}
@@ -0,0 +1,91 @@
+package lorawan
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/lora/lorawan/region"
+)
+
+func TestDecodeMACCommands(t *testing.T) {
+	c := qt.New(t)
+
+	// LinkADRReq + DevStatusReq + RXTimingSetupReq
+	buf := []uint8{0x03, 0x51, 0x07, 0x00, 0x01, 0x06, 0x08, 0x02}
+	cmds, err := DecodeMACCommands(buf, 1)
+	c.Assert(err, qt.IsNil)
+	c.Assert(cmds, qt.HasLen, 3)
+	c.Assert(cmds[0].CID, qt.Equals, uint8(MAC_LINK_ADR))
+	c.Assert(cmds[0].Payload, qt.DeepEquals, []uint8{0x51, 0x07, 0x00, 0x01})
+	c.Assert(cmds[1].CID, qt.Equals, uint8(MAC_DEV_STATUS))
+	c.Assert(cmds[1].Payload, qt.HasLen, 0)
+	c.Assert(cmds[2].CID, qt.Equals, uint8(MAC_RX_TIMING))
+
+	// Truncated command
+	_, err = DecodeMACCommands([]uint8{0x03, 0x51}, 1)
+	c.Assert(err, qt.Equals, ErrInvalidPacketLength)
+
+	// Unknown command
+	cmds, err = DecodeMACCommands([]uint8{0x06, 0x80, 0x01}, 1)
+	c.Assert(err, qt.Equals, ErrUnknownMACCommand)
+	c.Assert(cmds, qt.HasLen, 1)
+}
+
+func TestHandleMACCommands(t *testing.T) {
+	c := qt.New(t)
+
+	rs := region.EU868()
+	UseRegionSettings(rs)
+	defer UseRegionSettings(nil)
+	s := &Session{}
+
+	// LinkADRReq DR5, TXPower 1, channels 0-2, NbTrans 1
+	// DevStatusReq
+	// RXParamSetupReq RX1DROffset 1, RX2 DR3, 869.525 MHz
+	err := handleMACCommands(s, []uint8{
+		0x03, 0x51, 0x07, 0x00, 0x01,
+		0x06,
+		0x05, 0x13, 0xD2, 0xAD, 0x84,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(rs.DataRate(), qt.Equals, uint8(5))
+	c.Assert(rs.TxPower(), qt.Equals, uint8(1))
+	c.Assert(s.NbTrans, qt.Equals, uint8(1))
+	c.Assert(s.DLSettings, qt.Equals, uint8(0x13))
+	c.Assert(s.RX2Frequency, qt.Equals, uint32(869525000))
+	c.Assert(s.macAnswers, qt.DeepEquals, []uint8{0x03, 0x07, 0x06, BATTERY_LEVEL_UNKNOWN, 0x00})
+	c.Assert(s.macStickyAnswers, qt.DeepEquals, []uint8{0x05, 0x07})
+
+	// Undefined channel 5 in mask: nothing is applied
+	err = handleMACCommands(s, []uint8{0x03, 0x20, 0x27, 0x00, 0x01})
+	c.Assert(err, qt.IsNil)
+	c.Assert(rs.DataRate(), qt.Equals, uint8(5))
+	c.Assert(s.macAnswers, qt.DeepEquals, []uint8{0x03, 0x07, 0x06, BATTERY_LEVEL_UNKNOWN, 0x00, 0x03, 0x06})
+
+	// NewChannelReq on channel 3, 867.1 MHz DR0-5
+	s.macAnswers = s.macAnswers[:0]
+	err = handleMACCommands(s, []uint8{0x07, 0x03, 0x18, 0x4F, 0x84, 0x50})
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.macAnswers, qt.DeepEquals, []uint8{0x07, 0x03})
+	mask := rs.ChannelMask()
+	c.Assert(mask.Enabled(3), qt.IsTrue)
+}
+
+func TestHandleTxParamSetup(t *testing.T) {
+	c := qt.New(t)
+
+	// Not implemented by EU868: no answer
+	UseRegionSettings(region.EU868())
+	defer UseRegionSettings(nil)
+	s := &Session{}
+	c.Assert(handleMACCommands(s, []uint8{0x09, 0x05}), qt.IsNil)
+	c.Assert(s.macAnswers, qt.HasLen, 0)
+
+	// AS923: no dwell time limit, 16 dBm
+	rs := region.AS923(region.AS923_GROUP_1)
+	UseRegionSettings(rs)
+	c.Assert(handleMACCommands(s, []uint8{0x09, 0x05}), qt.IsNil)
+	c.Assert(s.macAnswers, qt.DeepEquals, []uint8{0x09})
+	c.Assert(rs.MaxDwellTime(), qt.Equals, time.Duration(0))
+}
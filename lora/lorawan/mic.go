@@ -14,9 +14,11 @@ func genPayloadMIC(payload []uint8, key [16]uint8) [4]uint8 {
 	return mic
 }
 
-func calcMessageMIC(payload []uint8, key [16]uint8, dir uint8, addr []byte, fCnt uint32, lenMessage uint8) [4]uint8 {
+// micBlock builds the B0 (or LoRaWAN 1.1 B1) block prepended to a data
+// message to compute its MIC
+func micBlock(confFCnt uint16, txDr uint8, txCh uint8, dir uint8, addr []byte, fCnt uint32, lenMessage uint8) []byte {
 	var b0 []byte
-	b0 = append(b0, 0x49, 0x00, 0x00, 0x00, 0x00)
+	b0 = append(b0, 0x49, uint8(confFCnt), uint8(confFCnt>>8), txDr, txCh)
 	b0 = append(b0, dir)
 	b0 = append(b0, addr[:]...)
 	var b [4]byte
@@ -24,15 +26,42 @@ func calcMessageMIC(payload []uint8, key [16]uint8, dir uint8, addr []byte, fCnt
 	b0 = append(b0, b[:]...)
 	b0 = append(b0, 0x00)
 	b0 = append(b0, lenMessage)
+	return b0
+}
 
+// messageCmac computes the AES-CMAC of a MIC block followed by the message
+func messageCmac(key [16]uint8, block []byte, payload []uint8) []byte {
 	var full []byte
-	full = append(full, b0...)
+	full = append(full, block...)
 	full = append(full, payload...)
 
-	var mic [4]uint8
 	hash, _ := NewCmac(key[:])
 	hash.Write(full)
-	hb := hash.Sum([]byte{})
+	return hash.Sum([]byte{})
+}
+
+func calcMessageMIC(payload []uint8, key [16]uint8, dir uint8, addr []byte, fCnt uint32, lenMessage uint8) [4]uint8 {
+	var mic [4]uint8
+	hb := messageCmac(key, micBlock(0, 0, 0, dir, addr, fCnt, lenMessage), payload)
+	copy(mic[:], hb[0:4])
+	return mic
+}
+
+// calcUplinkMIC11 computes the MIC of a LoRaWAN 1.1 uplink: 2 bytes computed
+// with SNwkSIntKey followed by 2 bytes computed with FNwkSIntKey
+func calcUplinkMIC11(payload []uint8, fNwkSIntKey [16]uint8, sNwkSIntKey [16]uint8, confFCnt uint16, txDr uint8, txCh uint8, addr []byte, fCnt uint32) [4]uint8 {
+	var mic [4]uint8
+	cmacS := messageCmac(sNwkSIntKey, micBlock(confFCnt, txDr, txCh, 0, addr, fCnt, uint8(len(payload))), payload)
+	cmacF := messageCmac(fNwkSIntKey, micBlock(0, 0, 0, 0, addr, fCnt, uint8(len(payload))), payload)
+	copy(mic[0:2], cmacS[0:2])
+	copy(mic[2:4], cmacF[0:2])
+	return mic
+}
+
+// calcDownlinkMIC11 computes the MIC of a LoRaWAN 1.1 downlink
+func calcDownlinkMIC11(payload []uint8, sNwkSIntKey [16]uint8, confFCnt uint16, addr []byte, fCnt uint32) [4]uint8 {
+	var mic [4]uint8
+	hb := messageCmac(sNwkSIntKey, micBlock(confFCnt, 0, 0, 1, addr, fCnt, uint8(len(payload))), payload)
 	copy(mic[:], hb[0:4])
 	return mic
 }
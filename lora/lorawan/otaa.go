@@ -8,19 +8,33 @@ import (
 
 // Otaa is used to store Over The Air Activation data of a LoRaWAN session
 type Otaa struct {
-	DevEUI   [8]uint8
-	AppEUI   [8]uint8
-	AppKey   [16]uint8
-	devNonce [2]uint8
-	appNonce [3]uint8
-	NetID    [3]uint8
-	buf      []uint8
+	DevEUI       [8]uint8
+	AppEUI       [8]uint8  // JoinEUI with LoRaWAN 1.1
+	AppKey       [16]uint8 // root key of all session keys before LoRaWAN 1.1
+	NwkKey       [16]uint8 // LoRaWAN 1.1 network root key
+	Version      uint8     // LoRaWAN version implemented by the device
+	devNonce     [2]uint8
+	appNonce     [3]uint8 // JoinNonce with LoRaWAN 1.0.4 and 1.1
+	joinNonceSet bool     // appNonce holds the JoinNonce of an accepted join
+	NetID        [3]uint8
+	buf          []uint8
 }
 
-// Initialize DevNonce
+// LoRaWAN versions implemented by the device
+const (
+	LORAWAN_1_0_2 = iota // random DevNonce
+	LORAWAN_1_0_4        // DevNonce counter, increasing JoinNonce
+	LORAWAN_1_1          // LoRaWAN 1.0.4 plus separate network and application keys
+)
+
+// Initialize DevNonce.
+// From LoRaWAN 1.0.4, DevNonce is a counter that must never be reused: Otaa
+// must be saved (see MarshalBinary) after each join and restored at startup.
 func (o *Otaa) Init() {
 	o.buf = make([]uint8, 0)
-	o.generateDevNonce()
+	if o.Version == LORAWAN_1_0_2 {
+		o.generateDevNonce()
+	}
 }
 
 func (o *Otaa) generateDevNonce() {
@@ -88,6 +102,30 @@ func (o *Otaa) GetAppKey() string {
 	return hex.EncodeToString(o.AppKey[:])
 }
 
+// SetNwkKey configures the Otaa NwkKey, used by LoRaWAN 1.1 only
+func (o *Otaa) SetNwkKey(nwkKey []uint8) error {
+	if len(nwkKey) != 16 {
+		return ErrInvalidNwkKeyLength
+	}
+
+	copy(o.NwkKey[:], nwkKey)
+
+	return nil
+}
+
+func (o *Otaa) GetNwkKey() string {
+	return hex.EncodeToString(o.NwkKey[:])
+}
+
+// nwkKey returns the key protecting join procedure, which is AppKey before
+// LoRaWAN 1.1
+func (o *Otaa) nwkKey() [16]uint8 {
+	if o.Version == LORAWAN_1_1 {
+		return o.NwkKey
+	}
+	return o.AppKey
+}
+
 func (o *Otaa) GetNetID() string {
 	return hex.EncodeToString(o.NetID[:])
 }
@@ -104,6 +142,9 @@ func (o *Otaa) SetNetID(netID []uint8) error {
 
 // GenerateJoinRequest Generates a LoraWAN Join request
 func (o *Otaa) GenerateJoinRequest() ([]uint8, error) {
+	if o.Version != LORAWAN_1_0_2 && o.devNonce == [2]uint8{0xFF, 0xFF} {
+		return nil, ErrDevNonceExhausted
+	}
 	o.incrementDevNonce()
 
 	// TODO: Add checks
@@ -112,7 +153,7 @@ func (o *Otaa) GenerateJoinRequest() ([]uint8, error) {
 	o.buf = append(o.buf, reverseBytes(o.AppEUI[:])...)
 	o.buf = append(o.buf, reverseBytes(o.DevEUI[:])...)
 	o.buf = append(o.buf, o.devNonce[:]...)
-	mic := genPayloadMIC(o.buf, o.AppKey)
+	mic := genPayloadMIC(o.buf, o.nwkKey())
 	o.buf = append(o.buf, mic[:]...)
 
 	return o.buf, nil
@@ -126,7 +167,8 @@ func (o *Otaa) DecodeJoinAccept(phyPload []uint8, s *Session) error {
 	data := phyPload[1:] // Remove trailing 0x20
 
 	// Prepare AES Cipher
-	block, err := aes.NewCipher(o.AppKey[:])
+	nwkKey := o.nwkKey()
+	block, err := aes.NewCipher(nwkKey[:])
 	if err != nil {
 		return err
 	}
@@ -135,51 +177,103 @@ func (o *Otaa) DecodeJoinAccept(phyPload []uint8, s *Session) error {
 		block.Encrypt(buf[k*aes.BlockSize:], data[k*aes.BlockSize:])
 	}
 
-	copy(o.appNonce[:], buf[0:3])
-	copy(o.NetID[:], buf[3:6])
-	copy(s.DevAddr[:], buf[6:10])
-	s.DLSettings = buf[10]
-	s.RXDelay = buf[11]
-
-	if len(buf) > 16 {
-		copy(s.CFList[:], buf[12:28])
+	// Session is only updated once the JoinAccept is checked
+	var joinNonce [3]uint8
+	var netID [3]uint8
+	var devAddr [4]uint8
+	var cfList [16]uint8
+	copy(joinNonce[:], buf[0:3])
+	copy(netID[:], buf[3:6])
+	copy(devAddr[:], buf[6:10])
+	dlSettings := buf[10]
+	rxDelay := buf[11]
+	hasCFList := len(buf) > 16
+	if hasCFList {
+		copy(cfList[:], buf[12:28])
 	}
 	rxMic := buf[len(buf)-4:]
 
+	// A LoRaWAN 1.1 network server sets OptNeg
+	optNeg := o.Version == LORAWAN_1_1 && dlSettings&0x80 != 0
+
 	dataMic := []byte{}
+	micKey := nwkKey
+	if optNeg {
+		// JoinReqType | JoinEUI | DevNonce are added to the MIC
+		dataMic = append(dataMic, 0xFF)
+		dataMic = append(dataMic, reverseBytes(o.AppEUI[:])...)
+		dataMic = append(dataMic, o.devNonce[:]...)
+		micKey = deriveKey(nwkKey, 0x06, reverseBytes(o.DevEUI[:])) // JSIntKey
+	}
 	dataMic = append(dataMic, phyPload[0])
-	dataMic = append(dataMic, o.appNonce[:]...)
-	dataMic = append(dataMic, o.NetID[:]...)
-	dataMic = append(dataMic, s.DevAddr[:]...)
-	dataMic = append(dataMic, s.DLSettings)
-	dataMic = append(dataMic, s.RXDelay)
-	dataMic = append(dataMic, s.CFList[:]...)
-	computedMic := genPayloadMIC(dataMic[:], o.AppKey)
+	dataMic = append(dataMic, joinNonce[:]...)
+	dataMic = append(dataMic, netID[:]...)
+	dataMic = append(dataMic, devAddr[:]...)
+	dataMic = append(dataMic, dlSettings)
+	dataMic = append(dataMic, rxDelay)
+	if hasCFList {
+		dataMic = append(dataMic, cfList[:]...)
+	}
+	computedMic := genPayloadMIC(dataMic[:], micKey)
 	if !bytes.Equal(computedMic[:], rxMic[:]) {
 		return ErrInvalidMic
 	}
-	// Generate NwkSKey
-	// NwkSKey = aes128_encrypt(AppKey, 0x01|AppNonce|NetID|DevNonce|pad16)
-	sKey := []byte{}
-	sKey = append(sKey, 0x01)
-	sKey = append(sKey, o.appNonce[:]...)
-	sKey = append(sKey, o.NetID[:]...)
-	sKey = append(sKey, o.devNonce[:]...)
-	for i := 0; i < 7; i++ {
-		sKey = append(sKey, 0x00) // PAD to 16
-	}
-	block.Encrypt(buf, sKey)
-	copy(s.NwkSKey[:], buf[0:16])
-
-	// Generate AppSKey
-	// AppSKey = aes128_encrypt(AppKey, 0x02|AppNonce|NetID|DevNonce|pad16)
-	sKey[0] = 0x02
-	block.Encrypt(buf, sKey)
-	copy(s.AppSKey[:], buf[0:16])
-
-	// Reset counters
-	s.FCntDown = 0
-	s.FCntUp = 0
+
+	// From LoRaWAN 1.0.4, a replayed JoinAccept is rejected
+	if o.Version != LORAWAN_1_0_2 && o.joinNonceSet && nonce24(joinNonce) <= nonce24(o.appNonce) {
+		return ErrInvalidJoinNonce
+	}
+
+	o.appNonce = joinNonce
+	o.joinNonceSet = true
+	o.NetID = netID
+	s.DevAddr = devAddr
+	s.DLSettings = dlSettings & 0x7F
+	s.RXDelay = rxDelay
+	s.CFList = cfList
+
+	if optNeg {
+		// LoRaWAN 1.1 keys are derived from JoinNonce | JoinEUI | DevNonce
+		ctx := append(append(joinNonce[:], reverseBytes(o.AppEUI[:])...), o.devNonce[:]...)
+		s.FNwkSIntKey = deriveKey(o.NwkKey, 0x01, ctx)
+		s.SNwkSIntKey = deriveKey(o.NwkKey, 0x03, ctx)
+		s.NwkSEncKey = deriveKey(o.NwkKey, 0x04, ctx)
+		s.AppSKey = deriveKey(o.AppKey, 0x02, ctx)
+		s.NwkSKey = s.FNwkSIntKey
+		s.Version = LORAWAN_1_1
+	} else {
+		// NwkSKey = aes128_encrypt(NwkKey, 0x01|AppNonce|NetID|DevNonce|pad16)
+		// AppSKey = aes128_encrypt(NwkKey, 0x02|AppNonce|NetID|DevNonce|pad16)
+		ctx := append(append(joinNonce[:], netID[:]...), o.devNonce[:]...)
+		s.NwkSKey = deriveKey(nwkKey, 0x01, ctx)
+		s.AppSKey = deriveKey(nwkKey, 0x02, ctx)
+		s.FNwkSIntKey = s.NwkSKey
+		s.SNwkSIntKey = s.NwkSKey
+		s.NwkSEncKey = s.NwkSKey
+		s.Version = LORAWAN_1_0_2
+		if o.Version != LORAWAN_1_0_2 {
+			s.Version = LORAWAN_1_0_4
+		}
+	}
+
+	s.reset()
+	// LoRaWAN 1.1 network server waits for RekeyInd to use the new session
+	s.rekeyPending = optNeg
 
 	return nil
 }
+
+// deriveKey computes aes128_encrypt(key, prefix | data | pad16)
+func deriveKey(key [16]uint8, prefix uint8, data []uint8) [16]uint8 {
+	var in, out [16]uint8
+	in[0] = prefix
+	copy(in[1:], data)
+	block, _ := aes.NewCipher(key[:])
+	block.Encrypt(out[:], in[:])
+	return out
+}
+
+// nonce24 returns the value of a 3 bytes little endian nonce
+func nonce24(n [3]uint8) uint32 {
+	return uint32(n[0]) | uint32(n[1])<<8 | uint32(n[2])<<16
+}
@@ -0,0 +1,184 @@
+package lorawan
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var (
+	testAppKey = [16]uint8{0x2B, 0x7E, 0x15, 0x16, 0x28, 0xAE, 0xD2, 0xA6, 0xAB, 0xF7, 0x15, 0x88, 0x09, 0xCF, 0x4F, 0x3C}
+	testNwkKey = [16]uint8{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+)
+
+// joinAccept builds an encrypted JoinAccept, as a network server would
+func joinAccept(o *Otaa, key [16]uint8, micKey [16]uint8, micPrefix []uint8, joinNonce [3]uint8, dlSettings uint8) []uint8 {
+	plain := []uint8{0x20}
+	plain = append(plain, joinNonce[:]...)
+	plain = append(plain, 0x13, 0x00, 0x00)       // NetID
+	plain = append(plain, 0x04, 0x03, 0x02, 0x01) // DevAddr
+	plain = append(plain, dlSettings, 0x01)
+	mic := genPayloadMIC(append(append([]uint8{}, micPrefix...), plain...), micKey)
+	plain = append(plain, mic[:]...)
+
+	block, _ := aes.NewCipher(key[:])
+	out := []uint8{plain[0]}
+	enc := make([]uint8, 16)
+	block.Decrypt(enc, plain[1:17])
+	return append(out, enc...)
+}
+
+func TestJoinAccept10(t *testing.T) {
+	c := qt.New(t)
+
+	o := &Otaa{AppKey: testAppKey}
+	o.Init()
+	_, err := o.GenerateJoinRequest()
+	c.Assert(err, qt.IsNil)
+
+	s := &Session{FCntUp: 10}
+	ja := joinAccept(o, testAppKey, testAppKey, nil, [3]uint8{1, 0, 0}, 0x00)
+	c.Assert(o.DecodeJoinAccept(ja, s), qt.IsNil)
+	c.Assert(s.Version, qt.Equals, uint8(LORAWAN_1_0_2))
+	c.Assert(s.DevAddr, qt.Equals, [4]uint8{0x04, 0x03, 0x02, 0x01})
+	c.Assert(s.FCntUp, qt.Equals, uint32(0))
+	c.Assert(s.NwkSKey, qt.Not(qt.Equals), s.AppSKey)
+	c.Assert(s.SNwkSIntKey, qt.Equals, s.NwkSKey)
+
+	// Corrupted MIC
+	ja[len(ja)-1] ^= 0xFF
+	c.Assert(o.DecodeJoinAccept(ja, s), qt.Equals, ErrInvalidMic)
+}
+
+func TestJoinAccept104(t *testing.T) {
+	c := qt.New(t)
+
+	o := &Otaa{AppKey: testAppKey, Version: LORAWAN_1_0_4}
+	o.Init()
+	req, err := o.GenerateJoinRequest()
+	c.Assert(err, qt.IsNil)
+	c.Assert(req[17:19], qt.DeepEquals, []uint8{0x01, 0x00})
+
+	// DevNonce is a counter, kept by Init
+	o.Init()
+	req, _ = o.GenerateJoinRequest()
+	c.Assert(req[17:19], qt.DeepEquals, []uint8{0x02, 0x00})
+
+	s := &Session{}
+	c.Assert(o.DecodeJoinAccept(joinAccept(o, testAppKey, testAppKey, nil, [3]uint8{5, 0, 0}, 0x00), s), qt.IsNil)
+	c.Assert(s.Version, qt.Equals, uint8(LORAWAN_1_0_4))
+
+	// JoinNonce must increase, even after a reboot
+	data, _ := o.MarshalBinary()
+	r := &Otaa{}
+	c.Assert(r.UnmarshalBinary(data), qt.IsNil)
+	r.Init()
+	r.GenerateJoinRequest()
+	c.Assert(r.DecodeJoinAccept(joinAccept(r, testAppKey, testAppKey, nil, [3]uint8{5, 0, 0}, 0x00), s), qt.Equals, ErrInvalidJoinNonce)
+	c.Assert(r.DecodeJoinAccept(joinAccept(r, testAppKey, testAppKey, nil, [3]uint8{6, 0, 0}, 0x00), s), qt.IsNil)
+
+	r.devNonce = [2]uint8{0xFF, 0xFF}
+	_, err = r.GenerateJoinRequest()
+	c.Assert(err, qt.Equals, ErrDevNonceExhausted)
+}
+
+func TestJoinAccept11(t *testing.T) {
+	c := qt.New(t)
+
+	o := &Otaa{AppKey: testAppKey, NwkKey: testNwkKey, Version: LORAWAN_1_1}
+	o.SetAppEUI([]uint8{0x70, 0xB3, 0xD5, 0x7E, 0xD0, 0x00, 0x00, 0x01})
+	o.SetDevEUI([]uint8{0x00, 0x04, 0xA3, 0x0B, 0x00, 0x1C, 0x05, 0x30})
+	o.Init()
+	req, err := o.GenerateJoinRequest()
+	c.Assert(err, qt.IsNil)
+	// Join request MIC uses NwkKey
+	mic := genPayloadMIC(req[:19], testNwkKey)
+	c.Assert(req[19:], qt.DeepEquals, mic[:])
+
+	// JoinAccept MIC uses JSIntKey with JoinReqType | JoinEUI | DevNonce
+	jsIntKey := deriveKey(testNwkKey, 0x06, reverseBytes(o.DevEUI[:]))
+	prefix := append([]uint8{0xFF}, reverseBytes(o.AppEUI[:])...)
+	prefix = append(prefix, o.devNonce[:]...)
+	s := &Session{}
+	ja := joinAccept(o, testNwkKey, jsIntKey, prefix, [3]uint8{1, 0, 0}, 0x80)
+	c.Assert(o.DecodeJoinAccept(ja, s), qt.IsNil)
+	c.Assert(s.Version, qt.Equals, uint8(LORAWAN_1_1))
+	c.Assert(s.DLSettings, qt.Equals, uint8(0))
+	c.Assert(s.rekeyPending, qt.IsTrue)
+	c.Assert(s.FNwkSIntKey, qt.Not(qt.Equals), s.SNwkSIntKey)
+	c.Assert(s.SNwkSIntKey, qt.Not(qt.Equals), s.NwkSEncKey)
+	ctx := append([]uint8{1, 0, 0}, reverseBytes(o.AppEUI[:])...)
+	c.Assert(s.AppSKey, qt.Equals, deriveKey(testAppKey, 0x02, append(ctx, o.devNonce[:]...)))
+
+	// LoRaWAN 1.0 network server: OptNeg not set, NwkKey used as 1.0 AppKey
+	o.GenerateJoinRequest()
+	ja = joinAccept(o, testNwkKey, testNwkKey, nil, [3]uint8{2, 0, 0}, 0x00)
+	c.Assert(o.DecodeJoinAccept(ja, s), qt.IsNil)
+	c.Assert(s.Version, qt.Equals, uint8(LORAWAN_1_0_4))
+}
+
+func TestUplink10(t *testing.T) {
+	c := qt.New(t)
+
+	// Sample frame of lora-packet: "test" sent on port 1 with FCnt 2
+	s := &Session{FCntUp: 2}
+	s.SetDevAddr([]uint8{0xF1, 0x7D, 0xBE, 0x49})
+	key, _ := hex.DecodeString("44024241ed4ce9a68c6a8bc055233fd3")
+	s.SetNwkSKey(key)
+	key, _ = hex.DecodeString("ec925802ae430ca77fd3dd73cb2cc588")
+	s.SetAppSKey(key)
+
+	msg, err := s.GenMessage(0, []uint8("test"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(hex.EncodeToString(msg), qt.Equals, "40f17dbe4900020001954378762b11ff0d")
+}
+
+func TestSession11(t *testing.T) {
+	c := qt.New(t)
+
+	s := &Session{Version: LORAWAN_1_1, rekeyPending: true}
+	s.DevAddr = [4]uint8{0x04, 0x03, 0x02, 0x01}
+	s.FNwkSIntKey = [16]uint8{1}
+	s.SNwkSIntKey = [16]uint8{2}
+	s.NwkSEncKey = [16]uint8{3}
+	s.AppSKey = [16]uint8{4}
+
+	// RekeyInd is sent encrypted in FOpts
+	msg, err := s.GenMessage(0, []uint8{0x42})
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg[5]&fCtrlFOptsLenMsk, qt.Equals, uint8(2))
+	fOpts, _ := s.genFRMPayload(s.NwkSEncKey, 0, 0, msg[8:10], true)
+	c.Assert(fOpts, qt.DeepEquals, []uint8{MAC_REKEY, LORAWAN_1_1_MINOR})
+
+	// MIC depends on data rate and channel
+	mic := calcUplinkMIC11(msg[:len(msg)-4], s.FNwkSIntKey, s.SNwkSIntKey, 0, 0, 0, s.DevAddr[:], 0)
+	c.Assert(msg[len(msg)-4:], qt.DeepEquals, mic[:])
+	s.signMessage(msg, 0, 5, 2)
+	c.Assert(msg[len(msg)-4:len(msg)-2], qt.Not(qt.DeepEquals), mic[:2])
+	c.Assert(msg[len(msg)-2:], qt.DeepEquals, mic[2:])
+
+	// Application downlink with RekeyConf in FOpts, counted by AFCntDown
+	fOpts, _ = s.genFRMPayload(s.NwkSEncKey, 1, 0, []uint8{MAC_REKEY, LORAWAN_1_1_MINOR}, true)
+	dl := []uint8{mTypeUnconfirmedDataDown, 0x04, 0x03, 0x02, 0x01, 0x02, 0x00, 0x00}
+	dl = append(dl, fOpts...)
+	data, _ := s.genFRMPayload(s.AppSKey, 1, 0, []uint8("hi"), false)
+	dl = append(dl, 10)
+	dl = append(dl, data...)
+	mic = calcDownlinkMIC11(dl, s.SNwkSIntKey, 0, s.DevAddr[:], 0)
+	dl = append(dl, mic[:]...)
+
+	d, err := s.decodeDownlink(dl)
+	c.Assert(err, qt.IsNil)
+	c.Assert(d.fPort, qt.Equals, uint8(10))
+	c.Assert(string(d.payload), qt.Equals, "hi")
+	c.Assert(s.AFCntDown, qt.Equals, uint32(1))
+	c.Assert(s.FCntDown, qt.Equals, uint32(0))
+	c.Assert(handleMACCommands(s, d.fOpts), qt.IsNil)
+	c.Assert(s.rekeyPending, qt.IsFalse)
+
+	// Replayed downlink
+	_, err = s.decodeDownlink(dl)
+	c.Assert(err, qt.Equals, ErrInvalidFCntDown)
+}
@@ -0,0 +1,170 @@
+package region
+
+import (
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+)
+
+const (
+	AS923_DEFAULT_PREAMBLE_LEN = 8
+	AS923_DEFAULT_TX_POWER_DBM = 16
+	AS923_FREQUENCY_1          = 923200000 // first default channel of AS923-1
+	AS923_FREQUENCY_2          = 923400000 // second default channel of AS923-1
+	AS923_RX2_FREQUENCY        = 923200000 // RX2 frequency of AS923-1
+	AS923_MAX_EIRP_DBM         = 16
+	AS923_NUM_CHANNELS         = 16
+	AS923_NUM_DEFAULT_CHANNELS = 2
+	AS923_MIN_FREQUENCY        = 915000000
+	AS923_MAX_FREQUENCY        = 928000000
+	AS923_MAX_DWELL_TIME       = 400 * time.Millisecond
+)
+
+// AS923 frequency plan groups, the frequencies of AS923-1 are shifted by an
+// offset for the other groups
+const (
+	AS923_GROUP_1 = 1
+	AS923_GROUP_2 = 2
+	AS923_GROUP_3 = 3
+	AS923_GROUP_4 = 4
+)
+
+// as923FrequencyOffset holds the frequency offset of each AS923 group
+var as923FrequencyOffset = [...]int32{
+	AS923_GROUP_1: 0,
+	AS923_GROUP_2: -1800000,
+	AS923_GROUP_3: -6600000,
+	AS923_GROUP_4: -5900000,
+}
+
+var dataRatesAS923 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0}, // DR0
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0}, // DR1
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0}, // DR2
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0},  // DR3
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0},  // DR4
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0},  // DR5
+	{lora.SpreadingFactor7, lora.Bandwidth_250_0},  // DR6
+}
+
+type ChannelAS struct {
+	channel
+}
+
+func (c *ChannelAS) Next() bool {
+	return false
+}
+
+type SettingsAS923 struct {
+	settings
+	downlinkDwellTime bool // RX1 data rate can't be lower than DR2
+}
+
+// AS923 returns the settings of an AS923 group (AS923_GROUP_1 to
+// AS923_GROUP_4). Unknown groups use AS923-1.
+func AS923(group uint8) *SettingsAS923 {
+	if group < AS923_GROUP_1 || group > AS923_GROUP_4 {
+		group = AS923_GROUP_1
+	}
+	offset := as923FrequencyOffset[group]
+	freq1 := uint32(AS923_FREQUENCY_1 + offset)
+	freq2 := uint32(AS923_FREQUENCY_2 + offset)
+	rx2Freq := uint32(AS923_RX2_FREQUENCY + offset)
+
+	return &SettingsAS923{settings: settings{
+		joinRequestChannel: &ChannelAS{channel: channel{freq1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelAS{channel: channel{freq1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelAS{channel: channel{freq1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		rx1Channel: &ChannelAS{channel: channel{0,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelAS{channel: channel{rx2Freq,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor10,
+			lora.CodingRate4_5,
+			AS923_DEFAULT_PREAMBLE_LEN,
+			AS923_DEFAULT_TX_POWER_DBM}},
+		dataRates:          dataRatesAS923,
+		rx2Frequency:       rx2Freq,
+		rx2DataRate:        2,
+		channels:           channelsAS923(freq1, freq2),
+		channelMask:        ChannelMask{0b11},
+		defaultChannelMask: ChannelMask{0b11},
+		nextChannel:        AS923_NUM_CHANNELS - 1,
+		numDefaultChannels: AS923_NUM_DEFAULT_CHANNELS,
+		dataRate:           3,
+		maxUplinkDataRate:  6,
+		maxTxPower:         7,
+		maxEIRP:            AS923_MAX_EIRP_DBM,
+		maxRx1DROffset:     7,
+		minFrequency:       AS923_MIN_FREQUENCY,
+		maxFrequency:       AS923_MAX_FREQUENCY,
+		maxDwellTime:       AS923_MAX_DWELL_TIME,
+	}, downlinkDwellTime: true}
+}
+
+// channelsAS923 returns the AS923 channel plan, with the 2 default channels
+func channelsAS923(freq1, freq2 uint32) []channelSlot {
+	c := make([]channelSlot, AS923_NUM_CHANNELS)
+	c[0] = channelSlot{freq1, 0, 5}
+	c[1] = channelSlot{freq2, 0, 5}
+	return c
+}
+
+// UpdateChannelMask applies a LinkADRReq ChMask to mask.
+// ChMaskCntl 0 selects channels 0-15, 6 enables all defined channels.
+func (r *SettingsAS923) UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	return r.updateChannelMask16(mask, chMaskCntl, chMask)
+}
+
+// SetChannel creates, modifies (or deletes when frequency is 0) an uplink
+// channel. The default channels can't be changed.
+func (r *SettingsAS923) SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (bool, bool) {
+	return r.setDynamicChannel(index, frequency, minDR, maxDR)
+}
+
+// ApplyCFList adds the up to 5 extra channels sent in a JoinAccept CFList
+func (r *SettingsAS923) ApplyCFList(cfList [16]uint8) {
+	r.applyCFListChannels(cfList, 5)
+}
+
+// Rx1Channel returns the channel used for the first receive window.
+// AS923 replies on the uplink frequency. The data rate can't be lower than
+// DR2 when the downlink dwell time is limited.
+func (r *SettingsAS923) Rx1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	minDR := uint8(0)
+	if r.downlinkDwellTime {
+		minDR = 2
+	}
+	return r.rx1SameFrequency(uplink, rx1DROffset, minDR, 5)
+}
+
+// SetTxParams applies the dwell time limits and max EIRP of a TxParamSetupReq
+func (r *SettingsAS923) SetTxParams(uplinkDwellTime, downlinkDwellTime bool, maxEIRP int8) bool {
+	r.maxDwellTime = 0
+	if uplinkDwellTime {
+		r.maxDwellTime = AS923_MAX_DWELL_TIME
+	}
+	r.downlinkDwellTime = downlinkDwellTime
+	r.maxEIRP = maxEIRP
+	r.SetTxPower(r.txPower)
+	return true
+}
@@ -5,8 +5,35 @@ import "tinygo.org/x/drivers/lora"
 const (
 	AU915_DEFAULT_PREAMBLE_LEN = 8
 	AU915_DEFAULT_TX_POWER_DBM = 20
+	AU915_UPLINK_FREQUENCY_125 = 915200000 // first 125 kHz uplink channel
+	AU915_UPLINK_FREQUENCY_500 = 915900000 // first 500 kHz uplink channel
+	AU915_UPLINK_STEP_125      = 200000
+	AU915_UPLINK_STEP_500      = 1600000
+	AU915_DOWNLINK_FREQUENCY   = lora.MHz_923_3
+	AU915_DOWNLINK_STEP        = 600000
+	AU915_RX2_FREQUENCY        = lora.MHz_923_3
+	AU915_MAX_EIRP_DBM         = 30
+	AU915_MIN_FREQUENCY        = 915000000
+	AU915_MAX_FREQUENCY        = 928000000
 )
 
+var dataRatesAU915 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0}, // DR0
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0}, // DR1
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0}, // DR2
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0},  // DR3
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0},  // DR4
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0},  // DR5
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0},  // DR6
+	{}, // DR7 (RFU)
+	{lora.SpreadingFactor12, lora.Bandwidth_500_0}, // DR8
+	{lora.SpreadingFactor11, lora.Bandwidth_500_0}, // DR9
+	{lora.SpreadingFactor10, lora.Bandwidth_500_0}, // DR10
+	{lora.SpreadingFactor9, lora.Bandwidth_500_0},  // DR11
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0},  // DR12
+	{lora.SpreadingFactor7, lora.Bandwidth_500_0},  // DR13
+}
+
 type ChannelAU struct {
 	channel
 }
@@ -39,9 +66,92 @@ func AU915() *SettingsAU915 {
 			lora.CodingRate4_5,
 			AU915_DEFAULT_PREAMBLE_LEN,
 			AU915_DEFAULT_TX_POWER_DBM}},
+		rx1Channel: &ChannelAU{channel: channel{0,
+			lora.Bandwidth_500_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			AU915_DEFAULT_PREAMBLE_LEN,
+			AU915_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelAU{channel: channel{AU915_RX2_FREQUENCY,
+			lora.Bandwidth_500_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			AU915_DEFAULT_PREAMBLE_LEN,
+			AU915_DEFAULT_TX_POWER_DBM}},
+		dataRates:          dataRatesAU915,
+		rx2Frequency:       AU915_RX2_FREQUENCY,
+		rx2DataRate:        8,
+		channels:           channelsAU915(),
+		channelMask:        ChannelMask{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0x00FF},
+		defaultChannelMask: ChannelMask{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0x00FF},
+		nextChannel:        7,
+		nextJoinChannel:    7,
+		dataRate:           3,
+		maxUplinkDataRate:  6,
+		maxTxPower:         14,
+		maxEIRP:            AU915_MAX_EIRP_DBM,
+		maxRx1DROffset:     5,
+		minFrequency:       AU915_MIN_FREQUENCY,
+		maxFrequency:       AU915_MAX_FREQUENCY,
 	}}
 }
 
+// channelsAU915 returns the 64 125 kHz and 8 500 kHz AU915 uplink channels
+func channelsAU915() []channelSlot {
+	c := make([]channelSlot, 72)
+	for i := 0; i < 64; i++ {
+		c[i] = channelSlot{AU915_UPLINK_FREQUENCY_125 + uint32(i)*AU915_UPLINK_STEP_125, 0, 5}
+	}
+	for i := 0; i < 8; i++ {
+		c[64+i] = channelSlot{AU915_UPLINK_FREQUENCY_500 + uint32(i)*AU915_UPLINK_STEP_500, 6, 6}
+	}
+	return c
+}
+
+// SetSubBands restricts uplinks to the channels of the given sub-bands
+// (SUB_BAND_1 to SUB_BAND_8), matching the channels of the network gateways.
+// These channels are also used for join requests, and enabled again by ADR
+// when the network stays silent.
+func (r *SettingsAU915) SetSubBands(subBands uint8) bool {
+	return r.setSubBands500(subBands)
+}
+
+// JoinRequestChannel returns the channel for next join request, hopping
+// over the enabled 125 kHz channels
+func (r *SettingsAU915) JoinRequestChannel() Channel {
+	r.hopJoinChannel(64)
+	return r.joinRequestChannel
+}
+
+// UpdateChannelMask applies a LinkADRReq ChMask to mask
+func (r *SettingsAU915) UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	return updateChannelMask500(mask, chMaskCntl, chMask)
+}
+
+// ApplyCFList applies the channel mask sent in a JoinAccept CFList
+func (r *SettingsAU915) ApplyCFList(cfList [16]uint8) {
+	r.applyCFListMask(cfList)
+}
+
+// Rx1Channel returns the channel used for the first receive window.
+// AU915 replies on one of the 8 500 kHz downlink channels, picked from the
+// uplink channel number
+func (r *SettingsAU915) Rx1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	var ch uint32
+	if uplink.Bandwidth() == lora.Bandwidth_500_0 {
+		ch = 64 + (uplink.Frequency()-AU915_UPLINK_FREQUENCY_500)/AU915_UPLINK_STEP_500
+	} else {
+		ch = (uplink.Frequency() - AU915_UPLINK_FREQUENCY_125) / AU915_UPLINK_STEP_125
+	}
+	r.rx1Channel.SetFrequency(AU915_DOWNLINK_FREQUENCY + (ch%8)*AU915_DOWNLINK_STEP)
+	r.rx1Channel.SetSpreadingFactor(uplink.SpreadingFactor())
+	r.rx1Channel.SetBandwidth(lora.Bandwidth_500_0)
+	if dr, ok := dataRateIndex(r.dataRates, uplink.SpreadingFactor(), uplink.Bandwidth()); ok {
+		r.setDataRate(r.rx1Channel, rx1DataRate500(8, dr, rx1DROffset))
+	}
+	return r.rx1Channel
+}
+
 func Next(c *ChannelAU) bool {
 	return false
 }
@@ -40,3 +40,27 @@ func (c *channel) SetSpreadingFactor(v uint8) { c.spreadingFactor = v }
 func (c *channel) SetCodingRate(v uint8)      { c.codingRate = v }
 func (c *channel) SetPreambleLength(v uint16) { c.preambleLength = v }
 func (c *channel) SetTxPowerDBm(v int8)       { c.txPowerDBm = v }
+
+// ChannelMask holds the enabled state of up to 96 uplink channels
+type ChannelMask [6]uint16
+
+// Enabled reports if channel i is enabled
+func (m *ChannelMask) Enabled(i int) bool {
+	return m[i/16]&(1<<(i%16)) != 0
+}
+
+// Set enables or disables channel i
+func (m *ChannelMask) Set(i int, enabled bool) {
+	if enabled {
+		m[i/16] |= 1 << (i % 16)
+	} else {
+		m[i/16] &^= 1 << (i % 16)
+	}
+}
+
+// channelSlot describes an uplink channel of the region channel plan
+type channelSlot struct {
+	frequency uint32
+	minDR     uint8
+	maxDR     uint8
+}
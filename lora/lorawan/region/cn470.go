@@ -0,0 +1,136 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	CN470_DEFAULT_PREAMBLE_LEN = 8
+	CN470_DEFAULT_TX_POWER_DBM = 19
+	CN470_UPLINK_FREQUENCY     = 470300000 // first of the 96 uplink channels
+	CN470_UPLINK_STEP          = 200000
+	CN470_NUM_CHANNELS         = 96
+	CN470_DOWNLINK_FREQUENCY   = 500300000 // first of the 48 downlink channels
+	CN470_DOWNLINK_STEP        = 200000
+	CN470_NUM_DOWNLINK         = 48
+	CN470_RX2_FREQUENCY        = 505300000
+	CN470_MAX_EIRP_DBM         = 19
+	CN470_MIN_FREQUENCY        = 470000000
+	CN470_MAX_FREQUENCY        = 510000000
+)
+
+var dataRatesCN470 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0}, // DR0
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0}, // DR1
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0}, // DR2
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0},  // DR3
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0},  // DR4
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0},  // DR5
+}
+
+type ChannelCN struct {
+	channel
+}
+
+func (c *ChannelCN) Next() bool {
+	return false
+}
+
+type SettingsCN470 struct {
+	settings
+}
+
+func CN470() *SettingsCN470 {
+	return &SettingsCN470{settings: settings{
+		joinRequestChannel: &ChannelCN{channel: channel{CN470_UPLINK_FREQUENCY,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelCN{channel: channel{CN470_UPLINK_FREQUENCY,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		rx1Channel: &ChannelCN{channel: channel{0,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelCN{channel: channel{CN470_RX2_FREQUENCY,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			CN470_DEFAULT_PREAMBLE_LEN,
+			CN470_DEFAULT_TX_POWER_DBM}},
+		dataRates:          dataRatesCN470,
+		rx2Frequency:       CN470_RX2_FREQUENCY,
+		channels:           channelsCN470(),
+		channelMask:        ChannelMask{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF},
+		defaultChannelMask: ChannelMask{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF},
+		nextChannel:        CN470_NUM_CHANNELS - 1,
+		nextJoinChannel:    CN470_NUM_CHANNELS - 1,
+		dataRate:           3,
+		maxUplinkDataRate:  5,
+		maxTxPower:         7,
+		maxEIRP:            CN470_MAX_EIRP_DBM,
+		maxRx1DROffset:     5,
+		minFrequency:       CN470_MIN_FREQUENCY,
+		maxFrequency:       CN470_MAX_FREQUENCY,
+	}}
+}
+
+// channelsCN470 returns the 96 CN470 uplink channels
+func channelsCN470() []channelSlot {
+	c := make([]channelSlot, CN470_NUM_CHANNELS)
+	for i := range c {
+		c[i] = channelSlot{CN470_UPLINK_FREQUENCY + uint32(i)*CN470_UPLINK_STEP, 0, 5}
+	}
+	return c
+}
+
+// JoinRequestChannel returns the channel for next join request, hopping
+// over the enabled channels
+func (r *SettingsCN470) JoinRequestChannel() Channel {
+	r.hopJoinChannel(CN470_NUM_CHANNELS)
+	return r.joinRequestChannel
+}
+
+// JoinAcceptChannel returns the RX1 channel of the last join request
+func (r *SettingsCN470) JoinAcceptChannel() Channel {
+	return r.Rx1Channel(r.joinRequestChannel, 0)
+}
+
+// UpdateChannelMask applies a LinkADRReq ChMask to mask.
+// ChMaskCntl 0 to 5 select a bank of 16 channels, 6 enables all channels.
+func (r *SettingsCN470) UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	switch chMaskCntl {
+	case 0, 1, 2, 3, 4, 5:
+		mask[chMaskCntl] = chMask
+	case 6:
+		mask[0], mask[1], mask[2], mask[3], mask[4], mask[5] = 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF
+	default:
+		return false
+	}
+	return true
+}
+
+// ApplyCFList applies the channel mask sent in a JoinAccept CFList
+func (r *SettingsCN470) ApplyCFList(cfList [16]uint8) {
+	r.applyCFListMask(cfList)
+}
+
+// Rx1Channel returns the channel used for the first receive window.
+// CN470 replies on one of the 48 downlink channels, picked from the uplink
+// channel number
+func (r *SettingsCN470) Rx1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	ch := (uplink.Frequency() - CN470_UPLINK_FREQUENCY) / CN470_UPLINK_STEP
+	r.rx1Channel.SetFrequency(CN470_DOWNLINK_FREQUENCY + (ch%CN470_NUM_DOWNLINK)*CN470_DOWNLINK_STEP)
+	r.rx1Channel.SetSpreadingFactor(uplink.SpreadingFactor())
+	r.rx1Channel.SetBandwidth(uplink.Bandwidth())
+	if dr, ok := dataRateIndex(r.dataRates, uplink.SpreadingFactor(), uplink.Bandwidth()); ok {
+		r.setDataRate(r.rx1Channel, rx1DataRate(dr, rx1DROffset, 0, 5))
+	}
+	return r.rx1Channel
+}
@@ -0,0 +1,44 @@
+package region
+
+// DataRate describes the LoRa modulation used by a LoRaWAN data rate index
+type DataRate struct {
+	SpreadingFactor uint8
+	Bandwidth       uint8
+}
+
+// dataRateIndex looks up the data rate index matching the given modulation
+func dataRateIndex(dataRates []DataRate, sf uint8, bw uint8) (uint8, bool) {
+	for i, dr := range dataRates {
+		if dr.SpreadingFactor == sf && dr.Bandwidth == bw {
+			return uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+// rx1DataRate500 computes the RX1 data rate of regions answering on
+// 500 kHz downlink channels (DR8 to DR13)
+func rx1DataRate500(base uint8, dr uint8, rx1DROffset uint8) uint8 {
+	v := int(base) + int(dr) - int(rx1DROffset)
+	if v < 8 {
+		v = 8
+	} else if v > 13 {
+		v = 13
+	}
+	return uint8(v)
+}
+
+// rx1DataRate computes the RX1 data rate of regions replying with the uplink
+// modulation. Offsets 6 and 7 (AS923, IN865) raise the data rate by 1 and 2.
+func rx1DataRate(dr uint8, rx1DROffset uint8, minDR, maxDR uint8) uint8 {
+	v := int(dr) - int(rx1DROffset)
+	if rx1DROffset > 5 {
+		v = int(dr) + int(rx1DROffset) - 5
+	}
+	if v < int(minDR) {
+		v = int(minDR)
+	} else if v > int(maxDR) {
+		v = int(maxDR)
+	}
+	return uint8(v)
+}
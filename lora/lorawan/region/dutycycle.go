@@ -0,0 +1,77 @@
+package region
+
+import "time"
+
+// subBand is a regulatory sub-band, where the time spent transmitting is
+// limited by a duty cycle
+type subBand struct {
+	minFrequency uint32
+	maxFrequency uint32
+	dutyCycle    uint16    // transmission allowed 1/dutyCycle of the time
+	availableAt  time.Time // end of the off period of the last transmission
+}
+
+// subBandsEU868 returns the ETSI EN300.220 sub-bands used by EU868
+func subBandsEU868() []subBand {
+	return []subBand{
+		{863000000, 865000000, 1000, time.Time{}}, // 0.1%
+		{865000000, 868000000, 100, time.Time{}},  // 1%
+		{868000000, 868600000, 100, time.Time{}},  // 1%
+		{868700000, 869200000, 1000, time.Time{}}, // 0.1%
+		{869400000, 869650000, 10, time.Time{}},   // 10%
+		{869700000, 870000000, 100, time.Time{}},  // 1%
+	}
+}
+
+// subBand returns the sub-band of a frequency, or nil if the region has no
+// duty cycle limit. Frequencies between sub-bands get the strictest limit.
+func (r *settings) subBand(frequency uint32) *subBand {
+	if len(r.subBands) == 0 {
+		return nil
+	}
+	strictest := &r.subBands[0]
+	for i := range r.subBands {
+		b := &r.subBands[i]
+		if frequency >= b.minFrequency && frequency < b.maxFrequency {
+			return b
+		}
+		if b.dutyCycle > strictest.dutyCycle {
+			strictest = b
+		}
+	}
+	return strictest
+}
+
+// TxAvailableAt returns when the duty cycle allows to transmit again on
+// frequency. A time in the past means transmission is allowed now.
+func (r *settings) TxAvailableAt(frequency uint32) time.Time {
+	if b := r.subBand(frequency); b != nil {
+		return b.availableAt
+	}
+	return time.Time{}
+}
+
+// RegisterTx records a transmission of airtime duration ending at end, so
+// that its sub-band stays off for the rest of the duty cycle period
+func (r *settings) RegisterTx(frequency uint32, end time.Time, airtime time.Duration) {
+	if b := r.subBand(frequency); b != nil {
+		b.availableAt = end.Add(airtime * time.Duration(b.dutyCycle-1))
+	}
+}
+
+// MaxDwellTime returns the longest allowed transmission, 0 if unlimited
+func (r *settings) MaxDwellTime() time.Duration {
+	return r.maxDwellTime
+}
+
+// ListenBeforeTalk reports if the channel must be checked free before
+// transmitting
+func (r *settings) ListenBeforeTalk() bool {
+	return r.listenBeforeTalk
+}
+
+// SetListenBeforeTalk enables listen before talk, required in some
+// countries of a region, such as Japan for AS923
+func (r *settings) SetListenBeforeTalk(enabled bool) {
+	r.listenBeforeTalk = enabled
+}
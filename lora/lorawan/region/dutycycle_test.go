@@ -0,0 +1,59 @@
+package region
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDutyCycleEU868(t *testing.T) {
+	c := qt.New(t)
+
+	// A simulated clock, not the wall clock
+	r := EU868()
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.Assert(r.TxAvailableAt(868100000).After(now), qt.IsFalse)
+
+	// 1% sub-band: 100 ms transmission makes it off for 9.9 s
+	r.RegisterTx(868100000, now, 100*time.Millisecond)
+	c.Assert(r.TxAvailableAt(868500000), qt.Equals, now.Add(9900*time.Millisecond))
+	// 10% sub-band is not affected
+	c.Assert(r.TxAvailableAt(869525000).IsZero(), qt.IsTrue)
+
+	// Uplinks hop to a channel of another sub-band
+	freqOK, drOK := r.SetChannel(3, 867100000, 0, 5)
+	c.Assert(freqOK && drOK, qt.IsTrue)
+	c.Assert(r.UplinkChannel(now).Frequency(), qt.Equals, uint32(867100000))
+	c.Assert(r.UplinkChannel(now).Frequency(), qt.Equals, uint32(867100000))
+
+	// All sub-bands off: channel available first is returned
+	r.RegisterTx(867100000, now, 200*time.Millisecond)
+	c.Assert(r.UplinkChannel(now).Frequency(), qt.Equals, uint32(868100000))
+
+	// Once the 868.1 MHz sub-band is back on, uplinks hop through it again
+	later := now.Add(10 * time.Second)
+	c.Assert(r.UplinkChannel(later).Frequency(), qt.Equals, uint32(868300000))
+	c.Assert(r.UplinkChannel(later).Frequency(), qt.Equals, uint32(868500000))
+}
+
+func TestMaxDwellTime(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(EU868().MaxDwellTime(), qt.Equals, time.Duration(0))
+	c.Assert(US915().MaxDwellTime(), qt.Equals, 400*time.Millisecond)
+	c.Assert(US915().TxAvailableAt(902300000).IsZero(), qt.IsTrue)
+}
+
+func TestListenBeforeTalk(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(KR920().ListenBeforeTalk(), qt.IsTrue)
+	c.Assert(EU868().ListenBeforeTalk(), qt.IsFalse)
+
+	// Required in Japan only
+	r := AS923(AS923_GROUP_1)
+	c.Assert(r.ListenBeforeTalk(), qt.IsFalse)
+	r.SetListenBeforeTalk(true)
+	c.Assert(r.ListenBeforeTalk(), qt.IsTrue)
+}
@@ -5,8 +5,24 @@ import "tinygo.org/x/drivers/lora"
 const (
 	EU868_DEFAULT_PREAMBLE_LEN = 8
 	EU868_DEFAULT_TX_POWER_DBM = 20
+	EU868_RX2_FREQUENCY        = lora.MHz_869_525
+	EU868_MAX_EIRP_DBM         = 16
+	EU868_NUM_CHANNELS         = 16
+	EU868_NUM_DEFAULT_CHANNELS = 3
+	EU868_MIN_FREQUENCY        = 863000000
+	EU868_MAX_FREQUENCY        = 870000000
 )
 
+var dataRatesEU868 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0}, // DR0
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0}, // DR1
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0}, // DR2
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0},  // DR3
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0},  // DR4
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0},  // DR5
+	{lora.SpreadingFactor7, lora.Bandwidth_250_0},  // DR6
+}
+
 type ChannelEU struct {
 	channel
 }
@@ -39,5 +55,64 @@ func EU868() *SettingsEU868 {
 			lora.CodingRate4_7,
 			EU868_DEFAULT_PREAMBLE_LEN,
 			EU868_DEFAULT_TX_POWER_DBM}},
+		rx1Channel: &ChannelEU{channel: channel{0,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			EU868_DEFAULT_PREAMBLE_LEN,
+			EU868_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelEU{channel: channel{EU868_RX2_FREQUENCY,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			EU868_DEFAULT_PREAMBLE_LEN,
+			EU868_DEFAULT_TX_POWER_DBM}},
+		dataRates:          dataRatesEU868,
+		rx2Frequency:       EU868_RX2_FREQUENCY,
+		channels:           channelsEU868(),
+		channelMask:        ChannelMask{0b111},
+		defaultChannelMask: ChannelMask{0b111},
+		nextChannel:        EU868_NUM_CHANNELS - 1,
+		numDefaultChannels: EU868_NUM_DEFAULT_CHANNELS,
+		dataRate:           3,
+		maxUplinkDataRate:  6,
+		maxTxPower:         7,
+		maxEIRP:            EU868_MAX_EIRP_DBM,
+		maxRx1DROffset:     5,
+		minFrequency:       EU868_MIN_FREQUENCY,
+		maxFrequency:       EU868_MAX_FREQUENCY,
+		subBands:           subBandsEU868(),
 	}}
 }
+
+// channelsEU868 returns the EU868 channel plan, with the 3 default channels
+func channelsEU868() []channelSlot {
+	c := make([]channelSlot, EU868_NUM_CHANNELS)
+	c[0] = channelSlot{lora.MHz_868_1, 0, 5}
+	c[1] = channelSlot{lora.MHz_868_3, 0, 5}
+	c[2] = channelSlot{lora.MHz_868_5, 0, 5}
+	return c
+}
+
+// UpdateChannelMask applies a LinkADRReq ChMask to mask.
+// ChMaskCntl 0 selects channels 0-15, 6 enables all defined channels.
+func (r *SettingsEU868) UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	return r.updateChannelMask16(mask, chMaskCntl, chMask)
+}
+
+// SetChannel creates, modifies (or deletes when frequency is 0) an uplink
+// channel. The default channels can't be changed.
+func (r *SettingsEU868) SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (bool, bool) {
+	return r.setDynamicChannel(index, frequency, minDR, maxDR)
+}
+
+// ApplyCFList adds the up to 5 extra channels sent in a JoinAccept CFList
+func (r *SettingsEU868) ApplyCFList(cfList [16]uint8) {
+	r.applyCFListChannels(cfList, 5)
+}
+
+// Rx1Channel returns the channel used for the first receive window.
+// EU868 replies on the uplink frequency, with the data rate lowered by rx1DROffset
+func (r *SettingsEU868) Rx1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	return r.rx1SameFrequency(uplink, rx1DROffset, 0, 7)
+}
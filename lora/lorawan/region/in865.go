@@ -0,0 +1,120 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	IN865_DEFAULT_PREAMBLE_LEN = 8
+	IN865_DEFAULT_TX_POWER_DBM = 20
+	IN865_FREQUENCY_1          = 865062500
+	IN865_FREQUENCY_2          = 865402500
+	IN865_FREQUENCY_3          = 865985000
+	IN865_RX2_FREQUENCY        = 866550000
+	IN865_MAX_EIRP_DBM         = 30
+	IN865_NUM_CHANNELS         = 16
+	IN865_NUM_DEFAULT_CHANNELS = 3
+	IN865_MIN_FREQUENCY        = 865000000
+	IN865_MAX_FREQUENCY        = 867000000
+)
+
+var dataRatesIN865 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0}, // DR0
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0}, // DR1
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0}, // DR2
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0},  // DR3
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0},  // DR4
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0},  // DR5
+}
+
+type ChannelIN struct {
+	channel
+}
+
+func (c *ChannelIN) Next() bool {
+	return false
+}
+
+type SettingsIN865 struct {
+	settings
+}
+
+func IN865() *SettingsIN865 {
+	return &SettingsIN865{settings: settings{
+		joinRequestChannel: &ChannelIN{channel: channel{IN865_FREQUENCY_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelIN{channel: channel{IN865_FREQUENCY_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelIN{channel: channel{IN865_FREQUENCY_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		rx1Channel: &ChannelIN{channel: channel{0,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelIN{channel: channel{IN865_RX2_FREQUENCY,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor10,
+			lora.CodingRate4_5,
+			IN865_DEFAULT_PREAMBLE_LEN,
+			IN865_DEFAULT_TX_POWER_DBM}},
+		dataRates:          dataRatesIN865,
+		rx2Frequency:       IN865_RX2_FREQUENCY,
+		rx2DataRate:        2,
+		channels:           channelsIN865(),
+		channelMask:        ChannelMask{0b111},
+		defaultChannelMask: ChannelMask{0b111},
+		nextChannel:        IN865_NUM_CHANNELS - 1,
+		numDefaultChannels: IN865_NUM_DEFAULT_CHANNELS,
+		dataRate:           3,
+		maxUplinkDataRate:  5,
+		maxTxPower:         10,
+		maxEIRP:            IN865_MAX_EIRP_DBM,
+		maxRx1DROffset:     7,
+		minFrequency:       IN865_MIN_FREQUENCY,
+		maxFrequency:       IN865_MAX_FREQUENCY,
+	}}
+}
+
+// channelsIN865 returns the IN865 channel plan, with the 3 default channels
+func channelsIN865() []channelSlot {
+	c := make([]channelSlot, IN865_NUM_CHANNELS)
+	c[0] = channelSlot{IN865_FREQUENCY_1, 0, 5}
+	c[1] = channelSlot{IN865_FREQUENCY_2, 0, 5}
+	c[2] = channelSlot{IN865_FREQUENCY_3, 0, 5}
+	return c
+}
+
+// UpdateChannelMask applies a LinkADRReq ChMask to mask.
+// ChMaskCntl 0 selects channels 0-15, 6 enables all defined channels.
+func (r *SettingsIN865) UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	return r.updateChannelMask16(mask, chMaskCntl, chMask)
+}
+
+// SetChannel creates, modifies (or deletes when frequency is 0) an uplink
+// channel. The default channels can't be changed.
+func (r *SettingsIN865) SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (bool, bool) {
+	return r.setDynamicChannel(index, frequency, minDR, maxDR)
+}
+
+// ApplyCFList adds the up to 5 extra channels sent in a JoinAccept CFList
+func (r *SettingsIN865) ApplyCFList(cfList [16]uint8) {
+	r.applyCFListChannels(cfList, 5)
+}
+
+// Rx1Channel returns the channel used for the first receive window.
+// IN865 replies on the uplink frequency, offsets 6 and 7 raise the data rate
+func (r *SettingsIN865) Rx1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	return r.rx1SameFrequency(uplink, rx1DROffset, 0, 5)
+}
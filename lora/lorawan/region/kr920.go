@@ -0,0 +1,120 @@
+package region
+
+import "tinygo.org/x/drivers/lora"
+
+const (
+	KR920_DEFAULT_PREAMBLE_LEN = 8
+	KR920_DEFAULT_TX_POWER_DBM = 14
+	KR920_FREQUENCY_1          = 922100000
+	KR920_FREQUENCY_2          = 922300000
+	KR920_FREQUENCY_3          = 922500000
+	KR920_RX2_FREQUENCY        = 921900000
+	KR920_MAX_EIRP_DBM         = 14
+	KR920_NUM_CHANNELS         = 16
+	KR920_NUM_DEFAULT_CHANNELS = 3
+	KR920_MIN_FREQUENCY        = 920900000
+	KR920_MAX_FREQUENCY        = 923300000
+)
+
+var dataRatesKR920 = []DataRate{
+	{lora.SpreadingFactor12, lora.Bandwidth_125_0}, // DR0
+	{lora.SpreadingFactor11, lora.Bandwidth_125_0}, // DR1
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0}, // DR2
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0},  // DR3
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0},  // DR4
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0},  // DR5
+}
+
+type ChannelKR struct {
+	channel
+}
+
+func (c *ChannelKR) Next() bool {
+	return false
+}
+
+type SettingsKR920 struct {
+	settings
+}
+
+func KR920() *SettingsKR920 {
+	return &SettingsKR920{settings: settings{
+		joinRequestChannel: &ChannelKR{channel: channel{KR920_FREQUENCY_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		joinAcceptChannel: &ChannelKR{channel: channel{KR920_FREQUENCY_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		uplinkChannel: &ChannelKR{channel: channel{KR920_FREQUENCY_1,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		rx1Channel: &ChannelKR{channel: channel{0,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor9,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelKR{channel: channel{KR920_RX2_FREQUENCY,
+			lora.Bandwidth_125_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			KR920_DEFAULT_PREAMBLE_LEN,
+			KR920_DEFAULT_TX_POWER_DBM}},
+		dataRates:          dataRatesKR920,
+		rx2Frequency:       KR920_RX2_FREQUENCY,
+		channels:           channelsKR920(),
+		channelMask:        ChannelMask{0b111},
+		defaultChannelMask: ChannelMask{0b111},
+		nextChannel:        KR920_NUM_CHANNELS - 1,
+		numDefaultChannels: KR920_NUM_DEFAULT_CHANNELS,
+		dataRate:           3,
+		maxUplinkDataRate:  5,
+		maxTxPower:         7,
+		maxEIRP:            KR920_MAX_EIRP_DBM,
+		maxRx1DROffset:     5,
+		minFrequency:       KR920_MIN_FREQUENCY,
+		maxFrequency:       KR920_MAX_FREQUENCY,
+		listenBeforeTalk:   true,
+	}}
+}
+
+// channelsKR920 returns the KR920 channel plan, with the 3 default channels
+func channelsKR920() []channelSlot {
+	c := make([]channelSlot, KR920_NUM_CHANNELS)
+	c[0] = channelSlot{KR920_FREQUENCY_1, 0, 5}
+	c[1] = channelSlot{KR920_FREQUENCY_2, 0, 5}
+	c[2] = channelSlot{KR920_FREQUENCY_3, 0, 5}
+	return c
+}
+
+// UpdateChannelMask applies a LinkADRReq ChMask to mask.
+// ChMaskCntl 0 selects channels 0-15, 6 enables all defined channels.
+func (r *SettingsKR920) UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	return r.updateChannelMask16(mask, chMaskCntl, chMask)
+}
+
+// SetChannel creates, modifies (or deletes when frequency is 0) an uplink
+// channel. The default channels can't be changed.
+func (r *SettingsKR920) SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (bool, bool) {
+	return r.setDynamicChannel(index, frequency, minDR, maxDR)
+}
+
+// ApplyCFList adds the up to 5 extra channels sent in a JoinAccept CFList
+func (r *SettingsKR920) ApplyCFList(cfList [16]uint8) {
+	r.applyCFListChannels(cfList, 5)
+}
+
+// Rx1Channel returns the channel used for the first receive window.
+// KR920 replies on the uplink frequency, with the data rate lowered by rx1DROffset
+func (r *SettingsKR920) Rx1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	return r.rx1SameFrequency(uplink, rx1DROffset, 0, 5)
+}
@@ -1,15 +1,64 @@
 package region
 
+import "time"
+
 type Settings interface {
 	JoinRequestChannel() Channel
 	JoinAcceptChannel() Channel
-	UplinkChannel() Channel
+	UplinkChannel(now time.Time) Channel
+	UplinkChannelIndex() uint8
+	Rx1Channel(uplink Channel, rx1DROffset uint8) Channel
+	Rx2Channel(rx2DataRate uint8) Channel
+
+	// MAC layer support
+	DataRate() uint8
+	SetDataRate(dr uint8) bool
+	TxPower() uint8
+	SetTxPower(index uint8) bool
+	ChannelMask() ChannelMask
+	SetChannelMask(mask ChannelMask) bool
+	EnableDefaultChannels()
+	UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool
+	SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (freqOK bool, drOK bool)
+	ApplyCFList(cfList [16]uint8)
+	SetTxParams(uplinkDwellTime, downlinkDwellTime bool, maxEIRP int8) bool
+	ValidFrequency(frequency uint32) bool
+	ValidRx1DROffset(rx1DROffset uint8) bool
+	ValidDownlinkDataRate(dr uint8) bool
+
+	// Regulatory limits
+	TxAvailableAt(frequency uint32) time.Time
+	RegisterTx(frequency uint32, end time.Time, airtime time.Duration)
+	MaxDwellTime() time.Duration
+	ListenBeforeTalk() bool
 }
 
 type settings struct {
 	joinRequestChannel Channel
 	joinAcceptChannel  Channel
 	uplinkChannel      Channel
+	rx1Channel         Channel
+	rx2Channel         Channel
+	dataRates          []DataRate
+	rx2Frequency       uint32
+	rx2DataRate        uint8
+	channels           []channelSlot // uplink channel plan
+	channelMask        ChannelMask   // enabled uplink channels
+	defaultChannelMask ChannelMask   // channels enabled at startup
+	nextChannel        int           // next uplink channel to try
+	nextJoinChannel    int           // next join request channel to try
+	numDefaultChannels int           // channels that can't be changed by the network
+	dataRate           uint8         // current uplink data rate
+	maxUplinkDataRate  uint8
+	txPower            uint8 // current TX power index
+	maxTxPower         uint8 // highest valid TX power index
+	maxEIRP            int8  // TX power (dBm) of index 0
+	maxRx1DROffset     uint8
+	minFrequency       uint32
+	maxFrequency       uint32
+	subBands           []subBand     // duty cycle limited sub-bands
+	maxDwellTime       time.Duration // longest transmission, 0 if unlimited
+	listenBeforeTalk   bool          // channel must be free before transmitting
 }
 
 func (r *settings) JoinRequestChannel() Channel {
@@ -20,6 +69,294 @@ func (r *settings) JoinAcceptChannel() Channel {
 	return r.joinAcceptChannel
 }
 
-func (r *settings) UplinkChannel() Channel {
+// UplinkChannel returns the channel for next uplink, hopping over the
+// enabled channels that support the current data rate.
+// Channels whose sub-band is off due to duty cycle at now are skipped, unless
+// all are off: the channel available first is then returned.
+func (r *settings) UplinkChannel(now time.Time) Channel {
+	first := -1
+	var firstAt time.Time
+	for i := 0; i < len(r.channels); i++ {
+		n := (r.nextChannel + 1 + i) % len(r.channels)
+		c := &r.channels[n]
+		if c.frequency == 0 || !r.channelMask.Enabled(n) || r.dataRate < c.minDR || r.dataRate > c.maxDR {
+			continue
+		}
+		at := r.TxAvailableAt(c.frequency)
+		if !at.After(now) {
+			first = n
+			break
+		}
+		if first < 0 || at.Before(firstAt) {
+			first, firstAt = n, at
+		}
+	}
+	if first >= 0 {
+		r.nextChannel = first
+		r.uplinkChannel.SetFrequency(r.channels[first].frequency)
+	}
 	return r.uplinkChannel
 }
+
+// UplinkChannelIndex returns the index of the last uplink channel in the
+// region channel plan
+func (r *settings) UplinkChannelIndex() uint8 {
+	return uint8(r.nextChannel)
+}
+
+// Rx2Channel returns the channel used for the second receive window
+func (r *settings) Rx2Channel(rx2DataRate uint8) Channel {
+	r.rx2Channel.SetFrequency(r.rx2Frequency)
+	if !r.setDataRate(r.rx2Channel, rx2DataRate) {
+		r.setDataRate(r.rx2Channel, r.rx2DataRate)
+	}
+	return r.rx2Channel
+}
+
+// setDataRate applies the modulation of a data rate index to a channel
+func (r *settings) setDataRate(c Channel, dr uint8) bool {
+	if int(dr) >= len(r.dataRates) || r.dataRates[dr].SpreadingFactor == 0 {
+		return false
+	}
+	c.SetSpreadingFactor(r.dataRates[dr].SpreadingFactor)
+	c.SetBandwidth(r.dataRates[dr].Bandwidth)
+	return true
+}
+
+// DataRate returns the current uplink data rate index
+func (r *settings) DataRate() uint8 {
+	return r.dataRate
+}
+
+// SetDataRate changes the uplink data rate, returns false if not supported
+// by any enabled channel
+func (r *settings) SetDataRate(dr uint8) bool {
+	if dr > r.maxUplinkDataRate || !r.dataRateAvailable(dr) || !r.setDataRate(r.uplinkChannel, dr) {
+		return false
+	}
+	r.dataRate = dr
+	return true
+}
+
+// dataRateAvailable checks an enabled channel supports the data rate
+func (r *settings) dataRateAvailable(dr uint8) bool {
+	for i, c := range r.channels {
+		if c.frequency != 0 && r.channelMask.Enabled(i) && dr >= c.minDR && dr <= c.maxDR {
+			return true
+		}
+	}
+	return false
+}
+
+// TxPower returns the current TX power index
+func (r *settings) TxPower() uint8 {
+	return r.txPower
+}
+
+// SetTxPower changes the TX power index (0 is max EIRP, each step is -2 dB)
+func (r *settings) SetTxPower(index uint8) bool {
+	if index > r.maxTxPower {
+		return false
+	}
+	r.txPower = index
+	r.uplinkChannel.SetTxPowerDBm(r.maxEIRP - 2*int8(index))
+	return true
+}
+
+// ChannelMask returns the enabled uplink channels
+func (r *settings) ChannelMask() ChannelMask {
+	return r.channelMask
+}
+
+// SetChannelMask changes the enabled uplink channels.
+// It fails if a channel is not defined or no channel would remain enabled.
+func (r *settings) SetChannelMask(mask ChannelMask) bool {
+	enabled := 0
+	for i := 0; i < len(mask)*16; i++ {
+		if !mask.Enabled(i) {
+			continue
+		}
+		if i >= len(r.channels) || r.channels[i].frequency == 0 {
+			return false
+		}
+		enabled++
+	}
+	if enabled == 0 {
+		return false
+	}
+	r.channelMask = mask
+	return true
+}
+
+// EnableDefaultChannels enables again the default uplink channels
+func (r *settings) EnableDefaultChannels() {
+	for i := range r.channelMask {
+		r.channelMask[i] |= r.defaultChannelMask[i]
+	}
+}
+
+// SetChannel is not supported by regions with a fixed channel plan
+func (r *settings) SetChannel(index uint8, frequency uint32, minDR, maxDR uint8) (bool, bool) {
+	return false, false
+}
+
+// SetTxParams is only supported by regions implementing TxParamSetupReq
+func (r *settings) SetTxParams(uplinkDwellTime, downlinkDwellTime bool, maxEIRP int8) bool {
+	return false
+}
+
+// ValidFrequency checks a frequency is within the region band
+func (r *settings) ValidFrequency(frequency uint32) bool {
+	return frequency >= r.minFrequency && frequency <= r.maxFrequency
+}
+
+// ValidRx1DROffset checks the RX1 data rate offset is supported by the region
+func (r *settings) ValidRx1DROffset(rx1DROffset uint8) bool {
+	return rx1DROffset <= r.maxRx1DROffset
+}
+
+// ValidDownlinkDataRate checks a data rate can be used for RX2
+func (r *settings) ValidDownlinkDataRate(dr uint8) bool {
+	return int(dr) < len(r.dataRates) && r.dataRates[dr].SpreadingFactor != 0
+}
+
+// updateChannelMask500 interprets ChMaskCntl for regions made of 64 125 kHz
+// channels followed by 8 500 kHz channels (US915, AU915)
+func updateChannelMask500(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	switch chMaskCntl {
+	case 0, 1, 2, 3:
+		mask[chMaskCntl] = chMask
+	case 4:
+		mask[4] = chMask & 0xFF
+	case 5:
+		// Each bit of ChMask LSB enables a bank of 8 125 kHz channels plus
+		// the matching 500 kHz channel
+		for b := 0; b < 8; b++ {
+			on := chMask&(1<<b) != 0
+			for i := 0; i < 8; i++ {
+				mask.Set(b*8+i, on)
+			}
+			mask.Set(64+b, on)
+		}
+	case 6:
+		mask[0], mask[1], mask[2], mask[3] = 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF
+		mask[4] = chMask & 0xFF
+	case 7:
+		mask[0], mask[1], mask[2], mask[3] = 0, 0, 0, 0
+		mask[4] = chMask & 0xFF
+	default:
+		return false
+	}
+	return true
+}
+
+// Sub-bands of 8 125 kHz channels and one 500 kHz channel, used to select
+// the channels of US915 and AU915 gateways
+const (
+	SUB_BAND_1   = 1 << iota // channels 0-7 and 64
+	SUB_BAND_2               // channels 8-15 and 65
+	SUB_BAND_3               // channels 16-23 and 66
+	SUB_BAND_4               // channels 24-31 and 67
+	SUB_BAND_5               // channels 32-39 and 68
+	SUB_BAND_6               // channels 40-47 and 69
+	SUB_BAND_7               // channels 48-55 and 70
+	SUB_BAND_8               // channels 56-63 and 71
+	SUB_BAND_ALL = 0xFF
+)
+
+// setSubBands500 enables only the channels of the given sub-bands, and makes
+// them the default channels
+func (r *settings) setSubBands500(subBands uint8) bool {
+	var mask ChannelMask
+	updateChannelMask500(&mask, 5, uint16(subBands))
+	if !r.SetChannelMask(mask) {
+		return false
+	}
+	r.defaultChannelMask = mask
+	return true
+}
+
+// hopJoinChannel moves the join request channel to the next enabled channel
+// among the first count channels of the channel plan
+func (r *settings) hopJoinChannel(count int) {
+	for i := 0; i < count; i++ {
+		r.nextJoinChannel = (r.nextJoinChannel + 1) % count
+		if r.channelMask.Enabled(r.nextJoinChannel) && r.channels[r.nextJoinChannel].frequency != 0 {
+			r.joinRequestChannel.SetFrequency(r.channels[r.nextJoinChannel].frequency)
+			return
+		}
+	}
+}
+
+// updateChannelMask16 interprets ChMaskCntl for regions with up to 16
+// channels: 0 selects channels 0-15, 6 enables all defined channels
+func (r *settings) updateChannelMask16(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	switch chMaskCntl {
+	case 0:
+		mask[0] = chMask
+	case 6:
+		mask[0] = 0
+		for i, c := range r.channels {
+			if c.frequency != 0 {
+				mask.Set(i, true)
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// setDynamicChannel creates, modifies (or deletes when frequency is 0) an
+// uplink channel of a dynamic channel plan. Default channels can't be changed.
+func (r *settings) setDynamicChannel(index uint8, frequency uint32, minDR, maxDR uint8) (bool, bool) {
+	if int(index) < r.numDefaultChannels || int(index) >= len(r.channels) {
+		return false, false
+	}
+	freqOK := frequency == 0 || r.ValidFrequency(frequency)
+	drOK := minDR <= maxDR && maxDR <= r.maxUplinkDataRate
+	if !freqOK || !drOK {
+		return freqOK, drOK
+	}
+	r.channels[index] = channelSlot{frequency, minDR, maxDR}
+	r.channelMask.Set(int(index), frequency != 0)
+	return true, true
+}
+
+// applyCFListChannels adds the up to 5 extra channels of a CFList of type 0,
+// following the default channels
+func (r *settings) applyCFListChannels(cfList [16]uint8, maxDR uint8) {
+	if cfList[15] != 0 {
+		return
+	}
+	for i := 0; i < 5; i++ {
+		freq := (uint32(cfList[3*i]) | uint32(cfList[3*i+1])<<8 | uint32(cfList[3*i+2])<<16) * 100
+		if freq != 0 {
+			r.setDynamicChannel(uint8(r.numDefaultChannels+i), freq, 0, maxDR)
+		}
+	}
+}
+
+// rx1SameFrequency sets the RX1 channel for regions replying on the uplink
+// frequency, with the data rate given by rx1DataRate
+func (r *settings) rx1SameFrequency(uplink Channel, rx1DROffset uint8, minDR, maxDR uint8) Channel {
+	r.rx1Channel.SetFrequency(uplink.Frequency())
+	r.rx1Channel.SetSpreadingFactor(uplink.SpreadingFactor())
+	r.rx1Channel.SetBandwidth(uplink.Bandwidth())
+	if dr, ok := dataRateIndex(r.dataRates, uplink.SpreadingFactor(), uplink.Bandwidth()); ok {
+		r.setDataRate(r.rx1Channel, rx1DataRate(dr, rx1DROffset, minDR, maxDR))
+	}
+	return r.rx1Channel
+}
+
+// applyCFListMask applies a CFList of type 1 (channel mask)
+func (r *settings) applyCFListMask(cfList [16]uint8) {
+	if cfList[15] != 1 {
+		return
+	}
+	var mask ChannelMask
+	for i := range mask {
+		mask[i] = uint16(cfList[2*i]) | uint16(cfList[2*i+1])<<8
+	}
+	r.SetChannelMask(mask)
+}
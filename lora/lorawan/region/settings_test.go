@@ -0,0 +1,136 @@
+package region
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/lora"
+)
+
+func TestAS923Groups(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		group uint8
+		freq  uint32
+		rx2   uint32
+	}{
+		{AS923_GROUP_1, 923200000, 923200000},
+		{AS923_GROUP_2, 921400000, 921400000},
+		{AS923_GROUP_3, 916600000, 916600000},
+		{AS923_GROUP_4, 917300000, 917300000},
+	}
+	for _, tc := range tests {
+		r := AS923(tc.group)
+		c.Assert(r.JoinRequestChannel().Frequency(), qt.Equals, tc.freq)
+		c.Assert(r.UplinkChannel(time.Now()).Frequency(), qt.Equals, tc.freq)
+		c.Assert(r.UplinkChannel(time.Now()).Frequency(), qt.Equals, tc.freq+200000)
+		rx2 := r.Rx2Channel(2)
+		c.Assert(rx2.Frequency(), qt.Equals, tc.rx2)
+		c.Assert(rx2.SpreadingFactor(), qt.Equals, uint8(lora.SpreadingFactor10))
+	}
+}
+
+func TestAS923TxParams(t *testing.T) {
+	c := qt.New(t)
+
+	r := AS923(AS923_GROUP_1)
+	c.Assert(r.MaxDwellTime(), qt.Equals, 400*time.Millisecond)
+
+	// Downlink dwell time limits RX1 to DR2
+	r.SetDataRate(2)
+	rx1 := r.Rx1Channel(r.UplinkChannel(time.Now()), 2)
+	c.Assert(rx1.SpreadingFactor(), qt.Equals, uint8(lora.SpreadingFactor10))
+
+	c.Assert(r.SetTxParams(false, false, 14), qt.IsTrue)
+	c.Assert(r.MaxDwellTime(), qt.Equals, time.Duration(0))
+	rx1 = r.Rx1Channel(r.UplinkChannel(time.Now()), 2)
+	c.Assert(rx1.SpreadingFactor(), qt.Equals, uint8(lora.SpreadingFactor12))
+	c.Assert(r.UplinkChannel(time.Now()).TxPowerDBm(), qt.Equals, int8(14))
+
+	c.Assert(EU868().SetTxParams(false, false, 14), qt.IsFalse)
+}
+
+func TestRx1DataRateOffset(t *testing.T) {
+	c := qt.New(t)
+
+	r := IN865()
+	r.SetDataRate(3)
+	// Offsets 6 and 7 raise the data rate, up to DR5
+	c.Assert(r.Rx1Channel(r.UplinkChannel(time.Now()), 6).SpreadingFactor(), qt.Equals, uint8(lora.SpreadingFactor8))
+	c.Assert(r.Rx1Channel(r.UplinkChannel(time.Now()), 7).SpreadingFactor(), qt.Equals, uint8(lora.SpreadingFactor7))
+	r.SetDataRate(5)
+	c.Assert(r.Rx1Channel(r.UplinkChannel(time.Now()), 7).SpreadingFactor(), qt.Equals, uint8(lora.SpreadingFactor7))
+	c.Assert(r.Rx2Channel(2).Frequency(), qt.Equals, uint32(IN865_RX2_FREQUENCY))
+
+	k := KR920()
+	c.Assert(k.ValidRx1DROffset(6), qt.IsFalse)
+	c.Assert(k.Rx2Channel(0).Frequency(), qt.Equals, uint32(KR920_RX2_FREQUENCY))
+}
+
+func TestDynamicChannelPlan(t *testing.T) {
+	c := qt.New(t)
+
+	r := KR920()
+	// CFList with 922.7 and 922.9 MHz
+	r.ApplyCFList([16]uint8{0xF8, 0xCA, 0x8C, 0xC8, 0xD2, 0x8C})
+	mask := r.ChannelMask()
+	c.Assert(mask[0], qt.Equals, uint16(0b11111))
+	r.UplinkChannel(time.Now())
+	r.UplinkChannel(time.Now())
+	r.UplinkChannel(time.Now())
+	c.Assert(r.UplinkChannel(time.Now()).Frequency(), qt.Equals, uint32(922700000))
+
+	freqOK, drOK := r.SetChannel(1, 922700000, 0, 5)
+	c.Assert(freqOK || drOK, qt.IsFalse)
+	freqOK, drOK = r.SetChannel(5, 930000000, 0, 5)
+	c.Assert(freqOK, qt.IsFalse)
+	c.Assert(drOK, qt.IsTrue)
+}
+
+func TestUS915SubBands(t *testing.T) {
+	c := qt.New(t)
+
+	r := US915()
+	c.Assert(r.SetSubBands(SUB_BAND_2), qt.IsTrue)
+	mask := r.ChannelMask()
+	c.Assert(mask, qt.Equals, ChannelMask{0xFF00, 0, 0, 0, 0x0002})
+
+	// Join requests and uplinks use sub-band channels only
+	c.Assert(r.JoinRequestChannel().Frequency(), qt.Equals, uint32(903900000))
+	c.Assert(r.JoinRequestChannel().Frequency(), qt.Equals, uint32(904100000))
+	c.Assert(r.UplinkChannel(time.Now()).Frequency(), qt.Equals, uint32(904600000))
+	r.SetDataRate(0)
+	c.Assert(r.UplinkChannel(time.Now()).Frequency(), qt.Equals, uint32(903900000))
+
+	// ADR back-off only enables the selected sub-band
+	r.SetChannelMask(ChannelMask{0x0100})
+	r.EnableDefaultChannels()
+	c.Assert(r.ChannelMask(), qt.Equals, ChannelMask{0xFF00, 0, 0, 0, 0x0002})
+
+	a := AU915()
+	c.Assert(a.SetSubBands(SUB_BAND_1|SUB_BAND_8), qt.IsTrue)
+	c.Assert(a.ChannelMask(), qt.Equals, ChannelMask{0x00FF, 0, 0, 0xFF00, 0x0081})
+	c.Assert(a.SetSubBands(0), qt.IsFalse)
+}
+
+func TestCN470(t *testing.T) {
+	c := qt.New(t)
+
+	r := CN470()
+	c.Assert(r.JoinRequestChannel().Frequency(), qt.Equals, uint32(470300000))
+	c.Assert(r.JoinAcceptChannel().Frequency(), qt.Equals, uint32(500300000))
+
+	// Channel 50 replies on downlink channel 2
+	c.Assert(r.SetChannelMask(ChannelMask{0, 0, 0, 0x0004}), qt.IsTrue)
+	up := r.UplinkChannel(time.Now())
+	c.Assert(up.Frequency(), qt.Equals, uint32(480300000))
+	c.Assert(r.Rx1Channel(up, 0).Frequency(), qt.Equals, uint32(500700000))
+
+	// CFList of type 1 carries 96 channel mask bits
+	var cfList [16]uint8
+	cfList[10], cfList[11], cfList[15] = 0xFF, 0xFF, 1
+	r.ApplyCFList(cfList)
+	c.Assert(r.ChannelMask(), qt.Equals, ChannelMask{0, 0, 0, 0, 0, 0xFFFF})
+}
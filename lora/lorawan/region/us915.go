@@ -1,14 +1,42 @@
 package region
 
-import "tinygo.org/x/drivers/lora"
+import (
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+)
 
 const (
 	US915_DEFAULT_PREAMBLE_LEN     = 8
 	US915_DEFAULT_TX_POWER_DBM     = 20
 	US915_FREQUENCY_INCREMENT_DR_0 = 200000  // only for 125 kHz Bandwidth
 	US915_FREQUENCY_INCREMENT_DR_4 = 1600000 // only for 500 kHz Bandwidth
+	US915_DOWNLINK_FREQUENCY_BASE  = lora.MHz_923_3
+	US915_DOWNLINK_FREQUENCY_STEP  = 600000
+	US915_RX2_FREQUENCY            = lora.MHz_923_3
+	US915_MAX_EIRP_DBM             = 30
+	US915_MIN_FREQUENCY            = 902000000
+	US915_MAX_FREQUENCY            = 928000000
+	US915_MAX_DWELL_TIME           = 400 * time.Millisecond // FCC limit per channel
 )
 
+var dataRatesUS915 = []DataRate{
+	{lora.SpreadingFactor10, lora.Bandwidth_125_0}, // DR0
+	{lora.SpreadingFactor9, lora.Bandwidth_125_0},  // DR1
+	{lora.SpreadingFactor8, lora.Bandwidth_125_0},  // DR2
+	{lora.SpreadingFactor7, lora.Bandwidth_125_0},  // DR3
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0},  // DR4
+	{}, // DR5 (RFU)
+	{}, // DR6 (RFU)
+	{}, // DR7 (RFU)
+	{lora.SpreadingFactor12, lora.Bandwidth_500_0}, // DR8
+	{lora.SpreadingFactor11, lora.Bandwidth_500_0}, // DR9
+	{lora.SpreadingFactor10, lora.Bandwidth_500_0}, // DR10
+	{lora.SpreadingFactor9, lora.Bandwidth_500_0},  // DR11
+	{lora.SpreadingFactor8, lora.Bandwidth_500_0},  // DR12
+	{lora.SpreadingFactor7, lora.Bandwidth_500_0},  // DR13
+}
+
 type ChannelUS struct {
 	channel
 }
@@ -73,10 +101,94 @@ func US915() *SettingsUS915 {
 			US915_DEFAULT_PREAMBLE_LEN,
 			US915_DEFAULT_TX_POWER_DBM}},
 		uplinkChannel: &ChannelUS{channel: channel{lora.Mhz_903_0,
+			lora.Bandwidth_500_0,
+			lora.SpreadingFactor8,
+			lora.CodingRate4_5,
+			US915_DEFAULT_PREAMBLE_LEN,
+			US915_DEFAULT_TX_POWER_DBM}},
+		rx1Channel: &ChannelUS{channel: channel{0,
 			lora.Bandwidth_500_0,
 			lora.SpreadingFactor9,
 			lora.CodingRate4_5,
 			US915_DEFAULT_PREAMBLE_LEN,
 			US915_DEFAULT_TX_POWER_DBM}},
+		rx2Channel: &ChannelUS{channel: channel{US915_RX2_FREQUENCY,
+			lora.Bandwidth_500_0,
+			lora.SpreadingFactor12,
+			lora.CodingRate4_5,
+			US915_DEFAULT_PREAMBLE_LEN,
+			US915_DEFAULT_TX_POWER_DBM}},
+		dataRates:          dataRatesUS915,
+		rx2Frequency:       US915_RX2_FREQUENCY,
+		rx2DataRate:        8,
+		channels:           channelsUS915(),
+		channelMask:        ChannelMask{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0x00FF},
+		defaultChannelMask: ChannelMask{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0x00FF},
+		nextChannel:        63,
+		nextJoinChannel:    63,
+		dataRate:           4,
+		maxUplinkDataRate:  4,
+		maxTxPower:         14,
+		maxEIRP:            US915_MAX_EIRP_DBM,
+		maxRx1DROffset:     3,
+		minFrequency:       US915_MIN_FREQUENCY,
+		maxFrequency:       US915_MAX_FREQUENCY,
+		maxDwellTime:       US915_MAX_DWELL_TIME,
 	}}
 }
+
+// channelsUS915 returns the 64 125 kHz and 8 500 kHz US915 uplink channels
+func channelsUS915() []channelSlot {
+	c := make([]channelSlot, 72)
+	for i := 0; i < 64; i++ {
+		c[i] = channelSlot{lora.MHz_902_3 + uint32(i)*US915_FREQUENCY_INCREMENT_DR_0, 0, 3}
+	}
+	for i := 0; i < 8; i++ {
+		c[64+i] = channelSlot{lora.Mhz_903_0 + uint32(i)*US915_FREQUENCY_INCREMENT_DR_4, 4, 4}
+	}
+	return c
+}
+
+// SetSubBands restricts uplinks to the channels of the given sub-bands
+// (SUB_BAND_1 to SUB_BAND_8), matching the channels of the network gateways.
+// These channels are also used for join requests, and enabled again by ADR
+// when the network stays silent.
+func (r *SettingsUS915) SetSubBands(subBands uint8) bool {
+	return r.setSubBands500(subBands)
+}
+
+// JoinRequestChannel returns the channel for next join request, hopping
+// over the enabled 125 kHz channels
+func (r *SettingsUS915) JoinRequestChannel() Channel {
+	r.hopJoinChannel(64)
+	return r.joinRequestChannel
+}
+
+// UpdateChannelMask applies a LinkADRReq ChMask to mask
+func (r *SettingsUS915) UpdateChannelMask(mask *ChannelMask, chMaskCntl uint8, chMask uint16) bool {
+	return updateChannelMask500(mask, chMaskCntl, chMask)
+}
+
+// ApplyCFList applies the channel mask sent in a JoinAccept CFList
+func (r *SettingsUS915) ApplyCFList(cfList [16]uint8) {
+	r.applyCFListMask(cfList)
+}
+
+// Rx1Channel returns the channel used for the first receive window.
+// US915 replies on one of the 8 500 kHz downlink channels, picked from the
+// uplink channel number
+func (r *SettingsUS915) Rx1Channel(uplink Channel, rx1DROffset uint8) Channel {
+	var ch uint32
+	if uplink.Bandwidth() == lora.Bandwidth_500_0 {
+		ch = 64 + (uplink.Frequency()-lora.Mhz_903_0)/US915_FREQUENCY_INCREMENT_DR_4
+	} else {
+		ch = (uplink.Frequency() - lora.MHz_902_3) / US915_FREQUENCY_INCREMENT_DR_0
+	}
+	r.rx1Channel.SetFrequency(US915_DOWNLINK_FREQUENCY_BASE + (ch%8)*US915_DOWNLINK_FREQUENCY_STEP)
+	r.rx1Channel.SetSpreadingFactor(uplink.SpreadingFactor())
+	r.rx1Channel.SetBandwidth(lora.Bandwidth_500_0)
+	if dr, ok := dataRateIndex(r.dataRates, uplink.SpreadingFactor(), uplink.Bandwidth()); ok {
+		r.setDataRate(r.rx1Channel, rx1DataRate500(10, dr, rx1DROffset))
+	}
+	return r.rx1Channel
+}
@@ -1,10 +1,13 @@
 package lorawan
 
 import (
+	"bytes"
 	"crypto/aes"
 	"encoding/binary"
 	"encoding/hex"
 	"math"
+
+	"tinygo.org/x/drivers/lora/lorawan/region"
 )
 
 // Session is used to store session data of a LoRaWAN session
@@ -17,6 +20,67 @@ type Session struct {
 	CFList     [16]uint8
 	RXDelay    uint8
 	DLSettings uint8
+
+	// LoRaWAN 1.1 session. Before 1.1, NwkSKey is used for all network keys
+	// and FCntDown counts all downlinks.
+	Version     uint8     // LORAWAN_1_1 when negotiated with the network
+	FNwkSIntKey [16]uint8 // uplink MIC key
+	SNwkSIntKey [16]uint8 // uplink and downlink MIC key
+	NwkSEncKey  [16]uint8 // MAC commands encryption key
+	AFCntDown   uint32    // application downlinks counter, FCntDown counts MAC only downlinks
+
+	// MAC layer state, updated by network MAC commands
+	RX2Frequency uint32 // RX2 frequency set by RXParamSetupReq, 0 for region default
+	MaxDCycle    uint8  // aggregated duty cycle limit is 1/2^MaxDCycle
+	NbTrans      uint8  // number of transmissions of each uplink, 0 means 1
+	LinkMargin   uint8  // demodulation margin (dB) reported by LinkCheckAns
+	LinkGwCnt    uint8  // number of gateways reported by LinkCheckAns
+
+	// Adaptive Data Rate
+	ADR       bool   // let the network control data rate and TX power
+	ADRAckCnt uint32 // uplinks sent since last downlink
+
+	adrAckReq        bool    // ask the network to answer, to check ADR link is alive
+	ackPending       bool    // a confirmed downlink must be acknowledged by next uplink
+	ackFCnt          uint32  // frame counter of the confirmed downlink to acknowledge
+	rekeyPending     bool    // RekeyInd is sent until RekeyConf is received
+	uplinkFCnt       uint32  // frame counter of the last uplink
+	uplinkConfFCnt   uint16  // ConfFCnt of the last uplink MIC
+	macAnswers       []uint8 // MAC commands to send with next uplink
+	macStickyAnswers []uint8 // MAC commands to repeat until a downlink is received
+
+	// Region state saved by MarshalBinary, applied by RestoreSession
+	regionSaved bool
+	dataRate    uint8
+	txPower     uint8
+	channelMask region.ChannelMask
+}
+
+const (
+	mTypeUnconfirmedDataUp   = 0x40
+	mTypeUnconfirmedDataDown = 0x60
+	mTypeConfirmedDataUp     = 0x80
+	mTypeConfirmedDataDown   = 0xA0
+	mTypeMask                = 0xE0
+
+	fCtrlADR         = 0x80
+	fCtrlADRACKReq   = 0x40
+	fCtrlACK         = 0x20
+	fCtrlFOptsLenMsk = 0x0F
+
+	// MaxFCntGap is the largest accepted jump of the downlink frame counter
+	MaxFCntGap = 16384
+)
+
+// downlink holds the decoded content of a data downlink message
+type downlink struct {
+	confirmed bool
+	fCtrl     uint8
+	fCnt      uint32
+	fOpts     []uint8
+	fPort     uint8
+	hasFPort  bool
+	payload   []uint8
 }
 
 // SetDevAddr configures the Session DevAddr
@@ -67,21 +131,80 @@ func (s *Session) GetAppSKey() string {
 	return hex.EncodeToString(s.AppSKey[:])
 }
 
+// reset clears counters and MAC state of a new session
+func (s *Session) reset() {
+	s.FCntDown = 0
+	s.AFCntDown = 0
+	s.FCntUp = 0
+	s.RX2Frequency = 0
+	s.MaxDCycle = 0
+	s.NbTrans = 0
+	s.LinkMargin = 0
+	s.LinkGwCnt = 0
+	s.ADRAckCnt = 0
+	s.adrAckReq = false
+	s.ackPending = false
+	s.rekeyPending = false
+	s.macAnswers = s.macAnswers[:0]
+	s.macStickyAnswers = s.macStickyAnswers[:0]
+	s.regionSaved = false
+}
+
+// saveRegion keeps the data rate, TX power and channel mask of rs
+func (s *Session) saveRegion(rs region.Settings) {
+	s.regionSaved = true
+	s.dataRate = rs.DataRate()
+	s.txPower = rs.TxPower()
+	s.channelMask = rs.ChannelMask()
+}
+
 // GenMessage generates an uplink message.
 func (s *Session) GenMessage(dir uint8, payload []uint8) ([]uint8, error) {
+	return s.genMessage(mTypeUnconfirmedDataUp, dir, payload)
+}
+
+// GenConfirmedMessage generates an uplink message that must be acknowledged
+// by the network.
+func (s *Session) GenConfirmedMessage(payload []uint8) ([]uint8, error) {
+	return s.genMessage(mTypeConfirmedDataUp, 0, payload)
+}
+
+func (s *Session) genMessage(mType uint8, dir uint8, payload []uint8) ([]uint8, error) {
 	var buf []uint8
-	buf = append(buf, 0b01000000) // FHDR Unconfirmed up
+	buf = append(buf, mType) // MHDR
 	buf = append(buf, s.DevAddr[:]...)
 
-	// FCtl : No ADR, No RFU, No ACK, No FPending, No FOpt
-	buf = append(buf, 0x00)
+	// FOpts are the pending MAC answers
+	var fOpts []uint8
+	fOpts = append(fOpts, s.macStickyAnswers...)
+	fOpts = append(fOpts, s.macAnswers...)
+	if s.rekeyPending && len(fOpts)+2 <= maxFOptsLen {
+		fOpts = append(fOpts, MAC_REKEY, LORAWAN_1_1_MINOR)
+	}
+	s.macAnswers = s.macAnswers[:0]
+
+	// FCtl : No RFU, No FPending
+	// ADR and ADRACKReq are set by the ADR algorithm
+	// ACK is set when answering a confirmed downlink
+	// FOptsLen is the size of pending MAC answers
+	fCtrl := uint8(len(fOpts))
+	if s.ADR {
+		fCtrl |= fCtrlADR
+		if s.adrAckReq {
+			fCtrl |= fCtrlADRACKReq
+		}
+	}
+	s.uplinkConfFCnt = 0
+	if s.ackPending {
+		fCtrl |= fCtrlACK
+		s.ackPending = false
+		s.uplinkConfFCnt = uint16(s.ackFCnt)
+	}
+	buf = append(buf, fCtrl)
 
 	// FCnt Up
 	buf = append(buf, uint8(s.FCntUp&0xFF), uint8((s.FCntUp>>8)&0xFF))
 
-	// FPort=1
-	buf = append(buf, 0x01)
-
 	fCnt := uint32(0)
 	if dir == 0 {
 		fCnt = s.FCntUp
@@ -89,19 +212,156 @@ func (s *Session) GenMessage(dir uint8, payload []uint8) ([]uint8, error) {
 	} else {
 		fCnt = s.FCntDown
 	}
-	data, err := s.genFRMPayload(dir, fCnt, payload, false)
+	s.uplinkFCnt = fCnt
+
+	// FOpts are encrypted with LoRaWAN 1.1
+	if s.Version == LORAWAN_1_1 && len(fOpts) > 0 {
+		var err error
+		fOpts, err = s.genFRMPayload(s.NwkSEncKey, dir, fCnt, fOpts, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf = append(buf, fOpts...)
+
+	// FPort=1
+	buf = append(buf, 0x01)
+
+	data, err := s.genFRMPayload(s.AppSKey, dir, fCnt, payload, false)
 	if err != nil {
 		return nil, err
 	}
 	buf = append(buf, data[:]...)
 
-	mic := calcMessageMIC(buf, s.NwkSKey, dir, s.DevAddr[:], fCnt, uint8(len(buf)))
-	buf = append(buf, mic[:]...)
+	buf = append(buf, 0, 0, 0, 0)
+	s.signMessage(buf, dir, 0, 0)
 
 	return buf, nil
 }
 
-func (s *Session) genFRMPayload(dir uint8, fCnt uint32, payload []byte, isFOpts bool) ([]byte, error) {
+// signMessage sets the MIC of the last message built by genMessage.
+// With LoRaWAN 1.1, the MIC of uplinks depends on the data rate and channel
+// index used to transmit them.
+func (s *Session) signMessage(buf []uint8, dir uint8, txDr uint8, txCh uint8) {
+	msg := buf[:len(buf)-4]
+	var mic [4]uint8
+	if s.Version == LORAWAN_1_1 && dir == 0 {
+		mic = calcUplinkMIC11(msg, s.FNwkSIntKey, s.SNwkSIntKey, s.uplinkConfFCnt, txDr, txCh, s.DevAddr[:], s.uplinkFCnt)
+	} else {
+		mic = calcMessageMIC(msg, s.NwkSKey, dir, s.DevAddr[:], s.uplinkFCnt, uint8(len(msg)))
+	}
+	copy(buf[len(msg):], mic[:])
+}
+
+// decodeDownlink checks and decrypts a data downlink PHYPayload
+func (s *Session) decodeDownlink(phyPload []uint8) (*downlink, error) {
+	// MHDR(1) + DevAddr(4) + FCtrl(1) + FCnt(2) + MIC(4)
+	if len(phyPload) < 12 {
+		return nil, ErrInvalidPacketLength
+	}
+
+	dl := &downlink{}
+	switch phyPload[0] & mTypeMask {
+	case mTypeUnconfirmedDataDown:
+	case mTypeConfirmedDataDown:
+		dl.confirmed = true
+	default:
+		return nil, ErrInvalidMessageType
+	}
+
+	if !bytes.Equal(phyPload[1:5], s.DevAddr[:]) {
+		return nil, ErrInvalidDevAddr
+	}
+
+	dl.fCtrl = phyPload[5]
+	fOptsLen := int(dl.fCtrl & fCtrlFOptsLenMsk)
+	if len(phyPload) < 12+fOptsLen {
+		return nil, ErrInvalidPacketLength
+	}
+	msgLen := len(phyPload) - 4
+	if msgLen > 8+fOptsLen {
+		dl.hasFPort = true
+		dl.fPort = phyPload[8+fOptsLen]
+	}
+
+	// LoRaWAN 1.1 counts application downlinks separately
+	fCntDown := &s.FCntDown
+	if s.Version == LORAWAN_1_1 && dl.hasFPort && dl.fPort != 0 {
+		fCntDown = &s.AFCntDown
+	}
+
+	// Rebuild the 32 bits frame counter from the 16 LSB sent over the air
+	fCnt16 := uint32(phyPload[6]) | uint32(phyPload[7])<<8
+	dl.fCnt = (*fCntDown &^ 0xFFFF) | fCnt16
+	if dl.fCnt < *fCntDown {
+		dl.fCnt += 0x10000
+	}
+	if dl.fCnt-*fCntDown >= MaxFCntGap {
+		return nil, ErrInvalidFCntDown
+	}
+
+	var mic [4]uint8
+	if s.Version == LORAWAN_1_1 {
+		// ConfFCnt is the counter of the acknowledged confirmed uplink
+		confFCnt := uint16(0)
+		if dl.fCtrl&fCtrlACK != 0 {
+			confFCnt = uint16(s.uplinkFCnt)
+		}
+		mic = calcDownlinkMIC11(phyPload[:msgLen], s.SNwkSIntKey, confFCnt, s.DevAddr[:], dl.fCnt)
+	} else {
+		mic = calcMessageMIC(phyPload[:msgLen], s.NwkSKey, 1, s.DevAddr[:], dl.fCnt, uint8(msgLen))
+	}
+	if !bytes.Equal(mic[:], phyPload[msgLen:]) {
+		return nil, ErrInvalidMic
+	}
+
+	dl.fOpts = phyPload[8 : 8+fOptsLen]
+	if s.Version == LORAWAN_1_1 && fOptsLen > 0 {
+		fOpts, err := s.genFRMPayload(s.NwkSEncKey, 1, dl.fCnt, dl.fOpts, true)
+		if err != nil {
+			return nil, err
+		}
+		dl.fOpts = fOpts
+	}
+	if dl.hasFPort {
+		if dl.fPort == 0 && fOptsLen > 0 {
+			// MAC commands can't be both in FOpts and FRMPayload
+			return nil, ErrInvalidPacketLength
+		}
+		key := s.AppSKey
+		if dl.fPort == 0 {
+			key = s.nwkSEncKey()
+		}
+		payload, err := s.genFRMPayload(key, 1, dl.fCnt, phyPload[9+fOptsLen:msgLen], false)
+		if err != nil {
+			return nil, err
+		}
+		dl.payload = payload
+	}
+
+	// Message is valid, update counters
+	// Sticky MAC answers are acknowledged by any downlink
+	*fCntDown = dl.fCnt + 1
+	s.ADRAckCnt = 0
+	s.adrAckReq = false
+	s.macStickyAnswers = s.macStickyAnswers[:0]
+	if dl.confirmed {
+		s.ackPending = true
+		s.ackFCnt = dl.fCnt
+	}
+
+	return dl, nil
+}
+
+// nwkSEncKey returns the key encrypting MAC commands sent in FRMPayload
+func (s *Session) nwkSEncKey() [16]uint8 {
+	if s.Version == LORAWAN_1_1 {
+		return s.NwkSEncKey
+	}
+	return s.NwkSKey
+}
+
+func (s *Session) genFRMPayload(key [16]uint8, dir uint8, fCnt uint32, payload []byte, isFOpts bool) ([]byte, error) {
 	k := len(payload) / aes.BlockSize
 	if len(payload)%aes.BlockSize != 0 {
 		k++
@@ -110,7 +370,7 @@ func (s *Session) genFRMPayload(dir uint8, fCnt uint32, payload []byte, isFOpts
 		return nil, ErrFrmPayloadTooLarge
 	}
 	encrypted := make([]byte, 0, k*16)
-	cipher, err := aes.NewCipher(s.AppSKey[:])
+	cipher, err := aes.NewCipher(key[:])
 	if err != nil {
 		panic(err)
 	}
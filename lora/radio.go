@@ -1,5 +1,7 @@
 package lora
 
+import "time"
+
 type Radio interface {
 	Reset()
 	Tx(pkt []uint8, timeoutMs uint32) error
@@ -17,3 +19,52 @@ type Radio interface {
 	SetHeaderType(headerType uint8)
 	LoraConfig(cnf Config)
 }
+
+// AsyncRadio is a Radio able to transmit and receive without blocking.
+// StartTx and StartRx return once the operation is started, its completion
+// is reported by a RadioEvent sent on the channel of GetRadioEventChan:
+// RadioEventTxDone, RadioEventRxDone, RadioEventTimeout or RadioEventCrcError.
+// After RadioEventRxDone, the packet is read with ReadPacket.
+type AsyncRadio interface {
+	Radio
+	GetRadioEventChan() chan RadioEvent
+	StartTx(pkt []uint8, timeoutMs uint32) error
+	StartRx(timeoutMs uint32) error
+	ReadPacket() (Packet, error)
+}
+
+// Packet is a received LoRa packet with its reception metadata
+type Packet struct {
+	Payload   []uint8
+	RSSI      int16     // signal strength, in dBm
+	SNR       int8      // signal to noise ratio, in dB
+	FreqError int32     // frequency offset of the transmitter, in Hz
+	Timestamp time.Time // end of reception
+}
+
+// FskRadio is a Radio also able to use FSK/GFSK modulation. FskConfig
+// switches the radio to FSK and LoraConfig back to LoRa: Tx and Rx, and
+// their asynchronous versions, use the current modem.
+type FskRadio interface {
+	Radio
+	FskConfig(cnf FskConfig) error
+	Modem() uint8
+}
+
+// CadRadio is an AsyncRadio able to detect LoRa transmissions on the
+// current frequency and spreading factor (Channel Activity Detection).
+// StartCad reports RadioEventCadDetected, or RadioEventCadDone when the
+// channel is free. ChannelActivity does the same and waits for the result.
+type CadRadio interface {
+	AsyncRadio
+	SetCadConfig(cnf CadConfig)
+	StartCad() error
+	ChannelActivity() (bool, error)
+}
+
+// CadConfig holds Channel Activity Detection parameters
+type CadConfig struct {
+	Symbols uint8 // number of symbols listened: 1, 2, 4, 8 or 16, 0 for default
+	DetPeak uint8 // detection peak threshold, 0 for a default based on spreading factor
+	DetMin  uint8 // minimum detection threshold, 0 for default
+}
@@ -7,6 +7,8 @@ const (
 	RadioEventWatchdog
 	RadioEventCrcError
 	RadioEventUnhandled
+	RadioEventCadDone     // channel activity detection done, channel free
+	RadioEventCadDetected // channel activity detection done, LoRa signal detected
 )
 
 // RadioEvent are used for communicating in the radio Event Channel
@@ -14,9 +14,14 @@ const (
 	SOCK_STREAM   = 0x1
 	SOCK_DGRAM    = 0x2
 	SOL_SOCKET    = 0x1
+	SO_BROADCAST  = 0x6
+	SO_RCVBUF     = 0x8
 	SO_KEEPALIVE  = 0x9
 	SOL_TCP       = 0x6
+	TCP_NODELAY   = 0x1
+	TCP_KEEPIDLE  = 0x4
 	TCP_KEEPINTVL = 0x5
+	TCP_KEEPCNT   = 0x6
 	IPPROTO_TCP   = 0x6
 	IPPROTO_UDP   = 0x11
 	// Made up, not a real IP protocol number.  This is used to create a
@@ -41,6 +46,8 @@ var (
 	ErrClosingSocket        = errors.New("Error closing socket")
 	ErrNotSupported         = errors.New("Not supported")
 	ErrInvalidSocketFd      = errors.New("Invalid socket fd")
+	ErrInvalidSockOptValue  = errors.New("Invalid socket option value")
+	ErrTLSVerifyFailed      = errors.New("TLS server verification failed")
 )
 
 // Duplicate of non-exported net.errTimeout
@@ -52,6 +59,62 @@ func (e *timeoutError) Error() string   { return "i/o timeout" }
 func (e *timeoutError) Timeout() bool   { return true }
 func (e *timeoutError) Temporary() bool { return true }
 
+// SockOptSupporter is implemented by Netdevers to report the socket options
+// supported by SetSockOpt.  SetSockOpt returns ErrNotSupported for the
+// others.
+type SockOptSupporter interface {
+	SockOptSupported(level int, opt int) bool
+}
+
+// SockOptInt returns the value of a socket option as an int.  Booleans are 0
+// or 1, durations are in seconds, as for TCP_KEEPINTVL.
+func SockOptInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case time.Duration:
+		return int(v / time.Second), nil
+	}
+	return 0, ErrInvalidSockOptValue
+}
+
+// TLSConfig configures the TLS connections of IPPROTO_TLS sockets, which
+// are handled by the device.  Certificates and keys are PEM encoded.
+type TLSConfig struct {
+	// RootCAs is a bundle of CA certificates to verify server
+	// certificates.  If empty, the device's built-in CAs are used.
+	RootCAs []byte
+
+	// Certificate and PrivateKey are the client credentials, for mutual
+	// TLS
+	Certificate []byte
+	PrivateKey  []byte
+
+	// ServerName is sent as SNI, if not the host name given to Connect
+	ServerName string
+
+	// ServerFingerprint, if set, pins the server certificate: it is the
+	// SHA-256 hash of the DER encoded certificate
+	ServerFingerprint []byte
+
+	// InsecureSkipVerify disables the verification of the server
+	// certificate
+	InsecureSkipVerify bool
+}
+
+// TLSConfigurer is implemented by Netdevers with configurable TLS.  The
+// config applies to IPPROTO_TLS sockets connected afterwards, and a nil
+// config restores the device defaults.  SetTLSConfig returns
+// ErrNotSupported for settings the device can't honor.
+type TLSConfigurer interface {
+	SetTLSConfig(config *TLSConfig) error
+}
+
 //go:linkname UseNetdev net.useNetdev
 func UseNetdev(dev Netdever)
 
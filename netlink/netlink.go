@@ -5,6 +5,7 @@ package netlink
 import (
 	"errors"
 	"net"
+	"net/netip"
 	"time"
 )
 
@@ -18,6 +19,9 @@ var (
 	ErrAuthTypeNoGood    = errors.New("Wifi authorization type not supported")
 	ErrConnectModeNoGood = errors.New("Connect mode not supported")
 	ErrNotSupported      = errors.New("Not supported")
+	ErrScanFailed        = errors.New("Wifi scan failed")
+	ErrNotConnected      = errors.New("Not connected")
+	ErrStaticIPNoGood    = errors.New("Static IP config not valid")
 )
 
 type Event int
@@ -28,6 +32,10 @@ const (
 	EventNetUp Event = iota
 	// The device's network connection is now DOWN
 	EventNetDown
+	// The link RSSI dropped below ConnectParams.RssiThreshold
+	EventRssiLow
+	// The link RSSI is back above ConnectParams.RssiThreshold
+	EventRssiOk
 )
 
 type ConnectMode int
@@ -47,6 +55,10 @@ const (
 	AuthTypeOpen             // No authorization required (open)
 	AuthTypeWPA              // WPA authorization
 	AuthTypeWPA2Mixed        // WPA2/WPA mixed authorization
+	AuthTypeWEP              // WEP authorization
+	AuthTypeWPA3             // WPA3 authorization
+	AuthTypeWPA3Mixed        // WPA3/WPA2 mixed authorization
+	AuthTypeUnknown          // Other authorization, e.g. enterprise
 )
 
 const DefaultConnectTimeout = 10 * time.Second
@@ -81,6 +93,121 @@ type ConnectParams struct {
 	// downed connection or hardware fault and try to recover the
 	// connection.  Set to zero to disable watchodog.
 	WatchdogTimeout time.Duration
+
+	// RSSI threshold, in dBm, for EventRssiLow and EventRssiOk events.
+	// The RSSI is checked on each watchdog tick.  Zero disables the
+	// events.
+	RssiThreshold int
+
+	// Static IPv4 address, subnet mask and gateway.  The default zero
+	// value of IP means the address is assigned by DHCP.
+	IP      netip.Addr
+	Subnet  netip.Addr
+	Gateway netip.Addr
+
+	// DNS servers, up to two IPv4 addresses.  If empty, DNS servers are
+	// assigned by DHCP.
+	DNS []netip.Addr
+
+	// Hostname of the device, sent to the DHCP server
+	Hostname string
+}
+
+// MaxDNSServers is the number of DNS servers of ConnectParams
+const MaxDNSServers = 2
+
+// CheckIPConfig checks the static IP address and DNS servers of the
+// connection parameters, returning ErrStaticIPNoGood if not valid
+func (p *ConnectParams) CheckIPConfig() error {
+	if p.IP.IsValid() {
+		if !p.IP.Is4() || !p.Subnet.Is4() || !p.Gateway.Is4() {
+			return ErrStaticIPNoGood
+		}
+	}
+	if len(p.DNS) > MaxDNSServers {
+		return ErrStaticIPNoGood
+	}
+	for _, dns := range p.DNS {
+		if !dns.Is4() {
+			return ErrStaticIPNoGood
+		}
+	}
+	return nil
+}
+
+// LinkInfo describes the current link, as returned by GetLinkInfo
+type LinkInfo struct {
+	// SSID of Wifi AP
+	Ssid string
+
+	// BSSID (MAC address) of Wifi AP
+	Bssid net.HardwareAddr
+
+	// Wifi channel, or zero if unknown
+	Channel int
+
+	// Received signal strength, in dBm
+	Rssi int
+
+	// IP address, subnet mask and gateway of the device
+	IP      netip.Addr
+	Subnet  netip.Addr
+	Gateway netip.Addr
+}
+
+// LinkInfoGetter is implemented by Netlinkers able to report information
+// about the current link.
+type LinkInfoGetter interface {
+	// GetLinkInfo returns information about the current link
+	GetLinkInfo() (LinkInfo, error)
+}
+
+// RssiHysteresis is the margin, in dB, above ConnectParams.RssiThreshold
+// for EventRssiOk, so a RSSI close to the threshold doesn't flood events
+const RssiHysteresis = 3
+
+// RssiMonitor tracks the link RSSI against ConnectParams.RssiThreshold,
+// for drivers to generate EventRssiLow and EventRssiOk events
+type RssiMonitor struct {
+	low bool
+}
+
+// Update returns the event to notify for a new RSSI measure, if any
+func (m *RssiMonitor) Update(threshold, rssi int) (Event, bool) {
+	switch {
+	case !m.low && rssi < threshold:
+		m.low = true
+		return EventRssiLow, true
+	case m.low && rssi >= threshold+RssiHysteresis:
+		m.low = false
+		return EventRssiOk, true
+	}
+	return 0, false
+}
+
+// ScanResult is a Wifi network found by NetScan
+type ScanResult struct {
+	// SSID of Wifi AP
+	Ssid string
+
+	// BSSID (MAC address) of Wifi AP
+	Bssid net.HardwareAddr
+
+	// Received signal strength, in dBm
+	Rssi int
+
+	// Wifi channel
+	Channel int
+
+	// Wifi authorization type
+	AuthType AuthType
+}
+
+// Scanner is implemented by Netlinkers of Wifi devices able to scan for
+// networks in range.
+type Scanner interface {
+	// NetScan scans for Wifi networks in range
+	NetScan() ([]ScanResult, error)
 }
 
 // Netlinker is TinyGo's OSI L2 data link layer interface.  Network device
@@ -0,0 +1,62 @@
+package netlink
+
+import (
+	"net/netip"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRssiMonitor(t *testing.T) {
+	c := qt.New(t)
+
+	var m RssiMonitor
+	steps := []struct {
+		rssi  int
+		event Event
+		ok    bool
+	}{
+		{rssi: -60},
+		{rssi: -70},
+		{rssi: -71, event: EventRssiLow, ok: true},
+		{rssi: -75},
+		{rssi: -69},
+		{rssi: -68},
+		{rssi: -67, event: EventRssiOk, ok: true},
+		{rssi: -60},
+		{rssi: -80, event: EventRssiLow, ok: true},
+	}
+	for _, step := range steps {
+		event, ok := m.Update(-70, step.rssi)
+		c.Check(ok, qt.Equals, step.ok, qt.Commentf("rssi %d", step.rssi))
+		if step.ok {
+			c.Check(event, qt.Equals, step.event)
+		}
+	}
+}
+
+func TestCheckIPConfig(t *testing.T) {
+	c := qt.New(t)
+
+	ip := netip.MustParseAddr("192.168.1.10")
+	subnet := netip.MustParseAddr("255.255.255.0")
+	gateway := netip.MustParseAddr("192.168.1.1")
+	ipv6 := netip.MustParseAddr("fe80::1")
+
+	tests := []struct {
+		params ConnectParams
+		err    error
+	}{
+		{params: ConnectParams{}},
+		{params: ConnectParams{IP: ip, Subnet: subnet, Gateway: gateway}},
+		{params: ConnectParams{IP: ip, Subnet: subnet, Gateway: gateway, DNS: []netip.Addr{gateway, ip}}},
+		{params: ConnectParams{DNS: []netip.Addr{gateway}}},
+		{params: ConnectParams{IP: ip}, err: ErrStaticIPNoGood},
+		{params: ConnectParams{IP: ipv6, Subnet: subnet, Gateway: gateway}, err: ErrStaticIPNoGood},
+		{params: ConnectParams{DNS: []netip.Addr{ipv6}}, err: ErrStaticIPNoGood},
+		{params: ConnectParams{DNS: []netip.Addr{gateway, gateway, gateway}}, err: ErrStaticIPNoGood},
+	}
+	for i, test := range tests {
+		c.Check(test.params.CheckIPConfig(), qt.Equals, test.err, qt.Commentf("test %d", i))
+	}
+}
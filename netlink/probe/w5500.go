@@ -0,0 +1,36 @@
+//go:build pico && w5500
+
+package probe
+
+import (
+	"machine"
+
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
+	"tinygo.org/x/drivers/w5500"
+)
+
+// Probe for the WIZnet W5500-EVB-Pico, built with -target=pico -tags=w5500
+
+func Probe() (netlink.Netlinker, netdev.Netdever) {
+
+	// Configure SPI for 33Mhz, Mode 0, MSB First
+	machine.SPI0.Configure(machine.SPIConfig{
+		Frequency: 33 * 1e6,
+		SCK:       machine.GPIO18,
+		SDO:       machine.GPIO19,
+		SDI:       machine.GPIO16,
+	})
+
+	cfg := w5500.Config{
+		Spi: machine.SPI0,
+		// Device pins
+		Cs:  machine.GPIO17,
+		Rst: machine.GPIO20,
+	}
+
+	eth := w5500.New(&cfg)
+	netdev.UseNetdev(eth)
+
+	return eth, eth
+}
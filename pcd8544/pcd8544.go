@@ -6,7 +6,6 @@ package pcd8544 // import "tinygo.org/x/drivers/pcd8544"
 import (
 	"errors"
 	"image/color"
-	"machine"
 	"time"
 
 	"tinygo.org/x/drivers"
@@ -15,13 +14,21 @@ import (
 // Device wraps an SPI connection.
 type Device struct {
 	bus        drivers.SPI
-	dcPin      machine.Pin
-	rstPin     machine.Pin
-	scePin     machine.Pin
+	dcPin      pin
+	rstPin     pin
+	scePin     pin
 	buffer     []byte
 	width      int16
 	height     int16
 	bufferSize int16
+
+	// The area of the buffer changed since the last Display, from column
+	// dirtyX0 to dirtyX1 and bank (8 pixel high row) dirtyBank0 to dirtyBank1
+	// included. It's empty when dirtyX0 > dirtyX1.
+	dirtyX0    int16
+	dirtyX1    int16
+	dirtyBank0 int16
+	dirtyBank1 int16
 }
 
 type Config struct {
@@ -29,14 +36,10 @@ type Config struct {
 	Height int16
 }
 
-// New creates a new PCD8544 connection. The SPI bus must already be configured.
-func New(bus drivers.SPI, dcPin, rstPin, scePin machine.Pin) *Device {
-	return &Device{
-		bus:    bus,
-		dcPin:  dcPin,
-		rstPin: rstPin,
-		scePin: scePin,
-	}
+// pin is an output pin, as machine.Pin
+type pin interface {
+	High()
+	Low()
 }
 
 // Configure initializes the display with default configuration
@@ -53,6 +56,7 @@ func (d *Device) Configure(cfg Config) {
 	}
 	d.bufferSize = d.width * d.height / 8
 	d.buffer = make([]byte, d.bufferSize)
+	d.setAllDirty()
 
 	d.rstPin.Low()
 	time.Sleep(100 * time.Nanosecond)
@@ -67,27 +71,71 @@ func (d *Device) Configure(cfg Config) {
 
 // ClearBuffer clears the image buffer
 func (d *Device) ClearBuffer() {
-	d.buffer = make([]byte, d.bufferSize)
+	for i := int16(0); i < d.bufferSize; i++ {
+		if d.buffer[i] != 0 {
+			d.buffer[i] = 0
+			d.setDirty(i%d.width, i/d.width)
+		}
+	}
 }
 
 // ClearDisplay clears the image buffer and clear the display
 func (d *Device) ClearDisplay() {
 	d.ClearBuffer()
+	d.setAllDirty()
 	d.Display()
 }
 
-// Display sends the whole buffer to the screen
+// Display sends the buffer to the screen. Only the area changed since the
+// last call is sent, if any.
 func (d *Device) Display() error {
-	d.SendCommand(FUNCTIONSET) // H = 0
-	d.SendCommand(SETXADDR)
-	d.SendCommand(SETYADDR)
+	if d.dirtyX0 > d.dirtyX1 {
+		return nil
+	}
+	x0, x1, bank0, bank1 := d.dirtyX0, d.dirtyX1, d.dirtyBank0, d.dirtyBank1
+	d.clearDirty()
 
-	for i := int16(0); i < d.bufferSize; i++ {
-		d.SendData(d.buffer[i])
+	d.SendCommand(FUNCTIONSET) // H = 0
+	for bank := bank0; bank <= bank1; bank++ {
+		d.SendCommand(SETXADDR | uint8(x0))
+		d.SendCommand(SETYADDR | uint8(bank))
+		for i := bank*d.width + x0; i <= bank*d.width+x1; i++ {
+			d.SendData(d.buffer[i])
+		}
 	}
 	return nil
 }
 
+// setDirty adds the column x of the bank to the area to send on Display.
+func (d *Device) setDirty(x, bank int16) {
+	if d.dirtyX0 > d.dirtyX1 {
+		d.dirtyX0, d.dirtyX1 = x, x
+		d.dirtyBank0, d.dirtyBank1 = bank, bank
+		return
+	}
+	if x < d.dirtyX0 {
+		d.dirtyX0 = x
+	} else if x > d.dirtyX1 {
+		d.dirtyX1 = x
+	}
+	if bank < d.dirtyBank0 {
+		d.dirtyBank0 = bank
+	} else if bank > d.dirtyBank1 {
+		d.dirtyBank1 = bank
+	}
+}
+
+// setAllDirty sends the whole buffer on the next Display.
+func (d *Device) setAllDirty() {
+	d.dirtyX0, d.dirtyX1 = 0, d.width-1
+	d.dirtyBank0, d.dirtyBank1 = 0, d.height/8-1
+}
+
+// clearDirty marks the buffer as sent.
+func (d *Device) clearDirty() {
+	d.dirtyX0, d.dirtyX1 = 0, -1
+}
+
 // sendDataCommand sends image data or a command to the screen
 func (d *Device) sendDataCommand(isCommand bool, data uint8) {
 	if isCommand {
@@ -108,10 +156,15 @@ func (d *Device) SetPixel(x int16, y int16, c color.RGBA) {
 		return
 	}
 	byteIndex := x + (y/8)*d.width
+	b := d.buffer[byteIndex]
 	if c.R != 0 || c.G != 0 || c.B != 0 {
-		d.buffer[byteIndex] |= 1 << uint8(y%8)
+		b |= 1 << uint8(y%8)
 	} else {
-		d.buffer[byteIndex] &^= 1 << uint8(y%8)
+		b &^= 1 << uint8(y%8)
+	}
+	if b != d.buffer[byteIndex] {
+		d.buffer[byteIndex] = b
+		d.setDirty(x, y/8)
 	}
 }
 
@@ -131,7 +184,10 @@ func (d *Device) SetBuffer(buffer []byte) error {
 		return errors.New("wrong size buffer")
 	}
 	for i := int16(0); i < d.bufferSize; i++ {
-		d.buffer[i] = buffer[i]
+		if d.buffer[i] != buffer[i] {
+			d.buffer[i] = buffer[i]
+			d.setDirty(i%d.width, i/d.width)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,182 @@
+package pcd8544
+
+import (
+	"image/color"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+// fakePin records the level of an output pin.
+type fakePin struct {
+	high bool
+}
+
+func (p *fakePin) High() { p.high = true }
+
+func (p *fakePin) Low() { p.high = false }
+
+// fakeBus records the commands and data sent to the display, as told by the
+// level of the D/C pin. The data sent between two commands is kept together.
+type fakeBus struct {
+	dc       *fakePin
+	commands []byte
+	data     [][]byte
+	lastData bool
+}
+
+func (b *fakeBus) Tx(w, r []byte) error {
+	for _, v := range w {
+		b.Transfer(v)
+	}
+	return nil
+}
+
+func (b *fakeBus) Transfer(v byte) (byte, error) {
+	if !b.dc.high {
+		b.commands = append(b.commands, v)
+		b.lastData = false
+	} else if b.lastData {
+		b.data[len(b.data)-1] = append(b.data[len(b.data)-1], v)
+	} else {
+		b.data = append(b.data, []byte{v})
+		b.lastData = true
+	}
+	return 0, nil
+}
+
+func (b *fakeBus) reset() {
+	b.commands, b.data, b.lastData = nil, nil, false
+}
+
+// newDevice returns a configured 84x48 display with its whole buffer
+// already sent.
+func newDevice(c *qt.C) (*Device, *fakeBus) {
+	dc := &fakePin{}
+	bus := &fakeBus{dc: dc}
+	d := &Device{bus: bus, dcPin: dc, rstPin: &fakePin{}, scePin: &fakePin{}}
+	d.Configure(Config{})
+	c.Assert(d.Display(), qt.IsNil)
+	bus.reset()
+	return d, bus
+}
+
+// dirty returns the area to send on the next Display, as x0, x1, bank0, bank1.
+func dirty(d *Device) [4]int16 {
+	if d.dirtyX0 > d.dirtyX1 {
+		return [4]int16{}
+	}
+	return [4]int16{d.dirtyX0, d.dirtyX1, d.dirtyBank0, d.dirtyBank1}
+}
+
+func clean(d *Device) bool {
+	return d.dirtyX0 > d.dirtyX1
+}
+
+// bankCommands returns the commands to write a bank from column x.
+func bankCommands(bank, x uint8) []byte {
+	return []byte{SETXADDR | x, SETYADDR | bank}
+}
+
+func TestConfigureDisplay(t *testing.T) {
+	c := qt.New(t)
+	dc := &fakePin{}
+	bus := &fakeBus{dc: dc}
+	d := &Device{bus: bus, dcPin: dc, rstPin: &fakePin{}, scePin: &fakePin{}}
+	d.Configure(Config{})
+	c.Assert(dirty(d), qt.Equals, [4]int16{0, 83, 0, 5})
+
+	bus.reset()
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.data, qt.HasLen, 6)
+	for bank, data := range bus.data {
+		c.Assert(data, qt.DeepEquals, d.buffer[bank*84:(bank+1)*84])
+	}
+	c.Assert(clean(d), qt.IsTrue)
+}
+
+func TestSetPixelDirty(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c)
+
+	c.Assert(clean(d), qt.IsTrue)
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.HasLen, 0)
+	c.Assert(bus.data, qt.HasLen, 0)
+
+	// Pixels outside the display and unchanged pixels aren't tracked
+	d.SetPixel(-1, 0, white)
+	d.SetPixel(84, 0, white)
+	d.SetPixel(0, -1, white)
+	d.SetPixel(0, 48, white)
+	d.SetPixel(5, 10, black)
+	c.Assert(clean(d), qt.IsTrue)
+
+	d.SetPixel(5, 10, white)
+	c.Assert(dirty(d), qt.Equals, [4]int16{5, 5, 1, 1})
+	d.SetPixel(20, 30, white)
+	c.Assert(dirty(d), qt.Equals, [4]int16{5, 20, 1, 3})
+
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, []byte{
+		FUNCTIONSET,
+		SETXADDR | 5, SETYADDR | 1,
+		SETXADDR | 5, SETYADDR | 2,
+		SETXADDR | 5, SETYADDR | 3,
+	})
+	c.Assert(bus.data, qt.HasLen, 3)
+	for i, data := range bus.data {
+		bank := i + 1
+		c.Assert(data, qt.DeepEquals, d.buffer[bank*84+5:bank*84+21])
+	}
+	c.Assert(bus.data[0][0], qt.Equals, byte(1<<2))
+	c.Assert(bus.data[2][15], qt.Equals, byte(1<<6))
+	c.Assert(clean(d), qt.IsTrue)
+
+	bus.reset()
+	d.SetPixel(83, 47, white)
+	c.Assert(dirty(d), qt.Equals, [4]int16{83, 83, 5, 5})
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, append([]byte{FUNCTIONSET}, bankCommands(5, 83)...))
+	c.Assert(bus.data, qt.DeepEquals, [][]byte{{0x80}})
+	c.Assert(clean(d), qt.IsTrue)
+}
+
+func TestSetBufferDirty(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c)
+
+	buffer := make([]byte, 84*48/8)
+	c.Assert(d.SetBuffer(buffer[1:]), qt.Not(qt.IsNil))
+	c.Assert(d.SetBuffer(buffer), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+
+	buffer[84+20] = 1
+	buffer[3*84+40] = 1
+	c.Assert(d.SetBuffer(buffer), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{20, 40, 1, 3})
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.data, qt.HasLen, 3)
+	c.Assert(clean(d), qt.IsTrue)
+
+	d.ClearBuffer()
+	c.Assert(dirty(d), qt.Equals, [4]int16{20, 40, 1, 3})
+}
+
+func TestClearDisplay(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c)
+
+	// ClearDisplay sends the whole buffer, even if it was already clear
+	d.ClearDisplay()
+	c.Assert(bus.data, qt.HasLen, 6)
+	for _, data := range bus.data {
+		c.Assert(data, qt.DeepEquals, make([]byte, 84))
+	}
+	c.Assert(clean(d), qt.IsTrue)
+}
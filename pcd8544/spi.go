@@ -0,0 +1,19 @@
+//go:build tinygo
+
+package pcd8544
+
+import (
+	"machine"
+
+	"tinygo.org/x/drivers"
+)
+
+// New creates a new PCD8544 connection. The SPI bus must already be configured.
+func New(bus drivers.SPI, dcPin, rstPin, scePin machine.Pin) *Device {
+	return &Device{
+		bus:    bus,
+		dcPin:  dcPin,
+		rstPin: rstPin,
+		scePin: scePin,
+	}
+}
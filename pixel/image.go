@@ -31,9 +31,7 @@ func NewImage[T Color](width, height int) Image[T] {
 	default:
 		// Formats like RGB444 that have 12 bits per pixel.
 		// We access these as bytes, so allocate the buffer as a byte slice.
-		bufBits := width * height * zeroColor.BitsPerPixel()
-		bufBytes := (bufBits + 7) / 8
-		buf := make([]byte, bufBytes)
+		buf := make([]byte, bufferSize[T](width, height))
 		data = unsafe.Pointer(&buf[0])
 	}
 	return Image[T]{
@@ -43,12 +41,28 @@ func NewImage[T Color](width, height int) Image[T] {
 	}
 }
 
+// bufferSize returns the size in bytes of a width x height image buffer.
+func bufferSize[T Color](width, height int) int {
+	var zeroColor T
+	switch {
+	case zeroColor.BitsPerPixel() == 1:
+		// Monochrome, stored in 8 pixel high columns (see setPixel).
+		return width * ((height + 7) / 8)
+	case zeroColor.BitsPerPixel()%8 == 0:
+		return int(unsafe.Sizeof(zeroColor)) * width * height
+	default:
+		// Formats like RGB444 that aren't a whole number of bytes.
+		numBits := zeroColor.BitsPerPixel() * width * height
+		return (numBits + 7) / 8 // round up
+	}
+}
+
 // Rescale returns a new Image buffer based on the img buffer.
 // The contents is undefined after the Rescale operation, and any modification
 // to the returned image will overwrite the underlying image buffer in undefined
-// ways. It will panic if width*height is larger than img.Len().
+// ways. It will panic if the new image doesn't fit in the img buffer.
 func (img Image[T]) Rescale(width, height int) Image[T] {
-	if width*height > img.Len() {
+	if bufferSize[T](width, height) > bufferSize[T](int(img.width), int(img.height)) {
 		panic("Image.Rescale size out of bounds")
 	}
 	return Image[T]{
@@ -79,17 +93,7 @@ func (img Image[T]) Len() int {
 // RawBuffer returns a byte slice that can be written directly to the screen
 // using DrawRGBBitmap8.
 func (img Image[T]) RawBuffer() []uint8 {
-	var zeroColor T
-	var numBytes int
-	switch {
-	case zeroColor.BitsPerPixel()%8 == 0:
-		// Each color starts at a whole byte offset.
-		numBytes = int(unsafe.Sizeof(zeroColor)) * int(img.width) * int(img.height)
-	default:
-		// Formats like RGB444 that aren't a whole number of bytes.
-		numBits := zeroColor.BitsPerPixel() * int(img.width) * int(img.height)
-		numBytes = (numBits + 7) / 8 // round up (see NewImage)
-	}
+	numBytes := bufferSize[T](int(img.width), int(img.height))
 	return unsafe.Slice((*byte)(img.data), numBytes)
 }
 
@@ -211,7 +215,7 @@ func (img Image[T]) FillSolidColor(color T) {
 		if color != zeroColor {
 			colorByte = 0xff
 		}
-		numBytes := int(img.width) * int(img.height) / 8
+		numBytes := bufferSize[T](int(img.width), int(img.height))
 		for i := 0; i < numBytes; i++ {
 			// TODO: this can be optimized a lot.
 			// - The store can be done as a 32-bit integer, after checking for
@@ -162,3 +162,49 @@ func testImageNoise[T pixel.Color](t *testing.T) {
 		t.Errorf("mismatch found: %d pixels are different (first diff at (%d, %d), expected %v, actual %v)", mismatch, firstX, firstY, firstExpected, firstActual)
 	}
 }
+
+func TestImageMonochromeBufferSize(t *testing.T) {
+	// Monochrome pixels are stored in 8 pixel high columns, so the buffer
+	// holds width bytes for each started group of 8 rows.
+	for _, tc := range []struct {
+		width, height, size int
+	}{
+		{5, 3, 5},
+		{5, 8, 5},
+		{5, 9, 10},
+		{84, 48, 504},
+		{128, 64, 1024},
+	} {
+		image := pixel.NewImage[pixel.Monochrome](tc.width, tc.height)
+		if n := len(image.RawBuffer()); n != tc.size {
+			t.Errorf("%dx%d: expected a buffer of %d bytes but got %d", tc.width, tc.height, tc.size, n)
+		}
+
+		// The last pixel is in the buffer
+		image.Set(tc.width-1, tc.height-1, true)
+		if !image.Get(tc.width-1, tc.height-1) {
+			t.Errorf("%dx%d: failed to set the last pixel", tc.width, tc.height)
+		}
+
+		// FillSolidColor fills the whole buffer
+		image.FillSolidColor(true)
+		for i, b := range image.RawBuffer() {
+			if b != 0xff {
+				t.Errorf("%dx%d: byte %d not filled: %#x", tc.width, tc.height, i, b)
+				break
+			}
+		}
+	}
+
+	// The buffer of a 5x9 image holds a 10x1 image, not a 10x8 one
+	image := pixel.NewImage[pixel.Monochrome](5, 9)
+	if n := len(image.Rescale(10, 1).RawBuffer()); n != 10 {
+		t.Errorf("expected a rescaled buffer of 10 bytes but got %d", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected Rescale to panic")
+		}
+	}()
+	image.Rescale(10, 9)
+}
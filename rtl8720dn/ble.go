@@ -0,0 +1,572 @@
+package rtl8720dn
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	errBLEInit                   = errors.New("bluetooth: init failed")
+	errBLENotEnabled             = errors.New("bluetooth: adapter not enabled")
+	errBLETimeout                = errors.New("bluetooth: timeout")
+	errBLEFailed                 = errors.New("bluetooth: request failed")
+	errNotConnected              = errors.New("bluetooth: not connected")
+	errAdvertisementPacketTooBig = errors.New("bluetooth: advertisement packet overflows")
+)
+
+// GAP messages, from gap_msg.h
+const (
+	GAP_MSG_LE_DEV_STATE_CHANGE  = 0x01
+	GAP_MSG_LE_CONN_STATE_CHANGE = 0x02
+
+	GAP_CONN_STATE_DISCONNECTED  = 0x00
+	GAP_CONN_STATE_CONNECTING    = 0x01
+	GAP_CONN_STATE_CONNECTED     = 0x02
+	GAP_CONN_STATE_DISCONNECTING = 0x03
+)
+
+// GAP callbacks, from gap_callback.h
+const (
+	GAP_MSG_LE_SCAN_INFO = 0x30
+)
+
+// GAP parameters
+const (
+	GAP_PARAM_ADV_DATA         = 0x261
+	GAP_PARAM_SCAN_RSP_DATA    = 0x262
+	GAP_PARAM_ADV_INTERVAL_MIN = 0x268
+	GAP_PARAM_ADV_INTERVAL_MAX = 0x269
+
+	GAP_PARAM_SCAN_MODE = 0x241
+
+	GAP_SCAN_MODE_ACTIVE = 0x01
+
+	GAP_PHYS_CONN_INIT_1M_BIT = 0x01
+	GAP_LOCAL_ADDR_LE_PUBLIC  = 0x00
+	GAP_REMOTE_ADDR_LE_PUBLIC = 0x00
+	GAP_REMOTE_ADDR_LE_RANDOM = 0x01
+)
+
+const (
+	// bleMaxAdvData is the size of legacy advertising data
+	bleMaxAdvData = 31
+	// bleTimeout is the timeout of GATT procedures
+	bleTimeout = 5 * time.Second
+	// blePollInterval is the period at which run() checks for callbacks
+	blePollInterval = 10 * time.Millisecond
+)
+
+// Adapter is the Bluetooth Low Energy interface of the rtl8720dn. Its API
+// follows the one of tinygo.org/x/bluetooth.
+//
+// Callbacks invoked by the rtl8720dn update the state of the adapter while
+// any request is made. Handlers set by the application are called later by
+// a background goroutine, so they can use the adapter.
+type Adapter struct {
+	r *rtl8720dn
+
+	enabled  bool
+	clientID uint8
+
+	connectHandler func(device Device, connected bool)
+	conns          map[uint8]*bleConn
+	connecting     bool
+	connected      int // conn_id found while connecting, -1 or -2 if failed
+
+	scanning bool
+	scanCb   func(*Adapter, ScanResult)
+
+	services []*Service
+
+	// events holds the handlers to call by run()
+	events []func()
+}
+
+// bleConn is the state of a link
+type bleConn struct {
+	address Address
+	state   uint8
+	central bool // connected by Connect
+
+	// current GATT client procedure
+	done     bool
+	cause    uint16
+	services []DeviceService
+	chars    []DeviceCharacteristic
+	descs    []bleDescriptor
+	value    []byte
+
+	notify map[uint16]func(buf []byte)
+}
+
+// BLE returns the Bluetooth Low Energy adapter of the rtl8720dn. It has to
+// be enabled before use.
+func (r *rtl8720dn) BLE() *Adapter {
+	if r.ble == nil {
+		r.ble = &Adapter{
+			r:         r,
+			conns:     make(map[uint8]*bleConn),
+			connected: -1,
+		}
+	}
+	return r.ble
+}
+
+// Enable starts the Bluetooth stack of the rtl8720dn, powering it on if the
+// WiFi interface is not in use.
+func (a *Adapter) Enable() error {
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a.enabled {
+		return nil
+	}
+	if r.uart == nil {
+		if err := r.powerOn(); err != nil {
+			return err
+		}
+	}
+
+	if !r.rpc_ble_init() {
+		return errBLEInit
+	}
+	links := r.rpc_le_get_max_link_num()
+	r.rpc_ble_server_init(bleMaxServices)
+	r.rpc_ble_client_init(1)
+	a.clientID = r.rpc_ble_add_client(0, links)
+	r.rpc_ble_start()
+
+	a.enabled = true
+	go a.run()
+	return nil
+}
+
+// run calls the handlers of the application, after checking for callbacks
+// from the rtl8720dn
+func (a *Adapter) run() {
+	for {
+		time.Sleep(blePollInterval)
+
+		a.r.mu.Lock()
+		a.r.poll()
+		events := a.events
+		a.events = nil
+		a.r.mu.Unlock()
+
+		for _, ev := range events {
+			ev()
+		}
+	}
+}
+
+// wait checks for callbacks from the rtl8720dn until done returns true. It
+// is called with r.mu locked, which is released while sleeping.
+func (a *Adapter) wait(timeout time.Duration, done func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		a.r.poll()
+		if done() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errBLETimeout
+		}
+		a.r.mu.Unlock()
+		time.Sleep(blePollInterval)
+		a.r.mu.Lock()
+	}
+}
+
+// SetConnectHandler sets a handler called when a connection is established
+// or lost, in both central and peripheral roles.
+func (a *Adapter) SetConnectHandler(c func(device Device, connected bool)) {
+	a.connectHandler = c
+}
+
+// handleGapMsg handles messages of the GAP layer, as T_IO_MSG: type,
+// subtype and 4 bytes of parameters
+func (a *Adapter) handleGapMsg(msg []byte) {
+	if len(msg) < 8 {
+		return
+	}
+	subtype := binary.LittleEndian.Uint16(msg[2:])
+	switch subtype {
+	case GAP_MSG_LE_CONN_STATE_CHANGE:
+		// T_GAP_CONN_STATE_CHANGE: conn_id, new_state, disc_cause
+		a.connStateChange(msg[4], msg[5])
+	}
+}
+
+func (a *Adapter) connStateChange(connID, state uint8) {
+	c, ok := a.conns[connID]
+	if !ok {
+		c = &bleConn{notify: make(map[uint16]func(buf []byte))}
+		a.conns[connID] = c
+	}
+	c.state = state
+
+	switch state {
+	case GAP_CONN_STATE_CONNECTED:
+		if a.connecting {
+			a.connected = int(connID)
+		}
+		a.events = append(a.events, func() {
+			a.r.mu.Lock()
+			var bdType uint8
+			a.r.rpc_le_get_conn_addr(connID, c.address.MAC[:], &bdType)
+			c.address.IsRandom = bdType == GAP_REMOTE_ADDR_LE_RANDOM
+			a.r.mu.Unlock()
+			if a.connectHandler != nil {
+				a.connectHandler(Device{a: a, connID: connID, Address: c.address}, true)
+			}
+		})
+
+	case GAP_CONN_STATE_DISCONNECTED:
+		if a.connecting && a.connected == -1 {
+			a.connected = -2
+		}
+		delete(a.conns, connID)
+		// Fail pending GATT procedures
+		c.done = true
+		c.cause = 0xFFFF
+		a.events = append(a.events, func() {
+			if a.connectHandler != nil {
+				a.connectHandler(Device{a: a, connID: connID, Address: c.address}, false)
+			}
+		})
+	}
+}
+
+// handleGapCallback handles results of GAP requests
+func (a *Adapter) handleGapCallback(cbType uint8, data []byte) {
+	switch cbType {
+	case GAP_MSG_LE_SCAN_INFO:
+		// T_LE_SCAN_INFO: bd_addr, remote_addr_type, adv_type, rssi,
+		// data_len, data
+		if len(data) < 10 || !a.scanning || a.scanCb == nil {
+			return
+		}
+		var result ScanResult
+		copy(result.Address.MAC[:], data[:6])
+		result.Address.IsRandom = data[6] == GAP_REMOTE_ADDR_LE_RANDOM
+		result.RSSI = int16(int8(data[8]))
+		n := int(data[9])
+		if n > len(data)-10 {
+			n = len(data) - 10
+		}
+		result.Payload = append([]byte(nil), data[10:10+n]...)
+		cb := a.scanCb
+		a.events = append(a.events, func() {
+			cb(a, result)
+		})
+	}
+}
+
+// MAC is a Bluetooth device address, least significant byte first.
+type MAC [6]byte
+
+// String returns the address in the usual format, like 11:22:33:AA:BB:CC.
+func (m MAC) String() string {
+	const hexDigits = "0123456789ABCDEF"
+	s := make([]byte, 0, 17)
+	for i := 5; i >= 0; i-- {
+		s = append(s, hexDigits[m[i]>>4], hexDigits[m[i]&0x0F])
+		if i > 0 {
+			s = append(s, ':')
+		}
+	}
+	return string(s)
+}
+
+// Address is the address of a remote device
+type Address struct {
+	MAC
+	IsRandom bool
+}
+
+// ScanResult is an advertisement received while scanning
+type ScanResult struct {
+	Address Address
+	RSSI    int16
+	// Payload is the raw advertising data
+	Payload []byte
+}
+
+// LocalName returns the complete or shortened local name of the device, if
+// advertised.
+func (s ScanResult) LocalName() string {
+	if b := findAdvField(s.Payload, 0x09); b != nil {
+		return string(b)
+	}
+	return string(findAdvField(s.Payload, 0x08))
+}
+
+// HasServiceUUID reports if the device advertises the service.
+func (s ScanResult) HasServiceUUID(uuid UUID) bool {
+	b := uuid.Bytes()
+	if uuid.Is16Bit() {
+		for _, t := range []uint8{0x02, 0x03} {
+			f := findAdvField(s.Payload, t)
+			for i := 0; i+2 <= len(f); i += 2 {
+				if f[i] == b[12] && f[i+1] == b[13] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, t := range []uint8{0x06, 0x07} {
+		f := findAdvField(s.Payload, t)
+		for i := 0; i+16 <= len(f); i += 16 {
+			if string(f[i:i+16]) == string(b[:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findAdvField returns the data of the first field of type t of
+// advertising data
+func findAdvField(payload []byte, t uint8) []byte {
+	for len(payload) >= 2 {
+		n := int(payload[0])
+		if n == 0 || n+1 > len(payload) {
+			return nil
+		}
+		if payload[1] == t {
+			return payload[2 : n+1]
+		}
+		payload = payload[n+1:]
+	}
+	return nil
+}
+
+// Scan starts scanning, calling callback for each advertisement received.
+// It blocks until StopScan is called, usually from the callback.
+func (a *Adapter) Scan(callback func(*Adapter, ScanResult)) error {
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !a.enabled {
+		return errBLENotEnabled
+	}
+
+	mode := []byte{GAP_SCAN_MODE_ACTIVE}
+	r.rpc_le_scan_set_param(GAP_PARAM_SCAN_MODE, mode)
+	if r.rpc_le_scan_start() != 0 {
+		return errBLEFailed
+	}
+	a.scanCb = callback
+	a.scanning = true
+
+	for a.scanning {
+		r.mu.Unlock()
+		time.Sleep(blePollInterval)
+		r.mu.Lock()
+	}
+	return nil
+}
+
+// StopScan stops a scan started by Scan.
+func (a *Adapter) StopScan() error {
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !a.scanning {
+		return nil
+	}
+	a.scanning = false
+	a.scanCb = nil
+	if r.rpc_le_scan_stop() != 0 {
+		return errBLEFailed
+	}
+	return nil
+}
+
+// Duration is a BLE interval, in units of 0.625ms
+type Duration uint16
+
+// NewDuration returns the Duration closest to interval.
+func NewDuration(interval time.Duration) Duration {
+	return Duration(interval * 8 / (5 * time.Millisecond))
+}
+
+// ManufacturerDataElement is manufacturer specific data, advertised with
+// the company identifier assigned by the Bluetooth SIG
+type ManufacturerDataElement struct {
+	CompanyID uint16
+	Data      []byte
+}
+
+// AdvertisementOptions configures the advertisement of a peripheral
+type AdvertisementOptions struct {
+	LocalName        string
+	ServiceUUIDs     []UUID
+	Interval         Duration
+	ManufacturerData []ManufacturerDataElement
+}
+
+// Advertisement is the advertisement of the adapter, as a peripheral
+type Advertisement struct {
+	a *Adapter
+}
+
+// DefaultAdvertisement returns the advertisement of the adapter.
+func (a *Adapter) DefaultAdvertisement() *Advertisement {
+	return &Advertisement{a: a}
+}
+
+// Configure sets the advertising data and interval. The default interval
+// is 100ms.
+func (adv *Advertisement) Configure(options AdvertisementOptions) error {
+	r := adv.a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !adv.a.enabled {
+		return errBLENotEnabled
+	}
+
+	// Flags: LE General Discoverable Mode, BR/EDR not supported
+	data := []byte{2, 0x01, 0x06}
+	if options.LocalName != "" {
+		data = append(data, uint8(len(options.LocalName)+1), 0x09)
+		data = append(data, options.LocalName...)
+	}
+	for _, uuid := range options.ServiceUUIDs {
+		b := uuid.Bytes()
+		if uuid.Is16Bit() {
+			data = append(data, 3, 0x03, b[12], b[13])
+		} else {
+			data = append(data, 17, 0x07)
+			data = append(data, b[:]...)
+		}
+	}
+	for _, m := range options.ManufacturerData {
+		data = append(data, uint8(len(m.Data)+3), 0xFF, uint8(m.CompanyID), uint8(m.CompanyID>>8))
+		data = append(data, m.Data...)
+	}
+	if len(data) > bleMaxAdvData {
+		return errAdvertisementPacketTooBig
+	}
+
+	interval := options.Interval
+	if interval == 0 {
+		interval = NewDuration(100 * time.Millisecond)
+	}
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], uint16(interval))
+	r.rpc_le_adv_set_param(GAP_PARAM_ADV_INTERVAL_MIN, b[:])
+	r.rpc_le_adv_set_param(GAP_PARAM_ADV_INTERVAL_MAX, b[:])
+
+	if r.rpc_le_adv_set_param(GAP_PARAM_ADV_DATA, data) != 0 {
+		return errBLEFailed
+	}
+	return nil
+}
+
+// Start starts advertising. Advertising stops when a central connects.
+func (adv *Advertisement) Start() error {
+	r := adv.a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rpc_le_adv_start() != 0 {
+		return errBLEFailed
+	}
+	return nil
+}
+
+// Stop stops advertising.
+func (adv *Advertisement) Stop() error {
+	r := adv.a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rpc_le_adv_stop() != 0 {
+		return errBLEFailed
+	}
+	return nil
+}
+
+// ConnectionParams are the parameters of Connect
+type ConnectionParams struct {
+	// ConnectionTimeout is the time to wait for the connection, 10s by
+	// default
+	ConnectionTimeout time.Duration
+}
+
+// Device is a connected remote device
+type Device struct {
+	a      *Adapter
+	connID uint8
+
+	Address Address
+}
+
+// Connect connects to a peripheral, as a central.
+func (a *Adapter) Connect(address Address, params ConnectionParams) (Device, error) {
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !a.enabled {
+		return Device{}, errBLENotEnabled
+	}
+
+	timeout := params.ConnectionTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	bdType := RPC_T_GAP_REMOTE_ADDR_TYPE(GAP_REMOTE_ADDR_LE_PUBLIC)
+	if address.IsRandom {
+		bdType = GAP_REMOTE_ADDR_LE_RANDOM
+	}
+
+	a.connecting = true
+	a.connected = -1
+	defer func() {
+		a.connecting = false
+	}()
+
+	scanTimeout := uint16(timeout / (10 * time.Millisecond))
+	if r.rpc_le_connect(GAP_PHYS_CONN_INIT_1M_BIT, address.MAC[:], bdType, GAP_LOCAL_ADDR_LE_PUBLIC, scanTimeout) != 0 {
+		return Device{}, errBLEFailed
+	}
+	if err := a.wait(timeout, func() bool { return a.connected != -1 }); err != nil {
+		return Device{}, err
+	}
+	if a.connected == -2 {
+		return Device{}, errBLEFailed
+	}
+
+	connID := uint8(a.connected)
+	if c, ok := a.conns[connID]; ok {
+		c.address = address
+		c.central = true
+	}
+	return Device{a: a, connID: connID, Address: address}, nil
+}
+
+// Disconnect closes the connection.
+func (d Device) Disconnect() error {
+	r := d.a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := d.a.conns[d.connID]; !ok {
+		return nil
+	}
+	if r.rpc_le_disconnect(d.connID) != 0 {
+		return errBLEFailed
+	}
+	return d.a.wait(bleTimeout, func() bool {
+		_, ok := d.a.conns[d.connID]
+		return !ok
+	})
+}
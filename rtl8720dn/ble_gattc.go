@@ -0,0 +1,362 @@
+package rtl8720dn
+
+import (
+	"errors"
+)
+
+var (
+	errServiceNotFound        = errors.New("bluetooth: service not found")
+	errCharacteristicNotFound = errors.New("bluetooth: characteristic not found")
+	errNoCCCD                 = errors.New("bluetooth: characteristic has no client configuration descriptor")
+)
+
+// GATT client, from profile_client.h
+const (
+	CLIENT_APP_CB_TYPE_DISC_STATE    = 0x00
+	CLIENT_APP_CB_TYPE_DISC_RESULT   = 0x01
+	CLIENT_APP_CB_TYPE_READ_RESULT   = 0x02
+	CLIENT_APP_CB_TYPE_WRITE_RESULT  = 0x03
+	CLIENT_APP_CB_TYPE_NOTIF_IND     = 0x04
+	CLIENT_APP_CB_TYPE_DISCONNECT_CB = 0x05
+
+	DISC_STATE_SRV_DONE             = 0x02
+	DISC_STATE_CHAR_DONE            = 0x06
+	DISC_STATE_CHAR_DESCRIPTOR_DONE = 0x0A
+	DISC_STATE_FAILED               = 0x0B
+
+	DISC_RESULT_ALL_SRV_UUID16   = 0x00
+	DISC_RESULT_ALL_SRV_UUID128  = 0x01
+	DISC_RESULT_CHAR_UUID16      = 0x03
+	DISC_RESULT_CHAR_UUID128     = 0x04
+	DISC_RESULT_CHAR_DESC_UUID16 = 0x05
+
+	GATT_WRITE_TYPE_REQ = 0x01
+	GATT_WRITE_TYPE_CMD = 0x02
+)
+
+// DeviceService is a service of a remote device
+type DeviceService struct {
+	device      Device
+	uuid        UUID
+	startHandle uint16
+	endHandle   uint16
+}
+
+// UUID returns the UUID of the service.
+func (s DeviceService) UUID() UUID {
+	return s.uuid
+}
+
+// DeviceCharacteristic is a characteristic of a remote device
+type DeviceCharacteristic struct {
+	device      Device
+	uuid        UUID
+	properties  uint8
+	valueHandle uint16
+	endHandle   uint16
+}
+
+// UUID returns the UUID of the characteristic.
+func (c DeviceCharacteristic) UUID() UUID {
+	return c.uuid
+}
+
+// bleDescriptor is a descriptor of a remote characteristic
+type bleDescriptor struct {
+	handle uint16
+	uuid   UUID
+}
+
+// gattRequest starts a GATT client procedure and waits for its completion.
+// It is called with r.mu locked.
+func (a *Adapter) gattRequest(connID uint8, start func() RPC_T_GAP_CAUSE) (*bleConn, error) {
+	c, ok := a.conns[connID]
+	if !ok {
+		return nil, errNotConnected
+	}
+	c.done, c.cause = false, 0
+	c.services, c.chars, c.descs, c.value = nil, nil, nil, nil
+
+	if start() != 0 {
+		return nil, errBLEFailed
+	}
+	if err := a.wait(bleTimeout, func() bool { return c.done }); err != nil {
+		return nil, err
+	}
+	if _, ok := a.conns[connID]; !ok {
+		return nil, errNotConnected
+	}
+	if c.cause != 0 {
+		return nil, errBLEFailed
+	}
+	return c, nil
+}
+
+// DiscoverServices returns the primary services of the device, filtered by
+// uuids if not empty.
+func (d Device) DiscoverServices(uuids []UUID) ([]DeviceService, error) {
+	a := d.a
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, err := a.gattRequest(d.connID, func() RPC_T_GAP_CAUSE {
+		return r.rpc_client_all_primary_srv_discovery(d.connID, a.clientID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var services []DeviceService
+	for _, s := range c.services {
+		if len(uuids) > 0 && !hasUUID(uuids, s.uuid) {
+			continue
+		}
+		s.device = d
+		services = append(services, s)
+	}
+	if len(services) < len(uuids) {
+		return services, errServiceNotFound
+	}
+	return services, nil
+}
+
+// DiscoverCharacteristics returns the characteristics of the service,
+// filtered by uuids if not empty.
+func (s DeviceService) DiscoverCharacteristics(uuids []UUID) ([]DeviceCharacteristic, error) {
+	d := s.device
+	a := d.a
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, err := a.gattRequest(d.connID, func() RPC_T_GAP_CAUSE {
+		return r.rpc_client_all_char_discovery(d.connID, a.clientID, s.startHandle, s.endHandle)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var chars []DeviceCharacteristic
+	for i, char := range c.chars {
+		// Descriptors of a characteristic end before the next declaration
+		char.endHandle = s.endHandle
+		if i+1 < len(c.chars) {
+			char.endHandle = c.chars[i+1].valueHandle - 2
+		}
+		if len(uuids) > 0 && !hasUUID(uuids, char.uuid) {
+			continue
+		}
+		char.device = d
+		chars = append(chars, char)
+	}
+	if len(chars) < len(uuids) {
+		return chars, errCharacteristicNotFound
+	}
+	return chars, nil
+}
+
+func hasUUID(uuids []UUID, uuid UUID) bool {
+	for _, u := range uuids {
+		if u == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads the value of the characteristic into data.
+func (c DeviceCharacteristic) Read(data []byte) (int, error) {
+	d := c.device
+	a := d.a
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, err := a.gattRequest(d.connID, func() RPC_T_GAP_CAUSE {
+		return r.rpc_client_attr_read(d.connID, a.clientID, c.valueHandle)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return copy(data, conn.value), nil
+}
+
+// Write writes the value of the characteristic, waiting for the answer of
+// the device.
+func (c DeviceCharacteristic) Write(p []byte) (int, error) {
+	d := c.device
+	a := d.a
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := c.write(GATT_WRITE_TYPE_REQ, c.valueHandle, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteWithoutResponse writes the value of the characteristic, without
+// waiting for an answer.
+func (c DeviceCharacteristic) WriteWithoutResponse(p []byte) (int, error) {
+	d := c.device
+	r := d.a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := d.a.conns[d.connID]; !ok {
+		return 0, errNotConnected
+	}
+	if r.rpc_client_attr_write(d.connID, d.a.clientID, GATT_WRITE_TYPE_CMD, c.valueHandle, p) != 0 {
+		return 0, errBLEFailed
+	}
+	return len(p), nil
+}
+
+// write writes an attribute with a write request. It is called with r.mu
+// locked.
+func (c DeviceCharacteristic) write(writeType RPC_T_GATT_WRITE_TYPE, handle uint16, p []byte) error {
+	d := c.device
+	a := d.a
+	_, err := a.gattRequest(d.connID, func() RPC_T_GAP_CAUSE {
+		return a.r.rpc_client_attr_write(d.connID, a.clientID, writeType, handle, p)
+	})
+	return err
+}
+
+// EnableNotifications subscribes to notifications, or indications, of the
+// characteristic. The callback is called with each new value. A nil
+// callback unsubscribes.
+func (c DeviceCharacteristic) EnableNotifications(callback func(buf []byte)) error {
+	d := c.device
+	a := d.a
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, err := a.gattRequest(d.connID, func() RPC_T_GAP_CAUSE {
+		return r.rpc_client_all_char_descriptor_discovery(d.connID, a.clientID, c.valueHandle+1, c.endHandle)
+	})
+	if err != nil {
+		return err
+	}
+
+	var cccd uint16
+	for _, desc := range conn.descs {
+		if desc.uuid == New16BitUUID(GATT_UUID_CHAR_CLIENT_CONFIG) {
+			cccd = desc.handle
+			break
+		}
+	}
+	if cccd == 0 {
+		return errNoCCCD
+	}
+
+	value := []byte{0x00, 0x00}
+	if callback != nil {
+		if CharacteristicPermissions(c.properties)&CharacteristicNotifyPermission != 0 {
+			value[0] = 0x01
+		} else {
+			value[0] = 0x02
+		}
+	}
+	if err := c.write(GATT_WRITE_TYPE_REQ, cccd, value); err != nil {
+		return err
+	}
+
+	if callback != nil {
+		conn.notify[c.valueHandle] = callback
+	} else {
+		delete(conn.notify, c.valueHandle)
+	}
+	return nil
+}
+
+// handleGattcCallback handles results of GATT client procedures, the type
+// being the first byte of cbData. Attribute values and discovery results
+// are in extra.
+func (a *Adapter) handleGattcCallback(connID uint8, cbData, extra []byte) {
+	c, ok := a.conns[connID]
+	if !ok || len(cbData) == 0 {
+		return
+	}
+	d := rpcDecoder{buf: cbData[1:]}
+	e := rpcDecoder{buf: extra}
+
+	switch cbData[0] {
+	case CLIENT_APP_CB_TYPE_DISC_STATE:
+		switch d.uint8() {
+		case DISC_STATE_SRV_DONE, DISC_STATE_CHAR_DONE, DISC_STATE_CHAR_DESCRIPTOR_DONE:
+			c.done = true
+		case DISC_STATE_FAILED:
+			c.done = true
+			c.cause = 1
+		}
+
+	case CLIENT_APP_CB_TYPE_DISC_RESULT:
+		switch d.uint8() {
+		case DISC_RESULT_ALL_SRV_UUID16:
+			start, end := e.uint16(), e.uint16()
+			c.services = append(c.services, DeviceService{
+				uuid:        New16BitUUID(e.uint16()),
+				startHandle: start,
+				endHandle:   end,
+			})
+		case DISC_RESULT_ALL_SRV_UUID128:
+			start, end := e.uint16(), e.uint16()
+			c.services = append(c.services, DeviceService{
+				uuid:        uuidFromBytes(e.buf),
+				startHandle: start,
+				endHandle:   end,
+			})
+		case DISC_RESULT_CHAR_UUID16:
+			e.uint16() // declaration handle
+			props, value := e.uint16(), e.uint16()
+			c.chars = append(c.chars, DeviceCharacteristic{
+				uuid:        New16BitUUID(e.uint16()),
+				properties:  uint8(props),
+				valueHandle: value,
+			})
+		case DISC_RESULT_CHAR_UUID128:
+			e.uint16() // declaration handle
+			props, value := e.uint16(), e.uint16()
+			c.chars = append(c.chars, DeviceCharacteristic{
+				uuid:        uuidFromBytes(e.buf),
+				properties:  uint8(props),
+				valueHandle: value,
+			})
+		case DISC_RESULT_CHAR_DESC_UUID16:
+			handle := e.uint16()
+			c.descs = append(c.descs, bleDescriptor{
+				handle: handle,
+				uuid:   New16BitUUID(e.uint16()),
+			})
+		}
+
+	case CLIENT_APP_CB_TYPE_READ_RESULT:
+		c.cause = d.uint16()
+		c.value = append([]byte(nil), extra...)
+		c.done = true
+
+	case CLIENT_APP_CB_TYPE_WRITE_RESULT:
+		c.cause = d.uint16()
+		c.done = true
+
+	case CLIENT_APP_CB_TYPE_NOTIF_IND:
+		notify := d.uint8() == 1
+		handle := d.uint16()
+		value := append([]byte(nil), extra...)
+		cb := c.notify[handle]
+		a.events = append(a.events, func() {
+			if !notify {
+				a.r.mu.Lock()
+				a.r.rpc_client_attr_ind_confirm(connID)
+				a.r.mu.Unlock()
+			}
+			if cb != nil {
+				cb(value)
+			}
+		})
+	}
+}
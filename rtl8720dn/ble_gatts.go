@@ -0,0 +1,203 @@
+package rtl8720dn
+
+import (
+	"errors"
+)
+
+var errTooManyServices = errors.New("bluetooth: too many services")
+
+// GATT server, from profile_server.h
+const (
+	SERVICE_CALLBACK_TYPE_INDIFICATION_NOTIFICATION = 0x01
+	SERVICE_CALLBACK_TYPE_READ_CHAR_VALUE           = 0x02
+	SERVICE_CALLBACK_TYPE_WRITE_CHAR_VALUE          = 0x03
+
+	GATT_PERM_READ  = 0x00000001
+	GATT_PERM_WRITE = 0x00000010
+
+	GATT_PDU_TYPE_NOTIFICATION = 0x01
+	GATT_PDU_TYPE_INDICATION   = 0x02
+
+	ATTRIB_FLAG_VALUE_INCL = 0x02
+	ATTRIB_FLAG_CCCD_APPL  = 0x10
+
+	GATT_UUID_CHAR_CLIENT_CONFIG = 0x2902
+)
+
+// bleMaxServices is the number of services of the GATT server
+const bleMaxServices = 8
+
+// CharacteristicPermissions are the properties of a characteristic
+type CharacteristicPermissions uint8
+
+const (
+	CharacteristicBroadcastPermission CharacteristicPermissions = 1 << iota
+	CharacteristicReadPermission
+	CharacteristicWriteWithoutResponsePermission
+	CharacteristicWritePermission
+	CharacteristicNotifyPermission
+	CharacteristicIndicatePermission
+)
+
+// Connection is the handle of a connection, in the peripheral role
+type Connection uint16
+
+// Service is a service of the GATT server
+type Service struct {
+	UUID            UUID
+	Characteristics []CharacteristicConfig
+
+	adapter   *Adapter
+	appID     uint8
+	serviceID uint8
+	chars     []*Characteristic
+}
+
+// CharacteristicConfig configures a characteristic of a service
+type CharacteristicConfig struct {
+	// Handle, if not nil, is set to the characteristic, for updates
+	Handle *Characteristic
+	UUID   UUID
+	Value  []byte
+	Flags  CharacteristicPermissions
+	// WriteEvent is called when a client writes the characteristic
+	WriteEvent func(client Connection, offset int, value []byte)
+}
+
+// Characteristic is a characteristic of the GATT server
+type Characteristic struct {
+	service    *Service
+	index      uint16
+	flags      CharacteristicPermissions
+	value      []byte
+	writeEvent func(client Connection, offset int, value []byte)
+}
+
+// AddService creates a service of the GATT server and its
+// characteristics.
+func (a *Adapter) AddService(service *Service) error {
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !a.enabled {
+		return errBLENotEnabled
+	}
+	if len(a.services) == bleMaxServices {
+		return errTooManyServices
+	}
+
+	uuid, n := service.UUID.rpcUUID()
+	service.adapter = a
+	service.appID = r.rpc_ble_create_service(uuid, n, true)
+
+	service.chars = service.chars[:0]
+	for i := range service.Characteristics {
+		cfg := &service.Characteristics[i]
+		char := cfg.Handle
+		if char == nil {
+			char = &Characteristic{}
+		}
+		*char = Characteristic{
+			service:    service,
+			flags:      cfg.Flags,
+			value:      append([]byte(nil), cfg.Value...),
+			writeEvent: cfg.WriteEvent,
+		}
+
+		var perms uint32
+		if cfg.Flags&CharacteristicReadPermission != 0 {
+			perms |= GATT_PERM_READ
+		}
+		if cfg.Flags&(CharacteristicWritePermission|CharacteristicWriteWithoutResponsePermission) != 0 {
+			perms |= GATT_PERM_WRITE
+		}
+		uuid, n := cfg.UUID.rpcUUID()
+		char.index = r.rpc_ble_create_char(service.appID, uuid, n, uint8(cfg.Flags), perms)
+
+		if cfg.Flags&(CharacteristicNotifyPermission|CharacteristicIndicatePermission) != 0 {
+			cccd, n := New16BitUUID(GATT_UUID_CHAR_CLIENT_CONFIG).rpcUUID()
+			r.rpc_ble_create_desc(service.appID, char.index, cccd, n,
+				ATTRIB_FLAG_VALUE_INCL|ATTRIB_FLAG_CCCD_APPL, GATT_PERM_READ|GATT_PERM_WRITE, 2, []byte{0, 0})
+		}
+		service.chars = append(service.chars, char)
+	}
+
+	service.serviceID = r.rpc_ble_service_start(service.appID)
+	a.services = append(a.services, service)
+	return nil
+}
+
+// Write updates the value of the characteristic, and notifies connected
+// clients if the characteristic has the notify or indicate property.
+func (c *Characteristic) Write(p []byte) (int, error) {
+	if c.service == nil {
+		return 0, errBLENotEnabled
+	}
+	a := c.service.adapter
+	r := a.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c.value = append(c.value[:0], p...)
+
+	var pdu RPC_T_GATT_PDU_TYPE
+	switch {
+	case c.flags&CharacteristicNotifyPermission != 0:
+		pdu = GATT_PDU_TYPE_NOTIFICATION
+	case c.flags&CharacteristicIndicatePermission != 0:
+		pdu = GATT_PDU_TYPE_INDICATION
+	default:
+		return len(p), nil
+	}
+	for connID, conn := range a.conns {
+		if conn.state == GAP_CONN_STATE_CONNECTED && !conn.central {
+			r.rpc_server_send_data(connID, c.service.serviceID, c.index, p, pdu)
+		}
+	}
+	return len(p), nil
+}
+
+// findCharacteristic returns the characteristic of a service with the
+// given attribute index
+func (a *Adapter) findCharacteristic(serviceID uint8, index uint16) *Characteristic {
+	for _, s := range a.services {
+		if s.serviceID != serviceID {
+			continue
+		}
+		for _, c := range s.chars {
+			if c.index == index {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// handleGattsCallback handles requests of clients to the GATT server. It
+// returns the value to answer read requests.
+func (a *Adapter) handleGattsCallback(serviceID, connID uint8, index uint16, event uint32, data []byte) []byte {
+	char := a.findCharacteristic(serviceID, index)
+	if char == nil {
+		return nil
+	}
+
+	switch event {
+	case SERVICE_CALLBACK_TYPE_READ_CHAR_VALUE:
+		if char.value == nil {
+			return []byte{}
+		}
+		return char.value
+
+	case SERVICE_CALLBACK_TYPE_WRITE_CHAR_VALUE:
+		char.value = append(char.value[:0], data...)
+		if char.writeEvent != nil {
+			value := append([]byte(nil), data...)
+			writeEvent := char.writeEvent
+			a.events = append(a.events, func() {
+				writeEvent(Connection(connID), 0, value)
+			})
+		}
+	}
+	return nil
+}
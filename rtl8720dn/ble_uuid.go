@@ -0,0 +1,131 @@
+package rtl8720dn
+
+import (
+	"errors"
+)
+
+var errInvalidUUID = errors.New("bluetooth: failed to parse UUID")
+
+// UUID is a 128 bits Bluetooth UUID, stored as little endian 32 bits words,
+// as in tinygo.org/x/bluetooth.
+type UUID [4]uint32
+
+// baseUUID is the base of 16 bits UUIDs: 00000000-0000-1000-8000-00805F9B34FB
+var baseUUID = UUID{0x5F9B34FB, 0x80000080, 0x00001000, 0x00000000}
+
+// NewUUID returns a UUID from its 16 bytes big endian representation.
+func NewUUID(uuid [16]byte) UUID {
+	var u UUID
+	for i := 0; i < 4; i++ {
+		j := 12 - 4*i
+		u[i] = uint32(uuid[j])<<24 | uint32(uuid[j+1])<<16 | uint32(uuid[j+2])<<8 | uint32(uuid[j+3])
+	}
+	return u
+}
+
+// New16BitUUID returns a UUID from a 16 bits UUID assigned by the Bluetooth
+// SIG.
+func New16BitUUID(shortUUID uint16) UUID {
+	u := baseUUID
+	u[3] = uint32(shortUUID)
+	return u
+}
+
+// ParseUUID parses a UUID in the usual string format, like
+// "6e400001-b5a3-f393-e0a9-e50e24dcca9e".
+func ParseUUID(s string) (UUID, error) {
+	var b [16]byte
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '-' {
+			continue
+		}
+		if n == 32 {
+			return UUID{}, errInvalidUUID
+		}
+		v, ok := fromHexDigit(s[i])
+		if !ok {
+			return UUID{}, errInvalidUUID
+		}
+		b[n/2] |= v << (4 * (1 - n%2))
+		n++
+	}
+	if n != 32 {
+		return UUID{}, errInvalidUUID
+	}
+	return NewUUID(b), nil
+}
+
+func fromHexDigit(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// Is16Bit reports if the UUID is a 16 bits UUID.
+func (u UUID) Is16Bit() bool {
+	return u[0] == baseUUID[0] && u[1] == baseUUID[1] && u[2] == baseUUID[2] && u[3] <= 0xFFFF
+}
+
+// Get16Bit returns the 16 bits value of a 16 bits UUID.
+func (u UUID) Get16Bit() uint16 {
+	return uint16(u[3])
+}
+
+// Bytes returns the 16 bytes little endian representation of the UUID, as
+// used over the air.
+func (u UUID) Bytes() [16]byte {
+	var b [16]byte
+	for i, w := range u {
+		b[4*i] = byte(w)
+		b[4*i+1] = byte(w >> 8)
+		b[4*i+2] = byte(w >> 16)
+		b[4*i+3] = byte(w >> 24)
+	}
+	return b
+}
+
+// String returns the UUID in the usual string format.
+func (u UUID) String() string {
+	const hexDigits = "0123456789abcdef"
+	b := u.Bytes()
+	s := make([]byte, 0, 36)
+	for i := 15; i >= 0; i-- {
+		s = append(s, hexDigits[b[i]>>4], hexDigits[b[i]&0x0F])
+		if i == 12 || i == 10 || i == 8 || i == 6 {
+			s = append(s, '-')
+		}
+	}
+	return string(s)
+}
+
+// rpcUUID returns the UUID as expected by the rtl8720dn: 16 bytes, holding
+// 2 bytes of a 16 bits UUID, and its length
+func (u UUID) rpcUUID() ([]uint8, uint8) {
+	b := u.Bytes()
+	if u.Is16Bit() {
+		var s [16]byte
+		s[0], s[1] = b[12], b[13]
+		return s[:], 2
+	}
+	return b[:], 16
+}
+
+// uuidFromBytes returns a UUID from its 16 bytes little endian
+// representation, or the zero UUID if b is too short.
+func uuidFromBytes(b []byte) UUID {
+	var u UUID
+	if len(b) < 16 {
+		return u
+	}
+	for i := range u {
+		u[i] = uint32(b[4*i]) | uint32(b[4*i+1])<<8 | uint32(b[4*i+2])<<16 | uint32(b[4*i+3])<<24
+	}
+	return u
+}
@@ -0,0 +1,127 @@
+package rtl8720dn
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Callbacks of the rpc_ble_callback interface, invoked by the rtl8720dn
+const (
+	rpcBleCallbackService = 0x0D
+
+	rpcBleHandleGapMsg  = 0x01
+	rpcBleGapCallback   = 0x02
+	rpcBleGattcCallback = 0x03
+	rpcBleGattsCallback = 0x04
+)
+
+const (
+	APP_RESULT_SUCCESS = 0x00
+)
+
+// rpcDecoder reads the parameters of an invocation. Reading past the end of
+// the message returns zero values.
+type rpcDecoder struct {
+	buf []byte
+}
+
+func (d *rpcDecoder) uint8() uint8 {
+	if len(d.buf) < 1 {
+		d.buf = nil
+		return 0
+	}
+	v := d.buf[0]
+	d.buf = d.buf[1:]
+	return v
+}
+
+func (d *rpcDecoder) uint16() uint16 {
+	if len(d.buf) < 2 {
+		d.buf = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+func (d *rpcDecoder) uint32() uint32 {
+	if len(d.buf) < 4 {
+		d.buf = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v
+}
+
+func (d *rpcDecoder) binary() []byte {
+	n := int(d.uint32())
+	if len(d.buf) < n {
+		d.buf = nil
+		return nil
+	}
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *rpcDecoder) nullableBinary() []byte {
+	if d.uint8() == 1 {
+		return nil
+	}
+	return d.binary()
+}
+
+// handleInvocation answers a callback invoked by the rtl8720dn, msg being
+// the whole message, header included. It is called from read(), with r.mu
+// locked: handlers must not make requests to the rtl8720dn.
+func (r *rtl8720dn) handleInvocation(msg []byte) {
+	if len(msg) < 8 {
+		return
+	}
+	request, service := msg[1], msg[2]
+	seq := binary.LittleEndian.Uint32(msg[4:])
+	d := rpcDecoder{buf: msg[8:]}
+
+	if r.debug {
+		fmt.Printf("invocation %02X:%02X\r\n", service, request)
+	}
+
+	out := startWriteMessage(invocationMessage, uint32(service), uint32(request), seq)
+	result := uint32(APP_RESULT_SUCCESS)
+
+	if service == rpcBleCallbackService && r.ble != nil {
+		a := r.ble
+		switch request {
+		case rpcBleHandleGapMsg:
+			a.handleGapMsg(d.binary())
+		case rpcBleGapCallback:
+			cbType := d.uint8()
+			a.handleGapCallback(cbType, d.binary())
+		case rpcBleGattcCallback:
+			d.uint8() // gatt_if
+			connID := d.uint8()
+			cbData := d.binary()
+			a.handleGattcCallback(connID, cbData, d.binary())
+		case rpcBleGattsCallback:
+			serviceID := d.uint8() // gatt_if
+			connID := d.uint8()
+			index := d.uint16()
+			event := d.uint32()
+			d.uint16() // property
+			value := a.handleGattsCallback(serviceID, connID, index, event, d.nullableBinary())
+			// read_cb_data : out []byte nullable
+			if value == nil {
+				out = append(out, 1)
+			} else {
+				out = append(out, 0)
+				out = append(out, byte(len(value)), byte(len(value)>>8), byte(len(value)>>16), byte(len(value)>>24))
+				out = append(out, value...)
+			}
+		}
+	}
+
+	out = append(out, byte(result), byte(result>>8), byte(result>>16), byte(result>>24))
+	r.reply(out)
+}
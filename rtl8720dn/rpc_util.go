@@ -63,7 +63,32 @@ func dumpHex(b []byte) {
 	}
 }
 
+const (
+	invocationMessage = 0x00
+	replyMessage      = 0x02
+)
+
+// read waits for the reply of the current request. Invocations of
+// callbacks by the rtl8720dn are handled meanwhile.
 func (r *rtl8720dn) read() {
+	for {
+		if r.readMessage() == replyMessage {
+			return
+		}
+	}
+}
+
+// poll handles the messages already sent by the rtl8720dn, without waiting
+// for a reply
+func (r *rtl8720dn) poll() {
+	for r.uart.Buffered() > 0 {
+		r.readMessage()
+	}
+}
+
+// readMessage reads a message into payload and returns its type.
+// Invocations are answered before returning.
+func (r *rtl8720dn) readMessage() uint8 {
 	for {
 		n, _ := io.ReadFull(r.uart, readBuf[:4])
 		if n == 0 {
@@ -92,8 +117,16 @@ func (r *rtl8720dn) read() {
 		if g, e := crcNew, crc; g != e {
 			fmt.Printf("err CRC16: got %04X want %04X\r\n", g, e)
 		}
-		if payload[0] == 0x02 || payload[0] == 0x00 {
-			return
+		if payload[0] == invocationMessage {
+			r.handleInvocation(payload[:n])
 		}
+		return payload[0]
 	}
 }
+
+// reply answers an invocation by the rtl8720dn, msg being the header
+// of the invocation followed by the out parameters and the result
+func (r *rtl8720dn) reply(msg []byte) {
+	msg[0] = replyMessage
+	r.performRequest(msg)
+}
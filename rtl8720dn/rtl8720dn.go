@@ -6,6 +6,7 @@
 package rtl8720dn // import "tinygo.org/x/drivers/rtl8720dn"
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -40,10 +41,32 @@ const (
 type sock int32
 
 type socket struct {
-	protocol int
-	inuse    bool
+	protocol  int
+	inuse     bool
+	connected bool
+	// options of a TLS socket, applied once connected
+	opts []sockOpt
 }
 
+type sockOpt struct {
+	level int32
+	name  int32
+	value int32
+}
+
+// lwIP socket options
+const (
+	LWIP_SOL_SOCKET    = 0xFFF
+	LWIP_SO_KEEPALIVE  = 0x0008
+	LWIP_SO_BROADCAST  = 0x0020
+	LWIP_SO_RCVBUF     = 0x1002
+	LWIP_IPPROTO_TCP   = 0x06
+	LWIP_TCP_NODELAY   = 0x01
+	LWIP_TCP_KEEPIDLE  = 0x03
+	LWIP_TCP_KEEPINTVL = 0x04
+	LWIP_TCP_KEEPCNT   = 0x05
+)
+
 type Config struct {
 	// Enable
 	En machine.Pin
@@ -72,9 +95,14 @@ type rtl8720dn struct {
 	deviceShown  bool
 
 	killWatchdog chan bool
+	rssiMonitor  netlink.RssiMonitor
+
+	tlsConfig *netdev.TLSConfig
 
 	// keyed by sock as returned by rpc_lwip_socket()
 	sockets map[sock]*socket
+
+	ble *Adapter
 }
 
 func newSocket(protocol int) *socket {
@@ -133,7 +161,40 @@ func (r *rtl8720dn) connectToAP() error {
 		r.notifyCb(netlink.EventNetUp)
 	}
 
-	return r.startDhcpc()
+	return r.configureIP()
+}
+
+// configureIP sets the hostname, then starts the DHCP client or sets the
+// static IP address, and sets the DNS servers, if any
+func (r *rtl8720dn) configureIP() error {
+	if r.params.Hostname != "" {
+		r.rpc_tcpip_adapter_set_hostname(0, r.params.Hostname)
+	}
+
+	if !r.params.IP.IsValid() {
+		if err := r.startDhcpc(); err != nil {
+			return err
+		}
+	} else {
+		r.rpc_tcpip_adapter_dhcpc_stop(0)
+		// tcpip_adapter_ip_info_t: ip, netmask, gw
+		var ipInfo []byte
+		ipInfo = append(ipInfo, r.params.IP.AsSlice()...)
+		ipInfo = append(ipInfo, r.params.Subnet.AsSlice()...)
+		ipInfo = append(ipInfo, r.params.Gateway.AsSlice()...)
+		if result := r.rpc_tcpip_adapter_set_ip_info(0, ipInfo); result == -1 {
+			return fmt.Errorf("Set IP info failed")
+		}
+	}
+
+	// DNS types are TCPIP_ADAPTER_DNS_MAIN (0) and _BACKUP (1)
+	for i, dns := range r.params.DNS {
+		if result := r.rpc_tcpip_adapter_set_dns_info(0, uint32(i), dns.AsSlice()); result == -1 {
+			return fmt.Errorf("Set DNS info failed")
+		}
+	}
+
+	return nil
 }
 
 func (r *rtl8720dn) startDhcps() error {
@@ -215,7 +276,22 @@ func (r *rtl8720dn) setupUART() {
 		RX: r.cfg.Rx, BaudRate: r.cfg.Baudrate})
 }
 
+// bleEnabled reports if the Bluetooth adapter is in use, in which case the
+// rtl8720dn must stay powered on
+func (r *rtl8720dn) bleEnabled() bool {
+	return r.ble != nil && r.ble.enabled
+}
+
 func (r *rtl8720dn) start() error {
+	if !r.bleEnabled() {
+		if err := r.powerOn(); err != nil {
+			return err
+		}
+	}
+	return r.initWifi()
+}
+
+func (r *rtl8720dn) powerOn() error {
 	en := r.cfg.En
 	if en == 0 {
 		return fmt.Errorf("Must set Config.En")
@@ -226,12 +302,14 @@ func (r *rtl8720dn) start() error {
 	en.High()
 	time.Sleep(1000 * time.Millisecond)
 	r.setupUART()
-	return r.initWifi()
+	return nil
 }
 
 func (r *rtl8720dn) stop() {
 	r.rpc_tcpip_adapter_stop(0)
-	r.cfg.En.Low()
+	if !r.bleEnabled() {
+		r.cfg.En.Low()
+	}
 }
 
 func (r *rtl8720dn) showDevice() {
@@ -249,10 +327,14 @@ func (r *rtl8720dn) showDevice() {
 func (r *rtl8720dn) showIP() {
 	if debugging(debugBasic) {
 		ip, subnet, gateway, _ := r.getIP()
+		assigned := "DHCP-assigned"
+		if r.params.IP.IsValid() {
+			assigned = "Static"
+		}
 		fmt.Printf("\r\n")
-		fmt.Printf("DHCP-assigned IP         : %s\r\n", ip)
-		fmt.Printf("DHCP-assigned subnet     : %s\r\n", subnet)
-		fmt.Printf("DHCP-assigned gateway    : %s\r\n", gateway)
+		fmt.Printf("%-25s: %s\r\n", assigned+" IP", ip)
+		fmt.Printf("%-25s: %s\r\n", assigned+" subnet", subnet)
+		fmt.Printf("%-25s: %s\r\n", assigned+" gateway", gateway)
 		fmt.Printf("\r\n")
 	}
 }
@@ -278,6 +360,12 @@ func (r *rtl8720dn) watchdog() {
 					r.notifyCb(netlink.EventNetDown)
 				}
 				r.netConnect(false)
+			} else if r.params.RssiThreshold != 0 {
+				var rssi int32
+				r.rpc_wifi_get_rssi(&rssi)
+				if event, ok := r.rssiMonitor.Update(r.params.RssiThreshold, int(rssi)); ok && r.notifyCb != nil {
+					r.notifyCb(event)
+				}
 			}
 			r.mu.Unlock()
 		}
@@ -332,6 +420,10 @@ func (r *rtl8720dn) NetConnect(params *netlink.ConnectParams) error {
 		return netlink.ErrConnected
 	}
 
+	if err := params.CheckIPConfig(); err != nil {
+		return err
+	}
+
 	r.params = params
 
 	r.showDriver()
@@ -383,6 +475,130 @@ func (r *rtl8720dn) NetNotify(cb func(netlink.Event)) {
 	r.notifyCb = cb
 }
 
+const (
+	// maxScanResults fits the records of a scan in a message
+	maxScanResults = 20
+
+	// Size of wifi_ap_record_t, as returned by
+	// rpc_wifi_scan_get_ap_records(): bssid[6], ssid[33], primary channel
+	// at 39, rssi at 44, authmode at 48
+	apRecordSize = 80
+)
+
+func (r *rtl8720dn) NetScan() ([]netlink.ScanResult, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[NetScan]\r\n")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.uart == nil {
+		r.showDriver()
+		if err := r.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	if result := r.rpc_wifi_scan_start(); result != 0 {
+		return nil, netlink.ErrScanFailed
+	}
+	for start := time.Now(); r.rpc_wifi_is_scaning(); {
+		if time.Since(start) > 10*time.Second {
+			return nil, netlink.ErrScanFailed
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	n := r.rpc_wifi_scan_get_ap_num()
+	if n > maxScanResults {
+		n = maxScanResults
+	}
+	records := make([]byte, int(n)*apRecordSize)
+	if result := r.rpc_wifi_scan_get_ap_records(n, records); result != 0 {
+		return nil, netlink.ErrScanFailed
+	}
+
+	results := make([]netlink.ScanResult, n)
+	for i := range results {
+		rec := records[i*apRecordSize : (i+1)*apRecordSize]
+		ssid := rec[6:39]
+		if end := bytes.IndexByte(ssid, 0); end != -1 {
+			ssid = ssid[:end]
+		}
+		results[i] = netlink.ScanResult{
+			Ssid:     string(ssid),
+			Bssid:    net.HardwareAddr(append([]byte(nil), rec[0:6]...)),
+			Rssi:     int(int8(rec[44])),
+			Channel:  int(rec[39]),
+			AuthType: authType(rec[48]),
+		}
+	}
+
+	return results, nil
+}
+
+func (r *rtl8720dn) GetLinkInfo() (netlink.LinkInfo, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[GetLinkInfo]\r\n")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.netConnected {
+		return netlink.LinkInfo{}, netlink.ErrNotConnected
+	}
+
+	info := netlink.LinkInfo{
+		Ssid: r.params.Ssid,
+	}
+
+	var bssid [6]uint8
+	if r.rpc_wifi_get_ap_bssid(bssid[:]) == 0 {
+		info.Bssid = net.HardwareAddr(bssid[:])
+	}
+
+	var channel, rssi int32
+	r.rpc_wifi_get_channel(&channel)
+	r.rpc_wifi_get_rssi(&rssi)
+	info.Channel = int(channel)
+	info.Rssi = int(rssi)
+
+	var err error
+	info.IP, info.Subnet, info.Gateway, err = r.getIP()
+	if err != nil {
+		return netlink.LinkInfo{}, err
+	}
+
+	return info, nil
+}
+
+// authType converts a wifi_auth_mode_t
+func authType(mode uint8) netlink.AuthType {
+	switch mode {
+	case 0: // WIFI_AUTH_OPEN
+		return netlink.AuthTypeOpen
+	case 1: // WIFI_AUTH_WEP
+		return netlink.AuthTypeWEP
+	case 2: // WIFI_AUTH_WPA_PSK
+		return netlink.AuthTypeWPA
+	case 3: // WIFI_AUTH_WPA2_PSK
+		return netlink.AuthTypeWPA2
+	case 4: // WIFI_AUTH_WPA_WPA2_PSK
+		return netlink.AuthTypeWPA2Mixed
+	case 5: // WIFI_AUTH_WPA2_ENTERPRISE
+		return netlink.AuthTypeUnknown
+	case 6: // WIFI_AUTH_WPA3_PSK
+		return netlink.AuthTypeWPA3
+	case 7: // WIFI_AUTH_WPA2_WPA3_PSK
+		return netlink.AuthTypeWPA3Mixed
+	}
+	return netlink.AuthTypeUnknown
+}
+
 func (r *rtl8720dn) GetHostByName(name string) (netip.Addr, error) {
 
 	if debugging(debugNetdev) {
@@ -439,9 +655,62 @@ func (r *rtl8720dn) clientTLS() uint32 {
 	client := r.rpc_wifi_ssl_client_create()
 	r.rpc_wifi_ssl_init(client)
 	r.rpc_wifi_ssl_set_timeout(client, 120*1000 /* usec? */)
+	if cfg := r.tlsConfig; cfg != nil {
+		if len(cfg.RootCAs) > 0 && !cfg.InsecureSkipVerify {
+			r.rpc_wifi_ssl_set_rootCA(client, string(cfg.RootCAs))
+		}
+		if len(cfg.Certificate) > 0 {
+			r.rpc_wifi_ssl_set_cliCert(client, string(cfg.Certificate))
+		}
+		if len(cfg.PrivateKey) > 0 {
+			r.rpc_wifi_ssl_set_cliKey(client, string(cfg.PrivateKey))
+		}
+	}
 	return client
 }
 
+func (r *rtl8720dn) SetTLSConfig(config *netdev.TLSConfig) error {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[SetTLSConfig]\r\n")
+	}
+
+	if config != nil {
+		// SNI is the host name given to Connect
+		if len(config.ServerName) > 0 {
+			return netdev.ErrNotSupported
+		}
+		// The firmware has no built-in CAs: the server is verified
+		// with RootCAs, or its fingerprint
+		if len(config.RootCAs) == 0 && len(config.ServerFingerprint) == 0 &&
+			!config.InsecureSkipVerify {
+			return netdev.ErrNotSupported
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tlsConfig = config
+	return nil
+}
+
+// verifyTLS checks the fingerprint of the server certificate, if pinned
+func (r *rtl8720dn) verifyTLS(client uint32, host string) error {
+	cfg := r.tlsConfig
+	if cfg == nil || len(cfg.ServerFingerprint) == 0 {
+		return nil
+	}
+	// The domain name, if any, is also checked against the certificate
+	if cfg.InsecureSkipVerify {
+		host = ""
+	}
+	if !r.rpc_wifi_verify_ssl_fingerprint(client, hex.EncodeToString(cfg.ServerFingerprint), host) {
+		return netdev.ErrTLSVerifyFailed
+	}
+	return nil
+}
+
 // See man socket(2) for standard Berkely sockets for Socket, Bind, etc.
 // The driver strives to meet the function and semantics of socket(2).
 
@@ -568,8 +837,21 @@ func (r *rtl8720dn) Connect(sockfd int, host string, ip netip.AddrPort) error {
 		if result == -1 {
 			return fmt.Errorf("Connect to %s:%d failed", host, port)
 		}
+		if err := r.verifyTLS(uint32(sock), host); err != nil {
+			r.rpc_wifi_stop_ssl_socket(uint32(sock))
+			return err
+		}
+		// Options of TLS sockets apply to the underlying TCP socket
+		s := r.rpc_wifi_ssl_get_socket(uint32(sock))
+		for _, opt := range socket.opts {
+			if err := r.setSockOpt(s, opt); err != nil {
+				return err
+			}
+		}
 	}
 
+	socket.connected = true
+
 	return nil
 }
 
@@ -823,13 +1105,81 @@ func (r *rtl8720dn) Close(sockfd int) error {
 	return nil
 }
 
+// lwipSockOpt returns the lwIP level and name of a socket option
+func lwipSockOpt(level int, opt int) (int32, int32, bool) {
+	switch level {
+	case netdev.SOL_SOCKET:
+		switch opt {
+		case netdev.SO_KEEPALIVE:
+			return LWIP_SOL_SOCKET, LWIP_SO_KEEPALIVE, true
+		case netdev.SO_BROADCAST:
+			return LWIP_SOL_SOCKET, LWIP_SO_BROADCAST, true
+		case netdev.SO_RCVBUF:
+			return LWIP_SOL_SOCKET, LWIP_SO_RCVBUF, true
+		}
+	case netdev.SOL_TCP:
+		switch opt {
+		case netdev.TCP_NODELAY:
+			return LWIP_IPPROTO_TCP, LWIP_TCP_NODELAY, true
+		case netdev.TCP_KEEPIDLE:
+			return LWIP_IPPROTO_TCP, LWIP_TCP_KEEPIDLE, true
+		case netdev.TCP_KEEPINTVL:
+			return LWIP_IPPROTO_TCP, LWIP_TCP_KEEPINTVL, true
+		case netdev.TCP_KEEPCNT:
+			return LWIP_IPPROTO_TCP, LWIP_TCP_KEEPCNT, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (r *rtl8720dn) SockOptSupported(level int, opt int) bool {
+	_, _, ok := lwipSockOpt(level, opt)
+	return ok
+}
+
 func (r *rtl8720dn) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
 
 	if debugging(debugNetdev) {
-		fmt.Printf("[SetSockOpt] sockfd: %d\r\n", sockfd)
+		fmt.Printf("[SetSockOpt] sockfd: %d, level: %d, opt: %d, value: %v\r\n",
+			sockfd, level, opt, value)
 	}
 
-	return netdev.ErrNotSupported
+	lwipLevel, lwipName, ok := lwipSockOpt(level, opt)
+	if !ok {
+		return netdev.ErrNotSupported
+	}
+	v, err := netdev.SockOptInt(value)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sock = sock(sockfd)
+	socket, ok := r.sockets[sock]
+	if !ok || !socket.inuse {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	o := sockOpt{level: lwipLevel, name: lwipName, value: int32(v)}
+	if socket.protocol == netdev.IPPROTO_TLS {
+		if !socket.connected {
+			socket.opts = append(socket.opts, o)
+			return nil
+		}
+		return r.setSockOpt(r.rpc_wifi_ssl_get_socket(uint32(sock)), o)
+	}
+
+	return r.setSockOpt(int32(sock), o)
+}
+
+func (r *rtl8720dn) setSockOpt(s int32, o sockOpt) error {
+	optval := []byte{byte(o.value), byte(o.value >> 8), byte(o.value >> 16), byte(o.value >> 24)}
+	if result := r.rpc_lwip_setsockopt(s, o.level, o.name, optval, uint32(len(optval))); result == -1 {
+		return fmt.Errorf("Setting socket option %d failed", o.name)
+	}
+	return nil
 }
 
 func (r *rtl8720dn) disconnect() error {
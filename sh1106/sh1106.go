@@ -6,13 +6,14 @@ package sh1106 // import "tinygo.org/x/drivers/sh1106"
 import (
 	"errors"
 	"image/color"
-	"machine"
 	"time"
 
 	"tinygo.org/x/drivers"
 	"tinygo.org/x/drivers/internal/legacy"
 )
 
+var errOutOfRange = errors.New("out of screen range")
+
 // Device wraps an SPI connection.
 type Device struct {
 	bus        Buser
@@ -22,6 +23,14 @@ type Device struct {
 	height     int16
 	bufferSize int16
 	vccState   VccMode
+
+	// The area of the buffer changed since the last Display, from column
+	// dirtyX0 to dirtyX1 and page (8 pixel high row) dirtyPage0 to dirtyPage1
+	// included. It's empty when dirtyX0 > dirtyX1.
+	dirtyX0    int16
+	dirtyX1    int16
+	dirtyPage0 int16
+	dirtyPage1 int16
 }
 
 // Config is the configuration for the display
@@ -37,13 +46,6 @@ type I2CBus struct {
 	Address uint16
 }
 
-type SPIBus struct {
-	wire     drivers.SPI
-	dcPin    machine.Pin
-	resetPin machine.Pin
-	csPin    machine.Pin
-}
-
 type Buser interface {
 	configure()
 	tx(data []byte, isCommand bool)
@@ -62,21 +64,6 @@ func NewI2C(bus drivers.I2C) Device {
 	}
 }
 
-// NewSPI creates a new SH1106 connection. The SPI wire must already be configured.
-func NewSPI(bus drivers.SPI, dcPin, resetPin, csPin machine.Pin) Device {
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	resetPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	csPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	return Device{
-		bus: &SPIBus{
-			wire:     bus,
-			dcPin:    dcPin,
-			resetPin: resetPin,
-			csPin:    csPin,
-		},
-	}
-}
-
 // Configure initializes the display with default configuration
 func (d *Device) Configure(cfg Config) {
 	if cfg.Width != 0 {
@@ -99,6 +86,7 @@ func (d *Device) Configure(cfg Config) {
 	}
 	d.bufferSize = d.width * d.height / 8
 	d.buffer = make([]byte, d.bufferSize)
+	d.setAllDirty()
 
 	d.bus.configure()
 
@@ -168,20 +156,32 @@ func (d *Device) Configure(cfg Config) {
 // ClearBuffer clears the image buffer
 func (d *Device) ClearBuffer() {
 	for i := int16(0); i < d.bufferSize; i++ {
-		d.buffer[i] = 0
+		if d.buffer[i] != 0 {
+			d.buffer[i] = 0
+			d.setDirty(i%d.width, i/d.width)
+		}
 	}
 }
 
 // ClearDisplay clears the image buffer and clear the display
 func (d *Device) ClearDisplay() {
 	d.ClearBuffer()
+	d.setAllDirty()
 	d.Display()
 }
 
-// Display sends the whole buffer to the screen
+// Display sends the buffer to the screen. Only the area changed since the
+// last call is sent, if any.
 func (d *Device) Display() error {
-	// In the 128x64 (SPI) screen resetting to 0x0 after 128 times corrupt the buffer
-	// Since we're printing the whole buffer, avoid resetting it
+	if d.dirtyX0 > d.dirtyX1 {
+		return nil
+	}
+	x0, x1, page0, page1 := d.dirtyX0, d.dirtyX1, d.dirtyPage0, d.dirtyPage1
+	d.clearDirty()
+
+	// In the 128x64 (SPI) screen resetting to 0x0 after 128 times corrupt the buffer,
+	// so the address window is only set on the others. Each page sent below sets
+	// its own start column anyway.
 	if d.width != 128 || d.height != 64 {
 		d.Command(COLUMNADDR)
 		d.Command(0)
@@ -191,16 +191,48 @@ func (d *Device) Display() error {
 		d.Command(uint8(d.height/8) - 1)
 	}
 
-	for pg := uint8(0); pg < uint8(d.height/8); pg++ {
-		d.Command(0xB0 | (pg & 0x07)) // SET_PAGE_ADDR
-		d.Command(SETLOWCOLUMN | 2)
-		d.Command(SETHIGHCOLUMN | 0)
-		d.Tx(d.buffer[uint16(pg)*0x80:uint16(pg+1)*0x80], false)
+	// The SH1106 has 132 columns, the display starts at column 2
+	col := uint8(x0 + 2)
+	for pg := page0; pg <= page1; pg++ {
+		d.Command(0xB0 | (uint8(pg) & 0x07)) // SET_PAGE_ADDR
+		d.Command(SETLOWCOLUMN | (col & 0x0F))
+		d.Command(SETHIGHCOLUMN | (col >> 4))
+		d.Tx(d.buffer[pg*d.width+x0:pg*d.width+x1+1], false)
 	}
 
 	return nil
 }
 
+// setDirty adds the column x of the page to the area to send on Display.
+func (d *Device) setDirty(x, page int16) {
+	if d.dirtyX0 > d.dirtyX1 {
+		d.dirtyX0, d.dirtyX1 = x, x
+		d.dirtyPage0, d.dirtyPage1 = page, page
+		return
+	}
+	if x < d.dirtyX0 {
+		d.dirtyX0 = x
+	} else if x > d.dirtyX1 {
+		d.dirtyX1 = x
+	}
+	if page < d.dirtyPage0 {
+		d.dirtyPage0 = page
+	} else if page > d.dirtyPage1 {
+		d.dirtyPage1 = page
+	}
+}
+
+// setAllDirty sends the whole buffer on the next Display.
+func (d *Device) setAllDirty() {
+	d.dirtyX0, d.dirtyX1 = 0, d.width-1
+	d.dirtyPage0, d.dirtyPage1 = 0, d.height/8-1
+}
+
+// clearDirty marks the buffer as sent.
+func (d *Device) clearDirty() {
+	d.dirtyX0, d.dirtyX1 = 0, -1
+}
+
 // SetPixel enables or disables a pixel in the buffer
 // color.RGBA{0, 0, 0, 255} is consider transparent, anything else
 // with enable a pixel on the screen
@@ -209,10 +241,15 @@ func (d *Device) SetPixel(x int16, y int16, c color.RGBA) {
 		return
 	}
 	byteIndex := x + (y/8)*d.width
+	b := d.buffer[byteIndex]
 	if c.R != 0 || c.G != 0 || c.B != 0 {
-		d.buffer[byteIndex] |= 1 << uint8(y%8)
+		b |= 1 << uint8(y%8)
 	} else {
-		d.buffer[byteIndex] &^= 1 << uint8(y%8)
+		b &^= 1 << uint8(y%8)
+	}
+	if b != d.buffer[byteIndex] {
+		d.buffer[byteIndex] = b
+		d.setDirty(x, y/8)
 	}
 }
 
@@ -232,11 +269,30 @@ func (d *Device) SetBuffer(buffer []byte) error {
 		return errors.New("wrong size buffer")
 	}
 	for i := int16(0); i < d.bufferSize; i++ {
-		d.buffer[i] = buffer[i]
+		if d.buffer[i] != buffer[i] {
+			d.buffer[i] = buffer[i]
+			d.setDirty(i%d.width, i/d.width)
+		}
 	}
 	return nil
 }
 
+// FillRectangle fills a rectangle at the given coordinates with a color.
+func (d *Device) FillRectangle(x, y, width, height int16, c color.RGBA) error {
+	if x < 0 || y < 0 || width <= 0 || height <= 0 ||
+		x+width > d.width || y+height > d.height {
+		return errOutOfRange
+	}
+
+	for j := y; j < y+height; j++ {
+		for i := x; i < x+width; i++ {
+			d.SetPixel(i, j, c)
+		}
+	}
+
+	return nil
+}
+
 func (d *Device) SetScroll(line int16) {
 	d.Command(SETSTARTLINE + uint8(line&0b111111))
 }
@@ -252,30 +308,9 @@ func (b *I2CBus) setAddress(address uint16) {
 	b.Address = address
 }
 
-// setAddress does nothing, but it's required to avoid reflection
-func (b *SPIBus) setAddress(address uint16) {
-	// do nothing
-	println("trying to Configure an address on a SPI device")
-}
-
 // configure does nothing, but it's required to avoid reflection
 func (b *I2CBus) configure() {}
 
-// configure configures some pins with the SPI bus
-func (b *SPIBus) configure() {
-	b.csPin.Low()
-	b.dcPin.Low()
-	b.resetPin.Low()
-
-	b.resetPin.High()
-	// busyWaitDelay(time.Millisecond)
-	time.Sleep(1 * time.Millisecond)
-	b.resetPin.Low()
-	// busyWaitDelay(10 * time.Millisecond)
-	time.Sleep(10 * time.Millisecond)
-	b.resetPin.High()
-}
-
 // Tx sends data to the display
 func (d *Device) Tx(data []byte, isCommand bool) {
 	d.bus.tx(data, isCommand)
@@ -290,38 +325,7 @@ func (b *I2CBus) tx(data []byte, isCommand bool) {
 	}
 }
 
-// tx sends data to the display (SPIBus implementation)
-func (b *SPIBus) tx(data []byte, isCommand bool) {
-	if isCommand {
-		b.csPin.High()
-		riseTimeDelay()
-		b.dcPin.Low()
-		b.csPin.Low()
-
-		b.wire.Tx(data, nil)
-		b.csPin.High()
-	} else {
-		b.csPin.High()
-		riseTimeDelay()
-		b.dcPin.High()
-		b.csPin.Low()
-
-		b.wire.Tx(data, nil)
-		b.csPin.High()
-	}
-}
-
 // Size returns the current size of the display.
 func (d *Device) Size() (w, h int16) {
 	return d.width, d.height
 }
-
-// TODO: is this really necessary? seems to work fine without this on macropad-rp2040 at least
-func riseTimeDelay() {
-	busyWaitDelay(1 * time.Microsecond)
-}
-
-func busyWaitDelay(duration time.Duration) {
-	for start := time.Now(); time.Since(start) < duration; {
-	}
-}
@@ -0,0 +1,157 @@
+package sh1106
+
+import (
+	"image/color"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+// fakeBus records the commands and data sent to the display.
+type fakeBus struct {
+	commands []byte
+	data     [][]byte
+}
+
+func (b *fakeBus) configure() {}
+
+func (b *fakeBus) setAddress(address uint16) {}
+
+func (b *fakeBus) tx(data []byte, isCommand bool) {
+	if isCommand {
+		b.commands = append(b.commands, data...)
+	} else {
+		b.data = append(b.data, append([]byte(nil), data...))
+	}
+}
+
+func (b *fakeBus) reset() {
+	b.commands, b.data = nil, nil
+}
+
+// newDevice returns a configured 128x64 display with its whole buffer
+// already sent.
+func newDevice(c *qt.C) (*Device, *fakeBus) {
+	bus := &fakeBus{}
+	d := &Device{bus: bus}
+	d.Configure(Config{Address: Address})
+	c.Assert(d.Display(), qt.IsNil)
+	bus.reset()
+	return d, bus
+}
+
+// dirty returns the area to send on the next Display, as x0, x1, page0, page1.
+func dirty(d *Device) [4]int16 {
+	if d.dirtyX0 > d.dirtyX1 {
+		return [4]int16{}
+	}
+	return [4]int16{d.dirtyX0, d.dirtyX1, d.dirtyPage0, d.dirtyPage1}
+}
+
+func clean(d *Device) bool {
+	return d.dirtyX0 > d.dirtyX1
+}
+
+// pageCommands returns the commands to write a page from column x, which is
+// offset by 2 in the 132 columns of the controller.
+func pageCommands(page, x uint8) []byte {
+	return []byte{0xB0 | page, SETLOWCOLUMN | (x+2)&0x0F, SETHIGHCOLUMN | (x+2)>>4}
+}
+
+func TestSetPixelDirty(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c)
+
+	c.Assert(clean(d), qt.IsTrue)
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.HasLen, 0)
+	c.Assert(bus.data, qt.HasLen, 0)
+
+	// Pixels outside the display and unchanged pixels aren't tracked
+	d.SetPixel(-1, 0, white)
+	d.SetPixel(128, 0, white)
+	d.SetPixel(0, -1, white)
+	d.SetPixel(0, 64, white)
+	d.SetPixel(5, 10, black)
+	c.Assert(clean(d), qt.IsTrue)
+
+	d.SetPixel(5, 10, white)
+	c.Assert(dirty(d), qt.Equals, [4]int16{5, 5, 1, 1})
+	d.SetPixel(0, 0, white)
+	d.SetPixel(127, 63, white)
+	c.Assert(dirty(d), qt.Equals, [4]int16{0, 127, 0, 7})
+
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.data, qt.HasLen, 8)
+	for page, data := range bus.data {
+		c.Assert(data, qt.DeepEquals, d.buffer[page*128:(page+1)*128])
+	}
+	c.Assert(clean(d), qt.IsTrue)
+
+	bus.reset()
+	d.SetPixel(127, 63, black)
+	c.Assert(dirty(d), qt.Equals, [4]int16{127, 127, 7, 7})
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, pageCommands(7, 127))
+	c.Assert(bus.data, qt.DeepEquals, [][]byte{{0}})
+	c.Assert(clean(d), qt.IsTrue)
+}
+
+func TestFillRectangleDirty(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c)
+
+	c.Assert(d.FillRectangle(121, 56, 8, 8, white), qt.Equals, errOutOfRange)
+	c.Assert(d.FillRectangle(120, 57, 8, 8, white), qt.Equals, errOutOfRange)
+	c.Assert(d.FillRectangle(-1, 0, 8, 8, white), qt.Equals, errOutOfRange)
+	c.Assert(d.FillRectangle(0, 0, 8, 0, white), qt.Equals, errOutOfRange)
+	c.Assert(clean(d), qt.IsTrue)
+
+	// y 12 to 21 spans pages 1 and 2
+	c.Assert(d.FillRectangle(10, 12, 5, 10, white), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{10, 14, 1, 2})
+
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, append(pageCommands(1, 10), pageCommands(2, 10)...))
+	c.Assert(bus.data, qt.DeepEquals, [][]byte{
+		{0xf0, 0xf0, 0xf0, 0xf0, 0xf0},
+		{0x3f, 0x3f, 0x3f, 0x3f, 0x3f},
+	})
+	c.Assert(clean(d), qt.IsTrue)
+
+	// Filling again with the same color changes nothing
+	c.Assert(d.FillRectangle(10, 12, 5, 10, white), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+
+	// The bottom right corner
+	c.Assert(d.FillRectangle(120, 56, 8, 8, white), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{120, 127, 7, 7})
+	d.clearDirty()
+	c.Assert(d.FillRectangle(0, 0, 128, 64, white), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{0, 127, 0, 7})
+}
+
+func TestSetBufferDirty(t *testing.T) {
+	c := qt.New(t)
+	d, _ := newDevice(c)
+
+	buffer := make([]byte, 128*64/8)
+	c.Assert(d.SetBuffer(buffer[1:]), qt.Not(qt.IsNil))
+	c.Assert(d.SetBuffer(buffer), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+
+	buffer[128+20] = 1
+	buffer[3*128+40] = 1
+	c.Assert(d.SetBuffer(buffer), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{20, 40, 1, 3})
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+
+	d.ClearBuffer()
+	c.Assert(dirty(d), qt.Equals, [4]int16{20, 40, 1, 3})
+}
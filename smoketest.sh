@@ -134,6 +134,7 @@ tinygo build -size short -o ./build/test.hex -target=macropad-rp2040 ./examples/
 tinygo build -size short -o ./build/test.uf2 -target=pico ./examples/mcp9808/main.go
 # network examples (espat)
 tinygo build -size short -o ./build/test.hex -target=challenger-rp2040 ./examples/net/ntpclient/
+tinygo build -size short -o ./build/test.hex -target=wioterminal -stack-size 8kb ./examples/net/wifiscan/
 # network examples (wifinina)
 tinygo build -size short -o ./build/test.hex -target=pyportal -stack-size 8kb ./examples/net/http-get/
 tinygo build -size short -o ./build/test.hex -target=arduino-nano33 -stack-size 8kb ./examples/net/tcpclient/
@@ -146,3 +147,6 @@ tinygo build -size short -o ./build/test.hex -target=nano-rp2040 -stack-size 8kb
 tinygo build -size short -o ./build/test.hex -target=wioterminal -stack-size 8kb ./examples/net/webclient/
 tinygo build -size short -o ./build/test.hex -target=wioterminal -stack-size 8kb ./examples/net/webserver/
 tinygo build -size short -o ./build/test.hex -target=wioterminal -stack-size 8kb ./examples/net/mqttclient/paho/
+tinygo build -size short -o ./build/test.hex -target=wioterminal -stack-size 8kb ./examples/rtl8720dn/bleperipheral/
+# network examples (w5500)
+tinygo build -size short -o ./build/test.uf2 -target=pico -tags=w5500 -stack-size 8kb ./examples/net/tcpecho/
@@ -6,7 +6,6 @@ package ssd1306 // import "tinygo.org/x/drivers/ssd1306"
 import (
 	"errors"
 	"image/color"
-	"machine"
 	"time"
 
 	"tinygo.org/x/drivers"
@@ -33,6 +32,14 @@ type Device struct {
 	canReset   bool
 	resetCol   ResetValue
 	resetPage  ResetValue
+
+	// The area of the buffer changed since the last Display, from column
+	// dirtyX0 to dirtyX1 and page (8 pixel high row) dirtyPage0 to dirtyPage1
+	// included. It's empty when dirtyX0 > dirtyX1.
+	dirtyX0    int16
+	dirtyX1    int16
+	dirtyPage0 int16
+	dirtyPage1 int16
 }
 
 // Config is the configuration for the display
@@ -55,13 +62,6 @@ type I2CBus struct {
 	Address uint16
 }
 
-type SPIBus struct {
-	wire     drivers.SPI
-	dcPin    machine.Pin
-	resetPin machine.Pin
-	csPin    machine.Pin
-}
-
 type Buser interface {
 	configure() error
 	tx(data []byte, isCommand bool) error
@@ -80,21 +80,6 @@ func NewI2C(bus drivers.I2C) Device {
 	}
 }
 
-// NewSPI creates a new SSD1306 connection. The SPI wire must already be configured.
-func NewSPI(bus drivers.SPI, dcPin, resetPin, csPin machine.Pin) Device {
-	dcPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	resetPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	csPin.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	return Device{
-		bus: &SPIBus{
-			wire:     bus,
-			dcPin:    dcPin,
-			resetPin: resetPin,
-			csPin:    csPin,
-		},
-	}
-}
-
 // Configure initializes the display with default configuration
 func (d *Device) Configure(cfg Config) {
 	var zeroReset ResetValue
@@ -128,6 +113,7 @@ func (d *Device) Configure(cfg Config) {
 	}
 	d.bufferSize = d.width * d.height / 8
 	d.buffer = make([]byte, d.bufferSize)
+	d.setAllDirty()
 	d.canReset = cfg.Address != 0 || d.width != 128 || d.height != 64 // I2C or not 128x64
 
 	d.bus.configure()
@@ -197,32 +183,83 @@ func (d *Device) Configure(cfg Config) {
 // ClearBuffer clears the image buffer
 func (d *Device) ClearBuffer() {
 	for i := int16(0); i < d.bufferSize; i++ {
-		d.buffer[i] = 0
+		if d.buffer[i] != 0 {
+			d.buffer[i] = 0
+			d.setDirty(i%d.width, i/d.width)
+		}
 	}
 }
 
 // ClearDisplay clears the image buffer and clear the display
 func (d *Device) ClearDisplay() {
 	d.ClearBuffer()
+	d.setAllDirty()
 	d.Display()
 }
 
-// Display sends the whole buffer to the screen
+// Display sends the buffer to the screen. Only the area changed since the
+// last call is sent, if any.
 func (d *Device) Display() error {
-	// Reset the screen to 0x0
-	// This works fine with I2C
+	if d.dirtyX0 > d.dirtyX1 {
+		return nil
+	}
+	x0, x1, page0, page1 := d.dirtyX0, d.dirtyX1, d.dirtyPage0, d.dirtyPage1
+	d.clearDirty()
+
 	// In the 128x64 (SPI) screen resetting to 0x0 after 128 times corrupt the buffer
-	// Since we're printing the whole buffer, avoid resetting it in this case
-	if d.canReset {
-		d.Command(COLUMNADDR)
-		d.Command(d.resetCol[0])
-		d.Command(d.resetCol[1])
-		d.Command(PAGEADDR)
-		d.Command(d.resetPage[0])
-		d.Command(d.resetPage[1])
+	// Since the address can't be set, send the whole buffer in this case
+	if !d.canReset {
+		return d.Tx(d.buffer, false)
+	}
+
+	// Set the area to write, the address wraps around to x0 on the next page
+	// after x1.
+	d.Command(COLUMNADDR)
+	d.Command(d.resetCol[0] + uint8(x0))
+	d.Command(d.resetCol[0] + uint8(x1))
+	d.Command(PAGEADDR)
+	d.Command(d.resetPage[0] + uint8(page0))
+	d.Command(d.resetPage[0] + uint8(page1))
+
+	if x0 == 0 && x1 == d.width-1 {
+		return d.Tx(d.buffer[page0*d.width:(page1+1)*d.width], false)
 	}
+	for page := page0; page <= page1; page++ {
+		if err := d.Tx(d.buffer[page*d.width+x0:page*d.width+x1+1], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return d.Tx(d.buffer, false)
+// setDirty adds the column x of the page to the area to send on Display.
+func (d *Device) setDirty(x, page int16) {
+	if d.dirtyX0 > d.dirtyX1 {
+		d.dirtyX0, d.dirtyX1 = x, x
+		d.dirtyPage0, d.dirtyPage1 = page, page
+		return
+	}
+	if x < d.dirtyX0 {
+		d.dirtyX0 = x
+	} else if x > d.dirtyX1 {
+		d.dirtyX1 = x
+	}
+	if page < d.dirtyPage0 {
+		d.dirtyPage0 = page
+	} else if page > d.dirtyPage1 {
+		d.dirtyPage1 = page
+	}
+}
+
+// setAllDirty sends the whole buffer on the next Display.
+func (d *Device) setAllDirty() {
+	d.dirtyX0, d.dirtyX1 = 0, d.width-1
+	d.dirtyPage0, d.dirtyPage1 = 0, d.height/8-1
+}
+
+// clearDirty marks the buffer as sent.
+func (d *Device) clearDirty() {
+	d.dirtyX0, d.dirtyX1 = 0, -1
 }
 
 // SetPixel enables or disables a pixel in the buffer
@@ -233,10 +270,15 @@ func (d *Device) SetPixel(x int16, y int16, c color.RGBA) {
 		return
 	}
 	byteIndex := x + (y/8)*d.width
+	b := d.buffer[byteIndex]
 	if c.R != 0 || c.G != 0 || c.B != 0 {
-		d.buffer[byteIndex] |= 1 << uint8(y%8)
+		b |= 1 << uint8(y%8)
 	} else {
-		d.buffer[byteIndex] &^= 1 << uint8(y%8)
+		b &^= 1 << uint8(y%8)
+	}
+	if b != d.buffer[byteIndex] {
+		d.buffer[byteIndex] = b
+		d.setDirty(x, y/8)
 	}
 }
 
@@ -255,13 +297,18 @@ func (d *Device) SetBuffer(buffer []byte) error {
 		return errBufferSize
 	}
 	for i := int16(0); i < d.bufferSize; i++ {
-		d.buffer[i] = buffer[i]
+		if d.buffer[i] != buffer[i] {
+			d.buffer[i] = buffer[i]
+			d.setDirty(i%d.width, i/d.width)
+		}
 	}
 	return nil
 }
 
-// GetBuffer returns the whole buffer
+// GetBuffer returns the whole buffer. As it may be modified, the whole buffer
+// is sent by the next Display.
 func (d *Device) GetBuffer() []byte {
+	d.setAllDirty()
 	return d.buffer
 }
 
@@ -276,31 +323,9 @@ func (b *I2CBus) setAddress(address uint16) error {
 	return nil
 }
 
-// setAddress does nothing, but it's required to avoid reflection
-func (b *SPIBus) setAddress(address uint16) error {
-	// do nothing
-	println("trying to Configure an address on a SPI device")
-	return nil
-}
-
 // configure does nothing, but it's required to avoid reflection
 func (b *I2CBus) configure() error { return nil }
 
-// configure configures some pins with the SPI bus
-func (b *SPIBus) configure() error {
-	b.csPin.Low()
-	b.dcPin.Low()
-	b.resetPin.Low()
-
-	b.resetPin.High()
-	time.Sleep(1 * time.Millisecond)
-	b.resetPin.Low()
-	time.Sleep(10 * time.Millisecond)
-	b.resetPin.High()
-
-	return nil
-}
-
 // Tx sends data to the display
 func (d *Device) Tx(data []byte, isCommand bool) error {
 	return d.bus.tx(data, isCommand)
@@ -315,34 +340,25 @@ func (b *I2CBus) tx(data []byte, isCommand bool) error {
 	}
 }
 
-// tx sends data to the display (SPIBus implementation)
-func (b *SPIBus) tx(data []byte, isCommand bool) error {
-	var err error
-
-	if isCommand {
-		b.csPin.High()
-		time.Sleep(1 * time.Millisecond)
-		b.dcPin.Low()
-		b.csPin.Low()
-
-		err = b.wire.Tx(data, nil)
-		b.csPin.High()
-	} else {
-		b.csPin.High()
-		time.Sleep(1 * time.Millisecond)
-		b.dcPin.High()
-		b.csPin.Low()
+// Size returns the current size of the display.
+func (d *Device) Size() (w, h int16) {
+	return d.width, d.height
+}
 
-		err = b.wire.Tx(data, nil)
-		b.csPin.High()
+// FillRectangle fills a rectangle at the given coordinates with a color.
+func (d *Device) FillRectangle(x, y, width, height int16, c color.RGBA) error {
+	if x < 0 || y < 0 || width <= 0 || height <= 0 ||
+		x+width > d.width || y+height > d.height {
+		return errOutOfRange
 	}
 
-	return err
-}
+	for j := y; j < y+height; j++ {
+		for i := x; i < x+width; i++ {
+			d.SetPixel(i, j, c)
+		}
+	}
 
-// Size returns the current size of the display.
-func (d *Device) Size() (w, h int16) {
-	return d.width, d.height
+	return nil
 }
 
 // DrawBitmap copies the bitmap to the screen at the given coordinates.
@@ -0,0 +1,207 @@
+package ssd1306
+
+import (
+	"image/color"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/pixel"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+// fakeBus records the commands and data sent to the display.
+type fakeBus struct {
+	commands []byte
+	data     [][]byte
+}
+
+func (b *fakeBus) configure() error { return nil }
+
+func (b *fakeBus) setAddress(address uint16) error { return nil }
+
+func (b *fakeBus) tx(data []byte, isCommand bool) error {
+	if isCommand {
+		b.commands = append(b.commands, data...)
+	} else {
+		b.data = append(b.data, append([]byte(nil), data...))
+	}
+	return nil
+}
+
+func (b *fakeBus) reset() {
+	b.commands, b.data = nil, nil
+}
+
+// newDevice returns a configured display with its whole buffer already sent.
+func newDevice(c *qt.C, cfg Config) (*Device, *fakeBus) {
+	bus := &fakeBus{}
+	d := &Device{bus: bus}
+	d.Configure(cfg)
+	c.Assert(d.Display(), qt.IsNil)
+	bus.reset()
+	return d, bus
+}
+
+// dirty returns the area to send on the next Display, as x0, x1, page0, page1.
+func dirty(d *Device) [4]int16 {
+	if d.dirtyX0 > d.dirtyX1 {
+		return [4]int16{}
+	}
+	return [4]int16{d.dirtyX0, d.dirtyX1, d.dirtyPage0, d.dirtyPage1}
+}
+
+func clean(d *Device) bool {
+	return d.dirtyX0 > d.dirtyX1
+}
+
+func TestSetPixelDirty(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c, Config{Width: 128, Height: 64, Address: Address})
+
+	c.Assert(clean(d), qt.IsTrue)
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.HasLen, 0)
+	c.Assert(bus.data, qt.HasLen, 0)
+
+	// Pixels outside the display and unchanged pixels aren't tracked
+	d.SetPixel(-1, 0, white)
+	d.SetPixel(128, 0, white)
+	d.SetPixel(0, -1, white)
+	d.SetPixel(0, 64, white)
+	d.SetPixel(5, 10, black)
+	c.Assert(clean(d), qt.IsTrue)
+
+	d.SetPixel(5, 10, white)
+	c.Assert(dirty(d), qt.Equals, [4]int16{5, 5, 1, 1})
+	d.SetPixel(0, 0, white)
+	d.SetPixel(127, 63, white)
+	c.Assert(dirty(d), qt.Equals, [4]int16{0, 127, 0, 7})
+
+	// The full width is sent at once
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, []byte{COLUMNADDR, 0, 127, PAGEADDR, 0, 7})
+	c.Assert(bus.data, qt.HasLen, 1)
+	c.Assert(bus.data[0], qt.DeepEquals, d.buffer)
+	c.Assert(clean(d), qt.IsTrue)
+
+	bus.reset()
+	d.SetPixel(127, 63, black)
+	c.Assert(dirty(d), qt.Equals, [4]int16{127, 127, 7, 7})
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, []byte{COLUMNADDR, 127, 127, PAGEADDR, 7, 7})
+	c.Assert(bus.data, qt.DeepEquals, [][]byte{{0}})
+	c.Assert(clean(d), qt.IsTrue)
+}
+
+func TestFillRectangleDirty(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c, Config{Width: 128, Height: 64, Address: Address})
+
+	c.Assert(d.FillRectangle(121, 56, 8, 8, white), qt.Equals, errOutOfRange)
+	c.Assert(d.FillRectangle(120, 57, 8, 8, white), qt.Equals, errOutOfRange)
+	c.Assert(d.FillRectangle(-1, 0, 8, 8, white), qt.Equals, errOutOfRange)
+	c.Assert(d.FillRectangle(0, 0, 0, 8, white), qt.Equals, errOutOfRange)
+	c.Assert(clean(d), qt.IsTrue)
+
+	// y 12 to 21 spans pages 1 and 2
+	c.Assert(d.FillRectangle(10, 12, 5, 10, white), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{10, 14, 1, 2})
+
+	// Each page of the area is sent on its own
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, []byte{COLUMNADDR, 10, 14, PAGEADDR, 1, 2})
+	c.Assert(bus.data, qt.DeepEquals, [][]byte{
+		{0xf0, 0xf0, 0xf0, 0xf0, 0xf0},
+		{0x3f, 0x3f, 0x3f, 0x3f, 0x3f},
+	})
+	c.Assert(clean(d), qt.IsTrue)
+
+	// Filling again with the same color changes nothing
+	c.Assert(d.FillRectangle(10, 12, 5, 10, white), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+
+	// The bottom right corner
+	c.Assert(d.FillRectangle(120, 56, 8, 8, white), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{120, 127, 7, 7})
+	d.clearDirty()
+	c.Assert(d.FillRectangle(0, 0, 128, 64, white), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{0, 127, 0, 7})
+}
+
+func TestDrawBitmapDirty(t *testing.T) {
+	c := qt.New(t)
+	d, bus := newDevice(c, Config{Width: 128, Height: 64, Address: Address})
+
+	bitmap := pixel.NewImage[pixel.Monochrome](3, 9)
+	c.Assert(d.DrawBitmap(126, 55, bitmap), qt.Equals, errOutOfRange)
+	c.Assert(d.DrawBitmap(125, 56, bitmap), qt.Equals, errOutOfRange)
+
+	// Only the changed pixels are tracked, not the whole bitmap
+	c.Assert(d.DrawBitmap(125, 55, bitmap), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+	bitmap.Set(1, 0, true)
+	bitmap.Set(2, 8, true)
+	c.Assert(d.DrawBitmap(125, 55, bitmap), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{126, 127, 6, 7})
+
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.DeepEquals, []byte{COLUMNADDR, 126, 127, PAGEADDR, 6, 7})
+	c.Assert(bus.data, qt.DeepEquals, [][]byte{{0x80, 0x00}, {0x00, 0x80}})
+	c.Assert(clean(d), qt.IsTrue)
+
+	// Rotation isn't supported, so coordinates are always those of the panel
+	c.Assert(d.SetRotation(drivers.Rotation90), qt.Equals, errNotImplemented)
+	c.Assert(d.Rotation(), qt.Equals, drivers.Rotation(drivers.Rotation0))
+	c.Assert(clean(d), qt.IsTrue)
+	bitmap = pixel.NewImage[pixel.Monochrome](1, 1)
+	bitmap.Set(0, 0, true)
+	c.Assert(d.DrawBitmap(0, 63, bitmap), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{0, 0, 7, 7})
+}
+
+func TestDisplayWholeBuffer(t *testing.T) {
+	c := qt.New(t)
+
+	// The address of 128x64 SPI displays can't be set
+	d, bus := newDevice(c, Config{Width: 128, Height: 64})
+	d.SetPixel(3, 3, white)
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.commands, qt.HasLen, 0)
+	c.Assert(bus.data, qt.HasLen, 1)
+	c.Assert(bus.data[0], qt.HasLen, 128*64/8)
+	c.Assert(clean(d), qt.IsTrue)
+
+	// The buffer may be modified through GetBuffer
+	d, bus = newDevice(c, Config{Width: 128, Height: 32, Address: Address})
+	d.GetBuffer()[0] = 0xff
+	c.Assert(dirty(d), qt.Equals, [4]int16{0, 127, 0, 3})
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(bus.data, qt.HasLen, 1)
+	c.Assert(bus.data[0], qt.HasLen, 128*32/8)
+	c.Assert(clean(d), qt.IsTrue)
+}
+
+func TestSetBufferDirty(t *testing.T) {
+	c := qt.New(t)
+	d, _ := newDevice(c, Config{Width: 128, Height: 64, Address: Address})
+
+	buffer := make([]byte, 128*64/8)
+	c.Assert(d.SetBuffer(buffer[1:]), qt.Equals, errBufferSize)
+	c.Assert(d.SetBuffer(buffer), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+
+	buffer[128+20] = 1
+	buffer[3*128+40] = 1
+	c.Assert(d.SetBuffer(buffer), qt.IsNil)
+	c.Assert(dirty(d), qt.Equals, [4]int16{20, 40, 1, 3})
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(clean(d), qt.IsTrue)
+
+	d.ClearBuffer()
+	c.Assert(dirty(d), qt.Equals, [4]int16{20, 40, 1, 3})
+}
@@ -0,0 +1,174 @@
+package sx126x
+
+import "tinygo.org/x/drivers/lora"
+
+// gfskRxBandwidths lists the supported GFSK receiver bandwidths, in Hz
+var gfskRxBandwidths = [...]struct {
+	hz   uint32
+	code uint8
+}{
+	{4800, SX126X_GFSK_RX_BW_4_8},
+	{5800, SX126X_GFSK_RX_BW_5_8},
+	{7300, SX126X_GFSK_RX_BW_7_3},
+	{9700, SX126X_GFSK_RX_BW_9_7},
+	{11700, SX126X_GFSK_RX_BW_11_7},
+	{14600, SX126X_GFSK_RX_BW_14_6},
+	{19500, SX126X_GFSK_RX_BW_19_5},
+	{23400, SX126X_GFSK_RX_BW_23_4},
+	{29300, SX126X_GFSK_RX_BW_29_3},
+	{39000, SX126X_GFSK_RX_BW_39_0},
+	{46900, SX126X_GFSK_RX_BW_46_9},
+	{58600, SX126X_GFSK_RX_BW_58_6},
+	{78200, SX126X_GFSK_RX_BW_78_2},
+	{93800, SX126X_GFSK_RX_BW_93_8},
+	{117300, SX126X_GFSK_RX_BW_117_3},
+	{156200, SX126X_GFSK_RX_BW_156_2},
+	{187200, SX126X_GFSK_RX_BW_187_2},
+	{234300, SX126X_GFSK_RX_BW_234_3},
+	{312000, SX126X_GFSK_RX_BW_312_0},
+	{373600, SX126X_GFSK_RX_BW_373_6},
+	{467000, SX126X_GFSK_RX_BW_467_0},
+}
+
+// FskConfig defines FSK/GFSK configuration for next operations, until
+// LoraConfig is called
+func (d *Device) FskConfig(cnf lora.FskConfig) error {
+	if _, ok := gfskRxBandwidth(cnf.RxBandwidth()); !ok {
+		return lora.ErrUnsupportedFskConfig
+	}
+	if _, ok := gfskShaping(cnf.Shaping); !ok {
+		return lora.ErrUnsupportedFskConfig
+	}
+	if cnf.Bitrate < 600 || cnf.Bitrate > 300000 || len(cnf.SyncWord) > 8 || cnf.Crc > lora.FskCrcCustom {
+		return lora.ErrUnsupportedFskConfig
+	}
+
+	d.fskConf = cnf
+	d.fskConf.SyncWord = append([]uint8(nil), cnf.SyncWord...)
+	d.modem = lora.ModemFSK
+	// Switch to standby prior to configuration changes
+	d.SetStandby()
+	d.ClearDeviceErrors()
+	d.ClearIrqStatus(SX126X_IRQ_ALL)
+	d.SetDioIrqParams(0x00, 0x00, 0x00, 0x00)
+	d.configureFsk(cnf.PayloadLength)
+	d.SetTxParams(d.fskConf.TxPowerDBm, SX126X_PA_RAMP_200U)
+	d.SetBufferBaseAddress(0, 0)
+	return nil
+}
+
+// Modem returns the current modem, lora.ModemLoRa or lora.ModemFSK
+func (d *Device) Modem() uint8 {
+	return d.modem
+}
+
+// SetModulationParamsGfsk sets GFSK modulation parameters (13.4.5)
+// bitrate and fdev are expressed in bit/s and Hz
+func (d *Device) SetModulationParamsGfsk(bitrate uint32, pulseShape, rxBw uint8, fdev uint32) {
+	br := 32 * uint32(SX126X_CRYSTAL_FREQ*1000000) / bitrate
+	fd := uint32(uint64(fdev) << SX126X_DIV_EXPONENT / uint64(SX126X_CRYSTAL_FREQ*1000000))
+	var p [8]uint8
+	p[0] = uint8((br >> 16) & 0xFF)
+	p[1] = uint8((br >> 8) & 0xFF)
+	p[2] = uint8(br & 0xFF)
+	p[3] = pulseShape
+	p[4] = rxBw
+	p[5] = uint8((fd >> 16) & 0xFF)
+	p[6] = uint8((fd >> 8) & 0xFF)
+	p[7] = uint8(fd & 0xFF)
+	d.ExecSetCommand(SX126X_CMD_SET_MODULATION_PARAMS, p[:])
+}
+
+// SetPacketParamGfsk sets GFSK packet parameters (13.4.6)
+// preamble, preamble detector and sync word lengths are expressed in bits
+func (d *Device) SetPacketParamGfsk(preambleLength uint16, preambleDetect, syncWordLength, addrComp, packetType, payloadLength, crcType, whitening uint8) {
+	var p [9]uint8
+	p[0] = uint8((preambleLength >> 8) & 0xFF)
+	p[1] = uint8(preambleLength & 0xFF)
+	p[2] = preambleDetect
+	p[3] = syncWordLength
+	p[4] = addrComp
+	p[5] = packetType
+	p[6] = payloadLength
+	p[7] = crcType
+	p[8] = whitening
+	d.ExecSetCommand(SX126X_CMD_SET_PACKET_PARAMS, p[:])
+}
+
+// configureFsk applies the FSK configuration, for packets of payloadLength
+// bytes (maximum length with variable length packets)
+func (d *Device) configureFsk(payloadLength uint8) {
+	cnf := &d.fskConf
+	rxBw, _ := gfskRxBandwidth(cnf.RxBandwidth())
+	shaping, _ := gfskShaping(cnf.Shaping)
+
+	d.SetPacketType(SX126X_PACKET_TYPE_GFSK)
+	d.SetRfFrequency(cnf.Freq)
+	d.SetModulationParamsGfsk(cnf.Bitrate, shaping, rxBw, cnf.Fdev)
+
+	// The preamble detector must be shorter than the preamble
+	detect := uint8(SX126X_GFSK_PREAMBLE_DETECT_8)
+	if cnf.Preamble >= 4 {
+		detect = SX126X_GFSK_PREAMBLE_DETECT_16
+	}
+	packetType := uint8(SX126X_GFSK_PACKET_VARIABLE)
+	if cnf.FixedLength {
+		packetType = SX126X_GFSK_PACKET_FIXED
+	}
+	crcType := uint8(SX126X_GFSK_CRC_OFF)
+	switch cnf.Crc {
+	case lora.FskCrcCCITT:
+		crcType = SX126X_GFSK_CRC_2_BYTE_INV
+		d.setGfskCrc(0x1021, 0x1D0F)
+	case lora.FskCrcIBM:
+		crcType = SX126X_GFSK_CRC_2_BYTE
+		d.setGfskCrc(0x8005, 0xFFFF)
+	case lora.FskCrcCustom:
+		crcType = SX126X_GFSK_CRC_2_BYTE
+		d.setGfskCrc(cnf.CrcPolynomial, cnf.CrcInit)
+	}
+	whitening := uint8(SX126X_GFSK_WHITENING_OFF)
+	if cnf.Whitening {
+		whitening = SX126X_GFSK_WHITENING_ON
+		// Only bit 0 of the MSB register belongs to the PN9 seed
+		r, _ := d.ReadRegister(SX126X_REG_WHITENING_INITIAL_MSB, 1)
+		d.WriteRegister(SX126X_REG_WHITENING_INITIAL_MSB, []uint8{r[0]&0xFE | 0x01, 0xFF})
+	}
+	d.SetPacketParamGfsk(cnf.Preamble*8, detect, uint8(len(cnf.SyncWord)*8), SX126X_GFSK_ADDRESS_FILT_OFF,
+		packetType, payloadLength, crcType, whitening)
+	if len(cnf.SyncWord) > 0 {
+		d.WriteRegister(SX126X_REG_SYNC_WORD_0, cnf.SyncWord)
+	}
+}
+
+// setGfskCrc sets the GFSK CRC polynomial and initial value
+func (d *Device) setGfskCrc(polynomial, init uint16) {
+	d.WriteRegister(SX126X_REG_CRC_INITIAL_MSB, []uint8{uint8(init >> 8), uint8(init)})
+	d.WriteRegister(SX126X_REG_CRC_POLYNOMIAL_MSB, []uint8{uint8(polynomial >> 8), uint8(polynomial)})
+}
+
+// gfskRxBandwidth returns the smallest receiver bandwidth of at least hz
+func gfskRxBandwidth(hz uint32) (uint8, bool) {
+	for _, bw := range gfskRxBandwidths {
+		if bw.hz >= hz {
+			return bw.code, true
+		}
+	}
+	return 0, false
+}
+
+func gfskShaping(shaping uint8) (uint8, bool) {
+	switch shaping {
+	case lora.FskShapingNone:
+		return SX126X_GFSK_FILTER_NONE, true
+	case lora.FskShapingGaussBT0_3:
+		return SX126X_GFSK_FILTER_GAUSS_0_3, true
+	case lora.FskShapingGaussBT0_5:
+		return SX126X_GFSK_FILTER_GAUSS_0_5, true
+	case lora.FskShapingGaussBT0_7:
+		return SX126X_GFSK_FILTER_GAUSS_0_7, true
+	case lora.FskShapingGaussBT1_0:
+		return SX126X_GFSK_FILTER_GAUSS_1, true
+	}
+	return 0, false
+}
@@ -79,6 +79,7 @@ const (
 	SX126X_REG_BROADCAST_ADDRESS     = 0x06CE
 	SX126X_REG_LORA_SYNC_WORD_MSB    = 0x0740
 	SX126X_REG_LORA_SYNC_WORD_LSB    = 0x0741
+	SX126X_REG_FREQ_ERROR            = 0x076B // LoRa frequency error indicator, not documented
 	SX126X_REG_RANDOM_NUMBER_0       = 0x0819
 	SX126X_REG_RANDOM_NUMBER_1       = 0x081A
 	SX126X_REG_RANDOM_NUMBER_2       = 0x081B
@@ -19,6 +19,7 @@ var (
 	errRadioNotFound          = errors.New("LoRa radio not found")
 	errUnexpectedRxRadioEvent = errors.New("Unexpected Radio Event during RX")
 	errUnexpectedTxRadioEvent = errors.New("Unexpected Radio Event during TX")
+	errUnexpectedCadEvent     = errors.New("Unexpected Radio Event during CAD")
 )
 
 const (
@@ -50,7 +51,10 @@ type Device struct {
 	deviceType     int                  // sx1261,sx1262,sx1268 (defaults sx1261)
 	spiTxBuf       []byte               // global Tx buffer to avoid heap allocations in interrupt
 	spiRxBuf       []byte               // global Rx buffer to avoid heap allocations in interrupt
-
+	rxTimestamp    time.Time            // time of the last RxDone interrupt
+	cadConf        lora.CadConfig       // Channel Activity Detection configuration
+	fskConf        lora.FskConfig       // Current FSK configuration
+	modem          uint8                // Current modem: lora.ModemLoRa or lora.ModemFSK
 }
 
 // New creates a new SX126x connection.
@@ -540,6 +544,7 @@ func (d *Device) ExecGetCommand(cmd uint8, size uint8) []uint8 {
 // NB: Change will be applied at next RX / TX
 func (d *Device) SetFrequency(freq uint32) {
 	d.loraConf.Freq = freq
+	d.fskConf.Freq = freq
 }
 
 // SetIqMode() defines the current IQ Mode (Standard/Inverted)
@@ -570,7 +575,7 @@ func (d *Device) SetCrc(enable bool) {
 	if enable {
 		d.loraConf.Crc = lora.CRCOn
 	} else {
-		d.loraConf.Crc = lora.CRCOn
+		d.loraConf.Crc = lora.CRCOff
 	}
 }
 
@@ -590,6 +595,7 @@ func (d *Device) SetPreambleLength(pl uint16) {
 // NB: Change will be applied at next RX / TX
 func (d *Device) SetTxPower(txpow int8) {
 	d.loraConf.LoraTxPowerDBm = txpow
+	d.fskConf.TxPowerDBm = txpow
 }
 
 // SetHeaderType sets implicit or explicit header mode
@@ -608,6 +614,7 @@ func (d *Device) LoraConfig(cnf lora.Config) {
 	// Save given configuration
 	d.loraConf = cnf
 	d.loraConf.SyncWord = syncword(int(cnf.SyncWord))
+	d.modem = lora.ModemLoRa
 	// Switch to standby prior to configuration changes
 	d.SetStandby()
 	// Clear errors, disable radio interrupts for the moment
@@ -625,7 +632,21 @@ func (d *Device) LoraConfig(cnf lora.Config) {
 
 // Tx sends a lora packet, (with timeout)
 func (d *Device) Tx(pkt []uint8, timeoutMs uint32) error {
-	if d.loraConf.Freq == 0 {
+	if err := d.StartTx(pkt, timeoutMs); err != nil {
+		return err
+	}
+
+	msg := <-d.GetRadioEventChan()
+	if msg.EventType != lora.RadioEventTxDone {
+		return errUnexpectedTxRadioEvent
+	}
+	return nil
+}
+
+// StartTx starts sending a packet with the current modem and returns
+// without waiting. RadioEventTxDone or RadioEventTimeout is sent once done.
+func (d *Device) StartTx(pkt []uint8, timeoutMs uint32) error {
+	if d.frequency() == 0 {
 		return lora.ErrUndefinedLoraConf
 	}
 
@@ -636,9 +657,19 @@ func (d *Device) Tx(pkt []uint8, timeoutMs uint32) error {
 		}
 	}
 
+	d.clearRadioEvents()
 	d.ClearIrqStatus(SX126X_IRQ_ALL)
 	irqVal := uint16(SX126X_IRQ_TX_DONE | SX126X_IRQ_TIMEOUT | SX126X_IRQ_CRC_ERR)
 	d.SetStandby()
+	if d.modem == lora.ModemFSK {
+		d.configureFsk(uint8(len(pkt)))
+		d.SetTxParams(d.fskConf.TxPowerDBm, SX126X_PA_RAMP_200U)
+		d.SetBufferBaseAddress(0, 0)
+		d.WriteBuffer(pkt)
+		d.SetDioIrqParams(irqVal, irqVal, SX126X_IRQ_NONE, SX126X_IRQ_NONE)
+		d.SetTx(timeoutMsToRtcSteps(timeoutMs))
+		return nil
+	}
 	d.SetPacketType(SX126X_PACKET_TYPE_LORA)
 	d.SetRfFrequency(d.loraConf.Freq)
 	d.SetTxParams(d.loraConf.LoraTxPowerDBm, SX126X_PA_RAMP_200U)
@@ -649,51 +680,218 @@ func (d *Device) Tx(pkt []uint8, timeoutMs uint32) error {
 	d.SetDioIrqParams(irqVal, irqVal, SX126X_IRQ_NONE, SX126X_IRQ_NONE)
 	d.SetSyncWord(d.loraConf.SyncWord)
 	d.SetTx(timeoutMsToRtcSteps(timeoutMs))
-
-	msg := <-d.GetRadioEventChan()
-	if msg.EventType != lora.RadioEventTxDone {
-		return errUnexpectedTxRadioEvent
-	}
 	return nil
 }
 
 // LoraRx tries to receive a Lora packet (with timeout in milliseconds)
 func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
-	if d.loraConf.Freq == 0 {
-		return nil, lora.ErrUndefinedLoraConf
+	if err := d.StartRx(timeoutMs); err != nil {
+		return nil, err
+	}
+
+	msg := <-d.GetRadioEventChan()
+
+	if msg.EventType == lora.RadioEventTimeout {
+		return nil, nil
+	} else if msg.EventType != lora.RadioEventRxDone {
+		return nil, errUnexpectedRxRadioEvent
+	}
+
+	pkt, err := d.ReadPacket()
+	return pkt.Payload, err
+}
+
+// StartRx starts listening for a packet with the current modem and returns
+// without waiting. RadioEventRxDone, RadioEventTimeout or RadioEventCrcError
+// is sent once done. A timeoutMs of 0 listens until a packet is received.
+func (d *Device) StartRx(timeoutMs uint32) error {
+	if d.frequency() == 0 {
+		return lora.ErrUndefinedLoraConf
 	}
 
 	if d.controller != nil {
 		err := d.controller.SetRfSwitchMode(RFSWITCH_RX)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
+	d.clearRadioEvents()
 	d.ClearIrqStatus(SX126X_IRQ_ALL)
 	irqVal := uint16(SX126X_IRQ_RX_DONE | SX126X_IRQ_TIMEOUT | SX126X_IRQ_CRC_ERR)
 	d.SetStandby()
 	d.SetBufferBaseAddress(0, 0)
+	if d.modem == lora.ModemFSK {
+		maxLen := d.fskConf.PayloadLength
+		if maxLen == 0 {
+			maxLen = 0xFF
+		}
+		d.configureFsk(maxLen)
+	} else {
+		d.SetRfFrequency(d.loraConf.Freq)
+		d.SetModulationParams(d.loraConf.Sf, bandwidth(d.loraConf.Bw), d.loraConf.Cr, d.loraConf.Ldr)
+		d.SetPacketParam(d.loraConf.Preamble, d.loraConf.HeaderType, d.loraConf.Crc, 0xFF, d.loraConf.Iq)
+	}
+	d.SetDioIrqParams(irqVal, irqVal, SX126X_IRQ_NONE, SX126X_IRQ_NONE)
+	d.SetRx(timeoutMsToRtcSteps(timeoutMs))
+	return nil
+}
+
+// ReadPacket returns the last received packet with its RSSI, SNR,
+// frequency error and reception time. It must be called after
+// RadioEventRxDone, before starting another operation.
+func (d *Device) ReadPacket() (lora.Packet, error) {
+	var rssi int16
+	var snr int8
+	var freqErr int32
+	if d.modem == lora.ModemFSK {
+		// RxStatus, RssiSync, RssiAvg (13.5.3)
+		r := d.ExecGetCommand(SX126X_CMD_GET_PACKET_STATUS, 3)
+		rssi = -int16(r[1]) / 2
+	} else {
+		rssi, snr, _ = d.GetLoraPacketStatus()
+		freqErr = d.GetFrequencyError()
+	}
+
+	pLen, pStart := d.GetRxBufferStatus()
+	d.SetBufferBaseAddress(0, pStart+1)
+	buf := d.ReadBuffer(pLen + 1)
+
+	return lora.Packet{
+		Payload:   append([]uint8(nil), buf[1:]...),
+		RSSI:      rssi,
+		SNR:       snr,
+		FreqError: freqErr,
+		Timestamp: d.rxTimestamp,
+	}, nil
+}
+
+// GetLoraPacketStatus returns the RSSI (dBm) and SNR (dB) of the last
+// received packet, and the RSSI of the LoRa signal once despread (13.5.3)
+func (d *Device) GetLoraPacketStatus() (rssiPkt int16, snrPkt int8, signalRssiPkt int16) {
+	r := d.ExecGetCommand(SX126X_CMD_GET_PACKET_STATUS, 3)
+	return -int16(r[0]) / 2, int8(r[1]) / 4, -int16(r[2]) / 2
+}
+
+// GetFrequencyError returns the frequency offset of the last received
+// packet, in Hz, estimated by the LoRa demodulator
+func (d *Device) GetFrequencyError() int32 {
+	r, _ := d.ReadRegister(SX126X_REG_FREQ_ERROR, 3)
+	// 20 bits two's complement value
+	efe := int32(r[0]&0x0F)<<16 | int32(r[1])<<8 | int32(r[2])
+	if efe&0x80000 != 0 {
+		efe -= 0x100000
+	}
+	return int32(int64(efe) * int64(lora.BandwidthHz(d.loraConf.Bw)) * 155 / 160000000)
+}
+
+// SetCadConfig defines Channel Activity Detection parameters for next CAD
+func (d *Device) SetCadConfig(cnf lora.CadConfig) {
+	d.cadConf = cnf
+}
+
+// SetCadParams configures Channel Activity Detection (13.4.7)
+// timeout is expressed in RTC Step unit, used with SX126X_CAD_GOTO_RX
+func (d *Device) SetCadParams(symbolNum, detPeak, detMin, exitMode uint8, timeoutRtcStep uint32) {
+	var p [7]uint8
+	p[0] = symbolNum
+	p[1] = detPeak
+	p[2] = detMin
+	p[3] = exitMode
+	p[4] = uint8((timeoutRtcStep >> 16) & 0xFF)
+	p[5] = uint8((timeoutRtcStep >> 8) & 0xFF)
+	p[6] = uint8((timeoutRtcStep >> 0) & 0xFF)
+	d.ExecSetCommand(SX126X_CMD_SET_CAD_PARAMS, p[:])
+}
+
+// StartCad starts a Channel Activity Detection on the current frequency and
+// spreading factor and returns without waiting.
+// RadioEventCadDetected or RadioEventCadDone is sent once done.
+func (d *Device) StartCad() error {
+	if d.loraConf.Freq == 0 {
+		return lora.ErrUndefinedLoraConf
+	}
+	if d.modem != lora.ModemLoRa {
+		return lora.ErrUnsupportedModem
+	}
+
+	if d.controller != nil {
+		err := d.controller.SetRfSwitchMode(RFSWITCH_RX)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Defaults recommended by AN1200.48 for 2 symbols
+	symbolNum := uint8(SX126X_CAD_ON_2_SYMB)
+	switch d.cadConf.Symbols {
+	case 1:
+		symbolNum = SX126X_CAD_ON_1_SYMB
+	case 4:
+		symbolNum = SX126X_CAD_ON_4_SYMB
+	case 8:
+		symbolNum = SX126X_CAD_ON_8_SYMB
+	case 16:
+		symbolNum = SX126X_CAD_ON_16_SYMB
+	}
+	detPeak := d.cadConf.DetPeak
+	if detPeak == 0 {
+		detPeak = d.loraConf.Sf + 13
+	}
+	detMin := d.cadConf.DetMin
+	if detMin == 0 {
+		detMin = 10
+	}
+
+	d.clearRadioEvents()
+	d.ClearIrqStatus(SX126X_IRQ_ALL)
+	irqVal := uint16(SX126X_IRQ_CAD_DONE | SX126X_IRQ_CAD_DETECTED)
+	d.SetStandby()
+	d.SetPacketType(SX126X_PACKET_TYPE_LORA)
 	d.SetRfFrequency(d.loraConf.Freq)
 	d.SetModulationParams(d.loraConf.Sf, bandwidth(d.loraConf.Bw), d.loraConf.Cr, d.loraConf.Ldr)
 	d.SetPacketParam(d.loraConf.Preamble, d.loraConf.HeaderType, d.loraConf.Crc, 0xFF, d.loraConf.Iq)
+	d.SetSyncWord(d.loraConf.SyncWord)
+	d.SetCadParams(symbolNum, detPeak, detMin, SX126X_CAD_GOTO_STDBY, 0)
 	d.SetDioIrqParams(irqVal, irqVal, SX126X_IRQ_NONE, SX126X_IRQ_NONE)
-	d.SetRx(timeoutMsToRtcSteps(timeoutMs))
+	d.ExecSetCommand(SX126X_CMD_SET_CAD, []uint8{})
+	return nil
+}
 
-	msg := <-d.GetRadioEventChan()
+// ChannelActivity runs a Channel Activity Detection and reports if a LoRa
+// signal was detected
+func (d *Device) ChannelActivity() (bool, error) {
+	if err := d.StartCad(); err != nil {
+		return false, err
+	}
 
-	if msg.EventType == lora.RadioEventTimeout {
-		return nil, nil
-	} else if msg.EventType != lora.RadioEventRxDone {
-		return nil, errUnexpectedRxRadioEvent
+	msg := <-d.GetRadioEventChan()
+	switch msg.EventType {
+	case lora.RadioEventCadDetected:
+		return true, nil
+	case lora.RadioEventCadDone:
+		return false, nil
 	}
+	return false, errUnexpectedCadEvent
+}
 
-	pLen, pStart := d.GetRxBufferStatus()
-	d.SetBufferBaseAddress(0, pStart+1)
-	pkt := d.ReadBuffer(pLen + 1)
-	pkt = pkt[1:]
+// frequency returns the frequency of the current modem
+func (d *Device) frequency() uint32 {
+	if d.modem == lora.ModemFSK {
+		return d.fskConf.Freq
+	}
+	return d.loraConf.Freq
+}
 
-	return pkt, nil
+// clearRadioEvents drops events left by a previous operation
+func (d *Device) clearRadioEvents() {
+	for {
+		select {
+		case <-d.radioEventChan:
+		default:
+			return
+		}
+	}
 }
 
 // HandleInterrupt must be called by main code on DIO state change.
@@ -702,6 +900,7 @@ func (d *Device) HandleInterrupt() {
 	d.ClearIrqStatus(SX126X_IRQ_ALL)
 
 	if (st & SX126X_IRQ_RX_DONE) > 0 {
+		d.rxTimestamp = time.Now()
 		select {
 		case d.radioEventChan <- lora.RadioEvent{lora.RadioEventRxDone, uint16(st), nil}:
 		default:
@@ -723,6 +922,17 @@ func (d *Device) HandleInterrupt() {
 
 	}
 
+	if (st & SX126X_IRQ_CAD_DONE) > 0 {
+		eType := lora.RadioEventCadDone
+		if (st & SX126X_IRQ_CAD_DETECTED) > 0 {
+			eType = lora.RadioEventCadDetected
+		}
+		select {
+		case d.radioEventChan <- lora.NewRadioEvent(eType, st, nil):
+		default:
+		}
+	}
+
 	if (st & SX126X_IRQ_CRC_ERR) > 0 {
 		select {
 		case d.radioEventChan <- lora.RadioEvent{lora.RadioEventCrcError, uint16(st), nil}:
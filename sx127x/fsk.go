@@ -0,0 +1,244 @@
+package sx127x
+
+import (
+	"time"
+
+	"tinygo.org/x/drivers/lora"
+)
+
+// FskConfig defines FSK/GFSK configuration for next operations, until
+// LoraConfig is called.
+// The sx127x needs a sync word, and packets, including the length byte of
+// variable length packets, must fit in its 64 bytes FIFO.
+func (d *Device) FskConfig(cnf lora.FskConfig) error {
+	if _, ok := fskRxBandwidth(cnf.RxBandwidth() / 2); !ok {
+		return lora.ErrUnsupportedFskConfig
+	}
+	if _, ok := fskShaping(cnf.Shaping); !ok {
+		return lora.ErrUnsupportedFskConfig
+	}
+	if cnf.Bitrate < 500 || cnf.Bitrate > 300000 || uint64(cnf.Fdev)<<19/32000000 > 0x3FFF {
+		return lora.ErrUnsupportedFskConfig
+	}
+	if len(cnf.SyncWord) == 0 || len(cnf.SyncWord) > 8 || cnf.Crc > lora.FskCrcIBM {
+		return lora.ErrUnsupportedFskConfig
+	}
+	if cnf.FixedLength && (cnf.PayloadLength == 0 || cnf.PayloadLength > SX127X_FSK_FIFO_SIZE) ||
+		!cnf.FixedLength && cnf.PayloadLength > SX127X_FSK_FIFO_SIZE-1 {
+		return lora.ErrUnsupportedFskConfig
+	}
+
+	d.fskConf = cnf
+	d.fskConf.SyncWord = append([]uint8(nil), cnf.SyncWord...)
+	d.modem = lora.ModemFSK
+	return nil
+}
+
+// Modem returns the current modem, lora.ModemLoRa or lora.ModemFSK
+func (d *Device) Modem() uint8 {
+	return d.modem
+}
+
+// SetOpModeFsk switches the device to FSK/OOK mode, in sleep mode
+func (d *Device) SetOpModeFsk() {
+	d.WriteRegister(SX127X_REG_OP_MODE, SX127X_OPMODE_FSK)
+}
+
+// SetBitrate sets the FSK bit rate, in bit/s
+func (d *Device) SetBitrate(bitrate uint32) {
+	d.fskConf.Bitrate = bitrate
+	br := 32000000 / bitrate
+	d.WriteRegister(SX127X_REG_FSK_BITRATE_MSB, uint8(br>>8))
+	d.WriteRegister(SX127X_REG_FSK_BITRATE_LSB, uint8(br))
+}
+
+// SetFrequencyDeviation sets the FSK frequency deviation, in Hz
+func (d *Device) SetFrequencyDeviation(fdev uint32) {
+	d.fskConf.Fdev = fdev
+	fd := uint64(fdev) << 19 / 32000000
+	d.WriteRegister(SX127X_REG_FSK_FDEV_MSB, uint8(fd>>8)&0x3F)
+	d.WriteRegister(SX127X_REG_FSK_FDEV_LSB, uint8(fd))
+}
+
+// startFskTx is StartTx for the FSK modem
+func (d *Device) startFskTx(pkt []uint8) error {
+	n := len(pkt)
+	if !d.fskConf.FixedLength {
+		n++
+	}
+	if n > SX127X_FSK_FIFO_SIZE {
+		return lora.ErrPacketTooLong
+	}
+
+	d.SetOpModeFsk()
+	d.configureFsk()
+	d.WriteRegister(SX127X_REG_DIO_MAPPING_1, SX127X_MAP_DIO0_FSK_PACKET|SX127X_MAP_DIO1_FSK_NONE)
+	if d.fskConf.FixedLength {
+		d.WriteRegister(SX127X_REG_FSK_PAYLOAD_LENGTH, uint8(len(pkt)))
+	}
+
+	// FIFO OPs cannot take place in Sleep mode !!!
+	d.SetOpMode(SX127X_OPMODE_STANDBY)
+	time.Sleep(time.Millisecond)
+	if !d.fskConf.FixedLength {
+		d.WriteRegister(SX127X_REG_FIFO, uint8(len(pkt)))
+	}
+	for i := 0; i < len(pkt); i++ {
+		d.WriteRegister(SX127X_REG_FIFO, pkt[i])
+	}
+
+	d.clearRadioEvents()
+	d.SetOpMode(SX127X_OPMODE_TX)
+	return nil
+}
+
+// startFskRx is StartRx for the FSK modem
+func (d *Device) startFskRx(timeoutMs uint32) {
+	d.SetOpModeFsk()
+	d.configureFsk()
+	d.WriteRegister(SX127X_REG_DIO_MAPPING_1, SX127X_MAP_DIO0_FSK_PACKET|SX127X_MAP_DIO1_FSK_NONE)
+	maxLen := d.fskConf.PayloadLength
+	if maxLen == 0 {
+		maxLen = SX127X_FSK_FIFO_SIZE - 1
+	}
+	d.WriteRegister(SX127X_REG_FSK_PAYLOAD_LENGTH, maxLen)
+
+	// FSK RX has no timeout of its own either
+	d.clearRadioEvents()
+	d.SetOpMode(SX127X_OPMODE_RX)
+	if timeoutMs > 0 {
+		d.rxTimer = time.AfterFunc(time.Millisecond*time.Duration(timeoutMs), d.rxTimeout)
+	}
+}
+
+// readFskPacket is ReadPacket for the FSK modem
+func (d *Device) readFskPacket() (lora.Packet, error) {
+	pLen := d.fskConf.PayloadLength
+	if !d.fskConf.FixedLength {
+		pLen = d.ReadRegister(SX127X_REG_FIFO)
+		if pLen > SX127X_FSK_FIFO_SIZE-1 {
+			return lora.Packet{}, lora.ErrPacketTooLong
+		}
+	}
+	rxData := []uint8{}
+	for i := uint8(0); i < pLen; i++ {
+		rxData = append(rxData, d.ReadRegister(SX127X_REG_FIFO))
+	}
+
+	// FEI is a two's complement value, in Fstep = Fxtal / 2^19
+	fei := int16(uint16(d.ReadRegister(SX127X_REG_FSK_FEI_MSB))<<8 | uint16(d.ReadRegister(SX127X_REG_FSK_FEI_LSB)))
+	return lora.Packet{
+		Payload:   rxData,
+		RSSI:      -int16(d.ReadRegister(SX127X_REG_FSK_RSSI_VALUE)) / 2,
+		FreqError: int32(int64(fei) * 32000000 >> 19),
+		Timestamp: d.rxTimestamp,
+	}, nil
+}
+
+// configureFsk applies the current FSK configuration to the device
+func (d *Device) configureFsk() {
+	cnf := &d.fskConf
+	rxBw, _ := fskRxBandwidth(cnf.RxBandwidth() / 2)
+	shaping, _ := fskShaping(cnf.Shaping)
+
+	d.SetLowFrequencyModeOn(false)                       // High freq mode
+	d.WriteRegister(SX127X_REG_PA_RAMP, shaping|0x08)    // shaping, PA ramp-up time 50 uSec
+	d.WriteRegister(SX127X_REG_LNA, SX127X_LNA_MAX_GAIN) // Set Low Noise Amplifier to MAX
+
+	d.SetFrequency(cnf.Freq)
+	d.SetTxPower(cnf.TxPowerDBm)
+	d.SetBitrate(cnf.Bitrate)
+	d.SetFrequencyDeviation(cnf.Fdev)
+	d.WriteRegister(SX127X_REG_FSK_RX_BW, rxBw)
+	d.WriteRegister(SX127X_REG_FSK_AFC_BW, rxBw)
+	// AFC and AGC on, RX triggered by preamble detection
+	d.WriteRegister(SX127X_REG_FSK_RX_CONFIG, 0x1E)
+	// Preamble detector on, 2 bytes, 10 chips tolerance
+	d.WriteRegister(SX127X_REG_FSK_PREAMBLE_DETECT, 0xAA)
+	d.WriteRegister(SX127X_REG_FSK_PREAMBLE_MSB, uint8(cnf.Preamble>>8))
+	d.WriteRegister(SX127X_REG_FSK_PREAMBLE_LSB, uint8(cnf.Preamble))
+
+	d.WriteRegister(SX127X_REG_FSK_SYNC_CONFIG, SX127X_FSK_SYNC_AUTORESTART_RX|SX127X_FSK_SYNC_ON|uint8(len(cnf.SyncWord)-1))
+	for i, b := range cnf.SyncWord {
+		d.WriteRegister(SX127X_REG_FSK_SYNC_VALUE_1+uint8(i), b)
+	}
+
+	// CRC is checked by HandleInterrupt: keep packets with a wrong CRC
+	pc := SX127X_FSK_CRC_AUTOCLEAR_OFF
+	if !cnf.FixedLength {
+		pc |= SX127X_FSK_PACKET_VARIABLE
+	}
+	if cnf.Whitening {
+		pc |= SX127X_FSK_DC_FREE_WHITENING
+	}
+	switch cnf.Crc {
+	case lora.FskCrcCCITT:
+		pc |= SX127X_FSK_CRC_ON
+	case lora.FskCrcIBM:
+		pc |= SX127X_FSK_CRC_ON | SX127X_FSK_CRC_IBM
+	}
+	d.WriteRegister(SX127X_REG_FSK_PACKET_CONFIG_1, pc)
+	d.WriteRegister(SX127X_REG_FSK_PACKET_CONFIG_2, SX127X_FSK_DATA_MODE_PACKET)
+	// TX starts as soon as the FIFO isn't empty
+	d.WriteRegister(SX127X_REG_FSK_FIFO_THRESH, 0x8F)
+}
+
+// handleFskInterrupt is HandleInterrupt for the FSK modem
+func (d *Device) handleFskInterrupt() {
+	st := d.ReadRegister(SX127X_REG_FSK_IRQ_FLAGS_2)
+
+	if (st & SX127X_IRQ_FSK_PACKET_SENT) > 0 {
+		d.SetOpMode(SX127X_OPMODE_STANDBY)
+		select {
+		case d.radioEventChan <- lora.NewRadioEvent(lora.RadioEventTxDone, uint16(st), nil):
+		default:
+		}
+	}
+
+	if (st & SX127X_IRQ_FSK_PAYLOAD_READY) > 0 {
+		d.rxTimestamp = time.Now()
+		if d.rxTimer != nil {
+			d.rxTimer.Stop()
+		}
+		// The FIFO is kept in standby, until ReadPacket
+		d.SetOpMode(SX127X_OPMODE_STANDBY)
+		eType := lora.RadioEventRxDone
+		if d.fskConf.Crc != lora.FskCrcOff && (st&SX127X_IRQ_FSK_CRC_OK) == 0 {
+			eType = lora.RadioEventCrcError
+		}
+		select {
+		case d.radioEventChan <- lora.NewRadioEvent(eType, uint16(st), nil):
+		default:
+		}
+	}
+}
+
+// fskRxBandwidth returns the RegRxBw value of the smallest single side
+// bandwidth of at least hz (table 40)
+func fskRxBandwidth(hz uint32) (uint8, bool) {
+	for exp := uint8(7); exp >= 1; exp-- {
+		for mant := uint8(2); ; mant-- {
+			if 32000000/(uint32(16+4*mant)<<(exp+2)) >= hz {
+				return mant<<3 | exp, true
+			}
+			if mant == 0 {
+				break
+			}
+		}
+	}
+	return 0, false
+}
+
+func fskShaping(shaping uint8) (uint8, bool) {
+	switch shaping {
+	case lora.FskShapingNone:
+		return SX127X_FSK_SHAPING_NONE, true
+	case lora.FskShapingGaussBT0_3:
+		return SX127X_FSK_SHAPING_GAUSS_0_3, true
+	case lora.FskShapingGaussBT0_5:
+		return SX127X_FSK_SHAPING_GAUSS_0_5, true
+	case lora.FskShapingGaussBT1_0:
+		return SX127X_FSK_SHAPING_GAUSS_1_0, true
+	}
+	return 0, false
+}
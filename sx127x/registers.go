@@ -59,12 +59,66 @@ const (
 	// DIO function mappings                D0D1D2D3
 	SX127X_MAP_DIO0_LORA_RXDONE = uint8(0x00) // 00------
 	SX127X_MAP_DIO0_LORA_TXDONE = uint8(0x40) // 01------
+	SX127X_MAP_DIO0_LORA_CDDONE = uint8(0x80) // 10------
 	SX127X_MAP_DIO1_LORA_RXTOUT = uint8(0x00) // --00----
+	SX127X_MAP_DIO1_LORA_CDDETD = uint8(0x20) // --10----
 	SX127X_MAP_DIO1_LORA_NOP    = uint8(0x30) // --11----
 	SX127X_MAP_DIO2_LORA_NOP    = uint8(0xC0) // ----11--
 
 	SX127X_PAYLOAD_LENGTH = uint8(0x40)
 
+	// FSK registers, differing from LoRa ones at same addresses
+	SX127X_REG_FSK_BITRATE_MSB     = 0x02
+	SX127X_REG_FSK_BITRATE_LSB     = 0x03
+	SX127X_REG_FSK_FDEV_MSB        = 0x04
+	SX127X_REG_FSK_FDEV_LSB        = 0x05
+	SX127X_REG_FSK_RX_CONFIG       = 0x0d
+	SX127X_REG_FSK_RSSI_VALUE      = 0x11
+	SX127X_REG_FSK_RX_BW           = 0x12
+	SX127X_REG_FSK_AFC_BW          = 0x13
+	SX127X_REG_FSK_FEI_MSB         = 0x1d
+	SX127X_REG_FSK_FEI_LSB         = 0x1e
+	SX127X_REG_FSK_PREAMBLE_DETECT = 0x1f
+	SX127X_REG_FSK_PREAMBLE_MSB    = 0x25
+	SX127X_REG_FSK_PREAMBLE_LSB    = 0x26
+	SX127X_REG_FSK_SYNC_CONFIG     = 0x27
+	SX127X_REG_FSK_SYNC_VALUE_1    = 0x28
+	SX127X_REG_FSK_PACKET_CONFIG_1 = 0x30
+	SX127X_REG_FSK_PACKET_CONFIG_2 = 0x31
+	SX127X_REG_FSK_PAYLOAD_LENGTH  = 0x32
+	SX127X_REG_FSK_FIFO_THRESH     = 0x35
+	SX127X_REG_FSK_IRQ_FLAGS_1     = 0x3e
+	SX127X_REG_FSK_IRQ_FLAGS_2     = 0x3f
+
+	// FSK IRQ flags 2
+	SX127X_IRQ_FSK_FIFO_OVERRUN  = uint8(0x10)
+	SX127X_IRQ_FSK_PACKET_SENT   = uint8(0x08)
+	SX127X_IRQ_FSK_PAYLOAD_READY = uint8(0x04)
+	SX127X_IRQ_FSK_CRC_OK        = uint8(0x02)
+
+	// FSK DIO mapping: DIO0=PacketSent/PayloadReady, DIO1 unused
+	SX127X_MAP_DIO0_FSK_PACKET = uint8(0x00)
+	SX127X_MAP_DIO1_FSK_NONE   = uint8(0x30)
+
+	// FSK packet configuration
+	SX127X_FSK_PACKET_VARIABLE     = uint8(0x80)
+	SX127X_FSK_DC_FREE_WHITENING   = uint8(0x40)
+	SX127X_FSK_CRC_ON              = uint8(0x10)
+	SX127X_FSK_CRC_AUTOCLEAR_OFF   = uint8(0x08)
+	SX127X_FSK_CRC_IBM             = uint8(0x01)
+	SX127X_FSK_DATA_MODE_PACKET    = uint8(0x40)
+	SX127X_FSK_SYNC_AUTORESTART_RX = uint8(0x40)
+	SX127X_FSK_SYNC_ON             = uint8(0x10)
+
+	// FSK Gaussian filter, in RegPaRamp
+	SX127X_FSK_SHAPING_NONE      = uint8(0x00)
+	SX127X_FSK_SHAPING_GAUSS_1_0 = uint8(0x20)
+	SX127X_FSK_SHAPING_GAUSS_0_5 = uint8(0x40)
+	SX127X_FSK_SHAPING_GAUSS_0_3 = uint8(0x60)
+
+	// FSK FIFO size
+	SX127X_FSK_FIFO_SIZE = 64
+
 	// Low Noise Amp
 	SX127X_LNA_MAX_GAIN = uint8(0x23)
 	SX127X_LNA_OFF_GAIN = uint8(0x00)
@@ -86,6 +140,7 @@ const (
 	SX127X_AGC_AUTO_ON  = uint8(0x01)
 	// Operation modes
 	SX127X_OPMODE_LORA      = uint8(0x80)
+	SX127X_OPMODE_FSK       = uint8(0x00)
 	SX127X_OPMODE_MASK      = uint8(0x07)
 	SX127X_OPMODE_SLEEP     = uint8(0x00)
 	SX127X_OPMODE_STANDBY   = uint8(0x01)
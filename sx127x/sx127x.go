@@ -30,6 +30,10 @@ type Device struct {
 	deviceType     int                  // sx1261,sx1262,sx1268 (defaults sx1261)
 	spiTxBuf       []byte               // global Tx buffer to avoid heap allocations in interrupt
 	spiRxBuf       []byte               // global Rx buffer to avoid heap allocations in interrupt
+	rxTimestamp    time.Time            // time of the last RxDone interrupt
+	rxTimer        *time.Timer          // stops continuous RX started by StartRx
+	fskConf        lora.FskConfig       // Current FSK configuration
+	modem          uint8                // Current modem: lora.ModemLoRa or lora.ModemFSK
 }
 
 // --------------------------------------------------
@@ -136,9 +140,9 @@ func (d *Device) IsTransmitting() bool {
 
 // LastPacketRSSI gives the RSSI of the last packet received
 func (d *Device) LastPacketRSSI() uint8 {
-	// section 5.5.5
+	// section 5.5.5, the LF port is used below 525 MHz
 	var adjustValue uint8 = 157
-	if d.loraConf.Freq < 868000000 {
+	if d.loraConf.Freq < 525000000 {
 		adjustValue = 164
 	}
 	return d.ReadRegister(SX127X_REG_PKT_RSSI_VALUE) - adjustValue
@@ -264,11 +268,13 @@ func (d *Device) LoraConfig(cnf lora.Config) {
 	// Save given configuration
 	d.loraConf = cnf
 	d.loraConf.SyncWord = syncword(int(cnf.SyncWord))
+	d.modem = lora.ModemLoRa
 }
 
 // SetFrequency updates the frequency the LoRa module is using
 func (d *Device) SetFrequency(frequency uint32) {
 	d.loraConf.Freq = frequency
+	d.fskConf.Freq = frequency
 	var frf = (uint64(frequency) << 19) / 32000000
 	d.WriteRegister(SX127X_REG_FRF_MSB, uint8(frf>>16))
 	d.WriteRegister(SX127X_REG_FRF_MID, uint8(frf>>8))
@@ -314,6 +320,7 @@ func (d *Device) SetSpreadingFactor(sf uint8) {
 // SetTxPower sets the transmitter output (with paBoost ON)
 func (d *Device) SetTxPower(txPower int8) {
 	d.loraConf.LoraTxPowerDBm = txPower
+	d.fskConf.TxPowerDBm = txPower
 	d.SetTxPowerWithPaBoost(txPower, true)
 }
 
@@ -367,25 +374,27 @@ func (d *Device) SetPublicNetwork(enabled bool) {
 
 // Tx sends a lora packet, (with timeout)
 func (d *Device) Tx(pkt []uint8, timeoutMs uint32) error {
-	d.SetOpModeLora()
-	d.SetOpMode(SX127X_OPMODE_SLEEP)
+	if err := d.StartTx(pkt, timeoutMs); err != nil {
+		return err
+	}
 
-	d.SetHopPeriod(0x00)
-	d.SetLowFrequencyModeOn(false)                                                      // High freq mode
-	d.WriteRegister(SX127X_REG_PA_RAMP, (d.ReadRegister(SX127X_REG_PA_RAMP)&0xF0)|0x08) // set PA ramp-up time 50 uSec
-	d.WriteRegister(SX127X_REG_LNA, SX127X_LNA_MAX_GAIN)                                // Set Low Noise Amplifier to MAX
+	msg := <-d.GetRadioEventChan()
+	if msg.EventType != lora.RadioEventTxDone {
+		return errors.New("Unexpected Radio Event while TX " + string(0x30+msg.EventType))
+	}
+	return nil
+}
 
-	d.SetFrequency(d.loraConf.Freq)
-	d.SetPreambleLength(d.loraConf.Preamble)
-	d.SetSyncWord(d.loraConf.SyncWord)
-	d.SetBandwidth(d.loraConf.Bw)
-	d.SetSpreadingFactor(d.loraConf.Sf)
-	d.SetIqMode(d.loraConf.Iq)
-	d.SetCodingRate(d.loraConf.Cr)
-	d.SetCrc(d.loraConf.Crc == lora.CRCOn)
-	d.SetTxPower(d.loraConf.LoraTxPowerDBm)
-	d.SetHeaderType(d.loraConf.HeaderType)
-	d.SetAgcAuto(SX127X_AGC_AUTO_ON)
+// StartTx starts sending a packet with the current modem and returns
+// without waiting. RadioEventTxDone is sent once done.
+func (d *Device) StartTx(pkt []uint8, timeoutMs uint32) error {
+	if d.modem == lora.ModemFSK {
+		return d.startFskTx(pkt)
+	}
+
+	d.SetOpModeLora()
+	d.SetOpMode(SX127X_OPMODE_SLEEP)
+	d.configure()
 
 	// set the IRQ mapping DIO0=TxDone DIO1=NOP DIO2=NOP
 	d.WriteRegister(SX127X_REG_DIO_MAPPING_1, SX127X_MAP_DIO0_LORA_TXDONE|SX127X_MAP_DIO1_LORA_NOP|SX127X_MAP_DIO2_LORA_NOP)
@@ -408,40 +417,46 @@ func (d *Device) Tx(pkt []uint8, timeoutMs uint32) error {
 	}
 
 	// Enable TX
+	d.clearRadioEvents()
 	d.SetOpMode(SX127X_OPMODE_TX)
-
-	msg := <-d.GetRadioEventChan()
-	if msg.EventType != lora.RadioEventTxDone {
-		return errors.New("Unexpected Radio Event while TX " + string(0x30+msg.EventType))
-	}
 	return nil
 }
 
 // Rx tries to receive a Lora packet (with timeout in milliseconds)
 func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
+	if err := d.StartRx(timeoutMs); err != nil {
+		return nil, err
+	}
+
+	msg := <-d.radioEventChan
+	if msg.EventType == lora.RadioEventTimeout {
+		return nil, nil
+	} else if msg.EventType != lora.RadioEventRxDone {
+		return nil, errors.New("Unexpected Radio Event while RX " + string(0x30+msg.EventType))
+	}
+
+	pkt, err := d.ReadPacket()
+	return pkt.Payload, err
+}
+
+// StartRx starts listening for a packet with the current modem and returns
+// without waiting. RadioEventRxDone, RadioEventTimeout or RadioEventCrcError
+// is sent once done. A timeoutMs of 0 listens until a packet is received.
+func (d *Device) StartRx(timeoutMs uint32) error {
+	if d.modem == lora.ModemFSK {
+		if d.fskConf.Freq == 0 {
+			return lora.ErrUndefinedLoraConf
+		}
+		d.startFskRx(timeoutMs)
+		return nil
+	}
 	if d.loraConf.Freq == 0 {
-		return nil, lora.ErrUndefinedLoraConf
+		return lora.ErrUndefinedLoraConf
 	}
 
 	d.SetOpModeLora()
 	d.SetOpMode(SX127X_OPMODE_SLEEP)
-
-	d.SetHopPeriod(0x00)
-	d.SetLowFrequencyModeOn(false)                                                      // High freq mode
-	d.WriteRegister(SX127X_REG_PA_RAMP, (d.ReadRegister(SX127X_REG_PA_RAMP)&0xF0)|0x08) // set PA ramp-up time 50 uSec
-	d.WriteRegister(SX127X_REG_LNA, SX127X_LNA_MAX_GAIN)                                // Set Low Noise Amplifier to MAX
-
-	d.SetFrequency(d.loraConf.Freq)
-	d.SetPreambleLength(d.loraConf.Preamble)
-	d.SetSyncWord(d.loraConf.SyncWord)
-	d.SetBandwidth(d.loraConf.Bw)
-	d.SetSpreadingFactor(d.loraConf.Sf)
-	d.SetIqMode(d.loraConf.Iq)
-	d.SetCodingRate(d.loraConf.Cr)
-	d.SetCrc(d.loraConf.Crc == lora.CRCOn)
-	d.SetTxPower(d.loraConf.LoraTxPowerDBm)
-	d.SetHeaderType(d.loraConf.HeaderType)
-	d.SetAgcAuto(SX127X_AGC_AUTO_ON)
+	d.configure()
 
 	// set the IRQ mapping DIO0=RxDone DIO1=RxTimeout DIO2=NOP
 	d.WriteRegister(SX127X_REG_DIO_MAPPING_1, SX127X_MAP_DIO0_LORA_RXDONE|SX127X_MAP_DIO1_LORA_RXTOUT|SX127X_MAP_DIO2_LORA_NOP)
@@ -451,18 +466,78 @@ func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
 	d.WriteRegister(SX127X_REG_IRQ_FLAGS_MASK, ^(SX127X_IRQ_LORA_RXDONE_MASK | SX127X_IRQ_LORA_RXTOUT_MASK))
 
 	// Single RX mode don't properly handle Timeouts on sx127x, so we use Continuous RX
-	// Go routine is a workaround to stop the Continuous RX and fire a timeout Event
+	// A timer stops the Continuous RX and fires a timeout Event
+	d.clearRadioEvents()
 	d.SetOpMode(SX127X_OPMODE_RX)
+	if timeoutMs > 0 {
+		d.rxTimer = time.AfterFunc(time.Millisecond*time.Duration(timeoutMs), d.rxTimeout)
+	}
+	return nil
+}
+
+// SetCadConfig defines Channel Activity Detection parameters.
+// The sx127x has no CAD settings, detection always lasts about 2 symbols.
+func (d *Device) SetCadConfig(cnf lora.CadConfig) {}
 
-	var msg lora.RadioEvent
+// StartCad starts a Channel Activity Detection on the current frequency and
+// spreading factor and returns without waiting.
+// RadioEventCadDetected or RadioEventCadDone is sent once done.
+func (d *Device) StartCad() error {
+	if d.loraConf.Freq == 0 {
+		return lora.ErrUndefinedLoraConf
+	}
+	if d.modem != lora.ModemLoRa {
+		return lora.ErrUnsupportedModem
+	}
+
+	d.SetOpModeLora()
+	d.SetOpMode(SX127X_OPMODE_SLEEP)
+	d.configure()
+
+	// set the IRQ mapping DIO0=CadDone DIO1=CadDetected DIO2=NOP
+	d.WriteRegister(SX127X_REG_DIO_MAPPING_1, SX127X_MAP_DIO0_LORA_CDDONE|SX127X_MAP_DIO1_LORA_CDDETD|SX127X_MAP_DIO2_LORA_NOP)
+	// Clear all radio IRQ Flags
+	d.WriteRegister(SX127X_REG_IRQ_FLAGS, 0xFF)
+	// Mask all but CadDone and CadDetected
+	d.WriteRegister(SX127X_REG_IRQ_FLAGS_MASK, ^(SX127X_IRQ_LORA_CDDONE_MASK | SX127X_IRQ_LORA_CDDETD_MASK))
+
+	d.clearRadioEvents()
+	d.SetOpMode(SX127X_OPMODE_CAD)
+	return nil
+}
+
+// ChannelActivity runs a Channel Activity Detection and reports if a LoRa
+// signal was detected
+func (d *Device) ChannelActivity() (bool, error) {
+	if err := d.StartCad(); err != nil {
+		return false, err
+	}
+
+	msg := <-d.radioEventChan
+	switch msg.EventType {
+	case lora.RadioEventCadDetected:
+		return true, nil
+	case lora.RadioEventCadDone:
+		return false, nil
+	}
+	return false, errors.New("Unexpected Radio Event while CAD " + string(rune(0x30+msg.EventType)))
+}
+
+// rxTimeout stops the RX started by StartRx and sends a timeout event
+func (d *Device) rxTimeout() {
+	d.SetOpMode(SX127X_OPMODE_STANDBY)
 	select {
-	case msg = <-d.radioEventChan:
-		if msg.EventType != lora.RadioEventRxDone {
-			return nil, errors.New("Unexpected Radio Event while RX " + string(0x30+msg.EventType))
-		}
-	case <-time.After(time.Millisecond * time.Duration(timeoutMs)):
-		d.SetOpMode(SX127X_OPMODE_STANDBY)
-		return nil, nil
+	case d.radioEventChan <- lora.RadioEvent{EventType: lora.RadioEventTimeout}:
+	default:
+	}
+}
+
+// ReadPacket returns the last received packet with its RSSI, SNR,
+// frequency error and reception time. It must be called after
+// RadioEventRxDone, before starting another operation.
+func (d *Device) ReadPacket() (lora.Packet, error) {
+	if d.modem == lora.ModemFSK {
+		return d.readFskPacket()
 	}
 
 	// Get the received payload
@@ -476,7 +551,76 @@ func (d *Device) Rx(timeoutMs uint32) ([]uint8, error) {
 	for i := uint8(0); i < pLen; i++ {
 		rxData = append(rxData, d.ReadRegister(SX127X_REG_FIFO))
 	}
-	return rxData, nil
+
+	// section 5.5.5, the LF port is used below 525 MHz
+	snr := int8(d.ReadRegister(SX127X_REG_PKT_SNR_VALUE)) / 4
+	rssi := int16(-157)
+	if d.loraConf.Freq < 525000000 {
+		rssi = -164
+	}
+	rssi += int16(d.ReadRegister(SX127X_REG_PKT_RSSI_VALUE))
+	if snr < 0 {
+		rssi += int16(snr)
+	}
+
+	return lora.Packet{
+		Payload:   rxData,
+		RSSI:      rssi,
+		SNR:       snr,
+		FreqError: d.frequencyError(),
+		Timestamp: d.rxTimestamp,
+	}, nil
+}
+
+// frequencyError returns the frequency offset of the last received packet
+// in Hz (section 4.1.5)
+func (d *Device) frequencyError() int32 {
+	// 20 bits two's complement value
+	fei := int32(d.ReadRegister(SX127X_REG_FREQ_ERROR_MSB)&0x0F)<<16 |
+		int32(d.ReadRegister(SX127X_REG_FREQ_ERROR_MID))<<8 |
+		int32(d.ReadRegister(SX127X_REG_FREQ_ERROR_LSB))
+	if fei&0x80000 != 0 {
+		fei -= 0x100000
+	}
+	// FreqError = FEI * 2^24 / Fxtal * BW / 500 kHz
+	bwHz := int64(lora.BandwidthHz(d.loraConf.Bw))
+	return int32(int64(fei) * (1 << 24) / 32000000 * bwHz / 500000)
+}
+
+// configure applies the current Lora configuration to the device
+func (d *Device) configure() {
+	d.SetHopPeriod(0x00)
+	d.SetLowFrequencyModeOn(false)                                                      // High freq mode
+	d.WriteRegister(SX127X_REG_PA_RAMP, (d.ReadRegister(SX127X_REG_PA_RAMP)&0xF0)|0x08) // set PA ramp-up time 50 uSec
+	d.WriteRegister(SX127X_REG_LNA, SX127X_LNA_MAX_GAIN)                                // Set Low Noise Amplifier to MAX
+
+	d.SetFrequency(d.loraConf.Freq)
+	d.SetPreambleLength(d.loraConf.Preamble)
+	d.SetSyncWord(d.loraConf.SyncWord)
+	d.SetBandwidth(d.loraConf.Bw)
+	d.SetSpreadingFactor(d.loraConf.Sf)
+	d.SetIqMode(d.loraConf.Iq)
+	d.SetCodingRate(d.loraConf.Cr)
+	d.SetCrc(d.loraConf.Crc == lora.CRCOn)
+	d.SetTxPower(d.loraConf.LoraTxPowerDBm)
+	d.SetHeaderType(d.loraConf.HeaderType)
+	d.SetAgcAuto(SX127X_AGC_AUTO_ON)
+}
+
+// clearRadioEvents stops the RX timer and drops events left by a previous
+// operation
+func (d *Device) clearRadioEvents() {
+	if d.rxTimer != nil {
+		d.rxTimer.Stop()
+		d.rxTimer = nil
+	}
+	for {
+		select {
+		case <-d.radioEventChan:
+		default:
+			return
+		}
+	}
 }
 
 // SetTxContinuousMode enable Continuous Tx mode
@@ -520,12 +664,20 @@ func (d *Device) RandomU32() uint32 {
 
 // HandleInterrupt must be called by main code on DIO state change.
 func (d *Device) HandleInterrupt() {
+	if d.modem == lora.ModemFSK {
+		d.handleFskInterrupt()
+		return
+	}
 
 	// Get IRQ and clear
 	st := d.ReadRegister(SX127X_REG_IRQ_FLAGS)
 	d.WriteRegister(SX127X_REG_IRQ_FLAGS, 0xFF)
 
 	if (st & SX127X_IRQ_LORA_RXDONE_MASK) > 0 {
+		d.rxTimestamp = time.Now()
+		if d.rxTimer != nil {
+			d.rxTimer.Stop()
+		}
 		select {
 		case d.radioEventChan <- lora.RadioEvent{lora.RadioEventRxDone, uint16(st), nil}:
 		default:
@@ -546,6 +698,17 @@ func (d *Device) HandleInterrupt() {
 		}
 	}
 
+	if (st & SX127X_IRQ_LORA_CDDONE_MASK) > 0 {
+		eType := lora.RadioEventCadDone
+		if (st & SX127X_IRQ_LORA_CDDETD_MASK) > 0 {
+			eType = lora.RadioEventCadDetected
+		}
+		select {
+		case d.radioEventChan <- lora.NewRadioEvent(eType, uint16(st), nil):
+		default:
+		}
+	}
+
 	if (st & SX127X_IRQ_LORA_CRCERR_MASK) > 0 {
 		select {
 		case d.radioEventChan <- lora.RadioEvent{lora.RadioEventCrcError, uint16(st), nil}:
@@ -0,0 +1,293 @@
+package tester
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/pixel"
+)
+
+var errOutOfBounds = errors.New("rectangle coordinates outside display area")
+
+// Display is an in-memory display implementing drivers.Displayer, plus the
+// FillRectangle, DrawBitmap, rotation and scrolling methods of the display
+// drivers, so drawing code can be tested on the host.
+//
+// Pixels are stored in the color format T of the emulated display, so colors
+// are rounded as on the real display. Like display memory, the framebuffer
+// is in the native orientation of the panel: rotation changes where pixels
+// are written, and Image returns what the panel shows, with scrolling.
+type Display[T pixel.Color] struct {
+	width    int16 // native size, at Rotation0
+	height   int16
+	buf      []T
+	rotation drivers.Rotation
+	flushes  int
+
+	scrolling   bool
+	topFixed    int16
+	bottomFixed int16
+	scroll      int16
+}
+
+// NewDisplay returns a display of the given native size, cleared to the
+// zero color of T.
+func NewDisplay[T pixel.Color](width, height int) *Display[T] {
+	if width <= 0 || height <= 0 || int(int16(width)) != width || int(int16(height)) != height {
+		panic("NewDisplay: width/height out of bounds")
+	}
+	return &Display[T]{
+		width:  int16(width),
+		height: int16(height),
+		buf:    make([]T, width*height),
+	}
+}
+
+// Size returns the current size of the display, which depends on rotation.
+func (d *Display[T]) Size() (x, y int16) {
+	if d.rotation%2 == drivers.Rotation90 {
+		return d.height, d.width
+	}
+	return d.width, d.height
+}
+
+// index returns the framebuffer index of x, y, or -1 if out of bounds.
+func (d *Display[T]) index(x, y int16) int {
+	w, h := d.Size()
+	if x < 0 || y < 0 || x >= w || y >= h {
+		return -1
+	}
+	if d.rotation >= drivers.Rotation0Mirror {
+		x = w - 1 - x
+	}
+	switch d.rotation % 4 {
+	case drivers.Rotation90:
+		x, y = d.width-1-y, x
+	case drivers.Rotation180:
+		x, y = d.width-1-x, d.height-1-y
+	case drivers.Rotation270:
+		x, y = y, d.height-1-x
+	}
+	return int(y)*int(d.width) + int(x)
+}
+
+// SetPixel sets a pixel in the framebuffer. Pixels out of the display are
+// ignored.
+func (d *Display[T]) SetPixel(x, y int16, c color.RGBA) {
+	if i := d.index(x, y); i >= 0 {
+		d.buf[i] = pixel.NewColor[T](c.R, c.G, c.B)
+	}
+}
+
+// GetPixel returns the color of a pixel, or the zero color if out of the
+// display.
+func (d *Display[T]) GetPixel(x, y int16) T {
+	if i := d.index(x, y); i >= 0 {
+		return d.buf[i]
+	}
+	var zero T
+	return zero
+}
+
+// Display counts a flush, see Flushes.
+func (d *Display[T]) Display() error {
+	d.flushes++
+	return nil
+}
+
+// Flushes returns how many times Display was called.
+func (d *Display[T]) Flushes() int {
+	return d.flushes
+}
+
+func (d *Display[T]) inBounds(x, y, width, height int16) bool {
+	w, h := d.Size()
+	return x >= 0 && y >= 0 && width > 0 && height > 0 &&
+		x+width <= w && y+height <= h
+}
+
+// FillRectangle fills a rectangle at a given coordinates with a color.
+func (d *Display[T]) FillRectangle(x, y, width, height int16, c color.RGBA) error {
+	if !d.inBounds(x, y, width, height) {
+		return errOutOfBounds
+	}
+	pc := pixel.NewColor[T](c.R, c.G, c.B)
+	for j := y; j < y+height; j++ {
+		for i := x; i < x+width; i++ {
+			d.buf[d.index(i, j)] = pc
+		}
+	}
+	return nil
+}
+
+// FillScreen fills the screen with a given color.
+func (d *Display[T]) FillScreen(c color.RGBA) {
+	w, h := d.Size()
+	d.FillRectangle(0, 0, w, h, c)
+}
+
+// DrawBitmap copies the bitmap to the framebuffer at the given coordinates.
+func (d *Display[T]) DrawBitmap(x, y int16, bitmap pixel.Image[T]) error {
+	width, height := bitmap.Size()
+	if !d.inBounds(x, y, int16(width), int16(height)) {
+		return errOutOfBounds
+	}
+	for j := 0; j < height; j++ {
+		for i := 0; i < width; i++ {
+			d.buf[d.index(x+int16(i), y+int16(j))] = bitmap.Get(i, j)
+		}
+	}
+	return nil
+}
+
+// Rotation returns the currently configured rotation.
+func (d *Display[T]) Rotation() drivers.Rotation {
+	return d.rotation
+}
+
+// SetRotation changes the rotation of the display (clock-wise). Mirrored
+// rotations flip x before rotating. Pixels already drawn stay in place.
+func (d *Display[T]) SetRotation(rotation drivers.Rotation) error {
+	if rotation > drivers.Rotation270Mirror {
+		return fmt.Errorf("invalid rotation %d", rotation)
+	}
+	d.rotation = rotation
+	return nil
+}
+
+// SetScrollArea sets an area to scroll with fixed top and bottom parts of
+// the display, in native rows.
+func (d *Display[T]) SetScrollArea(topFixedArea, bottomFixedArea int16) {
+	d.topFixed = topFixedArea
+	d.bottomFixed = bottomFixedArea
+}
+
+// SetScroll sets the framebuffer row shown at the top of the scroll area.
+func (d *Display[T]) SetScroll(line int16) {
+	d.scrolling = true
+	d.scroll = line
+}
+
+// StopScroll returns the display to its normal state.
+func (d *Display[T]) StopScroll() {
+	d.scrolling = false
+	d.topFixed, d.bottomFixed, d.scroll = 0, 0, 0
+}
+
+// row returns the framebuffer row shown on panel row y
+func (d *Display[T]) row(y int) int {
+	top, bottom := int(d.topFixed), int(d.height-d.bottomFixed)
+	if !d.scrolling || y < top || y >= bottom || top >= bottom {
+		return y
+	}
+	n := bottom - top
+	offset := ((int(d.scroll)-top)%n + n) % n
+	return top + (y-top+offset)%n
+}
+
+// Image returns a snapshot of the panel, in its native orientation.
+func (d *Display[T]) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, int(d.width), int(d.height)))
+	for y := 0; y < int(d.height); y++ {
+		src := d.buf[d.row(y)*int(d.width):]
+		for x := 0; x < int(d.width); x++ {
+			img.SetRGBA(x, y, src[x].RGBA())
+		}
+	}
+	return img
+}
+
+// WritePNG writes the snapshot of the panel as a PNG image.
+func (d *Display[T]) WritePNG(w io.Writer) error {
+	return png.Encode(w, d.Image())
+}
+
+// SavePNG saves the snapshot of the panel as a PNG file.
+func (d *Display[T]) SavePNG(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := d.WritePNG(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// UpdateGoldenEnv is the environment variable that makes AssertGolden
+// (re)write golden files instead of comparing with them, as in:
+//
+//	UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// AssertGolden compares the snapshot of the panel with the golden PNG file at
+// path, typically in testdata. On mismatch the snapshot is saved next to it,
+// with a .actual.png suffix, and the test fails.
+func (d *Display[T]) AssertGolden(t Failer, path string) {
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating golden dir: %v", err)
+		}
+		if err := d.SavePNG(path); err != nil {
+			t.Fatalf("updating golden file: %v", err)
+		}
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening golden file (run with %s=1 to create it): %v", UpdateGoldenEnv, err)
+		return
+	}
+	want, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("decoding golden file %s: %v", path, err)
+		return
+	}
+
+	if diff := compareImages(d.Image(), want); diff != "" {
+		actual := strings.TrimSuffix(path, ".png") + ".actual.png"
+		if err := d.SavePNG(actual); err == nil {
+			diff += ", see " + actual
+		}
+		t.Fatalf("display differs from %s: %s", path, diff)
+	}
+}
+
+// compareImages returns a description of the differences of got and want,
+// or "" if they're equal.
+func compareImages(got *image.RGBA, want image.Image) string {
+	if got.Bounds() != want.Bounds() {
+		return fmt.Sprintf("size %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+	var n int
+	var first image.Point
+	b := got.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r1, g1, b1, _ := got.At(x, y).RGBA()
+			r2, g2, b2, _ := want.At(x, y).RGBA()
+			if r1>>8 != r2>>8 || g1>>8 != g2>>8 || b1>>8 != b2>>8 {
+				if n == 0 {
+					first = image.Pt(x, y)
+				}
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d pixels differ, first at %v: got %v, want %v",
+		n, first, got.At(first.X, first.Y), want.At(first.X, first.Y))
+}
@@ -0,0 +1,103 @@
+package tester
+
+import (
+	"image/color"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/pixel"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+func TestDisplayGolden(t *testing.T) {
+	c := qt.New(t)
+	d := NewDisplay[pixel.RGB565BE](32, 24)
+
+	d.FillScreen(white)
+	c.Assert(d.FillRectangle(2, 2, 12, 8, red), qt.IsNil)
+	c.Assert(d.FillRectangle(18, 2, 12, 8, green), qt.IsNil)
+	c.Assert(d.FillRectangle(30, 20, 4, 4, blue), qt.Not(qt.IsNil))
+
+	bitmap := pixel.NewImage[pixel.RGB565BE](16, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			bitmap.Set(x, y, pixel.NewRGB565BE(uint8(x*16), 0, uint8(y*32)))
+		}
+	}
+	c.Assert(d.DrawBitmap(8, 14, bitmap), qt.IsNil)
+	for i := int16(0); i < 24; i++ {
+		d.SetPixel(i, i, blue)
+	}
+
+	c.Assert(d.Display(), qt.IsNil)
+	c.Assert(d.Flushes(), qt.Equals, 1)
+
+	d.AssertGolden(c, "testdata/display.png")
+}
+
+func TestDisplayRotation(t *testing.T) {
+	c := qt.New(t)
+	d := NewDisplay[pixel.RGB888](4, 2)
+
+	// Where logical 0,0 lands on the panel
+	for _, tc := range []struct {
+		rotation drivers.Rotation
+		x, y     int
+	}{
+		{drivers.Rotation0, 0, 0},
+		{drivers.Rotation90, 3, 0},
+		{drivers.Rotation180, 3, 1},
+		{drivers.Rotation270, 0, 1},
+		{drivers.Rotation0Mirror, 3, 0},
+		{drivers.Rotation90Mirror, 3, 1},
+	} {
+		d.FillScreen(color.RGBA{})
+		c.Assert(d.SetRotation(tc.rotation), qt.IsNil)
+		d.SetPixel(0, 0, red)
+		c.Assert(d.Image().RGBAAt(tc.x, tc.y), qt.Equals, red, qt.Commentf("rotation %d", tc.rotation))
+		c.Assert(d.GetPixel(0, 0), qt.Equals, pixel.NewRGB888(255, 0, 0))
+	}
+
+	c.Assert(d.SetRotation(drivers.Rotation90), qt.IsNil)
+	w, h := d.Size()
+	c.Assert([]int16{w, h}, qt.DeepEquals, []int16{2, 4})
+	c.Assert(d.FillRectangle(0, 0, 2, 4, green), qt.IsNil)
+	c.Assert(d.FillRectangle(0, 0, 4, 2, green), qt.Equals, errOutOfBounds)
+}
+
+func TestDisplayScroll(t *testing.T) {
+	c := qt.New(t)
+	d := NewDisplay[pixel.Monochrome](1, 8)
+
+	// Rows 0-7 set at 2 and 3 only
+	d.SetPixel(0, 2, white)
+	d.SetPixel(0, 3, white)
+
+	lit := func() (rows []int) {
+		img := d.Image()
+		for y := 0; y < 8; y++ {
+			if img.RGBAAt(0, y) == white {
+				rows = append(rows, y)
+			}
+		}
+		return rows
+	}
+
+	d.SetScroll(2)
+	c.Assert(lit(), qt.DeepEquals, []int{0, 1})
+
+	// Fixed top and bottom rows don't move
+	d.SetScrollArea(1, 1)
+	d.SetScroll(3)
+	c.Assert(lit(), qt.DeepEquals, []int{1, 6})
+
+	d.StopScroll()
+	c.Assert(lit(), qt.DeepEquals, []int{2, 3})
+}
@@ -0,0 +1,755 @@
+package tester
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
+)
+
+// ErrConnectionDropped is returned by Send and Recv on connections dropped
+// by DropConnections or LinkDown.
+var ErrConnectionDropped = errors.New("Connection dropped")
+
+// DefaultMaxSockets is the number of sockets of a NetDevice, if not set.
+const DefaultMaxSockets = 8
+
+// NetDevice implements netdev.Netdever and netlink.Netlinker in memory, so
+// networked code can be tested on the host.
+//
+// Connections are made to servers registered with Serve, or to sockets of
+// the device listening on its own address or the loopback address. Test
+// code connects to listening sockets with Dial.
+//
+// The link is scripted with LinkDown and LinkUp, and faults are injected
+// with FailConnects, FailDNS, FailSockets and DropConnections. As with
+// the network devices, a downed link is only recovered by the watchdog,
+// see netlink.ConnectParams.WatchdogTimeout.
+type NetDevice struct {
+	// Addresses of the device, once connected, unless static ones are
+	// set in netlink.ConnectParams
+	IP      netip.Addr
+	Subnet  netip.Addr
+	Gateway netip.Addr
+
+	// MAC address of the device
+	HardwareAddr net.HardwareAddr
+
+	// Hosts resolved by GetHostByName
+	Hosts map[string]netip.Addr
+
+	// Networks found by NetScan. If not empty, NetConnect only connects
+	// to these SSIDs.
+	Networks []netlink.ScanResult
+
+	// Number of sockets, DefaultMaxSockets if zero
+	MaxSockets int
+
+	mu           sync.Mutex
+	params       *netlink.ConnectParams
+	netConnected bool // between NetConnect and NetDisconnect
+	up           bool // link is up
+	apDown       bool // set by LinkDown
+	rssi         int
+	rssiMonitor  netlink.RssiMonitor
+	notifyCb     func(netlink.Event)
+	killWatchdog chan bool
+
+	connectFailures int
+	dnsFailure      bool
+	socketFailure   bool
+
+	tlsConfig *netdev.TLSConfig
+
+	sockets   map[int]*netSocket
+	servers   map[netip.AddrPort]func(conn net.Conn)
+	listeners map[uint16]*netSocket
+	nextPort  uint16
+}
+
+type netSocket struct {
+	protocol  int
+	laddr     netip.AddrPort
+	conn      net.Conn // local end of the connection, nil if none
+	dropped   bool
+	listening bool
+	accept    chan acceptedConn
+	opts      map[[2]int]int
+}
+
+type acceptedConn struct {
+	conn  net.Conn
+	raddr netip.AddrPort
+}
+
+// NewNetDevice returns a NetDevice on the 10.0.0.0/24 network.
+func NewNetDevice() *NetDevice {
+	return &NetDevice{
+		IP:           netip.MustParseAddr("10.0.0.2"),
+		Subnet:       netip.MustParseAddr("255.255.255.0"),
+		Gateway:      netip.MustParseAddr("10.0.0.1"),
+		HardwareAddr: net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		Hosts:        map[string]netip.Addr{},
+		rssi:         -50,
+		sockets:      map[int]*netSocket{},
+		servers:      map[netip.AddrPort]func(conn net.Conn){},
+		listeners:    map[uint16]*netSocket{},
+		nextPort:     49152,
+	}
+}
+
+// Serve registers a remote server at addr. Each connection to addr runs
+// handler in a new goroutine, with the remote end of the connection.
+func (d *NetDevice) Serve(addr netip.AddrPort, handler func(conn net.Conn)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers[addr] = handler
+}
+
+// Dial connects to the socket of the device listening on port, as a
+// remote client would.
+func (d *NetDevice) Dial(port uint16) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.up {
+		return nil, netlink.ErrNotConnected
+	}
+	listener, ok := d.listeners[port]
+	if !ok {
+		return nil, fmt.Errorf("Connect to port %d refused", port)
+	}
+	local, remote := net.Pipe()
+	raddr := netip.AddrPortFrom(d.Gateway, d.ephemeralPort())
+	select {
+	case listener.accept <- acceptedConn{conn: local, raddr: raddr}:
+	default:
+		local.Close()
+		return nil, fmt.Errorf("Connect to port %d refused", port)
+	}
+	return remote, nil
+}
+
+// LinkDown takes the network down, dropping connections.
+func (d *NetDevice) LinkDown() {
+	d.mu.Lock()
+	d.apDown = true
+	wasUp := d.up
+	d.up = false
+	d.dropConnections()
+	cb := d.notifyCb
+	d.mu.Unlock()
+
+	if wasUp && cb != nil {
+		cb(netlink.EventNetDown)
+	}
+}
+
+// LinkUp brings the network back up. The device reconnects on the next
+// watchdog tick.
+func (d *NetDevice) LinkUp() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.apDown = false
+}
+
+// SetRssi sets the RSSI of the link, in dBm.
+func (d *NetDevice) SetRssi(rssi int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rssi = rssi
+}
+
+// FailConnects makes the next n connection attempts to the network fail.
+func (d *NetDevice) FailConnects(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connectFailures = n
+}
+
+// FailDNS makes GetHostByName fail with netdev.ErrHostUnknown.
+func (d *NetDevice) FailDNS(fail bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dnsFailure = fail
+}
+
+// FailSockets makes Socket fail with netdev.ErrNoMoreSockets.
+func (d *NetDevice) FailSockets(fail bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.socketFailure = fail
+}
+
+// DropConnections drops all connections, the link staying up.
+func (d *NetDevice) DropConnections() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dropConnections()
+}
+
+// SockOpt returns the value of a socket option set by SetSockOpt.
+func (d *NetDevice) SockOpt(sockfd int, level int, opt int) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return 0, false
+	}
+	v, ok := socket.opts[[2]int{level, opt}]
+	return v, ok
+}
+
+func (d *NetDevice) dropConnections() {
+	for _, socket := range d.sockets {
+		if socket.conn != nil && !socket.dropped {
+			socket.conn.Close()
+			socket.dropped = true
+		}
+	}
+}
+
+func (d *NetDevice) ephemeralPort() uint16 {
+	port := d.nextPort
+	d.nextPort++
+	if d.nextPort == 0 {
+		d.nextPort = 49152
+	}
+	return port
+}
+
+// connectToAP makes a connection attempt to the network
+func (d *NetDevice) connectToAP() error {
+	if d.connectFailures > 0 {
+		d.connectFailures--
+		return netlink.ErrConnectFailed
+	}
+	if d.apDown {
+		return netlink.ErrConnectFailed
+	}
+	if len(d.Networks) > 0 {
+		if _, ok := d.network(d.params.Ssid); !ok {
+			return netlink.ErrConnectFailed
+		}
+	}
+	d.up = true
+	return nil
+}
+
+func (d *NetDevice) network(ssid string) (netlink.ScanResult, bool) {
+	for _, n := range d.Networks {
+		if n.Ssid == ssid {
+			return n, true
+		}
+	}
+	return netlink.ScanResult{}, false
+}
+
+func (d *NetDevice) NetConnect(params *netlink.ConnectParams) error {
+	d.mu.Lock()
+
+	if d.netConnected {
+		d.mu.Unlock()
+		return netlink.ErrConnected
+	}
+	if len(params.Ssid) == 0 {
+		d.mu.Unlock()
+		return netlink.ErrMissingSSID
+	}
+	if err := params.CheckIPConfig(); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+
+	d.params = params
+
+	// As on the devices, zero retries means retrying forever
+	for i := 0; params.Retries == 0 || i < params.Retries; i++ {
+		if d.connectToAP() == nil {
+			break
+		}
+		// Let LinkUp bring the network back
+		d.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		d.mu.Lock()
+	}
+	if !d.up {
+		d.mu.Unlock()
+		return netlink.ErrConnectFailed
+	}
+
+	d.netConnected = true
+	if params.WatchdogTimeout != 0 {
+		d.killWatchdog = make(chan bool)
+		go d.watchdog(d.killWatchdog, params.WatchdogTimeout)
+	}
+
+	cb := d.notifyCb
+	d.mu.Unlock()
+
+	if cb != nil {
+		cb(netlink.EventNetUp)
+	}
+	return nil
+}
+
+func (d *NetDevice) watchdog(kill chan bool, timeout time.Duration) {
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kill:
+			return
+		case <-ticker.C:
+			var events []netlink.Event
+			d.mu.Lock()
+			if !d.netConnected {
+				// Disconnected meanwhile
+			} else if !d.up {
+				if d.connectToAP() == nil {
+					events = append(events, netlink.EventNetUp)
+				}
+			} else if d.params.RssiThreshold != 0 {
+				if event, ok := d.rssiMonitor.Update(d.params.RssiThreshold, d.rssi); ok {
+					events = append(events, event)
+				}
+			}
+			cb := d.notifyCb
+			d.mu.Unlock()
+
+			if cb != nil {
+				for _, event := range events {
+					cb(event)
+				}
+			}
+		}
+	}
+}
+
+func (d *NetDevice) NetDisconnect() {
+	d.mu.Lock()
+
+	if !d.netConnected {
+		d.mu.Unlock()
+		return
+	}
+
+	if d.killWatchdog != nil {
+		close(d.killWatchdog)
+		d.killWatchdog = nil
+	}
+
+	d.dropConnections()
+	d.up = false
+	d.netConnected = false
+
+	cb := d.notifyCb
+	d.mu.Unlock()
+
+	if cb != nil {
+		cb(netlink.EventNetDown)
+	}
+}
+
+func (d *NetDevice) NetNotify(cb func(netlink.Event)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.notifyCb = cb
+}
+
+func (d *NetDevice) GetHardwareAddr() (net.HardwareAddr, error) {
+	return d.HardwareAddr, nil
+}
+
+func (d *NetDevice) NetScan() ([]netlink.ScanResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.apDown {
+		return nil, nil
+	}
+	return append([]netlink.ScanResult(nil), d.Networks...), nil
+}
+
+func (d *NetDevice) GetLinkInfo() (netlink.LinkInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.up {
+		return netlink.LinkInfo{}, netlink.ErrNotConnected
+	}
+
+	info := netlink.LinkInfo{
+		Ssid:    d.params.Ssid,
+		Rssi:    d.rssi,
+		IP:      d.IP,
+		Subnet:  d.Subnet,
+		Gateway: d.Gateway,
+	}
+	if d.params.IP.IsValid() {
+		info.IP = d.params.IP
+		info.Subnet = d.params.Subnet
+		info.Gateway = d.params.Gateway
+	}
+	if n, ok := d.network(d.params.Ssid); ok {
+		info.Bssid = n.Bssid
+		info.Channel = n.Channel
+	}
+	return info, nil
+}
+
+func (d *NetDevice) GetHostByName(name string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(name); err == nil {
+		return addr, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dnsFailure || !d.up {
+		return netip.Addr{}, netdev.ErrHostUnknown
+	}
+	addr, ok := d.Hosts[name]
+	if !ok {
+		return netip.Addr{}, netdev.ErrHostUnknown
+	}
+	return addr, nil
+}
+
+func (d *NetDevice) Addr() (netip.Addr, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.up {
+		return netip.Addr{}, netlink.ErrNotConnected
+	}
+	if d.params.IP.IsValid() {
+		return d.params.IP, nil
+	}
+	return d.IP, nil
+}
+
+func (d *NetDevice) Socket(domain int, stype int, protocol int) (int, error) {
+	switch domain {
+	case netdev.AF_INET:
+	default:
+		return -1, netdev.ErrFamilyNotSupported
+	}
+
+	switch {
+	case protocol == netdev.IPPROTO_TCP && stype == netdev.SOCK_STREAM:
+	case protocol == netdev.IPPROTO_TLS && stype == netdev.SOCK_STREAM:
+	case protocol == netdev.IPPROTO_UDP && stype == netdev.SOCK_DGRAM:
+	default:
+		return -1, netdev.ErrProtocolNotSupported
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.socketFailure {
+		return -1, netdev.ErrNoMoreSockets
+	}
+
+	sockfd := d.newSockfd()
+	if sockfd == -1 {
+		return -1, netdev.ErrNoMoreSockets
+	}
+
+	d.sockets[sockfd] = &netSocket{
+		protocol: protocol,
+		opts:     map[[2]int]int{},
+	}
+
+	return sockfd, nil
+}
+
+// newSockfd returns the lowest free socket fd, or -1 if none
+func (d *NetDevice) newSockfd() int {
+	max := d.MaxSockets
+	if max == 0 {
+		max = DefaultMaxSockets
+	}
+	for sockfd := 0; sockfd < max; sockfd++ {
+		if _, ok := d.sockets[sockfd]; !ok {
+			return sockfd
+		}
+	}
+	return -1
+}
+
+func (d *NetDevice) Bind(sockfd int, ip netip.AddrPort) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	socket.laddr = ip
+	return nil
+}
+
+func (d *NetDevice) Connect(sockfd int, host string, ip netip.AddrPort) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	// TLS sockets connect to the host name
+	addr := ip
+	if host != "" {
+		hostAddr, ok := d.Hosts[host]
+		if !ok || d.dnsFailure {
+			return fmt.Errorf("Connect to %s:%d failed", host, ip.Port())
+		}
+		addr = netip.AddrPortFrom(hostAddr, ip.Port())
+	}
+
+	if !d.up {
+		return fmt.Errorf("Connect to %s failed", addr)
+	}
+
+	local, remote := net.Pipe()
+
+	if handler, ok := d.servers[addr]; ok {
+		go handler(remote)
+	} else if listener, ok := d.listeners[addr.Port()]; ok && (addr.Addr() == d.IP || addr.Addr().IsLoopback()) {
+		raddr := netip.AddrPortFrom(d.IP, d.ephemeralPort())
+		select {
+		case listener.accept <- acceptedConn{conn: remote, raddr: raddr}:
+		default:
+			local.Close()
+			return fmt.Errorf("Connect to %s failed", addr)
+		}
+	} else {
+		local.Close()
+		return fmt.Errorf("Connect to %s failed", addr)
+	}
+
+	socket.conn = local
+	socket.dropped = false
+	return nil
+}
+
+func (d *NetDevice) Listen(sockfd int, backlog int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	switch socket.protocol {
+	case netdev.IPPROTO_TCP:
+	default:
+		return netdev.ErrProtocolNotSupported
+	}
+
+	port := socket.laddr.Port()
+	if _, ok := d.listeners[port]; ok {
+		return fmt.Errorf("Listen on port %d failed", port)
+	}
+	if backlog < 1 {
+		backlog = 1
+	}
+	socket.listening = true
+	socket.accept = make(chan acceptedConn, backlog)
+	d.listeners[port] = socket
+	return nil
+}
+
+func (d *NetDevice) Accept(sockfd int) (int, netip.AddrPort, error) {
+	d.mu.Lock()
+	server, ok := d.sockets[sockfd]
+	if !ok {
+		d.mu.Unlock()
+		return -1, netip.AddrPort{}, netdev.ErrInvalidSocketFd
+	}
+	if !server.listening {
+		d.mu.Unlock()
+		return -1, netip.AddrPort{}, fmt.Errorf("Must Listen before Accepting")
+	}
+	accept := server.accept
+	d.mu.Unlock()
+
+	client, ok := <-accept
+	if !ok {
+		// Listening socket closed
+		return -1, netip.AddrPort{}, netdev.ErrInvalidSocketFd
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	clientfd := d.newSockfd()
+	if clientfd == -1 {
+		client.conn.Close()
+		return -1, netip.AddrPort{}, netdev.ErrNoMoreSockets
+	}
+
+	d.sockets[clientfd] = &netSocket{
+		protocol: netdev.IPPROTO_TCP,
+		laddr:    netip.AddrPortFrom(d.IP, server.laddr.Port()),
+		conn:     client.conn,
+		opts:     map[[2]int]int{},
+	}
+
+	return clientfd, client.raddr, nil
+}
+
+// conn returns the connection of a socket
+func (d *NetDevice) conn(sockfd int) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return nil, netdev.ErrInvalidSocketFd
+	}
+	if socket.conn == nil {
+		return nil, fmt.Errorf("Must Connect before sending or receiving")
+	}
+	if socket.dropped {
+		return nil, ErrConnectionDropped
+	}
+	return socket.conn, nil
+}
+
+// ioError returns the error of a Send or Recv, checking if the connection
+// was dropped meanwhile
+func (d *NetDevice) ioError(sockfd int, err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return netdev.ErrTimeout
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if socket, ok := d.sockets[sockfd]; ok && socket.dropped {
+		return ErrConnectionDropped
+	}
+	return err
+}
+
+func (d *NetDevice) Send(sockfd int, buf []byte, flags int, deadline time.Time) (int, error) {
+	conn, err := d.conn(sockfd)
+	if err != nil {
+		return -1, err
+	}
+
+	conn.SetWriteDeadline(deadline)
+	n, err := conn.Write(buf)
+	if err != nil {
+		return -1, d.ioError(sockfd, err)
+	}
+	return n, nil
+}
+
+func (d *NetDevice) Recv(sockfd int, buf []byte, flags int, deadline time.Time) (int, error) {
+	conn, err := d.conn(sockfd)
+	if err != nil {
+		return -1, err
+	}
+
+	conn.SetReadDeadline(deadline)
+	n, err := conn.Read(buf)
+	if err != nil {
+		// The remote end closed the connection
+		if err == io.EOF {
+			return -1, io.EOF
+		}
+		return -1, d.ioError(sockfd, err)
+	}
+	return n, nil
+}
+
+func (d *NetDevice) Close(sockfd int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	if socket.conn != nil {
+		socket.conn.Close()
+	}
+	if socket.listening {
+		delete(d.listeners, socket.laddr.Port())
+		close(socket.accept)
+		for client := range socket.accept {
+			client.conn.Close()
+		}
+	}
+
+	delete(d.sockets, sockfd)
+	return nil
+}
+
+// SetTLSConfig records the TLS config, TLS connections of the NetDevice
+// being in the clear.
+func (d *NetDevice) SetTLSConfig(config *netdev.TLSConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tlsConfig = config
+	return nil
+}
+
+// TLSConfig returns the TLS config set by SetTLSConfig.
+func (d *NetDevice) TLSConfig() *netdev.TLSConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tlsConfig
+}
+
+func (d *NetDevice) SockOptSupported(level int, opt int) bool {
+	switch level {
+	case netdev.SOL_SOCKET:
+		switch opt {
+		case netdev.SO_KEEPALIVE, netdev.SO_BROADCAST, netdev.SO_RCVBUF:
+			return true
+		}
+	case netdev.SOL_TCP:
+		switch opt {
+		case netdev.TCP_NODELAY, netdev.TCP_KEEPIDLE, netdev.TCP_KEEPINTVL, netdev.TCP_KEEPCNT:
+			return true
+		}
+	}
+	return false
+}
+
+func (d *NetDevice) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
+	if !d.SockOptSupported(level, opt) {
+		return netdev.ErrNotSupported
+	}
+	v, err := netdev.SockOptInt(value)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	socket.opts[[2]int{level, opt}] = v
+	return nil
+}
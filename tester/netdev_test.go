@@ -0,0 +1,172 @@
+package tester
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
+)
+
+func connect(c *qt.C, d *NetDevice, params *netlink.ConnectParams) {
+	c.Assert(d.NetConnect(params), qt.IsNil)
+	c.Cleanup(d.NetDisconnect)
+}
+
+func echo(conn net.Conn) {
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+func TestNetDeviceConnect(t *testing.T) {
+	c := qt.New(t)
+	d := NewNetDevice()
+	server := netip.MustParseAddrPort("10.0.0.10:7")
+	d.Hosts["echo.local"] = server.Addr()
+	d.Serve(server, echo)
+	connect(c, d, &netlink.ConnectParams{Ssid: "test"})
+
+	addr, err := d.GetHostByName("echo.local")
+	c.Assert(err, qt.IsNil)
+	c.Assert(addr, qt.Equals, server.Addr())
+
+	sockfd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	c.Assert(err, qt.IsNil)
+	c.Assert(d.Connect(sockfd, "", netip.AddrPortFrom(addr, 7)), qt.IsNil)
+
+	n, err := d.Send(sockfd, []byte("hello"), 0, time.Time{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 5)
+
+	buf := make([]byte, 16)
+	n, err = d.Recv(sockfd, buf, 0, time.Time{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(buf[:n]), qt.Equals, "hello")
+
+	_, err = d.Recv(sockfd, buf, 0, time.Now().Add(10*time.Millisecond))
+	c.Assert(err, qt.Equals, netdev.ErrTimeout)
+
+	c.Assert(d.Close(sockfd), qt.IsNil)
+}
+
+func TestNetDeviceListen(t *testing.T) {
+	c := qt.New(t)
+	d := NewNetDevice()
+	connect(c, d, &netlink.ConnectParams{Ssid: "test"})
+
+	sockfd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	c.Assert(err, qt.IsNil)
+	c.Assert(d.Bind(sockfd, netip.AddrPortFrom(netip.Addr{}, 80)), qt.IsNil)
+	c.Assert(d.Listen(sockfd, 2), qt.IsNil)
+
+	conn, err := d.Dial(80)
+	c.Assert(err, qt.IsNil)
+	go func() {
+		conn.Write([]byte("GET"))
+		conn.Close()
+	}()
+
+	clientfd, _, err := d.Accept(sockfd)
+	c.Assert(err, qt.IsNil)
+	buf := make([]byte, 16)
+	n, err := d.Recv(clientfd, buf, 0, time.Time{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(buf[:n]), qt.Equals, "GET")
+	_, err = d.Recv(clientfd, buf, 0, time.Time{})
+	c.Assert(err, qt.Equals, io.EOF)
+
+	// Loopback connection
+	loopfd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	c.Assert(err, qt.IsNil)
+	c.Assert(d.Connect(loopfd, "", netip.MustParseAddrPort("127.0.0.1:80")), qt.IsNil)
+	clientfd, _, err = d.Accept(sockfd)
+	c.Assert(err, qt.IsNil)
+	go d.Send(loopfd, []byte("ping"), 0, time.Time{})
+	n, err = d.Recv(clientfd, buf, 0, time.Time{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(buf[:n]), qt.Equals, "ping")
+}
+
+func TestNetDeviceFaults(t *testing.T) {
+	c := qt.New(t)
+	d := NewNetDevice()
+	d.MaxSockets = 2
+	server := netip.MustParseAddrPort("10.0.0.10:7")
+	d.Hosts["echo.local"] = server.Addr()
+	d.Serve(server, echo)
+
+	d.FailConnects(2)
+	c.Assert(d.NetConnect(&netlink.ConnectParams{Ssid: "test", Retries: 2}), qt.Equals, netlink.ErrConnectFailed)
+	connect(c, d, &netlink.ConnectParams{Ssid: "test", Retries: 2})
+
+	d.FailDNS(true)
+	_, err := d.GetHostByName("echo.local")
+	c.Assert(err, qt.Equals, netdev.ErrHostUnknown)
+	d.FailDNS(false)
+
+	d.FailSockets(true)
+	_, err = d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	c.Assert(err, qt.Equals, netdev.ErrNoMoreSockets)
+	d.FailSockets(false)
+
+	sockfd, err := d.Socket(netdev.AF_INET, netdev.SOCK_STREAM, netdev.IPPROTO_TCP)
+	c.Assert(err, qt.IsNil)
+	_, err = d.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_UDP)
+	c.Assert(err, qt.IsNil)
+	_, err = d.Socket(netdev.AF_INET, netdev.SOCK_DGRAM, netdev.IPPROTO_UDP)
+	c.Assert(err, qt.Equals, netdev.ErrNoMoreSockets)
+
+	c.Assert(d.Connect(sockfd, "", server), qt.IsNil)
+	c.Assert(d.SetSockOpt(sockfd, netdev.SOL_SOCKET, netdev.SO_KEEPALIVE, true), qt.IsNil)
+	v, ok := d.SockOpt(sockfd, netdev.SOL_SOCKET, netdev.SO_KEEPALIVE)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(v, qt.Equals, 1)
+
+	d.DropConnections()
+	_, err = d.Send(sockfd, []byte("hello"), 0, time.Time{})
+	c.Assert(err, qt.Equals, ErrConnectionDropped)
+}
+
+func TestNetDeviceLinkEvents(t *testing.T) {
+	c := qt.New(t)
+	d := NewNetDevice()
+	events := make(chan netlink.Event, 8)
+	d.NetNotify(func(e netlink.Event) { events <- e })
+
+	next := func() netlink.Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			c.Fatalf("no event")
+		}
+		return 0
+	}
+
+	connect(c, d, &netlink.ConnectParams{
+		Ssid:            "test",
+		WatchdogTimeout: 10 * time.Millisecond,
+		RssiThreshold:   -80,
+	})
+	c.Assert(next(), qt.Equals, netlink.EventNetUp)
+
+	d.LinkDown()
+	c.Assert(next(), qt.Equals, netlink.EventNetDown)
+	_, err := d.GetLinkInfo()
+	c.Assert(err, qt.Equals, netlink.ErrNotConnected)
+
+	d.LinkUp()
+	c.Assert(next(), qt.Equals, netlink.EventNetUp)
+	info, err := d.GetLinkInfo()
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Ssid, qt.Equals, "test")
+
+	d.SetRssi(-90)
+	c.Assert(next(), qt.Equals, netlink.EventRssiLow)
+	d.SetRssi(-60)
+	c.Assert(next(), qt.Equals, netlink.EventRssiOk)
+}
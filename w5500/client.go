@@ -0,0 +1,170 @@
+//go:build tinygo
+
+package w5500
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+func (d *Device) newXid() uint32 {
+	if d.xid == 0 {
+		d.xid = uint32(time.Now().UnixNano())
+	}
+	d.xid++
+	return d.xid
+}
+
+func (d *Device) hostname() string {
+	if d.params == nil {
+		return ""
+	}
+	return d.params.Hostname
+}
+
+// dhcpExchange broadcasts a DHCP message of msgType, updating lease from
+// the server reply of type want
+func (d *Device) dhcpExchange(msgType, want uint8, xid uint32, lease *dhcpLease,
+	timeout time.Duration) error {
+
+	var got uint8
+	answer := func(msg []byte) bool {
+		var reply dhcpLease
+		got = parseDHCP(msg, xid, d.mac, &reply)
+		switch got {
+		case want:
+			*lease = reply
+			return true
+		case dhcpNak:
+			return true
+		}
+		return false
+	}
+
+	raddr := netip.AddrPortFrom(broadcastAddr, dhcpServerPort)
+
+	for i := 0; i < dhcpTries; i++ {
+		req := dhcpMessage(d.pkt[:0], msgType, xid, d.mac, d.hostname(), lease)
+		err := d.exchangeUDP(dhcpClientPort, raddr, req, timeout/dhcpTries, answer)
+		switch err {
+		case nil:
+			if got == dhcpNak {
+				return errDHCPNak
+			}
+			return nil
+		case netdev.ErrTimeout:
+			continue
+		}
+		return err
+	}
+
+	return netdev.ErrTimeout
+}
+
+func (d *Device) bindLease(lease *dhcpLease) {
+	lease.obtained = time.Now()
+	d.lease = *lease
+	d.setIP(lease.ip, lease.subnet, lease.gateway)
+	if len(d.params.DNS) == 0 {
+		d.dns = lease.dns
+	}
+}
+
+// dhcp gets an IP config from a DHCP server
+func (d *Device) dhcp(timeout time.Duration) error {
+
+	// No IP until the server offers one
+	d.setIP(netip.Addr{}, netip.Addr{}, netip.Addr{})
+
+	var lease dhcpLease
+	xid := d.newXid()
+
+	if err := d.dhcpExchange(dhcpDiscover, dhcpOffer, xid, &lease, timeout); err != nil {
+		return err
+	}
+
+	if debugging(debugDhcp) {
+		fmt.Printf("[DHCP] offer %s from %s\r\n", lease.ip, lease.server)
+	}
+
+	if err := d.dhcpExchange(dhcpRequest, dhcpAck, xid, &lease, timeout); err != nil {
+		return err
+	}
+
+	if debugging(debugDhcp) {
+		fmt.Printf("[DHCP] ack %s, lease %s\r\n", lease.ip, lease.leaseTime)
+	}
+
+	d.bindLease(&lease)
+	return nil
+}
+
+// renewLease asks the DHCP server to extend the lease.  Failures are only
+// reported once the lease expired, or if the server refused.
+func (d *Device) renewLease() error {
+
+	lease := d.lease
+	// Ask for our IP without naming the server, so any server may
+	// answer, as in the INIT-REBOOT state
+	lease.server = netip.Addr{}
+
+	err := d.dhcpExchange(dhcpRequest, dhcpAck, d.newXid(), &lease, dhcpTimeout)
+	if err != nil {
+		if debugging(debugDhcp) {
+			fmt.Printf("[DHCP] renew failed: %s\r\n", err)
+		}
+		if err == errDHCPNak || d.lease.expired() {
+			return err
+		}
+		return nil
+	}
+
+	if debugging(debugDhcp) {
+		fmt.Printf("[DHCP] renewed %s, lease %s\r\n", lease.ip, lease.leaseTime)
+	}
+
+	d.bindLease(&lease)
+	return nil
+}
+
+// resolve looks up name with the DNS servers from DHCP or ConnectParams
+func (d *Device) resolve(name string) (netip.Addr, error) {
+
+	for _, server := range d.dns {
+		raddr := netip.AddrPortFrom(server, dnsPort)
+		for i := 0; i < dnsTries; i++ {
+			id := uint16(d.newXid())
+			query, ok := dnsQuery(d.pkt[:0], id, name)
+			if !ok {
+				return netip.Addr{}, netdev.ErrHostUnknown
+			}
+
+			var addr netip.Addr
+			answer := func(msg []byte) bool {
+				var done bool
+				addr, done = parseDNS(msg, id)
+				return done
+			}
+
+			err := d.exchangeUDP(d.ephemeralPort(), raddr, query, dnsTimeout, answer)
+			switch err {
+			case nil:
+				if debugging(debugDns) {
+					fmt.Printf("[DNS] %s: %s from %s\r\n", name, addr, server)
+				}
+				if !addr.IsValid() {
+					return netip.Addr{}, netdev.ErrHostUnknown
+				}
+				return addr, nil
+			case netdev.ErrTimeout:
+				continue
+			}
+			return netip.Addr{}, err
+		}
+	}
+
+	return netip.Addr{}, netdev.ErrHostUnknown
+}
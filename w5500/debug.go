@@ -0,0 +1,19 @@
+//go:build tinygo
+
+package w5500
+
+type debug uint8
+
+const (
+	debugBasic  debug = 1 << iota // show version, mac addr, IP config
+	debugNetdev                   // show netdev entry points
+	debugDhcp                     // show DHCP exchanges
+	debugDns                      // show DNS lookups
+
+	debugOff = 0
+	debugAll = debugBasic | debugNetdev | debugDhcp | debugDns
+)
+
+func debugging(want debug) bool {
+	return (_debug & want) != 0
+}
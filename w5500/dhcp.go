@@ -0,0 +1,217 @@
+package w5500
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"tinygo.org/x/drivers/netlink"
+)
+
+// DHCP client, per RFC 2131
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+
+	dhcpMagic      = 0x63825363
+	dhcpHeaderLen  = 236
+	dhcpSnameOff   = 44
+	dhcpFileOff    = 108
+	dhcpTimeout    = 3 * time.Second
+	dhcpTries      = 3
+	dhcpMaxHostLen = 63
+)
+
+// DHCP message types
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpNak      = 6
+)
+
+// DHCP options
+const (
+	dhcpOptSubnet      = 1
+	dhcpOptRouter      = 3
+	dhcpOptDNS         = 6
+	dhcpOptHostname    = 12
+	dhcpOptRequestedIP = 50
+	dhcpOptLeaseTime   = 51
+	dhcpOptOverload    = 52
+	dhcpOptMsgType     = 53
+	dhcpOptServerID    = 54
+	dhcpOptParamList   = 55
+	dhcpOptRenewalTime = 58
+	dhcpOptClientID    = 61
+	dhcpOptPad         = 0
+	dhcpOptEnd         = 255
+)
+
+var errDHCPNak = errors.New("DHCP request refused")
+
+var broadcastAddr = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+
+type dhcpLease struct {
+	ip          netip.Addr
+	subnet      netip.Addr
+	gateway     netip.Addr
+	server      netip.Addr
+	dns         []netip.Addr
+	leaseTime   time.Duration // zero if infinite
+	renewalTime time.Duration
+	obtained    time.Time
+}
+
+func (l *dhcpLease) renewDue() bool {
+	return l.renewalTime != 0 && time.Since(l.obtained) > l.renewalTime
+}
+
+func (l *dhcpLease) expired() bool {
+	return l.leaseTime != 0 && time.Since(l.obtained) > l.leaseTime
+}
+
+func appendAddr(buf []byte, ip netip.Addr) []byte {
+	b := ip.As4()
+	return append(buf, b[:]...)
+}
+
+// dhcpMessage appends a DHCP client message to buf.  A REQUEST asks for
+// lease.ip, from lease.server if valid.
+func dhcpMessage(buf []byte, msgType uint8, xid uint32, mac net.HardwareAddr,
+	hostname string, lease *dhcpLease) []byte {
+
+	var hdr [dhcpHeaderLen]byte
+	hdr[0] = 1 // BOOTREQUEST
+	hdr[1] = 1 // Ethernet
+	hdr[2] = 6 // MAC address length
+	hdr[4], hdr[5], hdr[6], hdr[7] = byte(xid>>24), byte(xid>>16), byte(xid>>8), byte(xid)
+	hdr[10] = 0x80 // Broadcast reply, we can't receive unicast without an IP
+	copy(hdr[28:], mac)
+
+	buf = append(buf, hdr[:]...)
+	buf = append(buf, dhcpMagic>>24, dhcpMagic>>16&0xFF, dhcpMagic>>8&0xFF, dhcpMagic&0xFF)
+
+	buf = append(buf, dhcpOptMsgType, 1, msgType)
+	buf = append(buf, dhcpOptClientID, 7, 1)
+	buf = append(buf, mac...)
+
+	if len(hostname) > dhcpMaxHostLen {
+		hostname = hostname[:dhcpMaxHostLen]
+	}
+	if hostname != "" {
+		buf = append(buf, dhcpOptHostname, byte(len(hostname)))
+		buf = append(buf, hostname...)
+	}
+
+	if msgType == dhcpRequest {
+		buf = append(buf, dhcpOptRequestedIP, 4)
+		buf = appendAddr(buf, lease.ip)
+		if lease.server.IsValid() {
+			buf = append(buf, dhcpOptServerID, 4)
+			buf = appendAddr(buf, lease.server)
+		}
+	}
+
+	buf = append(buf, dhcpOptParamList, 5, dhcpOptSubnet, dhcpOptRouter,
+		dhcpOptDNS, dhcpOptLeaseTime, dhcpOptRenewalTime)
+
+	return append(buf, dhcpOptEnd)
+}
+
+func optAddr(data []byte) netip.Addr {
+	return netip.AddrFrom4([4]byte{data[0], data[1], data[2], data[3]})
+}
+
+func optSeconds(data []byte) time.Duration {
+	secs := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	if secs == 0xFFFFFFFF {
+		// Infinite
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// parseDHCP parses a DHCP server message into lease, returning the message
+// type, or zero if msg isn't a reply to transaction xid for mac
+func parseDHCP(msg []byte, xid uint32, mac net.HardwareAddr, lease *dhcpLease) uint8 {
+
+	if len(msg) < dhcpHeaderLen+4 {
+		return 0
+	}
+	if msg[0] != 2 || // BOOTREPLY
+		uint32(msg[4])<<24|uint32(msg[5])<<16|uint32(msg[6])<<8|uint32(msg[7]) != xid ||
+		string(msg[28:34]) != string(mac) ||
+		uint32(msg[236])<<24|uint32(msg[237])<<16|uint32(msg[238])<<8|uint32(msg[239]) != dhcpMagic {
+		return 0
+	}
+
+	var msgType, overload uint8
+
+	lease.ip = optAddr(msg[16:20])
+
+	if !parseOptions(msg[dhcpHeaderLen+4:], lease, &msgType, &overload) {
+		return 0
+	}
+	// Options which didn't fit continue in the file field, then the sname
+	// field
+	if overload&1 != 0 && !parseOptions(msg[dhcpFileOff:dhcpHeaderLen], lease, &msgType, nil) {
+		return 0
+	}
+	if overload&2 != 0 && !parseOptions(msg[dhcpSnameOff:dhcpFileOff], lease, &msgType, nil) {
+		return 0
+	}
+
+	if lease.renewalTime == 0 {
+		lease.renewalTime = lease.leaseTime / 2
+	}
+
+	return msgType
+}
+
+// parseOptions parses DHCP options into lease, and the message type and
+// option overload into msgType and overload, if overload isn't nil.  Returns
+// false if opts is truncated.
+func parseOptions(opts []byte, lease *dhcpLease, msgType, overload *uint8) bool {
+	for len(opts) > 0 {
+		opt := opts[0]
+		if opt == dhcpOptEnd {
+			break
+		}
+		if opt == dhcpOptPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return false
+		}
+		data := opts[2 : 2+opts[1]]
+		opts = opts[2+len(data):]
+
+		switch {
+		case opt == dhcpOptMsgType && len(data) == 1:
+			*msgType = data[0]
+		case opt == dhcpOptOverload && len(data) == 1 && overload != nil:
+			*overload = data[0]
+		case opt == dhcpOptSubnet && len(data) == 4:
+			lease.subnet = optAddr(data)
+		case opt == dhcpOptRouter && len(data) >= 4:
+			lease.gateway = optAddr(data)
+		case opt == dhcpOptServerID && len(data) == 4:
+			lease.server = optAddr(data)
+		case opt == dhcpOptLeaseTime && len(data) == 4:
+			lease.leaseTime = optSeconds(data)
+		case opt == dhcpOptRenewalTime && len(data) == 4:
+			lease.renewalTime = optSeconds(data)
+		case opt == dhcpOptDNS:
+			lease.dns = lease.dns[:0]
+			for ; len(data) >= 4 && len(lease.dns) < netlink.MaxDNSServers; data = data[4:] {
+				lease.dns = append(lease.dns, optAddr(data))
+			}
+		}
+	}
+	return true
+}
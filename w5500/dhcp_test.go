@@ -0,0 +1,232 @@
+package w5500
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var (
+	testMAC = net.HardwareAddr{0x02, 0x08, 0xDC, 0x12, 0x34, 0x56}
+	testXid = uint32(0x12345678)
+)
+
+// dhcpReply returns a server reply to testXid for testMAC, for IP
+// 192.168.1.50, with opts in the options field, and sname and file in those
+// fields.
+func dhcpReply(opts, sname, file []byte) []byte {
+	msg := make([]byte, dhcpHeaderLen, dhcpHeaderLen+4+len(opts))
+	msg[0] = 2 // BOOTREPLY
+	msg[4], msg[5], msg[6], msg[7] = 0x12, 0x34, 0x56, 0x78
+	copy(msg[16:20], []byte{192, 168, 1, 50})
+	copy(msg[28:], testMAC)
+	copy(msg[dhcpSnameOff:dhcpFileOff], sname)
+	copy(msg[dhcpFileOff:dhcpHeaderLen], file)
+	msg = append(msg, 0x63, 0x82, 0x53, 0x63)
+	return append(msg, opts...)
+}
+
+func addr(s string) netip.Addr {
+	return netip.MustParseAddr(s)
+}
+
+func TestParseDHCP(t *testing.T) {
+	c := qt.New(t)
+
+	ack := []byte{
+		dhcpOptMsgType, 1, dhcpAck,
+		dhcpOptSubnet, 4, 255, 255, 255, 0,
+		dhcpOptRouter, 8, 192, 168, 1, 1, 192, 168, 1, 2,
+		dhcpOptServerID, 4, 192, 168, 1, 1,
+		dhcpOptLeaseTime, 4, 0, 0, 0x0E, 0x10,
+		dhcpOptDNS, 8, 8, 8, 8, 8, 1, 1, 1, 1,
+		dhcpOptEnd,
+	}
+	acked := dhcpLease{
+		ip:          addr("192.168.1.50"),
+		subnet:      addr("255.255.255.0"),
+		gateway:     addr("192.168.1.1"),
+		server:      addr("192.168.1.1"),
+		dns:         []netip.Addr{addr("8.8.8.8"), addr("1.1.1.1")},
+		leaseTime:   time.Hour,
+		renewalTime: 30 * time.Minute,
+	}
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		msgType uint8
+		lease   dhcpLease
+	}{{
+		name:    "ack",
+		msg:     dhcpReply(ack, nil, nil),
+		msgType: dhcpAck,
+		lease:   acked,
+	}, {
+		name: "offer with renewal time and pads",
+		msg: dhcpReply([]byte{
+			dhcpOptPad, dhcpOptMsgType, 1, dhcpOffer,
+			dhcpOptPad, dhcpOptPad,
+			dhcpOptLeaseTime, 4, 0, 0, 0x0E, 0x10,
+			dhcpOptRenewalTime, 4, 0, 0, 0x03, 0x84,
+			dhcpOptEnd,
+		}, nil, nil),
+		msgType: dhcpOffer,
+		lease: dhcpLease{
+			ip:          addr("192.168.1.50"),
+			leaseTime:   time.Hour,
+			renewalTime: 15 * time.Minute,
+		},
+	}, {
+		name: "infinite lease",
+		msg: dhcpReply([]byte{
+			dhcpOptMsgType, 1, dhcpAck,
+			dhcpOptLeaseTime, 4, 0xFF, 0xFF, 0xFF, 0xFF,
+			dhcpOptEnd,
+		}, nil, nil),
+		msgType: dhcpAck,
+		lease:   dhcpLease{ip: addr("192.168.1.50")},
+	}, {
+		name: "nak",
+		msg: dhcpReply([]byte{
+			dhcpOptMsgType, 1, dhcpNak,
+			dhcpOptServerID, 4, 192, 168, 1, 1,
+			dhcpOptEnd,
+		}, nil, nil),
+		msgType: dhcpNak,
+		lease: dhcpLease{
+			ip:     addr("192.168.1.50"),
+			server: addr("192.168.1.1"),
+		},
+	}, {
+		name: "options overloaded in file",
+		msg: dhcpReply([]byte{
+			dhcpOptOverload, 1, 1,
+			dhcpOptEnd,
+		}, nil, ack),
+		msgType: dhcpAck,
+		lease:   acked,
+	}, {
+		name: "options overloaded in file and sname",
+		msg: dhcpReply([]byte{
+			dhcpOptMsgType, 1, dhcpAck,
+			dhcpOptOverload, 1, 3,
+			dhcpOptEnd,
+		}, []byte{
+			dhcpOptDNS, 4, 8, 8, 8, 8,
+			dhcpOptEnd,
+		}, []byte{
+			dhcpOptSubnet, 4, 255, 255, 255, 0,
+			dhcpOptDNS, 4, 1, 1, 1, 1,
+			dhcpOptEnd,
+		}),
+		msgType: dhcpAck,
+		lease: dhcpLease{
+			ip:     addr("192.168.1.50"),
+			subnet: addr("255.255.255.0"),
+			// The sname field comes last
+			dns: []netip.Addr{addr("8.8.8.8")},
+		},
+	}, {
+		name: "sname not overloaded",
+		msg: dhcpReply([]byte{
+			dhcpOptMsgType, 1, dhcpAck,
+			dhcpOptOverload, 1, 1,
+			dhcpOptEnd,
+		}, []byte("server"), []byte{dhcpOptEnd}),
+		msgType: dhcpAck,
+		lease:   dhcpLease{ip: addr("192.168.1.50")},
+	}, {
+		name:  "truncated option",
+		msg:   dhcpReply([]byte{dhcpOptMsgType, 1, dhcpAck, dhcpOptSubnet, 4, 255, 255}, nil, nil),
+		lease: dhcpLease{ip: addr("192.168.1.50")},
+	}, {
+		name: "truncated overloaded option",
+		msg: dhcpReply([]byte{
+			dhcpOptMsgType, 1, dhcpAck,
+			dhcpOptOverload, 1, 2,
+			dhcpOptEnd,
+		}, []byte{dhcpOptHostname, 100}, nil),
+		lease: dhcpLease{ip: addr("192.168.1.50")},
+	}, {
+		name: "truncated header",
+		msg:  dhcpReply(ack, nil, nil)[:dhcpHeaderLen+3],
+	}}
+
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			var lease dhcpLease
+			c.Assert(parseDHCP(test.msg, testXid, testMAC, &lease), qt.Equals, test.msgType)
+			if test.msgType == 0 {
+				return
+			}
+			c.Assert(lease.ip, qt.Equals, test.lease.ip)
+			c.Assert(lease.subnet, qt.Equals, test.lease.subnet)
+			c.Assert(lease.gateway, qt.Equals, test.lease.gateway)
+			c.Assert(lease.server, qt.Equals, test.lease.server)
+			c.Assert(lease.leaseTime, qt.Equals, test.lease.leaseTime)
+			c.Assert(lease.renewalTime, qt.Equals, test.lease.renewalTime)
+			c.Assert(lease.dns, qt.HasLen, len(test.lease.dns))
+			for i, dns := range test.lease.dns {
+				c.Assert(lease.dns[i], qt.Equals, dns)
+			}
+		})
+	}
+}
+
+func TestParseDHCPNotReply(t *testing.T) {
+	c := qt.New(t)
+
+	opts := []byte{dhcpOptMsgType, 1, dhcpAck, dhcpOptEnd}
+	var lease dhcpLease
+
+	msg := dhcpReply(opts, nil, nil)
+	c.Assert(parseDHCP(msg, testXid+1, testMAC, &lease), qt.Equals, uint8(0))
+	c.Assert(parseDHCP(msg, testXid, net.HardwareAddr{2, 0, 0, 0, 0, 1}, &lease), qt.Equals, uint8(0))
+
+	msg[0] = 1 // BOOTREQUEST
+	c.Assert(parseDHCP(msg, testXid, testMAC, &lease), qt.Equals, uint8(0))
+
+	msg = dhcpReply(opts, nil, nil)
+	msg[dhcpHeaderLen+3] = 0
+	c.Assert(parseDHCP(msg, testXid, testMAC, &lease), qt.Equals, uint8(0))
+}
+
+func TestDHCPMessage(t *testing.T) {
+	c := qt.New(t)
+
+	lease := dhcpLease{
+		ip:     addr("192.168.1.50"),
+		server: addr("192.168.1.1"),
+	}
+	msg := dhcpMessage(nil, dhcpRequest, testXid, testMAC, "w5500", &lease)
+
+	c.Assert(msg[:8], qt.DeepEquals, []byte{1, 1, 6, 0, 0x12, 0x34, 0x56, 0x78})
+	c.Assert(msg[10], qt.Equals, byte(0x80))
+	c.Assert(msg[28:34], qt.DeepEquals, []byte(testMAC))
+	c.Assert(msg[dhcpHeaderLen:dhcpHeaderLen+4], qt.DeepEquals, []byte{0x63, 0x82, 0x53, 0x63})
+	c.Assert(msg[dhcpHeaderLen+4:], qt.DeepEquals, []byte{
+		dhcpOptMsgType, 1, dhcpRequest,
+		dhcpOptClientID, 7, 1, 0x02, 0x08, 0xDC, 0x12, 0x34, 0x56,
+		dhcpOptHostname, 5, 'w', '5', '5', '0', '0',
+		dhcpOptRequestedIP, 4, 192, 168, 1, 50,
+		dhcpOptServerID, 4, 192, 168, 1, 1,
+		dhcpOptParamList, 5, dhcpOptSubnet, dhcpOptRouter, dhcpOptDNS,
+		dhcpOptLeaseTime, dhcpOptRenewalTime,
+		dhcpOptEnd,
+	})
+
+	// A DISCOVER asks for no IP, and a long hostname is truncated
+	long := string(make([]byte, 100))
+	msg = dhcpMessage(nil, dhcpDiscover, testXid, testMAC, long, &lease)
+	opts := msg[dhcpHeaderLen+4:]
+	c.Assert(opts[12:14], qt.DeepEquals, []byte{dhcpOptHostname, dhcpMaxHostLen})
+	c.Assert(opts[14+dhcpMaxHostLen:], qt.DeepEquals, []byte{
+		dhcpOptParamList, 5, dhcpOptSubnet, dhcpOptRouter, dhcpOptDNS,
+		dhcpOptLeaseTime, dhcpOptRenewalTime,
+		dhcpOptEnd,
+	})
+}
@@ -0,0 +1,109 @@
+package w5500
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// DNS stub resolver, per RFC 1035, for A records only
+
+const (
+	dnsPort      = 53
+	dnsTimeout   = 2 * time.Second
+	dnsTries     = 2
+	dnsHeaderLen = 12
+	dnsTypeA     = 1
+	dnsClassIN   = 1
+)
+
+// dnsQuery appends a recursive query for the A record of name to buf.
+// Returns false if name isn't a valid host name.
+func dnsQuery(buf []byte, id uint16, name string) ([]byte, bool) {
+
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return buf, false
+	}
+
+	buf = append(buf,
+		byte(id>>8), byte(id),
+		0x01, 0x00, // Recursion desired
+		0, 1, // One question
+		0, 0, 0, 0, 0, 0)
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return buf, false
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+
+	return append(buf, 0, 0, dnsTypeA, 0, dnsClassIN), true
+}
+
+// skipName returns the offset past the (maybe compressed) name at off, or
+// -1 if msg is truncated
+func skipName(msg []byte, off int) int {
+	for off < len(msg) {
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1
+		case n&0xC0 == 0xC0:
+			// Pointer to a name elsewhere in msg ends the name
+			return off + 2
+		}
+		off += 1 + n
+	}
+	return -1
+}
+
+// parseDNS returns the first A record of the reply to query id.  Returns
+// done false if msg isn't the reply; done true with no addr if the name
+// wasn't resolved.
+func parseDNS(msg []byte, id uint16) (addr netip.Addr, done bool) {
+
+	if len(msg) < dnsHeaderLen ||
+		uint16(msg[0])<<8|uint16(msg[1]) != id ||
+		msg[2]&0x80 == 0 { // Not a response
+		return netip.Addr{}, false
+	}
+
+	if msg[3]&0x0F != 0 {
+		// Server error, or no such name
+		return netip.Addr{}, true
+	}
+
+	qdcount := int(msg[4])<<8 | int(msg[5])
+	ancount := int(msg[6])<<8 | int(msg[7])
+
+	off := dnsHeaderLen
+	for i := 0; i < qdcount && off >= 0; i++ {
+		if off = skipName(msg, off); off >= 0 {
+			off += 4 // type, class
+		}
+	}
+
+	for i := 0; i < ancount && off >= 0; i++ {
+		off = skipName(msg, off)
+		if off < 0 || off+10 > len(msg) {
+			break
+		}
+		rtype := uint16(msg[off])<<8 | uint16(msg[off+1])
+		class := uint16(msg[off+2])<<8 | uint16(msg[off+3])
+		rdlen := int(msg[off+8])<<8 | int(msg[off+9])
+		off += 10
+		if off+rdlen > len(msg) {
+			break
+		}
+		if rtype == dnsTypeA && class == dnsClassIN && rdlen == 4 {
+			return netip.AddrFrom4([4]byte{msg[off], msg[off+1], msg[off+2], msg[off+3]}), true
+		}
+		// Skip CNAMEs, etc.
+		off += rdlen
+	}
+
+	return netip.Addr{}, true
+}
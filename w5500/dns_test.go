@@ -0,0 +1,133 @@
+package w5500
+
+import (
+	"net/netip"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// exampleCom is the encoded name example.com, at offset 12 of a reply
+var exampleCom = []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}
+
+// dnsReply returns a reply to query 0x1234 for example.com, with rcode and
+// the given answers.
+func dnsReply(rcode byte, answers ...[]byte) []byte {
+	msg := []byte{
+		0x12, 0x34,
+		0x81, 0x80 | rcode, // Response, recursion desired and available
+		0, 1, // One question
+		0, byte(len(answers)),
+		0, 0, 0, 0,
+	}
+	msg = append(msg, exampleCom...)
+	msg = append(msg, 0, dnsTypeA, 0, dnsClassIN)
+	for _, answer := range answers {
+		msg = append(msg, answer...)
+	}
+	return msg
+}
+
+// rr returns a resource record of class IN with name, the type and data.
+func rr(name []byte, rtype byte, data ...byte) []byte {
+	b := append([]byte(nil), name...)
+	b = append(b, 0, rtype, 0, dnsClassIN, 0, 0, 0x0E, 0x10, 0, byte(len(data)))
+	return append(b, data...)
+}
+
+func TestParseDNS(t *testing.T) {
+	c := qt.New(t)
+
+	// Pointer to example.com in the question
+	ptr := []byte{0xC0, dnsHeaderLen}
+	// www.example.com, with example.com compressed
+	www := []byte{3, 'w', 'w', 'w', 0xC0, dnsHeaderLen}
+	const cname = 5
+
+	tests := []struct {
+		name string
+		msg  []byte
+		addr string
+		done bool
+	}{{
+		name: "compressed name",
+		msg:  dnsReply(0, rr(ptr, dnsTypeA, 93, 184, 216, 34)),
+		addr: "93.184.216.34",
+		done: true,
+	}, {
+		name: "uncompressed name",
+		msg:  dnsReply(0, rr(exampleCom, dnsTypeA, 93, 184, 216, 34)),
+		addr: "93.184.216.34",
+		done: true,
+	}, {
+		name: "cname then a",
+		msg: dnsReply(0,
+			rr(ptr, cname, www...),
+			rr(www, dnsTypeA, 10, 0, 0, 1),
+			rr(www, dnsTypeA, 10, 0, 0, 2)),
+		addr: "10.0.0.1",
+		done: true,
+	}, {
+		name: "cname only",
+		msg:  dnsReply(0, rr(ptr, cname, www...)),
+		done: true,
+	}, {
+		name: "no such name",
+		msg:  dnsReply(3),
+		done: true,
+	}, {
+		name: "no answer",
+		msg:  dnsReply(0),
+		done: true,
+	}, {
+		name: "truncated rdata",
+		msg:  dnsReply(0, rr(ptr, dnsTypeA, 93, 184, 216, 34))[:dnsHeaderLen+len(exampleCom)+4+14],
+		done: true,
+	}, {
+		name: "truncated answer",
+		msg:  dnsReply(0, rr(ptr, dnsTypeA, 93, 184, 216, 34))[:dnsHeaderLen+len(exampleCom)+4+8],
+		done: true,
+	}, {
+		name: "truncated question",
+		msg:  dnsReply(0, rr(ptr, dnsTypeA, 93, 184, 216, 34))[:dnsHeaderLen+5],
+		done: true,
+	}, {
+		name: "truncated header",
+		msg:  dnsReply(0)[:dnsHeaderLen-1],
+	}, {
+		name: "other id",
+		msg:  append([]byte{0x12, 0x35}, dnsReply(0, rr(ptr, dnsTypeA, 1, 2, 3, 4))[2:]...),
+	}, {
+		name: "query",
+		msg:  append([]byte{0x12, 0x34, 0x01, 0x00}, dnsReply(0, rr(ptr, dnsTypeA, 1, 2, 3, 4))[4:]...),
+	}}
+
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			addr, done := parseDNS(test.msg, 0x1234)
+			c.Assert(done, qt.Equals, test.done)
+			if test.addr == "" {
+				c.Assert(addr.IsValid(), qt.IsFalse)
+				return
+			}
+			c.Assert(addr, qt.Equals, netip.MustParseAddr(test.addr))
+		})
+	}
+}
+
+func TestDNSQuery(t *testing.T) {
+	c := qt.New(t)
+
+	query, ok := dnsQuery(nil, 0x1234, "example.com.")
+	c.Assert(ok, qt.IsTrue)
+	want := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	want = append(want, exampleCom...)
+	want = append(want, 0, dnsTypeA, 0, dnsClassIN)
+	c.Assert(query, qt.DeepEquals, want)
+
+	long := string(make([]byte, 64))
+	for _, name := range []string{"", ".", "a..b", ".a", long + ".com"} {
+		_, ok := dnsQuery(nil, 0x1234, name)
+		c.Assert(ok, qt.IsFalse, qt.Commentf("name %q", name))
+	}
+}
@@ -0,0 +1,110 @@
+package w5500
+
+// Common registers
+const (
+	MR       = 0x0000 // Mode
+	GAR      = 0x0001 // Gateway address
+	SUBR     = 0x0005 // Subnet mask
+	SHAR     = 0x0009 // Source hardware (MAC) address
+	SIPR     = 0x000F // Source IP address
+	RTR      = 0x0019 // Retry time, in 100us units
+	RCR      = 0x001B // Retry count
+	PHYCFGR  = 0x002E // PHY configuration
+	VERSIONR = 0x0039 // Chip version
+)
+
+// Socket registers
+const (
+	SN_MR         = 0x0000 // Mode
+	SN_CR         = 0x0001 // Command
+	SN_IR         = 0x0002 // Interrupt
+	SN_SR         = 0x0003 // Status
+	SN_PORT       = 0x0004 // Source port
+	SN_DHAR       = 0x0006 // Destination hardware address
+	SN_DIPR       = 0x000C // Destination IP address
+	SN_DPORT      = 0x0010 // Destination port
+	SN_MSSR       = 0x0012 // Maximum segment size
+	SN_RXBUF_SIZE = 0x001E // Receive buffer size, in KB
+	SN_TXBUF_SIZE = 0x001F // Transmit buffer size, in KB
+	SN_TX_FSR     = 0x0020 // Transmit free size
+	SN_TX_RD      = 0x0022 // Transmit read pointer
+	SN_TX_WR      = 0x0024 // Transmit write pointer
+	SN_RX_RSR     = 0x0026 // Received size
+	SN_RX_RD      = 0x0028 // Receive read pointer
+	SN_RX_WR      = 0x002A // Receive write pointer
+	SN_KPALVTR    = 0x002F // Keep alive time, in 5s units
+)
+
+// MR bits
+const (
+	MR_RST = 0x80
+)
+
+// PHYCFGR bits
+const (
+	PHYCFGR_LNK = 0x01 // Link up
+	PHYCFGR_SPD = 0x02 // 100Mbps
+	PHYCFGR_DPX = 0x04 // Full duplex
+)
+
+// SN_MR protocols and flags
+const (
+	SN_MR_CLOSE  = 0x00
+	SN_MR_TCP    = 0x01
+	SN_MR_UDP    = 0x02
+	SN_MR_ND     = 0x20 // No delayed ACK (TCP)
+	SN_MR_BCASTB = 0x40 // Block broadcast (UDP)
+)
+
+// SN_CR commands
+const (
+	SN_CR_OPEN      = 0x01
+	SN_CR_LISTEN    = 0x02
+	SN_CR_CONNECT   = 0x04
+	SN_CR_DISCON    = 0x08
+	SN_CR_CLOSE     = 0x10
+	SN_CR_SEND      = 0x20
+	SN_CR_SEND_KEEP = 0x22
+	SN_CR_RECV      = 0x40
+)
+
+// SN_IR bits
+const (
+	SN_IR_CON     = 0x01
+	SN_IR_DISCON  = 0x02
+	SN_IR_RECV    = 0x04
+	SN_IR_TIMEOUT = 0x08
+	SN_IR_SENDOK  = 0x10
+)
+
+// SN_SR status
+const (
+	SOCK_CLOSED      = 0x00
+	SOCK_INIT        = 0x13
+	SOCK_LISTEN      = 0x14
+	SOCK_SYNSENT     = 0x15
+	SOCK_SYNRECV     = 0x16
+	SOCK_ESTABLISHED = 0x17
+	SOCK_FIN_WAIT    = 0x18
+	SOCK_CLOSING     = 0x1A
+	SOCK_TIME_WAIT   = 0x1B
+	SOCK_CLOSE_WAIT  = 0x1C
+	SOCK_LAST_ACK    = 0x1D
+	SOCK_UDP         = 0x22
+)
+
+// VERSIONR value of the W5500
+const VERSION = 0x04
+
+// Number of hardware sockets
+const NUM_SOCKETS = 8
+
+// Block select bits of the SPI control phase
+const (
+	blockCommon = 0x00
+	blockSocket = 0x01
+	blockTx     = 0x02
+	blockRx     = 0x03
+
+	controlWrite = 0x04
+)
@@ -0,0 +1,756 @@
+//go:build tinygo
+
+package w5500
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+
+	"tinygo.org/x/drivers/netdev"
+)
+
+const (
+	firstEphemeralPort = 49152
+	noHwSocket         = -1
+
+	// The last hardware socket is kept for the DHCP and DNS clients, so
+	// leases are renewed and names resolved even with all user sockets open
+	numUserSockets = NUM_SOCKETS - 1
+	clientHwSocket = NUM_SOCKETS - 1
+
+	// Each hardware socket has a 2KB transmit buffer, by default.  The
+	// W5500 adds the UDP header, so it only holds the payload.
+	maxUDPPayload = 2048
+	// Received datagrams are prefixed by the sender address and length
+	udpHeaderLen = 8
+
+	defaultKeepIntvl = 30 // seconds
+
+	// Largest DHCP or DNS message handled
+	maxPktLen = 576
+)
+
+type socket struct {
+	protocol  int
+	hw        int // hardware socket, or noHwSocket
+	laddr     netip.AddrPort
+	raddr     netip.AddrPort
+	noDelay   bool
+	keepAlive bool
+	keepIntvl int // seconds
+}
+
+func (d *Device) newSockfd() int {
+	if len(d.sockets) >= numUserSockets {
+		return -1
+	}
+	// Search for the next available sockfd starting at 0
+	for sockfd := 0; ; sockfd++ {
+		if _, ok := d.sockets[sockfd]; !ok {
+			return sockfd
+		}
+	}
+}
+
+func (d *Device) newHwSocket() int {
+	for hw, inUse := range d.hwInUse[:numUserSockets] {
+		if !inUse {
+			d.hwInUse[hw] = true
+			return hw
+		}
+	}
+	return noHwSocket
+}
+
+func (d *Device) freeHwSocket(hw int) {
+	d.sockCmd(hw, SN_CR_CLOSE)
+	d.hwInUse[hw] = false
+}
+
+func (d *Device) ephemeralPort() uint16 {
+	port := d.nextPort
+	d.nextPort++
+	if d.nextPort == 0 {
+		d.nextPort = firstEphemeralPort
+	}
+	return port
+}
+
+// sockCmd runs a socket command, waiting for the W5500 to accept it
+func (d *Device) sockCmd(hw int, cmd uint8) {
+	reg := sockBlock(hw, blockSocket)
+	d.writeUint8(reg, SN_CR, cmd)
+	for d.readUint8(reg, SN_CR) != 0 {
+	}
+}
+
+func (d *Device) sockStatus(hw int) uint8 {
+	return d.readUint8(sockBlock(hw, blockSocket), SN_SR)
+}
+
+// openHw opens a hardware socket in TCP or UDP mode, bound to port
+func (d *Device) openHw(hw int, mode uint8, port uint16) error {
+	reg := sockBlock(hw, blockSocket)
+
+	d.sockCmd(hw, SN_CR_CLOSE)
+	d.writeUint8(reg, SN_IR, 0xFF)
+	d.writeUint8(reg, SN_MR, mode)
+	d.writeUint16(reg, SN_PORT, port)
+	d.sockCmd(hw, SN_CR_OPEN)
+
+	want := uint8(SOCK_INIT)
+	if mode&0x0F == SN_MR_UDP {
+		want = SOCK_UDP
+	}
+	if d.sockStatus(hw) != want {
+		return fmt.Errorf("Opening hw socket %d failed", hw)
+	}
+	return nil
+}
+
+func (d *Device) openTCP(socket *socket, port uint16) error {
+	mode := uint8(SN_MR_TCP)
+	if socket.noDelay {
+		mode |= SN_MR_ND
+	}
+	if err := d.openHw(socket.hw, mode, port); err != nil {
+		return err
+	}
+	d.setKeepAlive(socket)
+	return nil
+}
+
+func (d *Device) openUDP(socket *socket) error {
+	if socket.hw != noHwSocket {
+		return nil
+	}
+	socket.hw = d.newHwSocket()
+	if socket.hw == noHwSocket {
+		return netdev.ErrNoMoreSockets
+	}
+	port := socket.laddr.Port()
+	if port == 0 {
+		port = d.ephemeralPort()
+	}
+	return d.openHw(socket.hw, SN_MR_UDP, port)
+}
+
+func (d *Device) setKeepAlive(socket *socket) {
+	if socket.hw == noHwSocket || socket.protocol != netdev.IPPROTO_TCP {
+		return
+	}
+	var units uint8
+	if socket.keepAlive {
+		units = uint8(socket.keepIntvl / 5)
+	}
+	d.writeUint8(sockBlock(socket.hw, blockSocket), SN_KPALVTR, units)
+}
+
+func (d *Device) remoteAddr(hw int) netip.AddrPort {
+	reg := sockBlock(hw, blockSocket)
+	ip := d.readAddr(reg, SN_DIPR)
+	port := d.readUint16(reg, SN_DPORT)
+	return netip.AddrPortFrom(ip, port)
+}
+
+func (d *Device) setRemoteAddr(hw int, raddr netip.AddrPort) {
+	reg := sockBlock(hw, blockSocket)
+	d.writeAddr(reg, SN_DIPR, raddr.Addr())
+	d.writeUint16(reg, SN_DPORT, raddr.Port())
+}
+
+// sendHw copies buf to the transmit buffer of a hardware socket, and sends
+// it, waiting for the W5500 to finish
+func (d *Device) sendHw(hw int, buf []byte) error {
+	reg := sockBlock(hw, blockSocket)
+
+	ptr := d.readUint16(reg, SN_TX_WR)
+	d.write(sockBlock(hw, blockTx), ptr, buf)
+	d.writeUint16(reg, SN_TX_WR, ptr+uint16(len(buf)))
+	d.sockCmd(hw, SN_CR_SEND)
+
+	for {
+		ir := d.readUint8(reg, SN_IR)
+		if ir&SN_IR_SENDOK != 0 {
+			d.writeUint8(reg, SN_IR, SN_IR_SENDOK)
+			return nil
+		}
+		if ir&SN_IR_TIMEOUT != 0 {
+			d.writeUint8(reg, SN_IR, SN_IR_TIMEOUT)
+			return io.EOF
+		}
+		if d.sockStatus(hw) == SOCK_CLOSED {
+			return io.EOF
+		}
+	}
+}
+
+// recvHw reads received data of a TCP hardware socket into buf
+func (d *Device) recvHw(hw int, buf []byte) {
+	reg := sockBlock(hw, blockSocket)
+
+	ptr := d.readUint16(reg, SN_RX_RD)
+	d.read(sockBlock(hw, blockRx), ptr, buf)
+	d.writeUint16(reg, SN_RX_RD, ptr+uint16(len(buf)))
+	d.sockCmd(hw, SN_CR_RECV)
+}
+
+// recvUDP reads the next received datagram of a UDP hardware socket into
+// buf, returning its length and sender.  The datagram is truncated if buf is
+// too small.  Returns -1 if nothing has been received.
+func (d *Device) recvUDP(hw int, buf []byte) (int, netip.AddrPort) {
+	reg := sockBlock(hw, blockSocket)
+
+	if d.readUint16Stable(reg, SN_RX_RSR) < udpHeaderLen {
+		return -1, netip.AddrPort{}
+	}
+
+	// Each datagram is prefixed by the sender IP, port and data length
+	var hdr [udpHeaderLen]byte
+	ptr := d.readUint16(reg, SN_RX_RD)
+	d.read(sockBlock(hw, blockRx), ptr, hdr[:])
+	raddr := netip.AddrPortFrom(netip.AddrFrom4([4]byte{hdr[0], hdr[1], hdr[2], hdr[3]}),
+		uint16(hdr[4])<<8|uint16(hdr[5]))
+	size := uint16(hdr[6])<<8 | uint16(hdr[7])
+
+	n := int(size)
+	if n > len(buf) {
+		n = len(buf)
+	}
+	d.read(sockBlock(hw, blockRx), ptr+udpHeaderLen, buf[:n])
+	d.writeUint16(reg, SN_RX_RD, ptr+udpHeaderLen+size)
+	d.sockCmd(hw, SN_CR_RECV)
+
+	return n, raddr
+}
+
+// exchangeUDP sends req to raddr from the client hardware socket bound to
+// lport, and passes replies to answer until it accepts one, or timeout.
+// Replies are received in d.pkt, so req may use d.pkt too.
+func (d *Device) exchangeUDP(lport uint16, raddr netip.AddrPort, req []byte,
+	timeout time.Duration, answer func(msg []byte) bool) error {
+
+	hw := clientHwSocket
+	d.hwInUse[hw] = true
+	defer d.freeHwSocket(hw)
+
+	if err := d.openHw(hw, SN_MR_UDP, lport); err != nil {
+		return err
+	}
+	if _, err := d.sendUDP(hw, raddr, req); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	for time.Since(start) < timeout {
+		n, _ := d.recvUDP(hw, d.pkt[:])
+		if n >= 0 {
+			if answer(d.pkt[:n]) {
+				return nil
+			}
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return netdev.ErrTimeout
+}
+
+// See man socket(2) for standard Berkely sockets for Socket, Bind, etc.
+// The driver strives to meet the function and semantics of socket(2).
+
+func (d *Device) Socket(domain int, stype int, protocol int) (int, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Socket] domain: %d, type: %d, protocol: %d\r\n",
+			domain, stype, protocol)
+	}
+
+	switch domain {
+	case netdev.AF_INET:
+	default:
+		return -1, netdev.ErrFamilyNotSupported
+	}
+
+	switch {
+	case protocol == netdev.IPPROTO_TCP && stype == netdev.SOCK_STREAM:
+	case protocol == netdev.IPPROTO_UDP && stype == netdev.SOCK_DGRAM:
+	default:
+		// No TLS, the W5500 doesn't do crypto
+		return -1, netdev.ErrProtocolNotSupported
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sockfd := d.newSockfd()
+	if sockfd == -1 {
+		return -1, netdev.ErrNoMoreSockets
+	}
+
+	d.sockets[sockfd] = &socket{
+		protocol:  protocol,
+		hw:        noHwSocket,
+		keepIntvl: defaultKeepIntvl,
+	}
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Socket] <-- sockfd %d\r\n", sockfd)
+	}
+
+	return sockfd, nil
+}
+
+func (d *Device) Bind(sockfd int, ip netip.AddrPort) error {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Bind] sockfd: %d, addr: %s\r\n", sockfd, ip)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	socket.laddr = ip
+
+	if socket.protocol == netdev.IPPROTO_UDP {
+		return d.openUDP(socket)
+	}
+
+	return nil
+}
+
+func (d *Device) Connect(sockfd int, host string, ip netip.AddrPort) error {
+
+	if debugging(debugNetdev) {
+		if host == "" {
+			fmt.Printf("[Connect] sockfd: %d, addr: %s\r\n", sockfd, ip)
+		} else {
+			fmt.Printf("[Connect] sockfd: %d, host: %s:%d\r\n", sockfd, host, ip.Port())
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	if socket.protocol == netdev.IPPROTO_UDP {
+		if err := d.openUDP(socket); err != nil {
+			return err
+		}
+		// See Send()
+		socket.raddr = ip
+		return nil
+	}
+
+	if socket.hw != noHwSocket {
+		return fmt.Errorf("Already connected")
+	}
+
+	socket.hw = d.newHwSocket()
+	if socket.hw == noHwSocket {
+		return netdev.ErrNoMoreSockets
+	}
+
+	port := socket.laddr.Port()
+	if port == 0 {
+		port = d.ephemeralPort()
+	}
+	if err := d.openTCP(socket, port); err != nil {
+		return err
+	}
+
+	d.setRemoteAddr(socket.hw, ip)
+	d.sockCmd(socket.hw, SN_CR_CONNECT)
+
+	// The W5500 gives up on its own, after its retransmission retries
+	for {
+		switch d.sockStatus(socket.hw) {
+		case SOCK_ESTABLISHED:
+			socket.raddr = ip
+			return nil
+		case SOCK_CLOSED:
+			d.freeHwSocket(socket.hw)
+			socket.hw = noHwSocket
+			if host == "" {
+				return fmt.Errorf("Connect to %s failed", ip)
+			}
+			return fmt.Errorf("Connect to %s:%d failed", host, ip.Port())
+		}
+
+		// Unlock while we sleep, so others can make progress
+		d.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		d.mu.Lock()
+	}
+}
+
+// listen puts a TCP server socket in listening state on a new hardware
+// socket, if one is available
+func (d *Device) listen(socket *socket) error {
+	socket.hw = d.newHwSocket()
+	if socket.hw == noHwSocket {
+		return netdev.ErrNoMoreSockets
+	}
+	if err := d.openTCP(socket, socket.laddr.Port()); err != nil {
+		return err
+	}
+	d.sockCmd(socket.hw, SN_CR_LISTEN)
+	if d.sockStatus(socket.hw) != SOCK_LISTEN {
+		return fmt.Errorf("Listen on port %d failed", socket.laddr.Port())
+	}
+	return nil
+}
+
+func (d *Device) Listen(sockfd int, backlog int) error {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Listen] sockfd: %d\r\n", sockfd)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	switch socket.protocol {
+	case netdev.IPPROTO_TCP:
+		return d.listen(socket)
+	case netdev.IPPROTO_UDP:
+		return d.openUDP(socket)
+	}
+
+	return netdev.ErrProtocolNotSupported
+}
+
+func (d *Device) Accept(sockfd int) (int, netip.AddrPort, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Accept] sockfd: %d\r\n", sockfd)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+		server, ok := d.sockets[sockfd]
+		if !ok {
+			return -1, netip.AddrPort{}, netdev.ErrInvalidSocketFd
+		}
+
+		if server.protocol != netdev.IPPROTO_TCP {
+			return -1, netip.AddrPort{}, netdev.ErrProtocolNotSupported
+		}
+
+		if server.hw == noHwSocket {
+			// The last client took our hardware socket, so try
+			// to listen again
+			if err := d.listen(server); err != nil && err != netdev.ErrNoMoreSockets {
+				return -1, netip.AddrPort{}, err
+			}
+		} else {
+			switch d.sockStatus(server.hw) {
+			case SOCK_ESTABLISHED, SOCK_CLOSE_WAIT:
+				// A W5500 socket in LISTEN state becomes the
+				// connection to the client, so hand it over
+				// to a new sockfd, and listen on another
+				clientfd := d.newSockfd()
+				if clientfd == -1 {
+					return -1, netip.AddrPort{}, netdev.ErrNoMoreSockets
+				}
+
+				raddr := d.remoteAddr(server.hw)
+				d.sockets[clientfd] = &socket{
+					protocol:  netdev.IPPROTO_TCP,
+					hw:        server.hw,
+					laddr:     server.laddr,
+					raddr:     raddr,
+					noDelay:   server.noDelay,
+					keepAlive: server.keepAlive,
+					keepIntvl: server.keepIntvl,
+				}
+
+				server.hw = noHwSocket
+				if err := d.listen(server); err != nil {
+					if server.hw != noHwSocket {
+						d.freeHwSocket(server.hw)
+					}
+					server.hw = noHwSocket
+				}
+
+				return clientfd, raddr, nil
+
+			case SOCK_CLOSED:
+				// Client came and went, listen again
+				d.freeHwSocket(server.hw)
+				server.hw = noHwSocket
+				continue
+			}
+		}
+
+		// Accept() will be sleeping most of the time, checking for
+		// new clients every 1/10 sec.
+		d.mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		d.mu.Lock()
+	}
+}
+
+func (d *Device) sendTCP(hw int, buf []byte, deadline time.Time) (int, error) {
+	reg := sockBlock(hw, blockSocket)
+
+	for sent := 0; sent < len(buf); {
+		// Check if we've timed out
+		if !deadline.IsZero() {
+			if time.Now().After(deadline) {
+				return -1, netdev.ErrTimeout
+			}
+		}
+
+		// Check if socket went down
+		switch d.sockStatus(hw) {
+		case SOCK_ESTABLISHED, SOCK_CLOSE_WAIT:
+		default:
+			return -1, io.EOF
+		}
+
+		free := int(d.readUint16Stable(reg, SN_TX_FSR))
+		if free == 0 {
+			// Unlock while we sleep, so others can make progress
+			d.mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			d.mu.Lock()
+			continue
+		}
+
+		end := len(buf)
+		if end-sent > free {
+			end = sent + free
+		}
+		if err := d.sendHw(hw, buf[sent:end]); err != nil {
+			return -1, err
+		}
+		sent = end
+	}
+
+	return len(buf), nil
+}
+
+func (d *Device) sendUDP(hw int, raddr netip.AddrPort, buf []byte) (int, error) {
+	if !raddr.IsValid() {
+		return -1, fmt.Errorf("Must Connect before Sending")
+	}
+	if len(buf) > maxUDPPayload {
+		return -1, fmt.Errorf("UDP datagram too long, len(buf)=%d", len(buf))
+	}
+	d.setRemoteAddr(hw, raddr)
+	if err := d.sendHw(hw, buf); err != nil {
+		return -1, fmt.Errorf("Send UDP data failed, len(buf)=%d", len(buf))
+	}
+	return len(buf), nil
+}
+
+func (d *Device) Send(sockfd int, buf []byte, flags int,
+	deadline time.Time) (int, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Send] sockfd: %d, len(buf): %d, flags: %d\r\n",
+			sockfd, len(buf), flags)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return -1, netdev.ErrInvalidSocketFd
+	}
+
+	if socket.hw == noHwSocket {
+		return -1, fmt.Errorf("Must Connect before Sending")
+	}
+
+	switch socket.protocol {
+	case netdev.IPPROTO_TCP:
+		return d.sendTCP(socket.hw, buf, deadline)
+	case netdev.IPPROTO_UDP:
+		return d.sendUDP(socket.hw, socket.raddr, buf)
+	}
+
+	return -1, netdev.ErrProtocolNotSupported
+}
+
+func (d *Device) Recv(sockfd int, buf []byte, flags int,
+	deadline time.Time) (int, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Recv] sockfd: %d, len(buf): %d, flags: %d\r\n",
+			sockfd, len(buf), flags)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+		socket, ok := d.sockets[sockfd]
+		if !ok {
+			return -1, netdev.ErrInvalidSocketFd
+		}
+
+		if socket.hw == noHwSocket {
+			return -1, io.EOF
+		}
+
+		// Check if we've timed out
+		if !deadline.IsZero() {
+			if time.Now().After(deadline) {
+				return -1, netdev.ErrTimeout
+			}
+		}
+
+		// Recv() doesn't return unless there is data, even a single
+		// byte, or on error such as timeout or EOF.
+
+		switch socket.protocol {
+		case netdev.IPPROTO_UDP:
+			if n, _ := d.recvUDP(socket.hw, buf); n >= 0 {
+				return n, nil
+			}
+
+		case netdev.IPPROTO_TCP:
+			n := int(d.readUint16Stable(sockBlock(socket.hw, blockSocket), SN_RX_RSR))
+			if n > 0 {
+				if n > len(buf) {
+					n = len(buf)
+				}
+				d.recvHw(socket.hw, buf[:n])
+				if debugging(debugNetdev) {
+					fmt.Printf("[<--Recv] sockfd: %d, n: %d\r\n",
+						sockfd, n)
+				}
+				return n, nil
+			}
+
+			// Check if socket went down, with no data left
+			if d.sockStatus(socket.hw) != SOCK_ESTABLISHED {
+				if debugging(debugNetdev) {
+					fmt.Printf("[<--Recv] sockfd: %d, EOF\r\n", sockfd)
+				}
+				return -1, io.EOF
+			}
+		}
+
+		// Unlock while we sleep, so others can make progress
+		d.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		d.mu.Lock()
+	}
+}
+
+func (d *Device) Close(sockfd int) error {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[Close] sockfd: %d\r\n", sockfd)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	if socket.hw != noHwSocket {
+		if socket.protocol == netdev.IPPROTO_TCP {
+			switch d.sockStatus(socket.hw) {
+			case SOCK_ESTABLISHED, SOCK_CLOSE_WAIT:
+				// Graceful close, waiting a bit for the FIN
+				// handshake before closing for good
+				d.sockCmd(socket.hw, SN_CR_DISCON)
+				for i := 0; i < 100 && d.sockStatus(socket.hw) != SOCK_CLOSED; i++ {
+					d.mu.Unlock()
+					time.Sleep(10 * time.Millisecond)
+					d.mu.Lock()
+				}
+			}
+		}
+		d.freeHwSocket(socket.hw)
+	}
+
+	delete(d.sockets, sockfd)
+
+	return nil
+}
+
+func (d *Device) SockOptSupported(level int, opt int) bool {
+	switch {
+	case level == netdev.SOL_SOCKET && opt == netdev.SO_KEEPALIVE:
+	case level == netdev.SOL_SOCKET && opt == netdev.SO_BROADCAST:
+	case level == netdev.SOL_TCP && opt == netdev.TCP_NODELAY:
+	case level == netdev.SOL_TCP && opt == netdev.TCP_KEEPINTVL:
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *Device) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[SetSockOpt] sockfd: %d, level: %d, opt: %d, value: %v\r\n",
+			sockfd, level, opt, value)
+	}
+
+	if !d.SockOptSupported(level, opt) {
+		return netdev.ErrNotSupported
+	}
+
+	v, err := netdev.SockOptInt(value)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	socket, ok := d.sockets[sockfd]
+	if !ok {
+		return netdev.ErrInvalidSocketFd
+	}
+
+	switch opt {
+	case netdev.SO_KEEPALIVE:
+		socket.keepAlive = v != 0
+	case netdev.TCP_KEEPINTVL:
+		// Sn_KPALVTR counts in 5 sec units
+		if v < 5 || v > 255*5 {
+			return netdev.ErrInvalidSockOptValue
+		}
+		socket.keepIntvl = v
+	case netdev.TCP_NODELAY:
+		// Maps to the W5500's no delayed ACK mode, which is taken
+		// when the socket is opened
+		socket.noDelay = v != 0
+	case netdev.SO_BROADCAST:
+		// UDP broadcasts are always allowed
+		return nil
+	}
+
+	d.setKeepAlive(socket)
+
+	return nil
+}
@@ -0,0 +1,508 @@
+//go:build tinygo
+
+// Package w5500 implements a TCP/UDP network device driver for the WIZnet
+// W5500 Ethernet controller, over SPI.  The W5500 has a hardwired TCP/IP
+// stack with 8 sockets; the driver adds DHCP and DNS clients.
+//
+// Datasheet:
+// https://docs.wiznet.io/img/products/w5500/W5500_ds_v110e.pdf
+
+package w5500 // import "tinygo.org/x/drivers/w5500"
+
+import (
+	"errors"
+	"fmt"
+	"machine"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/netdev"
+	"tinygo.org/x/drivers/netlink"
+)
+
+var _debug debug = debugBasic
+
+//var _debug debug = debugBasic | debugNetdev
+//var _debug debug = debugBasic | debugNetdev | debugDhcp | debugDns
+
+var (
+	driverName = "TinyGo W5500 Ethernet network device driver"
+)
+
+var ErrNotFound = errors.New("W5500 not found")
+
+// leaseCheckInterval is how often the DHCP lease is checked for renewal,
+// without a WatchdogTimeout
+const leaseCheckInterval = time.Minute
+
+// defaultMAC is a locally administered MAC address
+var defaultMAC = net.HardwareAddr{0x02, 0x08, 0xDC, 0x00, 0x00, 0x01}
+
+type Config struct {
+	// SPI bus, configured by the application.  The W5500 supports SPI
+	// mode 0 and 3, up to 80MHz.
+	Spi drivers.SPI
+	// Chip select
+	Cs machine.Pin
+	// Reset, active low, or machine.NoPin if not connected
+	Rst machine.Pin
+	// MAC address.  If not set, a locally administered address is used,
+	// which must be changed if there are several W5500 on the network.
+	MAC net.HardwareAddr
+}
+
+type Device struct {
+	cfg      *Config
+	bus      drivers.SPI
+	notifyCb func(netlink.Event)
+	mu       sync.Mutex
+
+	params *netlink.ConnectParams
+
+	netConnected bool
+	driverShown  bool
+	linkUp       bool // Ethernet link up, and IP configured
+
+	killWatchdog    chan bool
+	watchdogRunning bool
+
+	mac     net.HardwareAddr
+	ip      netip.Addr
+	subnet  netip.Addr
+	gateway netip.Addr
+	dns     []netip.Addr
+	lease   dhcpLease
+
+	sockets  map[int]*socket // keyed by sockfd
+	hwInUse  [NUM_SOCKETS]bool
+	nextPort uint16
+	xid      uint32
+
+	hdr [3]byte
+	reg [6]byte
+	pkt [maxPktLen]byte
+}
+
+func New(cfg *Config) *Device {
+	mac := cfg.MAC
+	if len(mac) != 6 {
+		mac = defaultMAC
+	}
+	return &Device{
+		cfg:          cfg,
+		bus:          cfg.Spi,
+		mac:          mac,
+		sockets:      make(map[int]*socket),
+		killWatchdog: make(chan bool, 1),
+		nextPort:     firstEphemeralPort,
+	}
+}
+
+// sockBlock returns the block select bits of a register block of a
+// hardware socket
+func sockBlock(hw int, block uint8) uint8 {
+	return uint8(hw)<<2 | block
+}
+
+func (d *Device) read(block uint8, addr uint16, buf []byte) {
+	d.hdr = [3]byte{byte(addr >> 8), byte(addr), block << 3}
+	d.cfg.Cs.Low()
+	d.bus.Tx(d.hdr[:], nil)
+	d.bus.Tx(nil, buf)
+	d.cfg.Cs.High()
+}
+
+func (d *Device) write(block uint8, addr uint16, buf []byte) {
+	d.hdr = [3]byte{byte(addr >> 8), byte(addr), block<<3 | controlWrite}
+	d.cfg.Cs.Low()
+	d.bus.Tx(d.hdr[:], nil)
+	d.bus.Tx(buf, nil)
+	d.cfg.Cs.High()
+}
+
+func (d *Device) readUint8(block uint8, addr uint16) uint8 {
+	d.read(block, addr, d.reg[:1])
+	return d.reg[0]
+}
+
+func (d *Device) writeUint8(block uint8, addr uint16, v uint8) {
+	d.reg[0] = v
+	d.write(block, addr, d.reg[:1])
+}
+
+func (d *Device) readUint16(block uint8, addr uint16) uint16 {
+	d.read(block, addr, d.reg[:2])
+	return uint16(d.reg[0])<<8 | uint16(d.reg[1])
+}
+
+func (d *Device) writeUint16(block uint8, addr uint16, v uint16) {
+	d.reg[0], d.reg[1] = byte(v>>8), byte(v)
+	d.write(block, addr, d.reg[:2])
+}
+
+// readUint16Stable reads a 16-bit register updated by the W5500, like
+// SN_RX_RSR, until two reads match, as advised by the datasheet
+func (d *Device) readUint16Stable(block uint8, addr uint16) uint16 {
+	v := d.readUint16(block, addr)
+	for {
+		w := d.readUint16(block, addr)
+		if w == v {
+			return v
+		}
+		v = w
+	}
+}
+
+func (d *Device) readAddr(block uint8, addr uint16) netip.Addr {
+	d.read(block, addr, d.reg[:4])
+	return netip.AddrFrom4([4]byte{d.reg[0], d.reg[1], d.reg[2], d.reg[3]})
+}
+
+func (d *Device) writeAddr(block uint8, addr uint16, ip netip.Addr) {
+	var b [4]byte
+	if ip.Is4() {
+		b = ip.As4()
+	}
+	copy(d.reg[:4], b[:])
+	d.write(block, addr, d.reg[:4])
+}
+
+func (d *Device) reset() error {
+	d.cfg.Cs.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	d.cfg.Cs.High()
+
+	if d.cfg.Rst != machine.NoPin {
+		d.cfg.Rst.Configure(machine.PinConfig{Mode: machine.PinOutput})
+		d.cfg.Rst.Low()
+		time.Sleep(1 * time.Millisecond)
+		d.cfg.Rst.High()
+		// Wait for the PLL to lock
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	d.writeUint8(blockCommon, MR, MR_RST)
+	for i := 0; d.readUint8(blockCommon, MR)&MR_RST != 0; i++ {
+		if i == 100 {
+			return ErrNotFound
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	if d.readUint8(blockCommon, VERSIONR) != VERSION {
+		return ErrNotFound
+	}
+
+	// Sockets are closed by the reset
+	for sockfd := range d.sockets {
+		delete(d.sockets, sockfd)
+	}
+	d.hwInUse = [NUM_SOCKETS]bool{}
+
+	d.write(blockCommon, SHAR, d.mac)
+	d.setIP(netip.Addr{}, netip.Addr{}, netip.Addr{})
+	return nil
+}
+
+func (d *Device) setIP(ip, subnet, gateway netip.Addr) {
+	d.ip, d.subnet, d.gateway = ip, subnet, gateway
+	d.writeAddr(blockCommon, SIPR, ip)
+	d.writeAddr(blockCommon, SUBR, subnet)
+	d.writeAddr(blockCommon, GAR, gateway)
+}
+
+// phyLinkUp reports if the Ethernet cable is connected to a network
+func (d *Device) phyLinkUp() bool {
+	return d.readUint8(blockCommon, PHYCFGR)&PHYCFGR_LNK != 0
+}
+
+func (d *Device) showDriver() {
+	if d.driverShown {
+		return
+	}
+	if debugging(debugBasic) {
+		fmt.Printf("\r\n")
+		fmt.Printf("%s\r\n\r\n", driverName)
+		fmt.Printf("Driver version           : %s\r\n", drivers.Version)
+		fmt.Printf("MAC address              : %s\r\n", d.mac)
+		fmt.Printf("\r\n")
+	}
+	d.driverShown = true
+}
+
+func (d *Device) showIP() {
+	if debugging(debugBasic) {
+		assigned := "DHCP-assigned"
+		if d.params.IP.IsValid() {
+			assigned = "Static"
+		}
+		fmt.Printf("\r\n")
+		fmt.Printf("%-25s: %s\r\n", assigned+" IP", d.ip)
+		fmt.Printf("%-25s: %s\r\n", assigned+" subnet", d.subnet)
+		fmt.Printf("%-25s: %s\r\n", assigned+" gateway", d.gateway)
+		fmt.Printf("\r\n")
+	}
+}
+
+// configureIP sets the static IP config, or gets one by DHCP
+func (d *Device) configureIP(timeout time.Duration) error {
+	if d.params.IP.IsValid() {
+		d.setIP(d.params.IP, d.params.Subnet, d.params.Gateway)
+		d.dns = nil
+	} else if err := d.dhcp(timeout); err != nil {
+		return err
+	}
+	if len(d.params.DNS) > 0 {
+		d.dns = d.params.DNS
+	}
+	return nil
+}
+
+// connect waits for the Ethernet link, then configures IP
+func (d *Device) connect() error {
+
+	timeout := d.params.ConnectTimeout
+	if timeout == 0 {
+		timeout = netlink.DefaultConnectTimeout
+	}
+
+	if debugging(debugBasic) {
+		fmt.Printf("Connecting to Ethernet...")
+	}
+
+	start := time.Now()
+	for !d.phyLinkUp() {
+		if time.Since(start) > timeout {
+			if debugging(debugBasic) {
+				fmt.Printf("FAILED (no link)\r\n")
+			}
+			return netlink.ErrConnectTimeout
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := d.configureIP(timeout); err != nil {
+		if debugging(debugBasic) {
+			fmt.Printf("FAILED (%s)\r\n", err)
+		}
+		return netlink.ErrConnectFailed
+	}
+
+	if debugging(debugBasic) {
+		fmt.Printf("CONNECTED\r\n")
+	}
+
+	d.linkUp = true
+	if d.notifyCb != nil {
+		d.notifyCb(netlink.EventNetUp)
+	}
+	return nil
+}
+
+func (d *Device) netConnect() error {
+	for i := 0; d.params.Retries == 0 || i < d.params.Retries; i++ {
+		if err := d.connect(); err != nil {
+			switch err {
+			case netlink.ErrConnectTimeout, netlink.ErrConnectFailed:
+				continue
+			}
+			return err
+		}
+		d.showIP()
+		return nil
+	}
+	return netlink.ErrConnectFailed
+}
+
+// watchdog checks the Ethernet link every WatchdogTimeout, if set, and
+// renews the DHCP lease when due
+func (d *Device) watchdog(interval time.Duration) {
+	checkLink := d.params.WatchdogTimeout != 0
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-d.killWatchdog:
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			switch {
+			case !d.netConnected:
+				// Disconnected meanwhile
+			case checkLink && d.linkUp && !d.phyLinkUp():
+				if debugging(debugBasic) {
+					fmt.Printf("Watchdog: Ethernet link DOWN\r\n")
+				}
+				d.linkUp = false
+				if d.notifyCb != nil {
+					d.notifyCb(netlink.EventNetDown)
+				}
+			case checkLink && !d.linkUp && d.phyLinkUp():
+				if debugging(debugBasic) {
+					fmt.Printf("Watchdog: Ethernet link UP, trying again...\r\n")
+				}
+				if d.connect() == nil {
+					d.showIP()
+				}
+			case d.linkUp && d.lease.renewDue():
+				if err := d.renewLease(); err != nil {
+					if debugging(debugBasic) {
+						fmt.Printf("Watchdog: DHCP lease lost: %s\r\n", err)
+					}
+					d.linkUp = false
+					if d.notifyCb != nil {
+						d.notifyCb(netlink.EventNetDown)
+					}
+				}
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *Device) NetConnect(params *netlink.ConnectParams) error {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.netConnected {
+		return netlink.ErrConnected
+	}
+
+	switch params.ConnectMode {
+	case netlink.ConnectModeSTA:
+	default:
+		return netlink.ErrConnectModeNoGood
+	}
+
+	if err := params.CheckIPConfig(); err != nil {
+		return err
+	}
+
+	d.params = params
+
+	d.showDriver()
+
+	if err := d.reset(); err != nil {
+		return err
+	}
+
+	if err := d.netConnect(); err != nil {
+		return err
+	}
+
+	d.netConnected = true
+
+	interval := d.params.WatchdogTimeout
+	if interval == 0 && !d.params.IP.IsValid() {
+		// Still renew the DHCP lease
+		interval = leaseCheckInterval
+	}
+	if interval != 0 {
+		d.watchdogRunning = true
+		go d.watchdog(interval)
+	}
+
+	return nil
+}
+
+func (d *Device) NetDisconnect() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.netConnected {
+		return
+	}
+
+	if d.watchdogRunning {
+		d.killWatchdog <- true
+		d.watchdogRunning = false
+	}
+
+	for sockfd, socket := range d.sockets {
+		if socket.hw != -1 {
+			d.freeHwSocket(socket.hw)
+		}
+		delete(d.sockets, sockfd)
+	}
+
+	d.setIP(netip.Addr{}, netip.Addr{}, netip.Addr{})
+	d.lease = dhcpLease{}
+	d.linkUp = false
+	d.netConnected = false
+
+	if debugging(debugBasic) {
+		fmt.Printf("\r\nDisconnected from Ethernet\r\n\r\n")
+	}
+
+	if d.notifyCb != nil {
+		d.notifyCb(netlink.EventNetDown)
+	}
+}
+
+func (d *Device) NetNotify(cb func(netlink.Event)) {
+	d.notifyCb = cb
+}
+
+func (d *Device) GetLinkInfo() (netlink.LinkInfo, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[GetLinkInfo]\r\n")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.linkUp {
+		return netlink.LinkInfo{}, netlink.ErrNotConnected
+	}
+
+	return netlink.LinkInfo{
+		IP:      d.ip,
+		Subnet:  d.subnet,
+		Gateway: d.gateway,
+	}, nil
+}
+
+func (d *Device) GetHardwareAddr() (net.HardwareAddr, error) {
+	return d.mac, nil
+}
+
+func (d *Device) Addr() (netip.Addr, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[GetIPAddr]\r\n")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.linkUp {
+		return netip.Addr{}, netlink.ErrNotConnected
+	}
+	return d.ip, nil
+}
+
+func (d *Device) GetHostByName(name string) (netip.Addr, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[GetHostByName] name: %s\r\n", name)
+	}
+
+	// If it's already in dot-form, just return it
+	if ip, err := netip.ParseAddr(name); err == nil {
+		return ip, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.linkUp {
+		return netip.Addr{}, netdev.ErrHostUnknown
+	}
+
+	return d.resolve(name)
+}
@@ -5,6 +5,13 @@
 // firmware from Arduino.  For more information:
 // https://github.com/arduino/nina-fw
 //
+// TLS sockets verify servers with the CAs built into the firmware, against
+// the host name given to Connect.  The firmware has no commands to change
+// this, so SetTLSConfig returns netdev.ErrNotSupported for RootCAs,
+// ServerName, ServerFingerprint and InsecureSkipVerify.  Only a client
+// certificate and key, of up to 1300 bytes each, are supported, with a
+// firmware implementing them such as Adafruit's nina-fw.
+//
 // 12/2022    sfeldma@gmail.com    Heavily modified to use netdev interface
 
 package wifinina // import "tinygo.org/x/drivers/wifinina"
@@ -123,6 +130,8 @@ const (
 	cmdGetSocket         = 0x3F
 
 	// All commands with DATA_FLAG 0x4x send a 16bit Len
+	cmdSetClientCert = 0x40
+	cmdSetCertKey    = 0x41
 	cmdSendDataTCP   = 0x44
 	cmdGetDatabufTCP = 0x45
 	cmdInsertDataBuf = 0x46
@@ -216,6 +225,9 @@ type wifinina struct {
 
 	killWatchdog chan bool
 	fault        error
+	rssiMonitor  netlink.RssiMonitor
+
+	tlsConfig *netdev.TLSConfig
 
 	sockets map[int]*Socket // keyed by sockfd
 }
@@ -268,6 +280,8 @@ func (w *wifinina) connectToAP() error {
 
 	start := time.Now()
 
+	w.configureIP()
+
 	// Start the connection process
 	w.setPassphrase(w.params.Ssid, w.params.Passphrase)
 
@@ -429,10 +443,14 @@ func (w *wifinina) showDevice() {
 func (w *wifinina) showIP() {
 	if debugging(debugBasic) {
 		ip, subnet, gateway := w.getIP()
+		assigned := "DHCP-assigned"
+		if w.params.IP.IsValid() {
+			assigned = "Static"
+		}
 		fmt.Printf("\r\n")
-		fmt.Printf("DHCP-assigned IP         : %s\r\n", ip)
-		fmt.Printf("DHCP-assigned subnet     : %s\r\n", subnet)
-		fmt.Printf("DHCP-assigned gateway    : %s\r\n", gateway)
+		fmt.Printf("%-25s: %s\r\n", assigned+" IP", ip)
+		fmt.Printf("%-25s: %s\r\n", assigned+" subnet", subnet)
+		fmt.Printf("%-25s: %s\r\n", assigned+" gateway", gateway)
 		fmt.Printf("\r\n")
 	}
 }
@@ -469,6 +487,11 @@ func (w *wifinina) watchdog() {
 					w.notifyCb(netlink.EventNetDown)
 				}
 				w.netConnect(false)
+			} else if w.params.RssiThreshold != 0 {
+				rssi := int(w.getCurrentRSSI())
+				if event, ok := w.rssiMonitor.Update(w.params.RssiThreshold, rssi); ok && w.notifyCb != nil {
+					w.notifyCb(event)
+				}
 			}
 			w.mu.Unlock()
 		}
@@ -478,6 +501,7 @@ func (w *wifinina) watchdog() {
 func (w *wifinina) netConnect(reset bool) error {
 	if reset {
 		w.start()
+		w.configureTLS()
 	}
 	w.showDevice()
 
@@ -522,6 +546,10 @@ func (w *wifinina) NetConnect(params *netlink.ConnectParams) error {
 		return netlink.ErrConnected
 	}
 
+	if err := params.CheckIPConfig(); err != nil {
+		return err
+	}
+
 	w.params = params
 
 	w.showDriver()
@@ -571,6 +599,97 @@ func (w *wifinina) NetNotify(cb func(netlink.Event)) {
 	w.notifyCb = cb
 }
 
+func (w *wifinina) NetScan() ([]netlink.ScanResult, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[NetScan]\r\n")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.netConnected {
+		w.showDriver()
+		w.setupSPI()
+		w.start()
+	}
+
+	if w.startScanNetworks() != 1 {
+		return nil, netlink.ErrScanFailed
+	}
+
+	// The scan takes about 2 seconds, retry until networks are found
+	var n uint8
+	for i := 0; i < 5 && n == 0; i++ {
+		time.Sleep(2 * time.Second)
+		n = w.scanNetworks()
+	}
+	if n > maxNetworks {
+		n = maxNetworks
+	}
+
+	results := make([]netlink.ScanResult, n)
+	for i := range results {
+		results[i] = netlink.ScanResult{
+			Ssid:     w.getNetworkSSID(i),
+			Bssid:    append(net.HardwareAddr(nil), w.getNetworkBSSID(i)...),
+			Rssi:     int(w.getNetworkRSSI(i)),
+			Channel:  int(w.getNetworkChannel(i)),
+			AuthType: w.getNetworkEncrType(i).authType(),
+		}
+	}
+
+	if w.fault != nil {
+		return nil, w.fault
+	}
+
+	return results, nil
+}
+
+func (w *wifinina) GetLinkInfo() (netlink.LinkInfo, error) {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[GetLinkInfo]\r\n")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.netConnected {
+		return netlink.LinkInfo{}, netlink.ErrNotConnected
+	}
+
+	// The firmware doesn't report the channel of the current network
+	info := netlink.LinkInfo{
+		Ssid:  w.getCurrentSSID(),
+		Bssid: append(net.HardwareAddr(nil), w.getCurrentBSSID()...),
+		Rssi:  int(w.getCurrentRSSI()),
+	}
+	info.IP, info.Subnet, info.Gateway = w.getIP()
+
+	if w.fault != nil {
+		return netlink.LinkInfo{}, w.fault
+	}
+
+	return info, nil
+}
+
+func (e encryptionType) authType() netlink.AuthType {
+	switch e {
+	case encTypeNone:
+		return netlink.AuthTypeOpen
+	case encTypeWEP:
+		return netlink.AuthTypeWEP
+	case encTypeTKIP:
+		return netlink.AuthTypeWPA
+	case encTypeCCMP:
+		return netlink.AuthTypeWPA2
+	case encTypeAuto:
+		return netlink.AuthTypeWPA2Mixed
+	}
+	return netlink.AuthTypeUnknown
+}
+
 func (w *wifinina) GetHostByName(name string) (netip.Addr, error) {
 
 	if debugging(debugNetdev) {
@@ -1085,10 +1204,80 @@ func (w *wifinina) Close(sockfd int) error {
 	return nil
 }
 
+func (w *wifinina) SetTLSConfig(config *netdev.TLSConfig) error {
+
+	if debugging(debugNetdev) {
+		fmt.Printf("[SetTLSConfig]\r\n")
+	}
+
+	// The firmware verifies servers with its built-in CAs only, and the
+	// client credentials are loaded before connecting to the network
+	if config != nil {
+		if len(config.RootCAs) > 0 || len(config.ServerName) > 0 ||
+			len(config.ServerFingerprint) > 0 || config.InsecureSkipVerify {
+			return netdev.ErrNotSupported
+		}
+		if len(config.Certificate) > maxCertLen || len(config.PrivateKey) > maxCertLen {
+			return netdev.ErrNotSupported
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.netConnected {
+		return fmt.Errorf("Set TLS config before NetConnect")
+	}
+
+	w.tlsConfig = config
+	return nil
+}
+
+// Client certificates and keys are stored in a buffer of the firmware
+const maxCertLen = 1300
+
+// configureTLS loads the client certificate and key, after a reset
+func (w *wifinina) configureTLS() {
+	if w.tlsConfig == nil {
+		return
+	}
+	if len(w.tlsConfig.Certificate) > 0 {
+		w.setCert(cmdSetClientCert, w.tlsConfig.Certificate)
+	}
+	if len(w.tlsConfig.PrivateKey) > 0 {
+		w.setCert(cmdSetCertKey, w.tlsConfig.PrivateKey)
+	}
+}
+
+func (w *wifinina) setCert(cmd uint8, pem []byte) {
+	if debugging(debugCmd) {
+		fmt.Printf("    [cmdSetCert] cmd: %02X, len: %d\r\n", cmd, len(pem))
+	}
+
+	// Don't show keys in debug output
+	saveDebug := _debug
+	_debug = _debug & ^debugDetail
+	w.waitForChipReady()
+	w.spiChipSelect()
+	l := w.sendCmd(cmd, 1)
+	l += w.sendParamBuf(pem, true)
+	w.addPadding(l)
+	w.spiChipDeselect()
+	_debug = saveDebug
+
+	w.waitRspCmd1(cmd)
+}
+
+func (w *wifinina) SockOptSupported(level int, opt int) bool {
+	// Socket options aren't exposed by the NINA firmware
+	return false
+}
+
 func (w *wifinina) SetSockOpt(sockfd int, level int, opt int, value interface{}) error {
 
 	if debugging(debugNetdev) {
-		fmt.Printf("[SetSockOpt] sockfd: %d\r\n", sockfd)
+		fmt.Printf("[SetSockOpt] sockfd: %d, level: %d, opt: %d, value: %v\r\n",
+			sockfd, level, opt, value)
 	}
 
 	return netdev.ErrNotSupported
@@ -1476,6 +1665,45 @@ func (w *wifinina) setPassphraseForAP(ssid string, passphrase string) {
 	w.reqStr2(cmdSetAPPassphrase, ssid, passphrase)
 }
 
+// configureIP sets the hostname, and the static IP address and DNS servers,
+// if any, before connecting.  The firmware uses DHCP otherwise.
+func (w *wifinina) configureIP() {
+	if w.params.Hostname != "" {
+		w.setHostname(w.params.Hostname)
+	}
+	if w.params.IP.IsValid() {
+		w.setIPConfig(toUint32(w.params.IP.As4()),
+			toUint32(w.params.Gateway.As4()),
+			toUint32(w.params.Subnet.As4()))
+	}
+	if len(w.params.DNS) > 0 {
+		var dns [netlink.MaxDNSServers]uint32
+		for i, addr := range w.params.DNS {
+			dns[i] = toUint32(addr.As4())
+		}
+		w.setDNS(uint8(len(w.params.DNS)), dns[0], dns[1])
+	}
+}
+
+func (w *wifinina) setIPConfig(ip uint32, gateway uint32, subnet uint32) {
+	if debugging(debugCmd) {
+		fmt.Printf("    [cmdSetIPConfig] ip: %08X, gateway: %08X, subnet: %08X\r\n",
+			ip, gateway, subnet)
+	}
+
+	w.waitForChipReady()
+	w.spiChipSelect()
+	w.sendCmd(cmdSetIPConfig, 4)
+	w.sendParam8(3, false) // number of valid params
+	w.sendParam32(ip, false)
+	w.sendParam32(gateway, false)
+	w.sendParam32(subnet, true)
+	w.padTo4(21)
+	w.spiChipDeselect()
+
+	w.waitRspCmd1(cmdSetIPConfig)
+}
+
 func (w *wifinina) setDNS(which uint8, dns1 uint32, dns2 uint32) {
 	w.waitForChipReady()
 	w.spiChipSelect()
@@ -1492,7 +1720,10 @@ func (w *wifinina) setDNS(which uint8, dns1 uint32, dns2 uint32) {
 func (w *wifinina) setHostname(hostname string) {
 	w.waitForChipReady()
 	w.spiChipSelect()
-	w.sendCmd(cmdSetHostname, 3)
+	// A single param, the hostname: see setHostname in nina-fw's
+	// main/CommandHandler.cpp, and WiFiDrv::setHostname of the WiFiNINA
+	// library which sends PARAM_NUMS_1
+	w.sendCmd(cmdSetHostname, 1)
 	w.sendParamStr(hostname, true)
 	w.padTo4(5 + len(hostname))
 	w.spiChipDeselect()